@@ -0,0 +1,140 @@
+package wp_zip
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/diskusage"
+	"github.com/jfortunato/wp-zip/internal/packager"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var AnalyzeFormat string
+
+func init() {
+	analyzeCmd.Flags().BoolP("help", "", false, "help for this command")
+	analyzeCmd.Flags().StringVarP(&Host, "host", "h", "", "SFTP host (required)")
+	analyzeCmd.Flags().StringVarP(&Username, "username", "u", "", "SFTP username (required)")
+	analyzeCmd.Flags().StringVarP(&Password, "password", "p", "", "SFTP password (required or prompted)")
+	analyzeCmd.Flags().StringVarP(&Port, "port", "P", "22", "SFTP port")
+	analyzeCmd.Flags().StringVarP(&SiteUrl, "site-url", "", "", "Site url name of the live site, including the protocol (e.g. https://example.com)")
+	analyzeCmd.Flags().StringVarP(&Webroot, "webroot", "w", "", "Path to the public directory of the live site")
+	analyzeCmd.Flags().BoolVarP(&NoWPCli, "no-wp-cli", "", false, "Never use WP-CLI on the server, even when it is available")
+	analyzeCmd.Flags().StringVarP(&AnalyzeFormat, "format", "", "text", "How the analysis is printed: text or json")
+	analyzeCmd.Flags().BoolVarP(&verbose.Enabled, "verbose", "", false, "Log how the files are being listed")
+	analyzeCmd.MarkFlagRequired("host")
+	analyzeCmd.MarkFlagRequired("username")
+	rootCmd.AddCommand(analyzeCmd)
+}
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze -h sftp-host -u sftp-username -p sftp-password [flags]",
+	Short: "Report where the bytes of a site are, and what could be left out of its export",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if AnalyzeFormat != "text" && AnalyzeFormat != "json" {
+			log.Fatalf("unknown --format: %s", AnalyzeFormat)
+		}
+
+		var siteUrl types.SiteUrl
+		if SiteUrl != "" {
+			var err error
+			siteUrl, err = types.NewSiteUrl(SiteUrl)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		for Password == "" {
+			prompter := &packager.RuntimePrompter{}
+			Password = prompter.PromptForPassword("Enter SFTP password: ")
+		}
+
+		// Nothing is written to the server while it is analyzed, the same as in a dry run
+		p, err := packager.NewPackager(sftp.SSHCredentials{User: Username, Pass: Password, Host: Host, Port: Port}, siteUrl, types.PublicPath(Webroot), packager.Options{NoWPCli: NoWPCli, DryRun: true})
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		analysis, err := p.Analyze()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if err := printAnalysis(os.Stdout, analysis); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+// printAnalysis prints the analysis in the format asked for with --format.
+func printAnalysis(out io.Writer, analysis packager.Analysis) error {
+	if AnalyzeFormat == "json" {
+		b, err := json.Marshal(analysis)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+
+	files := analysis.Files
+	fmt.Fprintf(out, "Files under %s: %s in %d files\n", analysis.PublicPath, types.FormatBytes(files.Bytes), files.Files)
+	fmt.Fprintln(out, "\nLargest directories:")
+	printUsages(out, files.Directories)
+	fmt.Fprintln(out, "\nLargest files:")
+	printUsages(out, files.Largest)
+
+	fmt.Fprintf(out, "\nDatabase: %s\n", types.FormatBytes(analysis.DatabaseSize))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, table := range analysis.Tables[:min(len(analysis.Tables), diskusage.TOP)] {
+		fmt.Fprintf(w, "  %s\t%s\t%d rows\n", types.FormatBytes(table.Bytes), table.Name, table.Rows)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "\nMost likely not needed in an export:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(files.Findings) == 0 {
+		fmt.Fprintln(w, "  nothing")
+	}
+	for _, finding := range files.Findings {
+		reason := finding.Reason
+		if finding.LeftOut {
+			reason += " (left out by default)"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", types.FormatBytes(finding.Bytes), finding.Path, reason)
+	}
+	w.Flush()
+
+	if len(analysis.Excludes) > 0 {
+		var flags []string
+		for _, exclude := range analysis.Excludes {
+			flags = append(flags, "--exclude "+sftp.ShellQuote(exclude))
+		}
+		fmt.Fprintf(out, "\nTo leave out the rest, export with:\n  %s\n", strings.Join(flags, " "))
+	}
+
+	if len(analysis.Warnings) > 0 {
+		fmt.Fprintln(out, "\nWarnings:")
+		for _, warning := range analysis.Warnings {
+			fmt.Fprintf(out, "  - %s\n", warning)
+		}
+	}
+
+	return nil
+}
+
+func printUsages(out io.Writer, usages []diskusage.Usage) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, usage := range usages {
+		fmt.Fprintf(w, "  %s\t%s\n", types.FormatBytes(usage.Bytes), usage.Path)
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package wp_zip
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/packager"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	doctorCmd.Flags().BoolP("help", "", false, "help for this command")
+	doctorCmd.Flags().StringVarP(&Host, "host", "h", "", "SFTP host (required)")
+	doctorCmd.Flags().StringVarP(&Username, "username", "u", "", "SFTP username (required)")
+	doctorCmd.Flags().StringVarP(&Password, "password", "p", "", "SFTP password (required or prompted)")
+	doctorCmd.Flags().StringVarP(&Port, "port", "P", "22", "SFTP port")
+	doctorCmd.Flags().StringVarP(&Webroot, "webroot", "w", "", "Path to the public directory of the live site (every install that is found by default)")
+	doctorCmd.Flags().BoolVarP(&NoWPCli, "no-wp-cli", "", false, "Never use WP-CLI on the server, even when it is available")
+	doctorCmd.Flags().BoolVarP(&verbose.Enabled, "verbose", "", false, "Log how the server is being checked")
+	doctorCmd.MarkFlagRequired("host")
+	doctorCmd.MarkFlagRequired("username")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor -h sftp-host -u sftp-username -p sftp-password [flags]",
+	Short: "Check what the server can run, and how a site on it would be exported",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		for Password == "" {
+			prompter := &packager.RuntimePrompter{}
+			Password = prompter.PromptForPassword("Enter SFTP password: ")
+		}
+
+		session, err := packager.NewSession(sftp.SSHCredentials{User: Username, Pass: Password, Host: Host, Port: Port}, packager.Options{NoWPCli: NoWPCli})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer session.Close()
+
+		webroots := []types.PublicPath{types.PublicPath(Webroot)}
+		if Webroot == "" {
+			installs, err := session.Discover()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			webroots = nil
+			for _, install := range installs {
+				webroots = append(webroots, install.PublicPath)
+			}
+		}
+
+		printDoctor(os.Stdout, session, webroots)
+	},
+}
+
+// printDoctor prints the capabilities of the server, followed by how a site in each of the webroots would be exported.
+func printDoctor(out io.Writer, session *packager.Session, webroots []types.PublicPath) {
+	profile := session.Profile()
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Server capabilities:")
+	fmt.Fprintf(w, "  shell\t%s\n", yesNo[profile.Shell])
+	for _, c := range capabilities.All {
+		fmt.Fprintf(w, "  %s\t%s\n", c.Name, yesNo[profile.Has(c)])
+	}
+	w.Flush()
+
+	if len(webroots) == 0 {
+		fmt.Fprintln(out, "\nNo WordPress install was found, so the webroot is assumed to be writable.")
+		printStrategies(out, session.Strategies(true))
+		return
+	}
+	for _, webroot := range webroots {
+		writable := session.ProbeWebroot(webroot)
+		fmt.Fprintf(out, "\nWebroot %s (writable: %s):\n", webroot, yesNo[writable])
+		printStrategies(out, session.Strategies(writable))
+	}
+}
+
+func printStrategies(out io.Writer, strategies []packager.Strategy) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, s := range strategies {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", s.Step, s.Choice, s.Reason)
+	}
+	w.Flush()
+}
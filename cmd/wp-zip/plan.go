@@ -0,0 +1,87 @@
+package wp_zip
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/packager"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"text/tabwriter"
+)
+
+// printPlan prints what the export would do, in the format asked for with --plan-format.
+func printPlan(out io.Writer, p *packager.Packager) error {
+	plan, err := p.Plan()
+	if err != nil {
+		return err
+	}
+
+	if PlanFormat == "json" {
+		b, err := json.Marshal(plan)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Dry run, nothing was downloaded or written to the server.")
+	fmt.Fprintln(w, "\nSite:")
+	fmt.Fprintf(w, "  public path\t%s\n", plan.PublicPath)
+	fmt.Fprintf(w, "  site url\t%s\n", plan.SiteUrl)
+	fmt.Fprintf(w, "  WordPress\t%s\n", plan.Core)
+	fmt.Fprintf(w, "  wp-content\t%s\n", plan.Content)
+	fmt.Fprintf(w, "  wp-config.php\t%s\n", plan.Config)
+	if plan.Multisite != "" {
+		fmt.Fprintf(w, "  multisite\t%s\n", plan.Multisite)
+	}
+	if plan.Subsite != "" {
+		fmt.Fprintf(w, "  subsite\t%s\n", plan.Subsite)
+	}
+	fmt.Fprintln(w, "\nEstimated size:")
+	fmt.Fprintf(w, "  files\t%s\n", types.FormatBytes(plan.FilesSize))
+	fmt.Fprintf(w, "  database\t%s\n", types.FormatBytes(plan.DatabaseSize))
+	w.Flush()
+
+	fmt.Fprintln(out, "\nOperations:")
+	for _, op := range plan.Operations {
+		fmt.Fprintf(out, "  - %s\n", op)
+	}
+
+	fmt.Fprintln(out, "\nStrategies:")
+	printStrategies(out, plan.Strategies)
+
+	fmt.Fprintln(out, "\nUploaded to the server, and removed again once used:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(plan.Uploads) == 0 {
+		fmt.Fprintln(w, "  nothing")
+	}
+	for _, upload := range plan.Uploads {
+		fmt.Fprintf(w, "  %s\t%s\n", upload.Path, upload.Purpose)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nLeft out of %s:\n", plan.Core)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(plan.Exclusions) == 0 {
+		fmt.Fprintln(w, "  nothing")
+	}
+	for _, exclusion := range plan.Exclusions {
+		reason := exclusion.Reason
+		if exclusion.MinSize > 0 {
+			reason += fmt.Sprintf(", from %s", types.FormatBytes(exclusion.MinSize))
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", exclusion.Path, reason)
+	}
+	w.Flush()
+
+	if len(plan.Warnings) > 0 {
+		fmt.Fprintln(out, "\nWarnings:")
+		for _, warning := range plan.Warnings {
+			fmt.Fprintf(out, "  - %s\n", warning)
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,20 @@ package wp_zip
 
 import (
 	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/operations"
 	"github.com/jfortunato/wp-zip/internal/packager"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
 	"github.com/spf13/cobra"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 type VersionDetails struct {
@@ -21,12 +30,38 @@ var Password string
 var Port string
 var SiteUrl string
 var Webroot string
+var DbConcurrency int
+var DbPerTable bool
+var DbProfile string
+var MysqldumpArgs string
+var DbVerify string
+var DbCompress string
+var NoWPCli bool
+var WPCliDbExport bool
+var HttpUser string
+var HttpHeaders []string
+var Resolves []string
+var Insecure bool
+var HttpProxy string
+var HttpTimeout time.Duration
+var DbUser string
+var DbPass string
+var DbName string
+var DbHost string
+var TablePrefix string
+var Subsite string
+var All bool
+var Excludes []string
+var NoSmartExclude bool
+var DryRun bool
+var PlanFormat string
 
 // RunOptions are the pre-run validated options that are passed to the Run function
 type RunOptions struct {
 	sshCredentials sftp.SSHCredentials
 	siteUrl        types.SiteUrl
 	publicPath     types.PublicPath
+	packager       packager.Options
 }
 
 var Options RunOptions
@@ -40,6 +75,32 @@ func init() {
 	rootCmd.Flags().StringVarP(&Port, "port", "P", "22", "SFTP port")
 	rootCmd.Flags().StringVarP(&SiteUrl, "site-url", "", "", "Site url name of the live site, including the protocol (e.g. https://example.com)")
 	rootCmd.Flags().StringVarP(&Webroot, "webroot", "w", "", "Path to the public directory of the live site")
+	rootCmd.Flags().IntVarP(&DbConcurrency, "db-concurrency", "", 1, "Number of database tables to dump at once, each over its own SSH session (requires mysqldump)")
+	rootCmd.Flags().BoolVarP(&DbPerTable, "db-per-table", "", false, "Export each database table to its own database/<table>.sql file (requires mysqldump)")
+	rootCmd.Flags().StringVarP(&DbProfile, "db-profile", "", string(database.DumpProfileConsistent), "mysqldump profile: consistent (InnoDB snapshot), locking (lock tables, for MyISAM) or minimal")
+	rootCmd.Flags().StringVarP(&MysqldumpArgs, "mysqldump-args", "", "", "Extra arguments passed to mysqldump as is")
+	rootCmd.Flags().StringVarP(&DbVerify, "db-verify", "", string(database.VerifyWarn), "What to do when the database dump looks incomplete: warn, strict (fail) or off")
+	rootCmd.Flags().StringVarP(&DbCompress, "db-compress", "", string(database.CompressionNone), "Compress the database dump in the archive: none, gzip or zstd (compressed on the server when it can)")
+	rootCmd.Flags().BoolVarP(&NoWPCli, "no-wp-cli", "", false, "Never use WP-CLI on the server, even when it is available")
+	rootCmd.Flags().BoolVarP(&WPCliDbExport, "wp-cli-db-export", "", false, "Export the database with wp db export when WP-CLI is available")
+	rootCmd.Flags().BoolVarP(&verbose.Enabled, "verbose", "", false, "Log how the site is being read, such as whether WP-CLI is used")
+	rootCmd.Flags().StringVarP(&HttpUser, "http-user", "", "", "Basic auth credentials for the site, as user:password")
+	rootCmd.Flags().StringArrayVarP(&HttpHeaders, "http-header", "", nil, "Extra header sent with every request to the site, as \"Name: value\" (repeatable)")
+	rootCmd.Flags().StringArrayVarP(&Resolves, "resolve", "", nil, "Connect to the given ip instead of looking up the site, as host:ip or host:port:ip (repeatable)")
+	rootCmd.Flags().BoolVarP(&Insecure, "insecure", "", false, "Skip verifying the site's TLS certificate")
+	rootCmd.Flags().StringVarP(&HttpProxy, "http-proxy", "", "", "Proxy for requests to the site (defaults to the HTTP_PROXY/HTTPS_PROXY environment variables)")
+	rootCmd.Flags().DurationVarP(&HttpTimeout, "http-timeout", "", 0, "Time limit for each request to the site, such as 30s (no limit by default)")
+	rootCmd.Flags().StringVarP(&DbUser, "db-user", "", "", "Database user, instead of DB_USER from wp-config.php")
+	rootCmd.Flags().StringVarP(&DbPass, "db-pass", "", "", "Database password, instead of DB_PASSWORD from wp-config.php")
+	rootCmd.Flags().StringVarP(&DbName, "db-name", "", "", "Database name, instead of DB_NAME from wp-config.php")
+	rootCmd.Flags().StringVarP(&DbHost, "db-host", "", "", "Database host, with an optional port or socket, instead of DB_HOST from wp-config.php")
+	rootCmd.Flags().StringVarP(&TablePrefix, "table-prefix", "", "", "Database table prefix, instead of $table_prefix from wp-config.php")
+	rootCmd.Flags().StringVarP(&Subsite, "subsite", "", "", "Export only this subsite of a multisite network as a single site, given its blog id or domain")
+	rootCmd.Flags().BoolVarP(&All, "all", "", false, "Export every WordPress install on the server, each into its own archive named after the output filename")
+	rootCmd.Flags().StringArrayVarP(&Excludes, "exclude", "", nil, "Leave a file or directory out of the archive, given relative to WordPress or as a glob such as **/error_log (repeatable)")
+	rootCmd.Flags().BoolVarP(&NoSmartExclude, "no-smart-exclude", "", false, "Keep the backups of backup plugins, archives and database dumps over 50M, and caches, which are left out by default")
+	rootCmd.Flags().BoolVarP(&DryRun, "dry-run", "", false, "Print what the export would do, without downloading anything or writing to the server")
+	rootCmd.Flags().StringVarP(&PlanFormat, "plan-format", "", "text", "How --dry-run prints the plan: text or json")
 	rootCmd.MarkFlagRequired("host")
 	rootCmd.MarkFlagRequired("username")
 }
@@ -70,28 +131,164 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
+		// Each install has its own webroot, site url and database, so none of them can be given for all of them
+		if All {
+			for _, name := range []string{"webroot", "site-url", "subsite", "db-user", "db-pass", "db-name", "db-host", "table-prefix"} {
+				if cmd.Flags().Changed(name) {
+					log.Fatalf("--%s cannot be used with --all", name)
+				}
+			}
+		}
+
+		if PlanFormat != "text" && PlanFormat != "json" {
+			log.Fatalf("unknown --plan-format: %s", PlanFormat)
+		}
+
+		if DbConcurrency < 1 {
+			log.Fatalln("--db-concurrency must be at least 1")
+		}
+
+		profile, err := database.ParseDumpProfile(DbProfile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		verify, err := database.ParseVerifyMode(DbVerify)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		compression, err := database.ParseCompression(DbCompress)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		httpOptions, err := parseHttpOptions()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
 		// Construct all the RunOptions
 		Options = RunOptions{
 			sftp.SSHCredentials{User: Username, Pass: Password, Host: Host, Port: Port},
 			siteUrl,
 			types.PublicPath(Webroot),
+			packager.Options{
+				Database:          database.ExportOptions{Concurrency: DbConcurrency, PerTableFiles: DbPerTable, Profile: profile, ExtraArgs: MysqldumpArgs, Verify: verify, Compression: compression},
+				NoWPCli:           NoWPCli,
+				WPCliDbExport:     WPCliDbExport,
+				Http:              httpOptions,
+				DatabaseOverrides: packager.DatabaseOverrides{User: DbUser, Pass: DbPass, Name: DbName, Host: DbHost, Prefix: TablePrefix},
+				Subsite:           Subsite,
+				Exclude:           Excludes,
+				NoSmartExclude:    NoSmartExclude,
+				DryRun:            DryRun,
+			},
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		p, err := packager.NewPackager(Options.sshCredentials, Options.siteUrl, Options.publicPath)
+		if All {
+			var output string
+			if len(args) > 0 {
+				output = args[0]
+			}
+			packageAll(output)
+			return
+		}
+
+		p, err := packager.NewPackager(Options.sshCredentials, Options.siteUrl, Options.publicPath, Options.packager)
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		err = p.PackageWP(args[0])
+		if DryRun {
+			err = printPlan(os.Stdout, p)
+		} else {
+			err = p.PackageWP(args[0])
+		}
 		if err != nil {
 			log.Fatalln(err)
 		}
 	},
 }
 
+// packageAll exports every install on the server over the same connection. An install that fails is reported, and the rest are still
+// exported.
+func packageAll(output string) {
+	session, err := packager.NewSession(Options.sshCredentials, Options.packager)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer session.Close()
+
+	installs, err := session.Discover()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(installs) == 0 {
+		log.Fatalln("no WordPress installs were found")
+	}
+
+	var failed int
+	for i, name := range packager.ArchiveNames(output, installs) {
+		if DryRun {
+			log.Printf("planning the export of %s", installs[i])
+		} else {
+			log.Printf("exporting %s to %s", installs[i], name)
+		}
+		p, err := session.NewPackager(installs[i].SiteUrl, installs[i].PublicPath)
+		if err == nil && DryRun {
+			err = printPlan(os.Stdout, p)
+		} else if err == nil {
+			err = p.PackageWP(name)
+		}
+		if err != nil {
+			log.Printf("could not export %s: %s", installs[i].PublicPath, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		log.Fatalf("%d of %d installs could not be exported", failed, len(installs))
+	}
+}
+
+func parseHttpOptions() (operations.HttpOptions, error) {
+	opts := operations.HttpOptions{Header: http.Header{}, Resolve: map[string]string{}, Insecure: Insecure, Timeout: HttpTimeout}
+
+	if HttpUser != "" {
+		opts.User, opts.Pass, _ = strings.Cut(HttpUser, ":")
+	}
+	for _, header := range HttpHeaders {
+		if err := operations.ParseHeader(opts.Header, header); err != nil {
+			return opts, err
+		}
+	}
+	for _, resolve := range Resolves {
+		if err := operations.ParseResolve(opts.Resolve, resolve); err != nil {
+			return opts, err
+		}
+	}
+	if HttpProxy != "" {
+		proxy, err := url.Parse(HttpProxy)
+		if err != nil || proxy.Host == "" {
+			return opts, fmt.Errorf("invalid --http-proxy: %s", HttpProxy)
+		}
+		opts.Proxy = proxy
+	}
+	if HttpTimeout < 0 {
+		return opts, errors.New("--http-timeout cannot be negative")
+	}
+
+	return opts, nil
+}
+
 func argsValidation() cobra.PositionalArgs {
 	return func(cmd *cobra.Command, args []string) error {
+		// A dry run doesn't write the archive, so it doesn't need its filename
+		if DryRun && len(args) == 0 {
+			return nil
+		}
 		// Must have exactly one argument
 		if len(args) != 1 {
 			return errors.New("requires exactly one argument")
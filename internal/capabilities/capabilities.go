@@ -0,0 +1,137 @@
+// Package capabilities probes what the remote server can run. Every tool is probed at once, over a single SSH session, and the result is
+// kept for the rest of the run, so that the factories that choose how to download the files or export the database don't each open
+// sessions of their own to check.
+package capabilities
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"strings"
+)
+
+// Capability is a tool that one of the strategies needs on the server. Command is the same command that the strategy checks for it with,
+// so that its check is answered by the profile.
+type Capability struct {
+	Name    string
+	Command string
+}
+
+var (
+	Tar         = Capability{"tar", "tar --version"}
+	Du          = Capability{"du", "command -v du"}
+	Find        = Capability{"find", "find . -maxdepth 0 -printf ''"}
+	Gzip        = Capability{"gzip", "gzip --version"}
+	Zstd        = Capability{"zstd", "zstd --version"}
+	MariadbDump = Capability{"mariadb-dump", "mariadb-dump --version"}
+	Mysqldump   = Capability{"mysqldump", "mysqldump --version"}
+	Mysql       = Capability{"mysql", "mysql --version"}
+	Php         = Capability{"php", "php -v"}
+	WPCli       = Capability{"wp-cli", "wp --info"}
+	Sha256sum   = Capability{"sha256sum", "command -v sha256sum"}
+)
+
+// All are the capabilities that are probed, in the order they are reported.
+var All = []Capability{Tar, Du, Find, Gzip, Zstd, MariadbDump, Mysqldump, Mysql, Php, WPCli, Sha256sum}
+
+// Profile is what the server can run.
+type Profile struct {
+	// Shell is whether the server runs commands at all. Without a shell only SFTP can be used, and none of the tools are available.
+	Shell bool
+	// results are whether each probed command succeeded
+	results map[string]bool
+	// webroots are whether each webroot that was probed is writable
+	webroots map[types.PublicPath]bool
+}
+
+// Probe runs every check in a single shell script. A check that the script didn't report on, such as when its output was cut short, is
+// left to be run on its own later.
+func Probe(runner sftp.RemoteCommandRunner) *Profile {
+	p := &Profile{results: map[string]bool{}, webroots: map[types.PublicPath]bool{}}
+
+	byName := map[string]Capability{}
+	for _, c := range All {
+		byName[c.Name] = c
+	}
+
+	output, err := runner.RunRemoteCommand(ProbeScript())
+	if err == nil {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			name, result, _ := strings.Cut(scanner.Text(), " ")
+			if c, ok := byName[name]; ok {
+				p.Shell = true
+				p.results[c.Command] = result == "yes"
+			}
+		}
+	}
+
+	// Without a shell, none of the checks can succeed, so there is no need to run them later
+	if !p.Shell {
+		verbose.Printf("the server doesn't run commands, only SFTP is available")
+		for _, c := range All {
+			p.results[c.Command] = false
+		}
+	}
+
+	return p
+}
+
+// ProbeScript checks each of the capabilities in turn, and prints its name followed by yes or no.
+func ProbeScript() string {
+	var checks []string
+	for _, c := range All {
+		checks = append(checks, fmt.Sprintf("if %s >/dev/null 2>&1; then echo '%s yes'; else echo '%s no'; fi", c.Command, c.Name, c.Name))
+	}
+
+	return strings.Join(checks, "; ")
+}
+
+// Has reports whether the server has the capability.
+func (p *Profile) Has(c Capability) bool {
+	return p.results[c.Command]
+}
+
+// DumpProgram returns the dump program that the database would be exported with, which is empty when the server has neither.
+func (p *Profile) DumpProgram() string {
+	for _, c := range []Capability{MariadbDump, Mysqldump} {
+		if p.Has(c) {
+			return c.Name
+		}
+	}
+
+	return ""
+}
+
+// ProbeWebroot checks whether the scripts that wp-zip deploys can be written to the webroot, the first time it is asked about the webroot.
+// Without a shell it can't be checked, and it is assumed to be writable, since the scripts are uploaded over SFTP anyway.
+func (p *Profile) ProbeWebroot(runner sftp.RemoteCommandRunner, publicPath types.PublicPath) bool {
+	writable, ok := p.webroots[publicPath]
+	if !ok {
+		writable = !p.Shell || runner.CanRunRemoteCommand("test -w "+sftp.ShellQuote(string(publicPath)))
+		p.webroots[publicPath] = writable
+	}
+
+	return writable
+}
+
+// Client answers the checks for the probed capabilities from the profile, and passes everything else on to the server.
+type Client struct {
+	sftp.Client
+	profile *Profile
+}
+
+// NewClient is the constructor for Client.
+func NewClient(client sftp.Client, profile *Profile) *Client {
+	return &Client{client, profile}
+}
+
+func (c *Client) CanRunRemoteCommand(command string) bool {
+	if result, ok := c.profile.results[command]; ok {
+		return result
+	}
+
+	return c.Client.CanRunRemoteCommand(command)
+}
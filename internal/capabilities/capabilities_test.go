@@ -0,0 +1,111 @@
+package capabilities
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	t.Run("it reads every capability from a single script", func(t *testing.T) {
+		runner := &RunnerSpy{outputs: map[string]string{ProbeScript(): "tar yes\ndu yes\ngzip no\nmysqldump yes\nmariadb-dump no\nphp yes\n"}}
+
+		p := Probe(runner)
+
+		if !p.Shell {
+			t.Errorf("got no shell; want one")
+		}
+		for c, want := range map[Capability]bool{Tar: true, Du: true, Gzip: false, Mysqldump: true, Php: true, WPCli: false} {
+			if got := p.Has(c); got != want {
+				t.Errorf("got %s %v; want %v", c.Name, got, want)
+			}
+		}
+		if got := p.DumpProgram(); got != "mysqldump" {
+			t.Errorf("got dump program %q; want mysqldump", got)
+		}
+		if len(runner.commands) != 1 {
+			t.Errorf("got %d commands run; want 1", len(runner.commands))
+		}
+	})
+
+	t.Run("it has nothing without a shell", func(t *testing.T) {
+		runner := &RunnerSpy{outputs: map[string]string{}}
+
+		p := Probe(runner)
+		client := NewClient(runner, p)
+
+		if p.Shell {
+			t.Errorf("got a shell; want none")
+		}
+		if client.CanRunRemoteCommand("tar --version") {
+			t.Errorf("got tar; want none")
+		}
+		if len(runner.commands) != 1 {
+			t.Errorf("got commands %v; want only the probe", runner.commands)
+		}
+		if !p.ProbeWebroot(runner, "public") {
+			t.Errorf("got a webroot that isn't writable; want it assumed to be writable")
+		}
+	})
+}
+
+func TestClient(t *testing.T) {
+	runner := &RunnerSpy{outputs: map[string]string{ProbeScript(): "tar yes\nmysql no\n", "test -f 'wp-config.php'": ""}}
+	client := NewClient(runner, Probe(runner))
+
+	if !client.CanRunRemoteCommand("tar --version") {
+		t.Errorf("got no tar; want tar")
+	}
+	if client.CanRunRemoteCommand("mysql --version") {
+		t.Errorf("got mysql; want none")
+	}
+	// Neither a command that wasn't probed, nor one the script didn't report on, is answered by the profile
+	if !client.CanRunRemoteCommand("test -f 'wp-config.php'") {
+		t.Errorf("got no wp-config.php; want it")
+	}
+	client.CanRunRemoteCommand("php -v")
+
+	want := []string{ProbeScript(), "test -f 'wp-config.php'", "php -v"}
+	if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got commands %q; want %q", runner.commands, want)
+	}
+}
+
+func TestProfile_ProbeWebroot(t *testing.T) {
+	runner := &RunnerSpy{outputs: map[string]string{ProbeScript(): "tar yes\n", "test -w 'public'": ""}}
+	p := Probe(runner)
+
+	if !p.ProbeWebroot(runner, "public") || !p.ProbeWebroot(runner, "public") {
+		t.Errorf("got a webroot that isn't writable; want it writable")
+	}
+	if p.ProbeWebroot(runner, "other") {
+		t.Errorf("got a writable webroot; want it not writable")
+	}
+	if len(runner.commands) != 3 {
+		t.Errorf("got commands %q; want each webroot checked once", runner.commands)
+	}
+}
+
+// RunnerSpy runs the commands that have an output, and records every command it is asked to run.
+type RunnerSpy struct {
+	sftp.Client
+	outputs  map[string]string
+	commands []string
+}
+
+func (r *RunnerSpy) CanRunRemoteCommand(command string) bool {
+	r.commands = append(r.commands, command)
+	_, ok := r.outputs[command]
+	return ok
+}
+
+func (r *RunnerSpy) RunRemoteCommand(command string) (io.Reader, error) {
+	r.commands = append(r.commands, command)
+	output, ok := r.outputs[command]
+	if !ok {
+		return nil, errors.New("command not found")
+	}
+	return strings.NewReader(output), nil
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// Compression is the encoding the dump is stored with in the archive.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// ParseCompression returns the compression with the given name. An empty name is no compression.
+func ParseCompression(name string) (Compression, error) {
+	switch Compression(name) {
+	case "":
+		return CompressionNone, nil
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return Compression(name), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownCompression, name)
+}
+
+// Enabled reports whether the dump is compressed at all. The zero value is no compression.
+func (c Compression) Enabled() bool {
+	return c == CompressionGzip || c == CompressionZstd
+}
+
+// Extension is added to the name of the dump file.
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+
+	return ""
+}
+
+// command is what the dump is piped through to compress it on the remote server.
+func (c Compression) command() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip -c"
+	case CompressionZstd:
+		return "zstd -c -q"
+	}
+
+	return ""
+}
+
+// AvailableRemotely reports whether the remote server can compress the dump itself, which also saves on the transfer.
+func (c Compression) AvailableRemotely(runner sftp.RemoteCommandRunner) bool {
+	capability, ok := c.Capability()
+	return ok && runner.CanRunRemoteCommand(capability.Command)
+}
+
+// Capability returns the program that compresses the dump on the server, which is probed along with the other tools.
+func (c Compression) Capability() (capabilities.Capability, bool) {
+	switch c {
+	case CompressionGzip:
+		return capabilities.Gzip, true
+	case CompressionZstd:
+		return capabilities.Zstd, true
+	}
+
+	return capabilities.Capability{}, false
+}
+
+// Compress compresses the reader locally.
+func (c Compression) Compress(r io.Reader) io.Reader {
+	if !c.Enabled() {
+		return r
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		w, err := c.newWriter(writer)
+		if err == nil {
+			_, err = io.Copy(w, r)
+			if errC := w.Close(); err == nil {
+				err = errC
+			}
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+// Decompress returns a reader of the uncompressed contents.
+func (c Compression) Decompress(r io.Reader) (io.Reader, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+
+	return r, nil
+}
+
+func (c Compression) newWriter(w io.Writer) (io.WriteCloser, error) {
+	if c == CompressionZstd {
+		return zstd.NewWriter(w)
+	}
+
+	return gzip.NewWriter(w), nil
+}
+
+// EncodedReader is a dump that has already been compressed, on the remote server.
+type EncodedReader struct {
+	io.Reader
+	Encoding Compression
+}
+
+// Close closes the underlying reader, when it can be closed.
+func (r *EncodedReader) Close() error {
+	if c, ok := r.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
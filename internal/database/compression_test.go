@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCompression(t *testing.T) {
+	for name, want := range map[string]Compression{"": CompressionNone, "none": CompressionNone, "gzip": CompressionGzip, "zstd": CompressionZstd} {
+		if got, err := ParseCompression(name); got != want || err != nil {
+			t.Errorf("got %s, %v; want %s", got, err, want)
+		}
+	}
+
+	if _, err := ParseCompression("bzip2"); !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("got error %v; want ErrUnknownCompression", err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(compression)+" reads back concatenated streams as one", func(t *testing.T) {
+			r := io.MultiReader(compression.Compress(strings.NewReader("first\n")), compression.Compress(strings.NewReader("second\n")))
+
+			d, err := compression.Decompress(r)
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+			b, err := io.ReadAll(d)
+
+			if err != nil || string(b) != "first\nsecond\n" {
+				t.Errorf("got %q, %v; want both streams", string(b), err)
+			}
+		})
+	}
+
+	t.Run("no compression leaves the reader alone", func(t *testing.T) {
+		r := strings.NewReader("dump")
+
+		if CompressionNone.Compress(r) != r || CompressionNone.Extension() != "" {
+			t.Errorf("got a compressed reader; want the same reader")
+		}
+	})
+}
+
+func TestMysqlCli_RunCompressed(t *testing.T) {
+	c := &MockCommandRunner{}
+
+	r, _ := (&MysqlCli{c, DatabaseCredentials{Name: "Dbname"}, randomStub}).RunCompressed(CompressionGzip, "mysqldump", "--quick")
+
+	want := `(set -o pipefail) 2>/dev/null && set -o pipefail; trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysqldump --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --quick 'Dbname' | gzip -c`
+	if len(c.commandsRun) != 1 || c.commandsRun[0] != want {
+		t.Errorf("got commands %v; want %s", c.commandsRun, want)
+	}
+	if encoded, ok := r.(*EncodedReader); !ok || encoded.Encoding != CompressionGzip {
+		t.Errorf("got %T; want a gzip EncodedReader", r)
+	}
+}
+
+func TestDumpScanner_ScanEncoded(t *testing.T) {
+	t.Run("it passes the compressed dump through and scans a decompressed copy", func(t *testing.T) {
+		compressed, _ := io.ReadAll(CompressionZstd.Compress(strings.NewReader(mysqldumpSample)))
+		s := NewDumpScanner()
+
+		b, _ := io.ReadAll(s.ScanEncoded(&EncodedReader{strings.NewReader(string(compressed)), CompressionZstd}))
+		s.Wait()
+
+		if string(b) != string(compressed) {
+			t.Errorf("got a different stream; want the compressed dump as is")
+		}
+		if !s.Complete() || s.Rows["wp_options"] != 4 {
+			t.Errorf("got complete %v and rows %v; want the dump to be scanned", s.Complete(), s.Rows)
+		}
+	})
+
+	t.Run("it stops scanning a dump that is closed before it is read to the end", func(t *testing.T) {
+		compressed, _ := io.ReadAll(CompressionGzip.Compress(strings.NewReader(mysqldumpSample)))
+		s := NewDumpScanner()
+
+		r := s.ScanEncoded(&EncodedReader{strings.NewReader(string(compressed)), CompressionGzip})
+		r.Read(make([]byte, 10))
+		r.(io.Closer).Close()
+
+		done := make(chan bool)
+		go func() {
+			s.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("got the scan still running; want it stopped")
+		}
+		if s.Complete() {
+			t.Errorf("got complete; want incomplete")
+		}
+	})
+
+	t.Run("a corrupt dump is not complete", func(t *testing.T) {
+		s := NewDumpScanner()
+
+		io.ReadAll(s.ScanEncoded(&EncodedReader{strings.NewReader("not gzip at all"), CompressionGzip}))
+		s.Wait()
+
+		if s.Complete() {
+			t.Errorf("got complete; want incomplete")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrAccessDenied       = errors.New("access denied, the database user or password is wrong")
+	ErrUnknownDatabase    = errors.New("the database does not exist")
+	ErrCannotConnect      = errors.New("cannot connect to the database")
+	ErrConnectionUntested = errors.New("could not test the database connection")
+)
+
+// The MySQL error codes that say what was wrong with the credentials.
+const (
+	ER_DBACCESS_DENIED_ERROR = 1044
+	ER_ACCESS_DENIED_ERROR   = 1045
+	ER_BAD_DB_ERROR          = 1049
+)
+
+var mysqlClientError = regexp.MustCompile(`ERROR (\d+)`)
+
+// ConnectionTester checks that the credentials work before anything is exported, so that a wrong password is reported right away instead
+// of as a failed dump.
+type ConnectionTester struct {
+	c           sftp.CommandRunnerUploader
+	p           types.PublicPath
+	siteUrl     types.SiteUrl
+	g           HttpGetter
+	credentials DatabaseCredentials
+	random      func() string
+}
+
+// NewConnectionTester is the constructor for ConnectionTester.
+func NewConnectionTester(c sftp.CommandRunnerUploader, p types.PublicPath, siteUrl types.SiteUrl, g HttpGetter, credentials DatabaseCredentials) *ConnectionTester {
+	return &ConnectionTester{c, p, siteUrl, g, credentials, phpscript.RandomName}
+}
+
+// Test connects to the database with the mysql client when the server has it, and with a PHP probe otherwise. A bad user or password is
+// ErrAccessDenied and a database that doesn't exist is ErrUnknownDatabase. ErrConnectionUntested means the connection couldn't be tried
+// at all, which says nothing about the credentials.
+func (t *ConnectionTester) Test() error {
+	if t.c.CanRunRemoteCommand(capabilities.Mysql.Command) {
+		return t.testWithCli()
+	}
+
+	return t.testWithPHP()
+}
+
+func (t *ConnectionTester) testWithCli() error {
+	output, err := NewMysqlCli(t.c, t.credentials).Run("mysql", `--skip-column-names --silent -e "SELECT 1"`)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrConnectionUntested, err)
+	}
+	// The client's error message is only known once the command has failed, which is when the output ends
+	if _, err = io.ReadAll(output); err == nil {
+		return nil
+	}
+
+	matches := mysqlClientError.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return fmt.Errorf("%w: %s", ErrCannotConnect, err)
+	}
+	code, _ := strconv.Atoi(matches[1])
+
+	return connectionError(code, err.Error())
+}
+
+func (t *ConnectionTester) testWithPHP() error {
+	deployment, err := phpscript.NewDeployer(t.c, t.p, t.random).Deploy(getConnectionProbeContents(t.credentials), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrConnectionUntested, err)
+	}
+	defer deployment.Remove()
+
+	resp, err := deployment.Execute(t.c, t.g, t.siteUrl, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrConnectionUntested, err)
+	}
+	defer resp.Close()
+
+	var result struct {
+		Errno int    `json:"errno"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp).Decode(&result); err != nil {
+		return fmt.Errorf("%w: unexpected response from the probe: %s", ErrConnectionUntested, err)
+	}
+
+	return connectionError(result.Errno, result.Error)
+}
+
+// connectionError turns a MySQL error code into the error that explains it, and is nil when there was no error.
+func connectionError(code int, message string) error {
+	message = strings.TrimSpace(message)
+
+	switch code {
+	case 0:
+		return nil
+	case ER_ACCESS_DENIED_ERROR, ER_DBACCESS_DENIED_ERROR:
+		return fmt.Errorf("%w: %s", ErrAccessDenied, message)
+	case ER_BAD_DB_ERROR:
+		return fmt.Errorf("%w: %s", ErrUnknownDatabase, message)
+	}
+
+	return fmt.Errorf("%w: %s", ErrCannotConnect, message)
+}
+
+func getConnectionProbeContents(creds DatabaseCredentials) string {
+	return fmt.Sprintf(`<?php
+
+// Connection errors are reported through mysqli_connect_errno() instead of as warnings or exceptions
+mysqli_report(MYSQLI_REPORT_OFF);
+$link = @%s;
+
+header('Content-Type: application/json');
+echo json_encode(['errno' => mysqli_connect_errno(), 'error' => mysqli_connect_error()]);
+if ($link) {
+    mysqli_close($link);
+}
+`, PhpMysqliConnect(creds))
+}
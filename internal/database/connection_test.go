@@ -0,0 +1,97 @@
+package database
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestConnectionTester_Test(t *testing.T) {
+	var tests = []struct {
+		name    string
+		runner  *ConnectionRunnerStub
+		wantErr error
+	}{
+		{
+			"mysql client connects",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "1\n"},
+			nil,
+		},
+		{
+			"mysql client bad password",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, err: errors.New("exit status 1: ERROR 1045 (28000): Access denied for user 'user'@'localhost' (using password: YES)")},
+			ErrAccessDenied,
+		},
+		{
+			"mysql client unknown database",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, err: errors.New("exit status 1: ERROR 1049 (42000): Unknown database 'db'")},
+			ErrUnknownDatabase,
+		},
+		{
+			"mysql client cannot reach the server",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, err: errors.New("exit status 1: ERROR 2002 (HY000): Can't connect to local MySQL server through socket")},
+			ErrCannotConnect,
+		},
+		{
+			"php probe connects",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"errno":0,"error":null}`},
+			nil,
+		},
+		{
+			"php probe bad password",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"errno":1045,"error":"Access denied for user 'user'@'localhost'"}`},
+			ErrAccessDenied,
+		},
+		{
+			"php probe unknown database",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"errno":1049,"error":"Unknown database 'db'"}`},
+			ErrUnknownDatabase,
+		},
+		{
+			"php probe cannot run",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: "<html>Fatal error</html>"},
+			ErrConnectionUntested,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := NewConnectionTester(tt.runner, "public", "https://localhost", nil, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"})
+			tester.random = randomStub
+
+			err := tester.Test()
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// ConnectionRunnerStub can only run the one program, and any command it runs outputs the same thing before failing with the error.
+type ConnectionRunnerStub struct {
+	canRun string
+	output string
+	err    error
+}
+
+func (r *ConnectionRunnerStub) CanRunRemoteCommand(command string) bool {
+	return command == r.canRun
+}
+
+func (r *ConnectionRunnerStub) RunRemoteCommand(command string) (io.Reader, error) {
+	if r.err != nil {
+		return io.MultiReader(strings.NewReader(r.output), iotest.ErrReader(r.err)), nil
+	}
+	return strings.NewReader(r.output), nil
+}
+
+func (r *ConnectionRunnerStub) Upload(src io.Reader, dst string) error   { return nil }
+func (r *ConnectionRunnerStub) Delete(dst string) error                  { return nil }
+func (r *ConnectionRunnerStub) Mkdir(dst string) error                   { return nil }
+func (r *ConnectionRunnerStub) Chmod(dst string, mode os.FileMode) error { return nil }
+func (r *ConnectionRunnerStub) Getwd() (string, error)                   { return "/home/user", nil }
@@ -1,32 +1,114 @@
 package database
 
 import (
+	"errors"
+	"fmt"
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
 	"io"
+	"log"
+	"net/http"
 )
 
+// DatabaseCredentials are what we need to connect to the site's database. The DB_HOST value from wp-config.php is split into its host,
+// port and socket parts, since every client we use takes them separately.
 type DatabaseCredentials struct {
-	User string
-	Pass string
-	Name string
-	Host string
+	User   string
+	Pass   string
+	Name   string
+	Host   string
+	Port   string
+	Socket string
+	// Charset is DB_CHARSET, which is empty if the site leaves it to the server
+	Charset string
+}
+
+// ExportOptions control how the database is exported.
+type ExportOptions struct {
+	// Concurrency is the number of tables dumped at once. Anything above 1 dumps each table over its own SSH session.
+	Concurrency int
+	// PerTableFiles writes each table to its own database/<table>.sql file instead of a single database.sql.
+	PerTableFiles bool
+	// Profile selects the mysqldump options, the zero value is the consistent profile
+	Profile DumpProfile
+	// ExtraArgs are passed through to mysqldump as is
+	ExtraArgs string
+	// Verify selects what happens when the dump does not look complete, the zero value only warns
+	Verify VerifyMode
+	// Compression is the encoding the dump is stored with, the zero value stores it uncompressed
+	Compression Compression
+	// Preferred is tried before the exporter that is detected, such as the one using WP-CLI. It is skipped for a parallel export.
+	Preferred DatabaseExporter
+	// Subsite is the subsite of a multisite network to export as a single site, or nil to export the whole database
+	Subsite *types.Extraction
+}
+
+// Parallel reports whether the options ask for the tables to be dumped one by one.
+func (o ExportOptions) Parallel() bool {
+	return o.Concurrency > 1 || o.PerTableFiles
 }
 
 type HttpGetter interface {
-	Get(url string) (resp io.ReadCloser, err error)
+	Get(url string, header http.Header) (resp io.ReadCloser, err error)
 }
 
 type DatabaseExporter interface {
 	Export() (io.Reader, error)
 }
 
-// NewDatabaseExporter is a factory function that returns a DatabaseExporter. It detects at runtime whether the remote server supports `mysqldump` or not, and returns the appropriate exporter.
-func NewDatabaseExporter(c sftp.Client, p types.PublicPath, u types.SiteUrl, g HttpGetter, e emitter.FileEmitter, creds DatabaseCredentials) DatabaseExporter {
-	if c.CanRunRemoteCommand("mysqldump --version") {
-		return &MysqldumpDatabaseExporter{c, creds}
+// NewDatabaseExporter is a factory function that returns a DatabaseExporter. It detects at runtime whether the remote server supports `mysqldump` (or MariaDB's `mariadb-dump`) or not, and returns the appropriate exporter.
+// Without `mysqldump`, the PHP exporter is tried first, and the native exporter is used if PHP can't be reached. A parallel export is only
+// possible with `mysqldump`, so the options asking for one are ignored otherwise. The dump program is only detected here, and the exporter
+// is given the one that was found.
+func NewDatabaseExporter(c sftp.Client, p types.PublicPath, u types.SiteUrl, g HttpGetter, e emitter.FileEmitter, creds DatabaseCredentials, opts ExportOptions) DatabaseExporter {
+	if opts.Preferred != nil && !opts.Parallel() {
+		preferred := opts.Preferred
+		opts.Preferred = nil
+		return NewFallbackDatabaseExporter(preferred, NewDatabaseExporter(c, p, u, g, e, creds, opts))
+	}
+
+	if program, err := DetectDumpProgram(c); err == nil {
+		verbose.Printf("exporting the database with %s", program)
+		if opts.Parallel() {
+			return &ParallelMysqldumpDatabaseExporter{c, creds, program, opts, phpscript.RandomName}
+		}
+		return &MysqldumpDatabaseExporter{c, creds, program, opts, phpscript.RandomName}
+	}
+
+	if opts.Parallel() {
+		log.Println("mysqldump is not available on the server, the database will be exported as a single file")
+	}
+
+	return &FallbackDatabaseExporter{[]DatabaseExporter{
+		&PHPDatabaseExporter{c, p, u, g, e, creds, phpscript.RandomName},
+		&NativeDatabaseExporter{c, creds},
+	}}
+}
+
+// FallbackDatabaseExporter tries each of its exporters in order, and returns the output of the first one that succeeds.
+type FallbackDatabaseExporter struct {
+	exporters []DatabaseExporter
+}
+
+// NewFallbackDatabaseExporter is the constructor for FallbackDatabaseExporter.
+func NewFallbackDatabaseExporter(exporters ...DatabaseExporter) *FallbackDatabaseExporter {
+	return &FallbackDatabaseExporter{exporters}
+}
+
+func (e *FallbackDatabaseExporter) Export() (io.Reader, error) {
+	var errs []error
+	for _, exporter := range e.exporters {
+		r, err := exporter.Export()
+		if err == nil {
+			verbose.Printf("exporting the database with %T", exporter)
+			return r, nil
+		}
+		verbose.Printf("could not export the database with %T: %s", exporter, err)
+		errs = append(errs, err)
 	}
 
-	return &PHPDatabaseExporter{c, p, u, g, e, creds}
+	return nil, fmt.Errorf("no database exporter succeeded: %w", errors.Join(errs...))
 }
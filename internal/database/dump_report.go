@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// VerifyMode selects what happens when a dump does not look complete.
+type VerifyMode string
+
+const (
+	// VerifyWarn logs the problems and keeps the archive. It is the default.
+	VerifyWarn VerifyMode = "warn"
+	// VerifyStrict fails the export.
+	VerifyStrict VerifyMode = "strict"
+	// VerifyOff skips the verification.
+	VerifyOff VerifyMode = "off"
+)
+
+var ErrUnknownVerifyMode = errors.New("unknown verify mode")
+
+// ParseVerifyMode returns the mode with the given name. An empty name is the default mode.
+func ParseVerifyMode(name string) (VerifyMode, error) {
+	switch VerifyMode(name) {
+	case "":
+		return VerifyWarn, nil
+	case VerifyWarn, VerifyStrict, VerifyOff:
+		return VerifyMode(name), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownVerifyMode, name)
+}
+
+// DumpVerifier scans every file of a dump as it is written, and checks the result against the row counts of the database.
+type DumpVerifier struct {
+	counter  RowCounter
+	scanners map[string]*DumpScanner
+	order    []string
+}
+
+// NewDumpVerifier is the constructor for DumpVerifier.
+func NewDumpVerifier(counter RowCounter) *DumpVerifier {
+	return &DumpVerifier{counter: counter, scanners: map[string]*DumpScanner{}}
+}
+
+// Scan returns a reader that passes the named dump file through, scanning it on the way.
+func (v *DumpVerifier) Scan(name string, r io.Reader) io.Reader {
+	scanner := NewDumpScanner()
+	v.scanners[name] = scanner
+	v.order = append(v.order, name)
+
+	if encoded, ok := r.(*EncodedReader); ok {
+		return scanner.ScanEncoded(encoded)
+	}
+
+	return scanner.Scan(r)
+}
+
+// TableReport compares the rows dumped for a single table with the rows the table holds.
+type TableReport struct {
+	Name   string `json:"name"`
+	Dumped int64  `json:"dumped"`
+	// Expected is nil when the table could not be counted
+	Expected *int64 `json:"expected"`
+	// Exact is false when Expected is only the estimate from information_schema
+	Exact bool `json:"exact"`
+	Ok    bool `json:"ok"`
+}
+
+// DumpReport is the result of verifying a dump. It is written into the archive next to the dump.
+type DumpReport struct {
+	// Complete is whether every dump file ends with the trailer
+	Complete bool          `json:"complete"`
+	Tables   []TableReport `json:"tables"`
+	// Problems mean the dump is most likely incomplete
+	Problems []string `json:"problems"`
+	// Warnings are about what could not be checked
+	Warnings []string `json:"warnings"`
+}
+
+// Ok reports whether no problems were found.
+func (r DumpReport) Ok() bool {
+	return len(r.Problems) == 0
+}
+
+// Report counts the rows in the database and compares them with what was scanned. It should only be called once every file has been read.
+func (v *DumpVerifier) Report() DumpReport {
+	report := DumpReport{Complete: true, Tables: []TableReport{}, Problems: []string{}, Warnings: []string{}}
+
+	if len(v.order) == 0 {
+		report.Complete = false
+		report.Problems = append(report.Problems, "no dump was written")
+	}
+
+	dumped := map[string]int64{}
+	created := map[string]bool{}
+	for _, name := range v.order {
+		scanner := v.scanners[name]
+		scanner.Wait()
+		if !scanner.Complete() {
+			report.Complete = false
+			report.Problems = append(report.Problems, fmt.Sprintf("%s does not end with the %q trailer, it was probably cut off", name, DUMP_TRAILER))
+		}
+		for table, rows := range scanner.Rows {
+			dumped[table] += rows
+		}
+		for table := range scanner.Tables {
+			created[table] = true
+		}
+	}
+
+	counts, err := v.counter.CountRows()
+	if err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("could not count the rows in the database: %s", err))
+	}
+
+	// Every table that is either in the database or in the dump
+	var tables []string
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	for table := range created {
+		if _, ok := counts[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		count, counted := counts[table]
+		t := TableReport{Name: table, Dumped: dumped[table], Ok: true}
+		if counted {
+			t.Expected = &count.Rows
+			t.Exact = count.Exact
+		}
+
+		switch {
+		case !counted:
+			// Nothing to compare with
+		case !created[table]:
+			t.Ok = false
+			report.Problems = append(report.Problems, fmt.Sprintf("table %s is missing from the dump", table))
+		case count.Exact && t.Dumped != count.Rows:
+			t.Ok = false
+			report.Problems = append(report.Problems, fmt.Sprintf("table %s has %d rows but %d were dumped", table, count.Rows, t.Dumped))
+		case !count.Exact && t.Dumped < count.Rows/2:
+			// The estimate can be far off, so only a dump with far fewer rows is suspicious
+			t.Ok = false
+			report.Problems = append(report.Problems, fmt.Sprintf("table %s has about %d rows but only %d were dumped", table, count.Rows, t.Dumped))
+		}
+
+		report.Tables = append(report.Tables, t)
+	}
+
+	return report
+}
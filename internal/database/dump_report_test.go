@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDumpVerifier_Report(t *testing.T) {
+	t.Run("it compares the dumped rows with the counts", func(t *testing.T) {
+		v := NewDumpVerifier(&RowCounterStub{counts: map[string]TableRowCount{
+			"wp_options": {Rows: 4, Exact: true},
+			"wp_posts":   {Rows: 3, Exact: true},
+		}})
+		io.ReadAll(v.Scan("database.sql", strings.NewReader(mysqldumpSample)))
+
+		report := v.Report()
+
+		if report.Ok() || !report.Complete {
+			t.Errorf("got %+v; want a complete dump with a problem", report)
+		}
+		if len(report.Problems) != 1 || report.Problems[0] != "table wp_posts has 3 rows but 2 were dumped" {
+			t.Errorf("got problems %v; want the wp_posts mismatch", report.Problems)
+		}
+		if len(report.Tables) != 2 || !report.Tables[0].Ok || report.Tables[1].Ok {
+			t.Errorf("got tables %+v; want wp_options ok and wp_posts not", report.Tables)
+		}
+	})
+
+	t.Run("it only flags estimates that are far off", func(t *testing.T) {
+		v := NewDumpVerifier(&RowCounterStub{counts: map[string]TableRowCount{
+			"wp_options": {Rows: 6},
+			"wp_posts":   {Rows: 10},
+		}})
+		io.ReadAll(v.Scan("database.sql", strings.NewReader(mysqldumpSample)))
+
+		report := v.Report()
+
+		if len(report.Problems) != 1 || report.Problems[0] != "table wp_posts has about 10 rows but only 2 were dumped" {
+			t.Errorf("got problems %v; want only the wp_posts estimate", report.Problems)
+		}
+	})
+
+	t.Run("it reports missing tables and a missing trailer", func(t *testing.T) {
+		v := NewDumpVerifier(&RowCounterStub{counts: map[string]TableRowCount{
+			"wp_options": {Rows: 4, Exact: true},
+			"wp_users":   {Rows: 1, Exact: true},
+		}})
+		io.ReadAll(v.Scan("database.sql", strings.NewReader(strings.Split(mysqldumpSample, "UNLOCK TABLES;")[0])))
+
+		report := v.Report()
+
+		want := []string{
+			`database.sql does not end with the "-- Dump completed" trailer, it was probably cut off`,
+			"table wp_users is missing from the dump",
+		}
+		if report.Complete || strings.Join(report.Problems, "\n") != strings.Join(want, "\n") {
+			t.Errorf("got problems %v; want %v", report.Problems, want)
+		}
+	})
+
+	t.Run("it only warns when the rows cannot be counted", func(t *testing.T) {
+		v := NewDumpVerifier(&RowCounterStub{err: errors.New("access denied")})
+		io.ReadAll(v.Scan("database.sql", strings.NewReader(mysqldumpSample)))
+
+		report := v.Report()
+
+		if !report.Ok() || len(report.Warnings) != 1 {
+			t.Errorf("got %+v; want no problems and a warning", report)
+		}
+		if len(report.Tables) != 2 || report.Tables[0].Expected != nil || report.Tables[0].Dumped != 4 {
+			t.Errorf("got tables %+v; want the dumped tables without counts", report.Tables)
+		}
+	})
+}
+
+func TestParseVerifyMode(t *testing.T) {
+	for name, want := range map[string]VerifyMode{"": VerifyWarn, "warn": VerifyWarn, "strict": VerifyStrict, "off": VerifyOff} {
+		if got, err := ParseVerifyMode(name); got != want || err != nil {
+			t.Errorf("got %s, %v; want %s", got, err, want)
+		}
+	}
+
+	if _, err := ParseVerifyMode("loud"); !errors.Is(err, ErrUnknownVerifyMode) {
+		t.Errorf("got error %v; want ErrUnknownVerifyMode", err)
+	}
+}
+
+func TestSubsiteRowCounter_CountRows(t *testing.T) {
+	counter := NewSubsiteRowCounter(&RowCounterStub{counts: map[string]TableRowCount{
+		"wp_blogs":   {Rows: 3, Exact: true},
+		"wp_options": {Rows: 4, Exact: true},
+		"wp_2_posts": {Rows: 5, Exact: true},
+		"wp_3_posts": {Rows: 6, Exact: true},
+		"wp_users":   {Rows: 7, Exact: true},
+	}}, types.Extraction{Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_"})
+
+	counts, err := counter.CountRows()
+
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	if len(counts) != 2 || counts["wp_2_posts"].Rows != 5 || counts["wp_users"].Rows != 7 {
+		t.Errorf("got counts %v; want only wp_2_posts and wp_users", counts)
+	}
+}
+
+func TestCountRows(t *testing.T) {
+	t.Run("it counts the smaller tables exactly", func(t *testing.T) {
+		var queries []string
+		counts, err := countRows(func(query string) ([][]string, error) {
+			queries = append(queries, query)
+			if query == SELECT_TABLE_ROWS_STMT {
+				return [][]string{{"wp_options", "120"}, {"wp_big", "9000000"}, {"wp_myisam", "NULL"}}, nil
+			}
+			return [][]string{{"wp_options", "118"}, {"wp_myisam", "3"}}, nil
+		})
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		want := "SELECT 'wp_options', COUNT(*) FROM `wp_options` UNION ALL SELECT 'wp_myisam', COUNT(*) FROM `wp_myisam`;"
+		if len(queries) != 2 || queries[1] != want {
+			t.Errorf("got queries %v; want %s", queries, want)
+		}
+		if counts["wp_options"] != (TableRowCount{118, true}) || counts["wp_big"] != (TableRowCount{9000000, false}) || counts["wp_myisam"] != (TableRowCount{3, true}) {
+			t.Errorf("got counts %v", counts)
+		}
+	})
+
+	t.Run("the mysql client runs the queries quoted for the shell", func(t *testing.T) {
+		c := &MockCommandRunner{commandsThatExist: map[string]string{
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --skip-column-names --silent -e ` + sftp.ShellQuote(SELECT_TABLE_ROWS_STMT) + ` 'Dbname'`:                    "wp_options\t1\n",
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --skip-column-names --silent -e 'SELECT '\''wp_options'\'', COUNT(*) FROM ` + "`wp_options`" + `;' 'Dbname'`: "wp_options\t2\n",
+		}}
+
+		counts, err := (&CliRowCounter{&MysqlCli{c, DatabaseCredentials{Name: "Dbname"}, randomStub}}).CountRows()
+
+		if err != nil || counts["wp_options"] != (TableRowCount{2, true}) {
+			t.Errorf("got %v, %v; want wp_options with 2 rows; ran %v", counts, err, c.commandsRun)
+		}
+	})
+}
+
+type RowCounterStub struct {
+	counts map[string]TableRowCount
+	err    error
+}
+
+func (c *RowCounterStub) CountRows() (map[string]TableRowCount, error) {
+	return c.counts, c.err
+}
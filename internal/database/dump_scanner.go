@@ -0,0 +1,310 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The comment that mysqldump, mysqldump-php and the native exporter all end a complete dump with.
+const DUMP_TRAILER = "-- Dump completed"
+
+// The most of a statement that is kept to recognise it. Only the part before the values is needed.
+const maxStatementPrefix = 64 * 1024
+
+var (
+	insertStatement      = regexp.MustCompile("(?is)^(?:INSERT|REPLACE)(?:\\s+(?:LOW_PRIORITY|DELAYED|HIGH_PRIORITY|IGNORE))*\\s+INTO\\s+`((?:[^`]|``)+)`")
+	createTableStatement = regexp.MustCompile("(?is)^CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?`((?:[^`]|``)+)`")
+	valuesKeyword        = regexp.MustCompile(`(?i)\bVALUES?\s*$`)
+)
+
+const (
+	scanNormal = iota
+	scanSingleQuote
+	scanDoubleQuote
+	scanBacktick
+	scanLineComment
+	scanBlockComment
+)
+
+const (
+	statementUnknown = iota
+	statementInsert
+	statementDelimiter
+	statementOther
+)
+
+// DumpScanner follows a SQL dump as it is being written, without holding on to it. It counts the rows inserted into each table, and
+// notices whether the dump ends with the trailer that is only written once a dump has completed.
+type DumpScanner struct {
+	// Rows is the number of rows inserted into each table
+	Rows map[string]int64
+	// Tables are the tables that were created
+	Tables map[string]bool
+
+	complete     bool
+	corrupt      bool
+	wg           sync.WaitGroup
+	state        int
+	escaped      bool
+	prev         byte
+	delimiter    string
+	delimMatched int
+	comment      []byte
+
+	statement []byte
+	kind      int
+	table     string
+	depth     int
+	sawValues bool
+}
+
+// NewDumpScanner is the constructor for DumpScanner.
+func NewDumpScanner() *DumpScanner {
+	return &DumpScanner{Rows: map[string]int64{}, Tables: map[string]bool{}, delimiter: ";"}
+}
+
+// Scan returns a reader that passes the dump through, scanning it on the way.
+func (s *DumpScanner) Scan(r io.Reader) io.Reader {
+	return io.TeeReader(r, s)
+}
+
+// ScanEncoded is Scan for a compressed dump. The dump is passed through as it is, while a copy of it is decompressed and scanned in the
+// background. Wait must be called before looking at the results, and the reader must be closed when it isn't read to the end, so that the
+// scan stops.
+func (s *DumpScanner) ScanEncoded(r *EncodedReader) io.Reader {
+	reader, writer := io.Pipe()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		d, err := r.Encoding.Decompress(reader)
+		if err == nil {
+			_, err = io.Copy(s, d)
+		}
+		if err != nil {
+			s.corrupt = true
+		}
+		// Keep reading whatever is left, so that the dump itself is never held up
+		io.Copy(io.Discard, reader)
+	}()
+
+	return &EncodedReader{&teeCloser{r.Reader, writer}, r.Encoding}
+}
+
+// Wait waits until a compressed dump has been scanned to the end.
+func (s *DumpScanner) Wait() {
+	s.wg.Wait()
+}
+
+// Complete reports whether everything scanned so far ends with the trailer.
+func (s *DumpScanner) Complete() bool {
+	if s.corrupt {
+		return false
+	}
+
+	// The trailer may be the very last line, without a newline after it
+	if s.state == scanLineComment && bytes.HasPrefix(s.comment, []byte(DUMP_TRAILER)) {
+		return true
+	}
+
+	return s.complete
+}
+
+func (s *DumpScanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.scan(b)
+	}
+
+	return len(p), nil
+}
+
+func (s *DumpScanner) scan(b byte) {
+	prev := s.prev
+	s.prev = b
+
+	switch s.state {
+	case scanSingleQuote, scanDoubleQuote:
+		if s.escaped {
+			s.escaped = false
+		} else if b == '\\' {
+			s.escaped = true
+		} else if (b == '\'' && s.state == scanSingleQuote) || (b == '"' && s.state == scanDoubleQuote) {
+			s.state = scanNormal
+		}
+		return
+	case scanBacktick:
+		s.keep(b)
+		if b == '`' {
+			s.state = scanNormal
+		}
+		return
+	case scanLineComment:
+		if b == '\n' {
+			if bytes.HasPrefix(s.comment, []byte(DUMP_TRAILER)) {
+				s.complete = true
+			}
+			s.state = scanNormal
+		} else if len(s.comment) < len(DUMP_TRAILER) {
+			s.comment = append(s.comment, b)
+		}
+		return
+	case scanBlockComment:
+		if prev == '*' && b == '/' {
+			s.state = scanNormal
+			// So that the closing slash can't also start a new comment
+			s.prev = 0
+		}
+		return
+	}
+
+	if !isSpace(b) {
+		s.complete = false
+	}
+
+	// Comments are only recognised at the start of a statement, which is the only place a dump puts them
+	if b == '-' && len(s.statement) == 1 && s.statement[0] == '-' {
+		s.resetStatement()
+		s.state = scanLineComment
+		s.comment = append(s.comment[:0], '-', '-')
+		return
+	}
+	if b == '*' && prev == '/' {
+		s.statement = bytes.TrimSuffix(s.statement, []byte("/"))
+		s.state = scanBlockComment
+		return
+	}
+
+	// The delimiter ends a statement. It is changed with the DELIMITER command around routines and triggers, which is a line of its own.
+	if s.kind == statementDelimiter {
+		if b == '\n' {
+			if fields := strings.Fields(string(s.statement)); len(fields) == 2 {
+				s.delimiter = fields[1]
+			}
+			s.resetStatement()
+			return
+		}
+		s.keep(b)
+		return
+	}
+	if b == s.delimiter[s.delimMatched] {
+		s.delimMatched++
+		if s.delimMatched == len(s.delimiter) {
+			s.resetStatement()
+		}
+		return
+	}
+	s.delimMatched = 0
+
+	switch b {
+	case '\'':
+		s.state = scanSingleQuote
+	case '"':
+		s.state = scanDoubleQuote
+	case '`':
+		s.state = scanBacktick
+		s.keep(b)
+	case '(':
+		s.openParen()
+		s.keep(b)
+	case ')':
+		s.depth--
+		s.keep(b)
+	default:
+		s.keep(b)
+	}
+}
+
+// openParen counts a row for each top level parenthesis after the VALUES keyword of an INSERT statement.
+func (s *DumpScanner) openParen() {
+	defer func() { s.depth++ }()
+
+	if s.depth != 0 {
+		return
+	}
+
+	if s.kind == statementUnknown {
+		s.kind = statementOther
+		if matches := insertStatement.FindSubmatch(s.statement); matches != nil {
+			s.kind = statementInsert
+			s.table = unquoteIdentifier(matches[1])
+		} else if matches := createTableStatement.FindSubmatch(s.statement); matches != nil {
+			s.Tables[unquoteIdentifier(matches[1])] = true
+		}
+	}
+
+	if s.kind != statementInsert {
+		return
+	}
+
+	if !s.sawValues {
+		s.sawValues = valuesKeyword.Match(s.statement)
+	}
+	if s.sawValues {
+		s.Rows[s.table]++
+	}
+}
+
+// keep adds to the statement, as long as it is still needed to recognise it.
+func (s *DumpScanner) keep(b byte) {
+	if s.sawValues || s.kind == statementOther || len(s.statement) >= maxStatementPrefix {
+		return
+	}
+	if len(s.statement) == 0 && isSpace(b) {
+		return
+	}
+	s.statement = append(s.statement, b)
+
+	if s.kind == statementUnknown && len(s.statement) == len("DELIMITER ") && strings.EqualFold(string(s.statement), "DELIMITER ") {
+		s.kind = statementDelimiter
+	}
+}
+
+func (s *DumpScanner) resetStatement() {
+	s.statement = s.statement[:0]
+	s.kind = statementUnknown
+	s.table = ""
+	s.depth = 0
+	s.sawValues = false
+	s.delimMatched = 0
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t'
+}
+
+func unquoteIdentifier(name []byte) string {
+	return strings.ReplaceAll(string(name), "``", "`")
+}
+
+// errNotReadToEnd stops the scan of a dump that was closed before it was read to the end.
+var errNotReadToEnd = errors.New("the dump was closed before it was read to the end")
+
+// teeCloser writes everything it reads into the pipe, and closes the pipe once it has read everything, or once it is closed.
+type teeCloser struct {
+	r io.Reader
+	w *io.PipeWriter
+}
+
+func (t *teeCloser) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	if err == io.EOF {
+		t.w.Close()
+	} else if err != nil {
+		t.w.CloseWithError(err)
+	}
+
+	return n, err
+}
+
+// Close stops the scan when the dump wasn't read to the end. Once it has been, the scan is left to finish.
+func (t *teeCloser) Close() error {
+	return t.w.CloseWithError(errNotReadToEnd)
+}
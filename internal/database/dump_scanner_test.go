@@ -0,0 +1,85 @@
+package database
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const mysqldumpSample = "-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)\n" +
+	"--\n" +
+	"-- Host: localhost    Database: wordpress\n" +
+	"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+	"DROP TABLE IF EXISTS `wp_options`;\n" +
+	"CREATE TABLE `wp_options` (\n" +
+	"  `option_id` bigint unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `option_value` longtext NOT NULL,\n" +
+	"  PRIMARY KEY (`option_id`)\n" +
+	") ENGINE=InnoDB;\n" +
+	"LOCK TABLES `wp_options` WRITE;\n" +
+	"/*!40000 ALTER TABLE `wp_options` DISABLE KEYS */;\n" +
+	"INSERT INTO `wp_options` VALUES (1,'a (tricky); value'),(2,'it\\'s \\\\'),(3,'-- not a comment');\n" +
+	"INSERT INTO `wp_options` VALUES (4,\"double (quoted)\");\n" +
+	"/*!40000 ALTER TABLE `wp_options` ENABLE KEYS */;\n" +
+	"UNLOCK TABLES;\n" +
+	"CREATE TABLE `wp_posts` (`ID` bigint) ENGINE=InnoDB;\n" +
+	"INSERT INTO `wp_posts` (`ID`) VALUES (1),(2);\n" +
+	"DELIMITER ;;\n" +
+	"CREATE PROCEDURE `p`() BEGIN INSERT INTO `wp_posts` VALUES (3); INSERT INTO `wp_posts` VALUES (4); END ;;\n" +
+	"DELIMITER ;\n" +
+	"/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;\n" +
+	"\n" +
+	"-- Dump completed on 2024-03-01 12:00:00\n"
+
+func TestDumpScanner(t *testing.T) {
+	t.Run("it counts the rows inserted into each table", func(t *testing.T) {
+		s := scanAll(mysqldumpSample)
+
+		if s.Rows["wp_options"] != 4 || s.Rows["wp_posts"] != 2 {
+			t.Errorf("got rows %v; want wp_options 4 and wp_posts 2", s.Rows)
+		}
+		if !s.Tables["wp_options"] || !s.Tables["wp_posts"] || len(s.Tables) != 2 {
+			t.Errorf("got tables %v; want wp_options and wp_posts", s.Tables)
+		}
+	})
+
+	t.Run("it knows whether the dump ends with the trailer", func(t *testing.T) {
+		var tests = []struct {
+			name     string
+			dump     string
+			complete bool
+		}{
+			{"complete", mysqldumpSample, true},
+			{"without a final newline", strings.TrimSuffix(mysqldumpSample, "\n"), true},
+			{"mysqldump-php", "INSERT INTO `t` VALUES (1);\n-- Dump completed on: Fri, 01 Mar 2024 12:00:00 +0000\n", true},
+			{"cut off", mysqldumpSample[:len(mysqldumpSample)/2], false},
+			{"an error after the trailer", mysqldumpSample + "<b>Fatal error</b>", false},
+			{"no trailer", "INSERT INTO `t` VALUES (1);\n", false},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if got := scanAll(test.dump).Complete(); got != test.complete {
+					t.Errorf("got %v; want %v", got, test.complete)
+				}
+			})
+		}
+	})
+
+	t.Run("it gives the same result however the stream is split", func(t *testing.T) {
+		s := NewDumpScanner()
+		for i := 0; i < len(mysqldumpSample); i += 7 {
+			s.Write([]byte(mysqldumpSample[i:min(i+7, len(mysqldumpSample))]))
+		}
+
+		if s.Rows["wp_options"] != 4 || !s.Complete() {
+			t.Errorf("got rows %v and complete %v; want 4 rows and complete", s.Rows, s.Complete())
+		}
+	})
+}
+
+func scanAll(dump string) *DumpScanner {
+	s := NewDumpScanner()
+	io.ReadAll(s.Scan(strings.NewReader(dump)))
+	return s
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+)
+
+var ErrCannotReadActiveExtensions = errors.New("cannot read the active plugins and themes")
+
+// This is the SQL statement used to read the active plugins and themes of a site, given its options table.
+const SELECT_ACTIVE_EXTENSIONS_STMT = "SELECT option_name, option_value FROM %s WHERE option_name IN ('active_plugins', 'template', 'stylesheet');"
+
+// This is the SQL statement used to read the plugins that are activated for every site of a multisite network, given its sitemeta table.
+const SELECT_NETWORK_PLUGINS_STMT = "SELECT meta_value FROM %s WHERE meta_key = 'active_sitewide_plugins';"
+
+// ActiveExtensions are the plugins and themes that a site has activated. Plugins are named by their file relative to the plugins
+// directory, such as akismet/akismet.php, and themes by their directory.
+type ActiveExtensions struct {
+	Plugins []string
+	// NetworkPlugins are activated for every site of a multisite network
+	NetworkPlugins []string
+	// Template is the parent theme when the active theme is a child theme, and the active theme otherwise
+	Template   string
+	Stylesheet string
+}
+
+// ExtensionsReader reads the active plugins and themes from the options of a site, with the mysql client or through the SSH tunnel. Only
+// the metadata needs them, so no PHP script is deployed into the webroot to read them.
+type ExtensionsReader struct {
+	*Querier
+	optionsTable string
+	// sitemetaTable is empty unless the site is part of a multisite network
+	sitemetaTable string
+}
+
+// NewExtensionsReader is the constructor for ExtensionsReader.
+func NewExtensionsReader(c sftp.CommandRunnerUploader, credentials DatabaseCredentials, optionsTable, sitemetaTable string) *ExtensionsReader {
+	return &ExtensionsReader{&Querier{c: c, credentials: credentials, random: phpscript.RandomName}, optionsTable, sitemetaTable}
+}
+
+// ReadActiveExtensions reads the active_plugins, template and stylesheet options, and the active_sitewide_plugins of the network.
+func (r *ExtensionsReader) ReadActiveExtensions() (ActiveExtensions, error) {
+	var active ActiveExtensions
+
+	rows, err := r.Query(fmt.Sprintf(SELECT_ACTIVE_EXTENSIONS_STMT, QuoteIdentifier(r.optionsTable)), 2)
+	if err != nil {
+		return active, fmt.Errorf("%w: %s", ErrCannotReadActiveExtensions, err)
+	}
+	for _, row := range rows {
+		switch row[0] {
+		case "active_plugins":
+			active.Plugins, _ = serializedStrings(row[1])
+		case "template":
+			active.Template = row[1]
+		case "stylesheet":
+			active.Stylesheet = row[1]
+		}
+	}
+
+	if r.sitemetaTable == "" {
+		return active, nil
+	}
+	rows, err = r.Query(fmt.Sprintf(SELECT_NETWORK_PLUGINS_STMT, QuoteIdentifier(r.sitemetaTable)), 1)
+	if err != nil {
+		return active, fmt.Errorf("%w: %s", ErrCannotReadActiveExtensions, err)
+	}
+	// The network plugins are the keys of an array, whose values are when each was activated
+	for _, row := range rows {
+		active.NetworkPlugins, _ = serializedStrings(row[0])
+	}
+
+	return active, nil
+}
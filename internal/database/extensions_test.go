@@ -0,0 +1,60 @@
+package database
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"reflect"
+	"testing"
+)
+
+func TestExtensionsReader_ReadActiveExtensions(t *testing.T) {
+	want := ActiveExtensions{Plugins: []string{"akismet/akismet.php", "hello.php"}, Template: "parent", Stylesheet: "child"}
+
+	var tests = []struct {
+		name    string
+		runner  *ConnectionRunnerStub
+		want    ActiveExtensions
+		wantErr error
+	}{
+		{
+			"mysql client",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "active_plugins\ta:2:{i:0;s:19:\"akismet/akismet.php\";i:1;s:9:\"hello.php\";}\ntemplate\tparent\nstylesheet\tchild\n"},
+			want,
+			nil,
+		},
+		{
+			"mysql client escaped value",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "stylesheet\tchild\\ttheme\n"},
+			ActiveExtensions{Stylesheet: "child\ttheme"},
+			nil,
+		},
+		{
+			"mysql client error",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, err: errors.New("exit status 1: ERROR 1146 (42S02): Table 'db.wp_options' doesn't exist")},
+			ActiveExtensions{},
+			ErrCannotReadActiveExtensions,
+		},
+		{
+			"no php script without the mysql client",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"rows":[["template","parent"]]}`},
+			ActiveExtensions{},
+			ErrCannotReadActiveExtensions,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewExtensionsReader(tt.runner, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_options", "")
+			reader.random = randomStub
+
+			got, err := reader.ReadActiveExtensions()
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
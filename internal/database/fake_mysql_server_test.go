@@ -0,0 +1,212 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// FakeMysqlServer is a small in-process server that speaks just enough of the MySQL client/server protocol for the go-sql-driver to connect
+// and run text queries against it. Results are stubbed per query; SET, START TRANSACTION and COMMIT statements always succeed, and any other
+// unknown query returns an error.
+type FakeMysqlServer struct {
+	listener net.Listener
+	results  map[string]FakeResult
+}
+
+type FakeColumn struct {
+	Name string
+	// Type is the MySQL protocol field type, e.g. 0x03 for INT or 0xfd for VARCHAR
+	Type byte
+	// Binary marks the column as using the binary charset, which is what distinguishes BLOB from TEXT and VARBINARY from VARCHAR
+	Binary bool
+}
+
+type FakeResult struct {
+	Columns []FakeColumn
+	// A nil value is NULL
+	Rows [][]*string
+}
+
+const (
+	fakeTypeInt     byte = 0x03
+	fakeTypeBlob    byte = 0xfc
+	fakeTypeVarchar byte = 0xfd
+)
+
+func StartFakeMysqlServer(t *testing.T, results map[string]FakeResult) *FakeMysqlServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start fake mysql server: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &FakeMysqlServer{listener: listener, results: results}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *FakeMysqlServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *FakeMysqlServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	p := &fakePacketConn{conn: conn}
+	p.write(handshakePacket())
+	// We accept any credentials
+	if _, err := p.read(); err != nil {
+		return
+	}
+	p.write(okPacket())
+
+	for {
+		p.seq = 0
+		data, err := p.read()
+		if err != nil || len(data) == 0 {
+			return
+		}
+
+		switch data[0] {
+		case 0x01: // COM_QUIT
+			return
+		case 0x03: // COM_QUERY
+			s.query(p, string(data[1:]))
+		default:
+			p.write(okPacket())
+		}
+	}
+}
+
+func (s *FakeMysqlServer) query(p *fakePacketConn, query string) {
+	result, ok := s.results[query]
+	if !ok {
+		upper := strings.ToUpper(query)
+		if strings.HasPrefix(upper, "SET ") || strings.HasPrefix(upper, "START TRANSACTION") || upper == "COMMIT" {
+			p.write(okPacket())
+		} else {
+			p.write(errorPacket(1064, "unexpected query: "+query))
+		}
+		return
+	}
+
+	p.write(lengthEncodedInt(uint64(len(result.Columns))))
+	for _, column := range result.Columns {
+		p.write(columnPacket(column))
+	}
+	p.write(eofPacket())
+	for _, row := range result.Rows {
+		var b bytes.Buffer
+		for _, value := range row {
+			if value == nil {
+				b.WriteByte(0xfb)
+			} else {
+				b.Write(lengthEncodedString(*value))
+			}
+		}
+		p.write(b.Bytes())
+	}
+	p.write(eofPacket())
+}
+
+type fakePacketConn struct {
+	conn net.Conn
+	seq  byte
+}
+
+func (p *fakePacketConn) read() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(p.conn, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	p.seq = header[3] + 1
+	data := make([]byte, length)
+	_, err := io.ReadFull(p.conn, data)
+	return data, err
+}
+
+func (p *fakePacketConn) write(data []byte) {
+	header := []byte{byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16), p.seq}
+	p.seq++
+	p.conn.Write(append(header, data...))
+}
+
+func handshakePacket() []byte {
+	var b bytes.Buffer
+	b.WriteByte(10)
+	b.WriteString("8.0.36-fake\x00")
+	b.Write([]byte{1, 0, 0, 0})
+	b.WriteString("abcdefgh")
+	b.WriteByte(0)
+	// CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 | CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION
+	b.Write([]byte{0x0d, 0xa2})
+	b.WriteByte(0x21)
+	b.Write([]byte{0x02, 0x00})
+	// CLIENT_MULTI_RESULTS | CLIENT_PLUGIN_AUTH
+	b.Write([]byte{0x0a, 0x00})
+	b.WriteByte(21)
+	b.Write(make([]byte, 10))
+	b.WriteString("ijklmnopqrst\x00")
+	b.WriteString("mysql_native_password\x00")
+	return b.Bytes()
+}
+
+func okPacket() []byte {
+	return []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+}
+
+func eofPacket() []byte {
+	return []byte{0xfe, 0x00, 0x00, 0x02, 0x00}
+}
+
+func errorPacket(code uint16, message string) []byte {
+	b := []byte{0xff, byte(code), byte(code >> 8)}
+	b = append(b, []byte("#42000")...)
+	return append(b, []byte(message)...)
+}
+
+func columnPacket(column FakeColumn) []byte {
+	var b bytes.Buffer
+	for _, s := range []string{"def", "db", "t", "t", column.Name, column.Name} {
+		b.Write(lengthEncodedString(s))
+	}
+	b.WriteByte(0x0c)
+	charset := uint16(33)
+	if column.Binary {
+		charset = 63
+	}
+	binary.Write(&b, binary.LittleEndian, charset)
+	binary.Write(&b, binary.LittleEndian, uint32(255))
+	b.WriteByte(column.Type)
+	b.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+	return b.Bytes()
+}
+
+func lengthEncodedInt(n uint64) []byte {
+	if n < 251 {
+		return []byte{byte(n)}
+	}
+	b := []byte{0xfe, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(b[1:], n)
+	return b
+}
+
+func lengthEncodedString(s string) []byte {
+	return append(lengthEncodedInt(uint64(len(s))), []byte(s)...)
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"io"
+	"path"
+	"strings"
+)
+
+// MysqlCli runs the MySQL client programs (mysql, mysqldump) on the remote server. The credentials are never placed on the command line,
+// where other users could see them in `ps` output or audit logs. Instead, each command gets its own option file that only the SSH user can
+// read, which is passed with --defaults-extra-file and removed by the remote shell as soon as the command exits.
+type MysqlCli struct {
+	c           sftp.CommandRunnerUploader
+	credentials DatabaseCredentials
+	random      func() string
+}
+
+// NewMysqlCli is the constructor for MysqlCli.
+func NewMysqlCli(c sftp.CommandRunnerUploader, credentials DatabaseCredentials) *MysqlCli {
+	return &MysqlCli{c, credentials, phpscript.RandomName}
+}
+
+// CanRun reports whether the program can be run successfully with the given arguments. The database name is appended automatically.
+func (m *MysqlCli) CanRun(program, args string) bool {
+	cmd, optionFile, err := m.prepare(program, args)
+	if err != nil {
+		return false
+	}
+	// The remote shell removes the option file itself, this is only a fallback in case it never got the chance
+	defer m.c.Delete(optionFile)
+
+	return m.c.CanRunRemoteCommand(cmd)
+}
+
+// Run runs the program with the given arguments and streams its output. The database name is appended automatically, followed by any
+// table names, which is how mysqldump is told to dump only some tables.
+func (m *MysqlCli) Run(program, args string, tables ...string) (io.Reader, error) {
+	cmd, _, err := m.prepare(program, args, tables...)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.c.RunRemoteCommand(cmd)
+}
+
+// RunCompressed runs the program like Run, but pipes its output through the compression command on the remote server. The compression
+// command exits successfully even when the program fails, so pipefail is turned on where the shell supports it. Where it doesn't, a
+// failed dump is still caught by its missing trailer.
+func (m *MysqlCli) RunCompressed(compression Compression, program, args string, tables ...string) (io.Reader, error) {
+	cmd, _, err := m.prepare(program, args, tables...)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := m.c.RunRemoteCommand("(set -o pipefail) 2>/dev/null && set -o pipefail; " + cmd + " | " + compression.command())
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncodedReader{r, compression}, nil
+}
+
+// prepare uploads a fresh option file into the home directory and returns the command that uses it. The option file is created empty and
+// restricted to mode 0600 before any credentials are written into it. Its path is absolute, so the command doesn't depend on the directory
+// it is run in.
+func (m *MysqlCli) prepare(program, args string, tables ...string) (string, string, error) {
+	home, err := m.c.Getwd()
+	if err != nil {
+		return "", "", fmt.Errorf("could not find the home directory for the mysql option file: %s", err)
+	}
+	optionFile := path.Join(home, ".wp-zip-"+m.random()+".cnf")
+
+	if err := m.c.Upload(strings.NewReader(""), optionFile); err != nil {
+		return "", "", fmt.Errorf("could not upload mysql option file: %s", err)
+	}
+	if err := m.c.Chmod(optionFile, 0600); err != nil {
+		m.c.Delete(optionFile)
+		return "", "", fmt.Errorf("could not restrict mysql option file: %s", err)
+	}
+	if err := m.c.Upload(strings.NewReader(MysqlOptionFile(m.credentials)), optionFile); err != nil {
+		m.c.Delete(optionFile)
+		return "", "", fmt.Errorf("could not upload mysql option file: %s", err)
+	}
+
+	cmd := fmt.Sprintf(`trap "rm -f '%[1]s'" EXIT; %[2]s --defaults-extra-file='%[1]s'`, optionFile, program)
+	if args != "" {
+		cmd += " " + args
+	}
+
+	cmd += " " + sftp.ShellQuote(m.credentials.Name)
+	for _, table := range tables {
+		cmd += " " + sftp.ShellQuote(table)
+	}
+
+	return cmd, optionFile, nil
+}
+
+// MysqlOptionFile returns the contents of a MySQL option file holding the credentials for the client programs.
+func MysqlOptionFile(credentials DatabaseCredentials) string {
+	contents := "[client]\n" +
+		"user=" + optionFileQuote(credentials.User) + "\n" +
+		"password=" + optionFileQuote(credentials.Pass) + "\n"
+
+	if credentials.Host != "" {
+		contents += "host=" + optionFileQuote(credentials.Host) + "\n"
+	}
+	if credentials.Port != "" {
+		contents += "port=" + credentials.Port + "\n"
+	}
+	if credentials.Socket != "" {
+		contents += "socket=" + optionFileQuote(credentials.Socket) + "\n"
+	}
+
+	return contents
+}
+
+// optionFileQuote wraps the value in double quotes, escaping the characters that the option file parser would otherwise interpret.
+func optionFileQuote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
@@ -4,34 +4,47 @@ import (
 	"errors"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"io"
-	"strings"
 )
 
 // MysqldumpDatabaseExporter is a DatabaseExporter that uses the mysqldump command to export the database. It should be the preferred exporter whenever possible.
 type MysqldumpDatabaseExporter struct {
-	commandRunner sftp.RemoteCommandRunner
+	commandRunner sftp.CommandRunnerUploader
 	credentials   DatabaseCredentials
+	// program is the dump program that was detected on the server
+	program string
+	opts    ExportOptions
+	random  func() string
 }
 
 func (e *MysqldumpDatabaseExporter) Export() (io.Reader, error) {
-	if !e.commandRunner.CanRunRemoteCommand("mysqldump --version") {
-		return nil, errors.New("mysqldump command not found")
+	if e.program == "" {
+		return nil, ErrNoDumpProgram
 	}
 
-	credentialsString := MysqlCliCredentials(e.credentials)
+	cli := &MysqlCli{e.commandRunner, e.credentials, e.random}
 
-	if !e.commandRunner.CanRunRemoteCommand("mysql " + credentialsString + ` -e"quit"`) {
+	if !cli.CanRun("mysql", `-e"quit"`) {
 		return nil, errors.New("MySQL credentials are incorrect")
 	}
 
-	return e.commandRunner.RunRemoteCommand("mysqldump --no-tablespaces " + credentialsString)
-}
+	dump, err := NewMysqldump(cli, e.program, e.opts)
+	if err != nil {
+		return nil, err
+	}
 
-func MysqlCliCredentials(credentials DatabaseCredentials) string {
-	// Since the credentials are wrapped in single quotes, we need to replace any single quotes in the credentials.
-	// For example, if the password is "password'123", we need to replace the single quote with '\'' so that the command
-	// looks like this: -p'password'\''123'
-	pass := strings.ReplaceAll(credentials.Pass, "'", `'\''`)
+	// Only the tables of a subsite are dumped, rather than the whole network
+	var tables []string
+	if e.opts.Subsite != nil {
+		all, err := listTables(cli)
+		if err != nil {
+			return nil, err
+		}
+		tables = NewSubsiteFilter(*e.opts.Subsite).Tables(all)
+	}
+
+	if e.opts.Compression.AvailableRemotely(e.commandRunner) {
+		return cli.RunCompressed(e.opts.Compression, dump.Program, dump.Args(), tables...)
+	}
 
-	return "--user='" + credentials.User + "' --password='" + pass + "' --host=" + credentials.Host + " " + credentials.Name
+	return cli.Run(dump.Program, dump.Args(), tables...)
 }
@@ -1,17 +1,23 @@
 package database
 
 import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/types"
+	_sftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
+	"os"
 	"strings"
 	"testing"
 )
 
 func TestMysqldumpDatabaseExporter_Export(t *testing.T) {
 	t.Run("it returns an error if the remote server cannot run the mysqldump command", func(t *testing.T) {
-		// The command runner will not be able to run the mysqldump command, so it will return an error
+		// No dump program was detected on the server, so it will return an error
 		commandRunner := &MockCommandRunner{}
 
-		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{"User", "Pass", "Dbname", "localhost"}}
+		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{User: "User", Pass: "Pass", Name: "Dbname", Host: "localhost"}, "", ExportOptions{}, randomStub}
 
 		// Assert error returned
 		_, err := exporter.Export()
@@ -22,10 +28,10 @@ func TestMysqldumpDatabaseExporter_Export(t *testing.T) {
 	})
 
 	t.Run("it returns an error if the credentials are incorrect", func(t *testing.T) {
-		// The command runner will pass the version check, but fail the credential check
-		commandRunner := &MockCommandRunner{map[string]string{"mysqldump --version": "mysqldump Ver 1.0"}}
+		// The command runner will fail the credential check
+		commandRunner := &MockCommandRunner{}
 
-		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{"User", "BadPass", "Dbname", "localhost"}}
+		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{User: "User", Pass: "BadPass", Name: "Dbname", Host: "localhost"}, "mysqldump", ExportOptions{}, randomStub}
 
 		// Assert error returned
 		_, err := exporter.Export()
@@ -36,76 +42,166 @@ func TestMysqldumpDatabaseExporter_Export(t *testing.T) {
 	})
 
 	t.Run("it uses mysqldump to export to the reader", func(t *testing.T) {
-		var tests = []struct {
-			name     string
-			creds    DatabaseCredentials
-			checkCmd string
-			dumpCmd  string
-		}{
-			{
-				"no special chars",
-				DatabaseCredentials{"User", "Pass", "Dbname", "localhost"},
-				`mysql --user='User' --password='Pass' --host=localhost Dbname -e"quit"`,
-				"mysqldump --no-tablespaces --user='User' --password='Pass' --host=localhost Dbname",
-			},
-			{
-				"single quote in middle of password",
-				DatabaseCredentials{"User", "Pa'ss", "Dbname", "localhost"},
-				`mysql --user='User' --password='Pa'\''ss' --host=localhost Dbname -e"quit"`,
-				`mysqldump --no-tablespaces --user='User' --password='Pa'\''ss' --host=localhost Dbname`,
-			},
-			{
-				"single quote at beginning of password",
-				DatabaseCredentials{"User", "'Pass", "Dbname", "localhost"},
-				`mysql --user='User' --password=''\''Pass' --host=localhost Dbname -e"quit"`,
-				`mysqldump --no-tablespaces --user='User' --password=''\''Pass' --host=localhost Dbname`,
-			},
-			{
-				"single quote at end of password",
-				DatabaseCredentials{"User", "Pass'", "Dbname", "localhost"},
-				`mysql --user='User' --password='Pass'\''' --host=localhost Dbname -e"quit"`,
-				`mysqldump --no-tablespaces --user='User' --password='Pass'\''' --host=localhost Dbname`,
-			},
-			{
-				"double quote in middle of password",
-				DatabaseCredentials{"User", `Pa"ss`, "Dbname", "localhost"},
-				`mysql --user='User' --password='Pa"ss' --host=localhost Dbname -e"quit"`,
-				`mysqldump --no-tablespaces --user='User' --password='Pa"ss' --host=localhost Dbname`,
-			},
+		expectedOutput := "mysqldump Dbname output"
+
+		commandRunner := &MockCommandRunner{commandsThatExist: map[string]string{
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' -e"quit" 'Dbname'`:                                                                           "",
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysqldump --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --no-tablespaces --single-transaction --quick --triggers --hex-blob --routines 'Dbname'`: expectedOutput,
+		}}
+
+		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{User: "User", Pass: "Pass", Name: "Dbname", Host: "localhost"}, "mysqldump", ExportOptions{}, randomStub}
+
+		r, _ := exporter.Export()
+
+		str, _ := io.ReadAll(r)
+		if string(str) != expectedOutput {
+			t.Errorf("exporter.Export() returned %s; want %s", r, expectedOutput)
+		}
+	})
+
+	t.Run("it only dumps the tables of a subsite", func(t *testing.T) {
+		commandRunner := &MockCommandRunner{commandsThatExist: map[string]string{
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' -e"quit" 'Dbname'`:                                                                                                                 "",
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --skip-column-names --silent -e "` + SELECT_TABLES_STMT + `" 'Dbname'`:                                                             "wp_2_posts\nwp_3_posts\nwp_blogs\nwp_posts\nwp_usermeta\nwp_users\n",
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysqldump --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --no-tablespaces --single-transaction --quick --triggers --hex-blob --routines 'Dbname' 'wp_2_posts' 'wp_usermeta' 'wp_users'`: "subsite dump",
+		}}
+		subsite := &types.Extraction{Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_"}
+
+		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{User: "User", Pass: "Pass", Name: "Dbname", Host: "localhost"}, "mysqldump", ExportOptions{Subsite: subsite}, randomStub}
+
+		r, err := exporter.Export()
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		str, _ := io.ReadAll(r)
+		if string(str) != "subsite dump" {
+			t.Errorf("got %s; want the dump of the subsite's tables", str)
+		}
+	})
+
+	t.Run("it never puts the password on the command line", func(t *testing.T) {
+		commandRunner := &MockCommandRunner{}
+
+		exporter := &MysqldumpDatabaseExporter{commandRunner, DatabaseCredentials{User: "User", Pass: "Secret", Name: "Dbname", Host: "localhost"}, "mysqldump", ExportOptions{}, randomStub}
+
+		_, _ = exporter.Export()
+
+		for _, cmd := range commandRunner.commandsRun {
+			if strings.Contains(cmd, "Secret") {
+				t.Errorf("got command %s; want it to not contain the password", cmd)
+			}
+		}
+
+		// The password is only ever written into the option file, after the file has been restricted
+		if commandRunner.uploads["/home/user/.wp-zip-abc.cnf"] != MysqlOptionFile(exporter.credentials) {
+			t.Errorf("got option file %s; want %s", commandRunner.uploads["/home/user/.wp-zip-abc.cnf"], MysqlOptionFile(exporter.credentials))
+		}
+		if commandRunner.modes["/home/user/.wp-zip-abc.cnf"] != 0600 {
+			t.Errorf("got option file mode %o; want 0600", commandRunner.modes["/home/user/.wp-zip-abc.cnf"])
 		}
+	})
+}
+
+func TestMysqlOptionFile(t *testing.T) {
+	var tests = []struct {
+		name     string
+		pass     string
+		expected string
+	}{
+		{"no special chars", "Pass", `password="Pass"`},
+		{"single quote in password", "Pa'ss", `password="Pa'ss"`},
+		{"double quote in password", `Pa"ss`, `password="Pa\"ss"`},
+		{"backslash in password", `Pa\ss`, `password="Pa\\ss"`},
+		{"hash in password", "Pa#ss", `password="Pa#ss"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contents := MysqlOptionFile(DatabaseCredentials{User: "User", Pass: test.pass, Name: "Dbname", Host: "localhost"})
+
+			if !strings.Contains(contents, "\n"+test.expected+"\n") {
+				t.Errorf("got %s; want it to contain %s", contents, test.expected)
+			}
+		})
+	}
+}
 
-		for _, test := range tests {
-			t.Run(test.name, func(t *testing.T) {
-				expectedOutput := "mysqldump Dbname output"
+func TestMysqlOptionFile_Connection(t *testing.T) {
+	contents := MysqlOptionFile(DatabaseCredentials{User: "User", Pass: "Pass", Name: "Dbname", Host: "db.internal", Port: "3307", Socket: "/tmp/mysql.sock"})
 
-				commandRunner := &MockCommandRunner{commandsThatExist: map[string]string{
-					"mysqldump --version": "mysqldump Ver 1.0",
-					test.checkCmd:         "",
-					test.dumpCmd:          expectedOutput,
-				}}
+	for _, want := range []string{`host="db.internal"`, "port=3307", `socket="/tmp/mysql.sock"`} {
+		if !strings.Contains(contents, want) {
+			t.Errorf("got %s; want it to contain %s", contents, want)
+		}
+	}
+}
 
-				exporter := &MysqldumpDatabaseExporter{commandRunner, test.creds}
+func randomStub() string {
+	return "abc"
+}
 
-				r, _ := exporter.Export()
+func TestNewDatabaseExporter(t *testing.T) {
+	t.Run("it detects the dump program once and passes it down to the exporter", func(t *testing.T) {
+		client := &ClientStub{&MockCommandRunner{commandsThatExist: map[string]string{"mariadb-dump --version": "", "mysqldump --version": ""}}}
 
-				str, _ := io.ReadAll(r)
-				if string(str) != expectedOutput {
-					t.Errorf("exporter.Export() returned %s; want %s", r, expectedOutput)
-				}
-			})
+		single, ok := NewDatabaseExporter(client, "", "", nil, nil, DatabaseCredentials{}, ExportOptions{}).(*MysqldumpDatabaseExporter)
+		if !ok || single.program != "mariadb-dump" {
+			t.Errorf("got %+v; want a mysqldump exporter using mariadb-dump", single)
+		}
+		parallel, ok := NewDatabaseExporter(client, "", "", nil, nil, DatabaseCredentials{}, ExportOptions{Concurrency: 2}).(*ParallelMysqldumpDatabaseExporter)
+		if !ok || parallel.program != "mariadb-dump" {
+			t.Errorf("got %+v; want a parallel mysqldump exporter using mariadb-dump", parallel)
 		}
 	})
 }
 
+// ClientStub is a whole sftp.Client, which only runs commands.
+type ClientStub struct {
+	*MockCommandRunner
+}
+
+func (c *ClientStub) ReadDir(path string) ([]os.FileInfo, error) { return nil, os.ErrNotExist }
+func (c *ClientStub) Open(path string) (*_sftp.File, error)      { return nil, os.ErrNotExist }
+func (c *ClientStub) NewSession() (*ssh.Session, error)          { return nil, errors.New("no sessions") }
+func (c *ClientStub) Dial(network, addr string) (net.Conn, error) {
+	return nil, errors.New("no tunnel")
+}
+
 type MockCommandRunner struct {
 	commandsThatExist map[string]string
+	commandsRun       []string
+	uploads           map[string]string
+	modes             map[string]os.FileMode
 }
 
 func (m *MockCommandRunner) CanRunRemoteCommand(command string) bool {
+	m.commandsRun = append(m.commandsRun, command)
 	_, ok := m.commandsThatExist[command]
 	return ok
 }
 
 func (m *MockCommandRunner) RunRemoteCommand(command string) (io.Reader, error) {
+	m.commandsRun = append(m.commandsRun, command)
 	return strings.NewReader(m.commandsThatExist[command]), nil
 }
+
+func (m *MockCommandRunner) Upload(r io.Reader, dst string) error {
+	if m.uploads == nil {
+		m.uploads = map[string]string{}
+	}
+	b, _ := io.ReadAll(r)
+	m.uploads[dst] = string(b)
+	return nil
+}
+
+func (m *MockCommandRunner) Delete(dst string) error { return nil }
+func (m *MockCommandRunner) Mkdir(dst string) error  { return nil }
+
+func (m *MockCommandRunner) Chmod(dst string, mode os.FileMode) error {
+	if m.modes == nil {
+		m.modes = map[string]os.FileMode{}
+	}
+	m.modes[dst] = mode
+	return nil
+}
+func (m *MockCommandRunner) Getwd() (string, error) { return "/home/user", nil }
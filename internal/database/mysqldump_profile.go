@@ -0,0 +1,172 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// DumpProfile names a set of mysqldump options.
+type DumpProfile string
+
+const (
+	// DumpProfileConsistent dumps InnoDB tables from a single snapshot without locking them. It is the default.
+	DumpProfileConsistent DumpProfile = "consistent"
+	// DumpProfileLocking locks the tables while they are dumped instead, which is the only way to get a consistent dump of MyISAM tables.
+	DumpProfileLocking DumpProfile = "locking"
+	// DumpProfileMinimal only passes the options that every server accepts, for servers where the others fail.
+	DumpProfileMinimal DumpProfile = "minimal"
+)
+
+// DumpProfiles are all the profiles that can be selected.
+var DumpProfiles = []DumpProfile{DumpProfileConsistent, DumpProfileLocking, DumpProfileMinimal}
+
+var (
+	ErrUnknownDumpProfile = errors.New("unknown dump profile")
+	ErrNoDumpProgram      = errors.New("mysqldump command not found")
+)
+
+// ParseDumpProfile returns the profile with the given name. An empty name is the default profile.
+func ParseDumpProfile(name string) (DumpProfile, error) {
+	if name == "" {
+		return DumpProfileConsistent, nil
+	}
+	for _, profile := range DumpProfiles {
+		if string(profile) == name {
+			return profile, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownDumpProfile, name)
+}
+
+// The dump programs we look for, in order of preference. Newer MariaDB releases only ship mysqldump as a deprecated alias of mariadb-dump.
+var dumpPrograms = []capabilities.Capability{capabilities.MariadbDump, capabilities.Mysqldump}
+
+// DetectDumpProgram returns the name of the dump program installed on the remote server. The checks are the probed capabilities, so a
+// client that has probed the server answers them without running anything.
+func DetectDumpProgram(c sftp.RemoteCommandRunner) (string, error) {
+	for _, program := range dumpPrograms {
+		if c.CanRunRemoteCommand(program.Command) {
+			return program.Name, nil
+		}
+	}
+
+	return "", ErrNoDumpProgram
+}
+
+// Mysqldump builds the arguments for the dump program, from the selected profile and what the server allows.
+type Mysqldump struct {
+	Program string
+	Profile DumpProfile
+	// Charset is the DB_CHARSET the site uses, empty to leave it to the server
+	Charset string
+	// Events is whether the user is allowed to dump the scheduled events
+	Events bool
+	// ExtraArgs are passed through to every dump as is
+	ExtraArgs string
+}
+
+// NewMysqldump builds the arguments for the dump program according to the options.
+func NewMysqldump(cli *MysqlCli, program string, opts ExportOptions) (*Mysqldump, error) {
+	profile, err := ParseDumpProfile(string(opts.Profile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mysqldump{Program: program, Profile: profile, Charset: cli.credentials.Charset, ExtraArgs: opts.ExtraArgs}
+
+	// Dumping events needs the EVENT privilege, which shared hosts often don't give out, and without it the whole dump fails
+	if profile != DumpProfileMinimal {
+		m.Events = cli.CanRun("mysql", `-e "SHOW EVENTS"`)
+		if !m.Events {
+			log.Println("not allowed to read the scheduled events, the database will be exported without them")
+		}
+	}
+
+	return m, nil
+}
+
+// Args are the arguments for a dump of the whole database.
+func (m *Mysqldump) Args() string {
+	args := m.tableArgs()
+	if m.Profile != DumpProfileMinimal {
+		args = append(args, "--routines")
+		if m.Events {
+			args = append(args, "--events")
+		}
+	}
+
+	return m.join(args)
+}
+
+// TableArgs are the arguments for a dump of some of the tables. The routines and events are left for RoutineArgs, since mysqldump would
+// otherwise repeat them in every dump.
+func (m *Mysqldump) TableArgs() string {
+	args := m.tableArgs()
+	if m.Profile != DumpProfileMinimal {
+		args = append(args, "--skip-routines", "--skip-events")
+	}
+
+	return m.join(args)
+}
+
+// RoutineArgs are the arguments for a dump of only the stored routines and events. They are empty if the profile does not dump them.
+func (m *Mysqldump) RoutineArgs() string {
+	if m.Profile == DumpProfileMinimal {
+		return ""
+	}
+
+	args := []string{"--no-tablespaces", "--no-create-info", "--no-data", "--skip-triggers", "--routines"}
+	if m.Events {
+		args = append(args, "--events")
+	}
+	args = append(args, m.charsetArgs()...)
+
+	return m.join(args)
+}
+
+func (m *Mysqldump) tableArgs() []string {
+	args := []string{"--no-tablespaces"}
+
+	switch m.Profile {
+	case DumpProfileConsistent:
+		args = append(args, "--single-transaction", "--quick", "--triggers", "--hex-blob")
+	case DumpProfileLocking:
+		args = append(args, "--lock-tables", "--quick", "--triggers", "--hex-blob")
+	}
+
+	return append(args, m.charsetArgs()...)
+}
+
+// A charset name is placed on the command line as is, so anything but a plain name is ignored.
+var validCharset = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func (m *Mysqldump) charsetArgs() []string {
+	charset := m.Charset
+	if charset == "" {
+		return nil
+	}
+	if !validCharset.MatchString(charset) {
+		log.Printf("ignoring invalid DB_CHARSET %q", charset)
+		return nil
+	}
+	// WordPress itself upgrades utf8 connections to utf8mb4, and the old 3 byte utf8 would mangle any emoji in the dump
+	if charset == "utf8" || charset == "utf8mb3" {
+		charset = "utf8mb4"
+	}
+
+	return []string{"--default-character-set=" + charset}
+}
+
+func (m *Mysqldump) join(args []string) string {
+	if m.ExtraArgs != "" {
+		args = append(args, m.ExtraArgs)
+	}
+
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseDumpProfile(t *testing.T) {
+	var tests = []struct {
+		name string
+		want DumpProfile
+		err  error
+	}{
+		{"", DumpProfileConsistent, nil},
+		{"consistent", DumpProfileConsistent, nil},
+		{"locking", DumpProfileLocking, nil},
+		{"minimal", DumpProfileMinimal, nil},
+		{"fast", "", ErrUnknownDumpProfile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseDumpProfile(test.name)
+
+			if got != test.want || !errors.Is(err, test.err) {
+				t.Errorf("got %s, %v; want %s, %v", got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestDetectDumpProgram(t *testing.T) {
+	t.Run("it prefers mariadb-dump", func(t *testing.T) {
+		c := &MockCommandRunner{commandsThatExist: map[string]string{"mariadb-dump --version": "", "mysqldump --version": ""}}
+
+		program, _ := DetectDumpProgram(c)
+
+		if program != "mariadb-dump" {
+			t.Errorf("got %s; want mariadb-dump", program)
+		}
+	})
+
+	t.Run("it falls back to mysqldump", func(t *testing.T) {
+		c := &MockCommandRunner{commandsThatExist: map[string]string{"mysqldump --version": ""}}
+
+		program, _ := DetectDumpProgram(c)
+
+		if program != "mysqldump" {
+			t.Errorf("got %s; want mysqldump", program)
+		}
+	})
+
+	t.Run("it returns an error if neither is installed", func(t *testing.T) {
+		_, err := DetectDumpProgram(&MockCommandRunner{})
+
+		if err == nil {
+			t.Errorf("got nil; want error")
+		}
+	})
+}
+
+func TestMysqldump_Args(t *testing.T) {
+	var tests = []struct {
+		name string
+		dump Mysqldump
+		want string
+	}{
+		{"consistent", Mysqldump{Profile: DumpProfileConsistent, Events: true}, "--no-tablespaces --single-transaction --quick --triggers --hex-blob --routines --events"},
+		{"locking", Mysqldump{Profile: DumpProfileLocking, Events: true}, "--no-tablespaces --lock-tables --quick --triggers --hex-blob --routines --events"},
+		{"minimal", Mysqldump{Profile: DumpProfileMinimal}, "--no-tablespaces"},
+		{"without events", Mysqldump{Profile: DumpProfileConsistent}, "--no-tablespaces --single-transaction --quick --triggers --hex-blob --routines"},
+		{"charset", Mysqldump{Profile: DumpProfileMinimal, Charset: "latin1"}, "--no-tablespaces --default-character-set=latin1"},
+		{"utf8 is upgraded", Mysqldump{Profile: DumpProfileMinimal, Charset: "utf8"}, "--no-tablespaces --default-character-set=utf8mb4"},
+		{"invalid charset", Mysqldump{Profile: DumpProfileMinimal, Charset: "utf8; rm -rf /"}, "--no-tablespaces"},
+		{"extra args", Mysqldump{Profile: DumpProfileMinimal, ExtraArgs: "--skip-lock-tables"}, "--no-tablespaces --skip-lock-tables"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.dump.Args(); got != test.want {
+				t.Errorf("got %s; want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewMysqldump(t *testing.T) {
+	t.Run("it only dumps events when the user is allowed to read them", func(t *testing.T) {
+		c := &MockCommandRunner{commandsThatExist: map[string]string{
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-abc.cnf' -e "SHOW EVENTS" 'Dbname'`: "",
+		}}
+
+		dump, err := NewMysqldump(&MysqlCli{c, DatabaseCredentials{Name: "Dbname", Charset: "utf8mb4"}, randomStub}, "mysqldump", ExportOptions{})
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if !dump.Events || dump.Program != "mysqldump" || dump.Profile != DumpProfileConsistent || dump.Charset != "utf8mb4" {
+			t.Errorf("got %+v; want events, mysqldump, the consistent profile and utf8mb4", dump)
+		}
+	})
+
+	t.Run("it returns an error for an unknown profile", func(t *testing.T) {
+		c := &MockCommandRunner{}
+
+		_, err := NewMysqldump(&MysqlCli{c, DatabaseCredentials{}, randomStub}, "mysqldump", ExportOptions{Profile: "fast"})
+
+		if !errors.Is(err, ErrUnknownDumpProfile) {
+			t.Errorf("got error %v; want ErrUnknownDumpProfile", err)
+		}
+	})
+}
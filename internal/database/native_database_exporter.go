@@ -0,0 +1,459 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of rows, and the approximate number of bytes, that are written into a single INSERT statement.
+const (
+	NATIVE_INSERT_BATCH_ROWS  = 500
+	NATIVE_INSERT_BATCH_BYTES = 1024 * 1024
+)
+
+// NativeDatabaseExporter is a DatabaseExporter that speaks the MySQL protocol directly from Go. The connection to the database is tunnelled
+// through the SSH connection, so it works even when the server has neither `mysqldump` nor PHP that we can reach over HTTP. It writes a
+// mysqldump compatible SQL stream, and is the last resort when neither of the other exporters can be used.
+type NativeDatabaseExporter struct {
+	d           sftp.Dialer
+	credentials DatabaseCredentials
+}
+
+func (e *NativeDatabaseExporter) Export() (io.Reader, error) {
+	db, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure we can actually connect before committing to this exporter
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to the database through the ssh tunnel: %s", err)
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		defer db.Close()
+		defer conn.Close()
+
+		w := bufio.NewWriter(writer)
+		err := (&nativeDumper{conn: conn, w: w, credentials: e.credentials}).dump(ctx)
+		if err == nil {
+			err = w.Flush()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+// open configures a database handle whose connections are dialed through the SSH connection. A socket from DB_HOST takes precedence over
+// the host and port, just as it does for the mysql client.
+func (e *NativeDatabaseExporter) open() (*sql.DB, error) {
+	network, addr := "tcp", net.JoinHostPort(phpHost(e.credentials), "3306")
+	if e.credentials.Port != "" {
+		addr = net.JoinHostPort(phpHost(e.credentials), e.credentials.Port)
+	}
+	if e.credentials.Socket != "" {
+		network, addr = "unix", e.credentials.Socket
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = e.credentials.User
+	cfg.Passwd = e.credentials.Pass
+	cfg.DBName = e.credentials.Name
+	cfg.Net = registerDialer(e.d, network)
+	cfg.Addr = addr
+	cfg.Collation = "utf8mb4_general_ci"
+	cfg.AllowNativePasswords = true
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+// dialerKey is an SSH connection along with the network that is dialed through it.
+type dialerKey struct {
+	d       sftp.Dialer
+	network string
+}
+
+// dialers are the names that the dial functions are registered under with the driver, by connection and network.
+var (
+	dialersMu sync.Mutex
+	dialers   = map[dialerKey]string{}
+)
+
+// registerDialer returns the network name under which the driver dials through the SSH connection. The driver keeps every dial function
+// for the life of the process, so each connection is only registered once per network, however many times the database is opened.
+func registerDialer(d sftp.Dialer, network string) string {
+	dialersMu.Lock()
+	defer dialersMu.Unlock()
+
+	key := dialerKey{d, network}
+	if name, ok := dialers[key]; ok {
+		return name
+	}
+
+	name := fmt.Sprintf("wp-zip-ssh-%d", len(dialers)+1)
+	mysql.RegisterDialContext(name, func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	})
+	dialers[key] = name
+
+	return name
+}
+
+// nativeDumper writes the dump for a single connection. Everything is read inside one consistent snapshot transaction.
+type nativeDumper struct {
+	conn        *sql.Conn
+	w           *bufio.Writer
+	credentials DatabaseCredentials
+}
+
+func (d *nativeDumper) dump(ctx context.Context) error {
+	for _, stmt := range []string{
+		"SET NAMES utf8mb4",
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION /*!40100 WITH CONSISTENT SNAPSHOT */",
+	} {
+		if _, err := d.conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not prepare the connection: %s", err)
+		}
+	}
+
+	var version string
+	if err := d.conn.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return fmt.Errorf("could not determine the server version: %s", err)
+	}
+
+	tables, views, err := d.listTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.writeHeader(version)
+
+	for _, table := range tables {
+		if err := d.dumpTable(ctx, table); err != nil {
+			return err
+		}
+	}
+
+	// Views may select from other views, so each one is first stood in for by a view with the same columns, the same as mysqldump does
+	for _, view := range views {
+		if err := d.dumpStandInView(ctx, view); err != nil {
+			return err
+		}
+	}
+
+	for _, view := range views {
+		if err := d.dumpView(ctx, view); err != nil {
+			return err
+		}
+	}
+
+	d.writeFooter()
+
+	_, err = d.conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// listTables returns the base tables and the views in the database, separately, since views are dumped after all the tables.
+func (d *nativeDumper) listTables(ctx context.Context) ([]string, []string, error) {
+	rows, err := d.conn.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list tables: %s", err)
+	}
+	defer rows.Close()
+
+	var tables, views []string
+	for rows.Next() {
+		var name, tableType string
+		if err := rows.Scan(&name, &tableType); err != nil {
+			return nil, nil, err
+		}
+		if tableType == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+
+	return tables, views, rows.Err()
+}
+
+func (d *nativeDumper) dumpTable(ctx context.Context, table string) error {
+	var name, create string
+	if err := d.conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+QuoteIdentifier(table)).Scan(&name, &create); err != nil {
+		return fmt.Errorf("could not read the structure of table %s: %s", table, err)
+	}
+
+	fmt.Fprintf(d.w, "\n--\n-- Table structure for table %s\n--\n\n", QuoteIdentifier(table))
+	fmt.Fprintf(d.w, "DROP TABLE IF EXISTS %s;\n", QuoteIdentifier(table))
+	fmt.Fprintf(d.w, "/*!40101 SET @saved_cs_client     = @@character_set_client */;\n/*!50503 SET character_set_client = utf8mb4 */;\n")
+	fmt.Fprintf(d.w, "%s;\n", create)
+	fmt.Fprintf(d.w, "/*!40101 SET character_set_client = @saved_cs_client */;\n")
+
+	if err := d.dumpRows(ctx, table); err != nil {
+		return err
+	}
+
+	return d.dumpTriggers(ctx, table)
+}
+
+func (d *nativeDumper) dumpRows(ctx context.Context, table string) error {
+	rows, err := d.conn.QueryContext(ctx, "SELECT * FROM "+QuoteIdentifier(table))
+	if err != nil {
+		return fmt.Errorf("could not read the rows of table %s: %s", table, err)
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.w, "\n--\n-- Dumping data for table %s\n--\n\n", QuoteIdentifier(table))
+	fmt.Fprintf(d.w, "LOCK TABLES %[1]s WRITE;\n/*!40000 ALTER TABLE %[1]s DISABLE KEYS */;\n", QuoteIdentifier(table))
+
+	values := make([]sql.RawBytes, len(columnTypes))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	batchRows, batchBytes := 0, 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		row := formatRow(values, columnTypes)
+
+		// Start a new INSERT statement whenever the current one is full
+		if batchRows > 0 && (batchRows >= NATIVE_INSERT_BATCH_ROWS || batchBytes+len(row) > NATIVE_INSERT_BATCH_BYTES) {
+			d.w.WriteString(";\n")
+			batchRows, batchBytes = 0, 0
+		}
+		if batchRows == 0 {
+			fmt.Fprintf(d.w, "INSERT INTO %s VALUES ", QuoteIdentifier(table))
+		} else {
+			d.w.WriteString(",")
+		}
+		d.w.WriteString(row)
+		batchRows++
+		batchBytes += len(row)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read the rows of table %s: %s", table, err)
+	}
+	if batchRows > 0 {
+		d.w.WriteString(";\n")
+	}
+
+	fmt.Fprintf(d.w, "/*!40000 ALTER TABLE %[1]s ENABLE KEYS */;\nUNLOCK TABLES;\n", QuoteIdentifier(table))
+
+	return nil
+}
+
+func (d *nativeDumper) dumpTriggers(ctx context.Context, table string) error {
+	// SHOW TRIGGERS LIKE would treat the _ in most table names as a wildcard, and so list the triggers of other tables too
+	triggers, err := d.firstColumn(ctx, "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = "+QuoteString(table)+" ORDER BY ACTION_ORDER")
+	if err != nil {
+		return fmt.Errorf("could not list the triggers of table %s: %s", table, err)
+	}
+
+	for _, trigger := range triggers {
+		stmt, err := d.showCreate(ctx, "SHOW CREATE TRIGGER "+QuoteIdentifier(trigger), "SQL Original Statement")
+		if err != nil {
+			return fmt.Errorf("could not read trigger %s: %s", trigger, err)
+		}
+		fmt.Fprintf(d.w, "DELIMITER ;;\n%s ;;\nDELIMITER ;\n", stmt)
+	}
+
+	return nil
+}
+
+// dumpStandInView writes a view that has the same columns as the view, but selects nothing from any table, so that the views which select
+// from it can be created before it is.
+func (d *nativeDumper) dumpStandInView(ctx context.Context, view string) error {
+	columns, err := d.firstColumn(ctx, "SHOW COLUMNS FROM "+QuoteIdentifier(view))
+	if err != nil {
+		return fmt.Errorf("could not read the columns of view %s: %s", view, err)
+	}
+	selected := make([]string, len(columns))
+	for i, column := range columns {
+		selected[i] = "\n 1 AS " + QuoteIdentifier(column)
+	}
+
+	fmt.Fprintf(d.w, "\n--\n-- Temporary view structure for view %s\n--\n\n", QuoteIdentifier(view))
+	fmt.Fprintf(d.w, "DROP TABLE IF EXISTS %[1]s;\n/*!50001 DROP VIEW IF EXISTS %[1]s*/;\n", QuoteIdentifier(view))
+	fmt.Fprintf(d.w, "/*!50001 CREATE VIEW %s AS SELECT %s*/;\n", QuoteIdentifier(view), strings.Join(selected, ","))
+
+	return nil
+}
+
+func (d *nativeDumper) dumpView(ctx context.Context, view string) error {
+	create, err := d.showCreate(ctx, "SHOW CREATE VIEW "+QuoteIdentifier(view), "Create View")
+	if err != nil {
+		return fmt.Errorf("could not read the structure of view %s: %s", view, err)
+	}
+
+	fmt.Fprintf(d.w, "\n--\n-- Final view structure for view %s\n--\n\n", QuoteIdentifier(view))
+	fmt.Fprintf(d.w, "DROP VIEW IF EXISTS %s;\n", QuoteIdentifier(view))
+	fmt.Fprintf(d.w, "%s;\n", create)
+
+	return nil
+}
+
+// firstColumn runs a query and returns the first column of every row, whatever other columns the result has.
+func (d *nativeDumper) firstColumn(ctx context.Context, query string) ([]string, error) {
+	rows, err := d.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var firsts []string
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		firsts = append(firsts, string(values[0]))
+	}
+
+	return firsts, rows.Err()
+}
+
+// showCreate runs one of the SHOW CREATE statements, whose results have a varying number of columns, and returns the named column.
+func (d *nativeDumper) showCreate(ctx context.Context, query, column string) (string, error) {
+	rows, err := d.conn.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		return "", fmt.Errorf("no result for %s", query)
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+	for i, name := range columns {
+		if name == column {
+			return string(values[i]), nil
+		}
+	}
+
+	return "", fmt.Errorf("no %s column in the result for %s", column, query)
+}
+
+func (d *nativeDumper) writeHeader(version string) {
+	host := d.credentials.Host
+	if d.credentials.Socket != "" {
+		host = d.credentials.Socket
+	}
+
+	fmt.Fprintf(d.w, "-- wp-zip native MySQL dump\n--\n-- Host: %s    Database: %s\n-- ------------------------------------------------------\n-- Server version\t%s\n\n", host, d.credentials.Name, version)
+	d.w.WriteString(`/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!50503 SET NAMES utf8mb4 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+`)
+}
+
+func (d *nativeDumper) writeFooter() {
+	d.w.WriteString(`
+/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
+
+`)
+	fmt.Fprintf(d.w, "-- Dump completed on %s\n", time.Now().Format("2006-01-02 15:04:05"))
+}
+
+// formatRow formats a single row as a parenthesized list of SQL values.
+func formatRow(values []sql.RawBytes, columnTypes []*sql.ColumnType) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = formatValue(value, columnTypes[i].DatabaseTypeName())
+	}
+	return "(" + strings.Join(formatted, ",") + ")"
+}
+
+// formatValue formats a single value according to its column type. Numbers are written as is, binary data is hex encoded (like
+// mysqldump's --hex-blob), and everything else is written as an escaped string.
+func formatValue(value sql.RawBytes, typeName string) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	switch typeName {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT", "UNSIGNED BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR":
+		return string(value)
+	case "BIT", "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		if len(value) == 0 {
+			return "''"
+		}
+		return "0x" + strings.ToUpper(hex.EncodeToString(value))
+	}
+
+	return QuoteString(string(value))
+}
+
+// QuoteIdentifier quotes a table or column name with backticks.
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// QuoteString quotes a value as a MySQL string literal, escaping it the same way mysqldump does.
+func QuoteString(value string) string {
+	r := strings.NewReplacer("\\", `\\`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "'", `\'`, "\"", `\"`, "\x1a", `\Z`)
+	return "'" + r.Replace(value) + "'"
+}
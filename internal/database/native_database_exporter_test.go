@@ -0,0 +1,229 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNativeDatabaseExporter_Export(t *testing.T) {
+	t.Run("it writes a mysqldump compatible dump through the tunnel", func(t *testing.T) {
+		server := StartFakeMysqlServer(t, fakeWordPressDatabase())
+		dialer := &DialerSpy{addr: server.Addr()}
+
+		exporter := &NativeDatabaseExporter{dialer, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}}
+
+		r, err := exporter.Export()
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		dump := string(b)
+
+		for _, want := range []string{
+			"/*!50503 SET NAMES utf8mb4 */;",
+			"DROP TABLE IF EXISTS `wp_options`;",
+			"CREATE TABLE `wp_options` (`option_id` int);",
+			"INSERT INTO `wp_options` VALUES (1,'siteurl','https://example.com'),(2,'it\\'s\\n\\\"quoted\\\"',NULL);",
+			"INSERT INTO `wp_files` VALUES (0x00FF10);",
+			"DELIMITER ;;\nCREATE TRIGGER `wp_options_trigger` BEFORE INSERT ON `wp_options` FOR EACH ROW SET @x = 1 ;;\nDELIMITER ;",
+			"DROP TABLE IF EXISTS `wp_view`;\n/*!50001 DROP VIEW IF EXISTS `wp_view`*/;\n/*!50001 CREATE VIEW `wp_view` AS SELECT \n 1 AS `1`*/;",
+			"DROP VIEW IF EXISTS `wp_view`;\nCREATE VIEW `wp_view` AS SELECT 1;",
+			"-- Dump completed on ",
+		} {
+			if !strings.Contains(dump, want) {
+				t.Errorf("got dump:\n%s\nwant it to contain:\n%s", dump, want)
+			}
+		}
+
+		// The view must be created after all of the tables, and after the stand-ins for every view
+		if strings.Index(dump, "/*!50001 CREATE VIEW") < strings.Index(dump, "CREATE TABLE `wp_files`") {
+			t.Errorf("got the stand-in view before the tables; want it after")
+		}
+		if strings.Index(dump, "\nCREATE VIEW") < strings.Index(dump, "/*!50001 CREATE VIEW") {
+			t.Errorf("got the view before its stand-in; want it after")
+		}
+
+		if dialer.network != "tcp" || dialer.requested != "localhost:3306" {
+			t.Errorf("got dial %s %s; want tcp localhost:3306", dialer.network, dialer.requested)
+		}
+	})
+
+	t.Run("it splits large tables into batched inserts", func(t *testing.T) {
+		results := fakeWordPressDatabase()
+		var rows [][]*string
+		for i := 0; i < NATIVE_INSERT_BATCH_ROWS+1; i++ {
+			rows = append(rows, []*string{str("1"), str("name"), str("value")})
+		}
+		results["SELECT * FROM `wp_options`"] = FakeResult{results["SELECT * FROM `wp_options`"].Columns, rows}
+		server := StartFakeMysqlServer(t, results)
+
+		exporter := &NativeDatabaseExporter{&DialerSpy{addr: server.Addr()}, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}}
+
+		r, _ := exporter.Export()
+		b, _ := io.ReadAll(r)
+
+		if got := strings.Count(string(b), "INSERT INTO `wp_options`"); got != 2 {
+			t.Errorf("got %d INSERT statements; want 2", got)
+		}
+	})
+
+	t.Run("it connects to the port or socket from DB_HOST", func(t *testing.T) {
+		var tests = []struct {
+			name        string
+			creds       DatabaseCredentials
+			wantNetwork string
+			wantAddr    string
+		}{
+			{"port", DatabaseCredentials{Host: "db.internal", Port: "3307"}, "tcp", "db.internal:3307"},
+			{"socket", DatabaseCredentials{Host: "localhost", Socket: "/tmp/mysql.sock"}, "unix", "/tmp/mysql.sock"},
+			{"empty host", DatabaseCredentials{}, "tcp", "localhost:3306"},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				server := StartFakeMysqlServer(t, fakeWordPressDatabase())
+				dialer := &DialerSpy{addr: server.Addr()}
+
+				r, _ := (&NativeDatabaseExporter{dialer, test.creds}).Export()
+				io.ReadAll(r)
+
+				if dialer.network != test.wantNetwork || dialer.requested != test.wantAddr {
+					t.Errorf("got dial %s %s; want %s %s", dialer.network, dialer.requested, test.wantNetwork, test.wantAddr)
+				}
+			})
+		}
+	})
+
+	t.Run("it returns an error if the tunnel cannot be opened", func(t *testing.T) {
+		exporter := &NativeDatabaseExporter{&DialerSpy{err: errors.New("channel open failed")}, DatabaseCredentials{Host: "localhost"}}
+
+		_, err := exporter.Export()
+
+		if err == nil {
+			t.Errorf("got nil; want error")
+		}
+	})
+}
+
+func TestFallbackDatabaseExporter_Export(t *testing.T) {
+	t.Run("it uses the first exporter that succeeds", func(t *testing.T) {
+		exporter := &FallbackDatabaseExporter{[]DatabaseExporter{
+			&ExporterStub{err: errors.New("php unreachable")},
+			&ExporterStub{output: "native output"},
+		}}
+
+		r, err := exporter.Export()
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "native output" {
+			t.Errorf("got %s; want native output", b)
+		}
+	})
+
+	t.Run("it returns every error if none succeed", func(t *testing.T) {
+		exporter := &FallbackDatabaseExporter{[]DatabaseExporter{
+			&ExporterStub{err: errors.New("php unreachable")},
+			&ExporterStub{err: errors.New("tunnel refused")},
+		}}
+
+		_, err := exporter.Export()
+
+		if err == nil || !strings.Contains(err.Error(), "php unreachable") || !strings.Contains(err.Error(), "tunnel refused") {
+			t.Errorf("got error %v; want both errors", err)
+		}
+	})
+}
+
+func fakeWordPressDatabase() map[string]FakeResult {
+	text := func(names ...string) []FakeColumn {
+		var columns []FakeColumn
+		for _, name := range names {
+			columns = append(columns, FakeColumn{Name: name, Type: fakeTypeVarchar})
+		}
+		return columns
+	}
+
+	return map[string]FakeResult{
+		"SELECT VERSION()": {text("VERSION()"), [][]*string{{str("8.0.36")}}},
+		"SHOW FULL TABLES": {text("Tables_in_db", "Table_type"), [][]*string{
+			{str("wp_options"), str("BASE TABLE")},
+			{str("wp_view"), str("VIEW")},
+			{str("wp_files"), str("BASE TABLE")},
+		}},
+		"SHOW CREATE TABLE `wp_options`": {text("Table", "Create Table"), [][]*string{{str("wp_options"), str("CREATE TABLE `wp_options` (`option_id` int)")}}},
+		"SELECT * FROM `wp_options`": {
+			[]FakeColumn{{Name: "option_id", Type: fakeTypeInt}, {Name: "option_name", Type: fakeTypeVarchar}, {Name: "option_value", Type: fakeTypeBlob}},
+			[][]*string{
+				{str("1"), str("siteurl"), str("https://example.com")},
+				{str("2"), str("it's\n\"quoted\""), nil},
+			},
+		},
+		"SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = 'wp_options' ORDER BY ACTION_ORDER": {text("TRIGGER_NAME"), [][]*string{{str("wp_options_trigger")}}},
+		"SHOW CREATE TRIGGER `wp_options_trigger`": {text("Trigger", "sql_mode", "SQL Original Statement"), [][]*string{
+			{str("wp_options_trigger"), str(""), str("CREATE TRIGGER `wp_options_trigger` BEFORE INSERT ON `wp_options` FOR EACH ROW SET @x = 1")},
+		}},
+		"SHOW CREATE TABLE `wp_files`": {text("Table", "Create Table"), [][]*string{{str("wp_files"), str("CREATE TABLE `wp_files` (`data` blob)")}}},
+		"SELECT * FROM `wp_files`": {
+			[]FakeColumn{{Name: "data", Type: fakeTypeBlob, Binary: true}},
+			[][]*string{{str("\x00\xff\x10")}},
+		},
+		"SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE EVENT_OBJECT_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = 'wp_files' ORDER BY ACTION_ORDER": {text("TRIGGER_NAME"), nil},
+		"SHOW COLUMNS FROM `wp_view`": {text("Field", "Type"), [][]*string{{str("1"), str("int")}}},
+		"SHOW CREATE VIEW `wp_view`":  {text("View", "Create View"), [][]*string{{str("wp_view"), str("CREATE VIEW `wp_view` AS SELECT 1")}}},
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
+
+type DialerSpy struct {
+	addr      string
+	err       error
+	network   string
+	requested string
+}
+
+func (d *DialerSpy) Dial(network, addr string) (net.Conn, error) {
+	d.network, d.requested = network, addr
+	if d.err != nil {
+		return nil, d.err
+	}
+	return net.Dial("tcp", d.addr)
+}
+
+type ExporterStub struct {
+	output string
+	err    error
+}
+
+func (e *ExporterStub) Export() (io.Reader, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return strings.NewReader(e.output), nil
+}
+
+func TestRegisterDialer(t *testing.T) {
+	dialer, other := &DialerSpy{}, &DialerSpy{}
+
+	first := registerDialer(dialer, "tcp")
+
+	if again := registerDialer(dialer, "tcp"); again != first {
+		t.Errorf("got %s the second time; want the same name %s", again, first)
+	}
+	if unix := registerDialer(dialer, "unix"); unix == first {
+		t.Errorf("got the same name for another network; want a new one")
+	}
+	if name := registerDialer(other, "tcp"); name == first {
+		t.Errorf("got the same name for another connection; want a new one")
+	}
+}
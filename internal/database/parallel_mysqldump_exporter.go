@@ -0,0 +1,261 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The name the stored routines and events are exported under, after all the tables.
+const ROUTINES_DUMP_NAME = "_routines"
+
+// The number of times a single table's dump is attempted before the whole export is given up on.
+const PARALLEL_EXPORT_ATTEMPTS = 3
+
+// This is the SQL statement used to list the tables (and views, last) that the parallel export dumps one by one.
+const SELECT_TABLES_STMT = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() ORDER BY table_type = 'VIEW', table_name;"
+
+// TableExporter is implemented by exporters that can export each table on its own.
+type TableExporter interface {
+	ExportTables(fn func(table string, r io.Reader) error) error
+}
+
+// ParallelMysqldumpDatabaseExporter dumps each table with its own `mysqldump` session, several at once, retrying a failed table on its own.
+// The tables are not consistent with each other, as they would be in a single dump.
+type ParallelMysqldumpDatabaseExporter struct {
+	commandRunner sftp.CommandRunnerUploader
+	credentials   DatabaseCredentials
+	// program is the dump program that was detected on the server
+	program string
+	opts    ExportOptions
+	random  func() string
+}
+
+// Export writes the tables one after another, in order, into a single stream.
+func (e *ParallelMysqldumpDatabaseExporter) Export() (io.Reader, error) {
+	jobs, err := e.listJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(e.exportTables(jobs, func(table string, r io.Reader) error {
+			_, err := io.Copy(writer, r)
+			return err
+		}))
+	}()
+
+	// Compressed streams can simply be concatenated, both gzip and zstd read them back as a single stream
+	if jobs[0].compression.Enabled() {
+		return &EncodedReader{reader, jobs[0].compression}, nil
+	}
+
+	return reader, nil
+}
+
+// ExportTables calls fn with the dump of each table, in order, followed by the routines and events under ROUTINES_DUMP_NAME. The dumps
+// themselves run concurrently, and are buffered in temporary files until it is their turn.
+func (e *ParallelMysqldumpDatabaseExporter) ExportTables(fn func(table string, r io.Reader) error) error {
+	jobs, err := e.listJobs()
+	if err != nil {
+		return err
+	}
+
+	return e.exportTables(jobs, fn)
+}
+
+// dumpJob is a single run of the dump program, for a single table or for the routines.
+type dumpJob struct {
+	name        string
+	program     string
+	args        string
+	tables      []string
+	compression Compression
+}
+
+func (e *ParallelMysqldumpDatabaseExporter) listJobs() ([]dumpJob, error) {
+	if e.program == "" {
+		return nil, ErrNoDumpProgram
+	}
+	cli := &MysqlCli{e.commandRunner, e.credentials, e.random}
+
+	dump, err := NewMysqldump(cli, e.program, e.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := listTables(cli)
+	if err != nil {
+		return nil, err
+	}
+	// Only the tables of a subsite are dumped, rather than the whole network
+	if e.opts.Subsite != nil {
+		tables = NewSubsiteFilter(*e.opts.Subsite).Tables(tables)
+	}
+
+	compression := CompressionNone
+	if e.opts.Compression.AvailableRemotely(e.commandRunner) {
+		compression = e.opts.Compression
+	}
+
+	var jobs []dumpJob
+	for _, table := range tables {
+		jobs = append(jobs, dumpJob{table, dump.Program, dump.TableArgs(), []string{table}, compression})
+	}
+	if args := dump.RoutineArgs(); args != "" {
+		jobs = append(jobs, dumpJob{ROUTINES_DUMP_NAME, dump.Program, args, nil, compression})
+	}
+
+	return jobs, nil
+}
+
+func listTables(cli *MysqlCli) ([]string, error) {
+	output, err := cli.Run("mysql", `--skip-column-names --silent -e "`+SELECT_TABLES_STMT+`"`)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(output)
+	if err != nil {
+		return nil, fmt.Errorf("could not list tables: %s", err)
+	}
+
+	var tables []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			tables = append(tables, line)
+		}
+	}
+	if len(tables) == 0 {
+		return nil, errors.New("could not list tables: no tables found")
+	}
+
+	return tables, nil
+}
+
+type tableDump struct {
+	file *os.File
+	err  error
+}
+
+func (e *ParallelMysqldumpDatabaseExporter) exportTables(jobs []dumpJob, fn func(table string, r io.Reader) error) error {
+	concurrency := e.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Each table gets its own channel, so that the results can be handed to fn in order no matter which dump finishes first
+	results := make([]chan tableDump, len(jobs))
+	for i := range results {
+		results[i] = make(chan tableDump, 1)
+	}
+	queue := make(chan int)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				file, err := e.dumpWithRetries(jobs[i])
+				results[i] <- tableDump{file, err}
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for i := range jobs {
+			select {
+			case queue <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var err error
+	for i, job := range jobs {
+		result := <-results[i]
+		err = result.err
+		if err == nil {
+			var r io.Reader = result.file
+			if job.compression.Enabled() {
+				r = &EncodedReader{r, job.compression}
+			}
+			err = fn(job.name, r)
+		}
+		removeTempFile(result.file)
+		if err != nil {
+			break
+		}
+	}
+
+	// If we stopped early, no more tables are queued, and any dump that already finished has its temporary file removed
+	close(stop)
+	wg.Wait()
+	for _, result := range results {
+		select {
+		case r := <-result:
+			removeTempFile(r.file)
+		default:
+		}
+	}
+
+	return err
+}
+
+// dumpWithRetries dumps a single table into a temporary file, retrying it on its own if the dump fails.
+func (e *ParallelMysqldumpDatabaseExporter) dumpWithRetries(job dumpJob) (*os.File, error) {
+	var err error
+	for attempt := 1; attempt <= PARALLEL_EXPORT_ATTEMPTS; attempt++ {
+		var file *os.File
+		file, err = e.dump(job)
+		if err == nil {
+			return file, nil
+		}
+		log.Printf("dump of table %s failed (attempt %d of %d): %s", job.name, attempt, PARALLEL_EXPORT_ATTEMPTS, err)
+	}
+
+	return nil, fmt.Errorf("could not dump table %s: %s", job.name, err)
+}
+
+func (e *ParallelMysqldumpDatabaseExporter) dump(job dumpJob) (*os.File, error) {
+	file, err := os.CreateTemp("", "wp-zip-table-*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	cli := &MysqlCli{e.commandRunner, e.credentials, e.random}
+	var output io.Reader
+	if job.compression.Enabled() {
+		output, err = cli.RunCompressed(job.compression, job.program, job.args, job.tables...)
+	} else {
+		output, err = cli.Run(job.program, job.args, job.tables...)
+	}
+	if err == nil {
+		_, err = io.Copy(file, output)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTempFile(file)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func removeTempFile(file *os.File) {
+	if file == nil {
+		return
+	}
+	file.Close()
+	os.Remove(file.Name())
+}
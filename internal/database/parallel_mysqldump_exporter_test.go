@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelMysqldumpDatabaseExporter_Export(t *testing.T) {
+	t.Run("it returns an error if the remote server cannot run the mysqldump command", func(t *testing.T) {
+		exporter := &ParallelMysqldumpDatabaseExporter{&TableDumpRunnerStub{}, DatabaseCredentials{Name: "Dbname"}, "", ExportOptions{Concurrency: 2}, randomStub}
+
+		_, err := exporter.Export()
+
+		if err == nil || err.Error() != "mysqldump command not found" {
+			t.Errorf("got error %v; want mysqldump command not found", err)
+		}
+	})
+
+	t.Run("it writes every table in order no matter which finishes first", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{
+			tables: []string{"wp_options", "wp_posts", "wp_users"},
+			// The first table is the slowest, so the others finish before it
+			delays: map[string]time.Duration{"wp_options": 50 * time.Millisecond},
+		}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 3}, randomStub}
+
+		r, err := exporter.Export()
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+
+		want := "-- wp_options\n-- wp_posts\n-- wp_users\n-- routines\n"
+		if string(b) != want {
+			t.Errorf("got %q; want %q", string(b), want)
+		}
+	})
+
+	t.Run("it dumps each table with its own single transaction, and the routines once", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{tables: []string{"wp_posts"}}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 2}, randomStub}
+
+		r, _ := exporter.Export()
+		_, _ = io.ReadAll(r)
+
+		for _, want := range []string{
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysqldump --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --no-tablespaces --single-transaction --quick --triggers --hex-blob --skip-routines --skip-events 'Dbname' 'wp_posts'`,
+			`trap "rm -f '/home/user/.wp-zip-abc.cnf'" EXIT; mysqldump --defaults-extra-file='/home/user/.wp-zip-abc.cnf' --no-tablespaces --no-create-info --no-data --skip-triggers --routines 'Dbname'`,
+		} {
+			if !runner.ran(want) {
+				t.Errorf("got commands %v; want %s", runner.commandsRun, want)
+			}
+		}
+	})
+
+	t.Run("it retries a failed table on its own", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{
+			tables:   []string{"wp_options", "wp_posts"},
+			failures: map[string]int{"wp_posts": PARALLEL_EXPORT_ATTEMPTS - 1},
+		}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 2}, randomStub}
+
+		r, _ := exporter.Export()
+		b, err := io.ReadAll(r)
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if string(b) != "-- wp_options\n-- wp_posts\n-- routines\n" {
+			t.Errorf("got %q; want both tables", string(b))
+		}
+		if runner.dumps["wp_options"] != 1 || runner.dumps["wp_posts"] != PARALLEL_EXPORT_ATTEMPTS {
+			t.Errorf("got dumps %v; want wp_options once and wp_posts %d times", runner.dumps, PARALLEL_EXPORT_ATTEMPTS)
+		}
+	})
+
+	t.Run("it gives up once a table has failed every attempt", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{
+			tables:   []string{"wp_options", "wp_posts"},
+			failures: map[string]int{"wp_posts": PARALLEL_EXPORT_ATTEMPTS},
+		}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 2}, randomStub}
+
+		r, _ := exporter.Export()
+		_, err := io.ReadAll(r)
+
+		if err == nil || !strings.Contains(err.Error(), "could not dump table wp_posts") {
+			t.Errorf("got error %v; want could not dump table wp_posts", err)
+		}
+	})
+}
+
+func TestParallelMysqldumpDatabaseExporter_ExportTables(t *testing.T) {
+	t.Run("it hands each table over on its own", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{tables: []string{"wp_options", "wp_posts"}}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 2}, randomStub}
+
+		got := map[string]string{}
+		var order []string
+		err := exporter.ExportTables(func(table string, r io.Reader) error {
+			b, _ := io.ReadAll(r)
+			got[table] = string(b)
+			order = append(order, table)
+			return nil
+		})
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if strings.Join(order, ",") != "wp_options,wp_posts,_routines" {
+			t.Errorf("got tables %v; want wp_options,wp_posts,_routines", order)
+		}
+		if got["wp_posts"] != "-- wp_posts\n" {
+			t.Errorf("got %q; want -- wp_posts", got["wp_posts"])
+		}
+	})
+
+	t.Run("it only dumps the tables of a subsite", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{tables: []string{"wp_2_options", "wp_2_posts", "wp_3_posts", "wp_blogs", "wp_options", "wp_users"}}
+		subsite := &types.Extraction{Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_"}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 2, Subsite: subsite}, randomStub}
+
+		var order []string
+		err := exporter.ExportTables(func(table string, r io.Reader) error {
+			order = append(order, table)
+			return nil
+		})
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if strings.Join(order, ",") != "wp_2_options,wp_2_posts,wp_users,_routines" {
+			t.Errorf("got tables %v; want wp_2_options,wp_2_posts,wp_users,_routines", order)
+		}
+	})
+
+	t.Run("it stops at the first error", func(t *testing.T) {
+		runner := &TableDumpRunnerStub{tables: []string{"wp_options", "wp_posts", "wp_users"}}
+		exporter := &ParallelMysqldumpDatabaseExporter{runner, DatabaseCredentials{Name: "Dbname"}, "mysqldump", ExportOptions{Concurrency: 1}, randomStub}
+
+		var calls int
+		err := exporter.ExportTables(func(table string, r io.Reader) error {
+			calls++
+			return errors.New("write failed")
+		})
+
+		if err == nil || err.Error() != "write failed" {
+			t.Errorf("got error %v; want write failed", err)
+		}
+		if calls != 1 {
+			t.Errorf("got %d calls; want 1", calls)
+		}
+	})
+}
+
+// TableDumpRunnerStub answers the table listing and the per table dumps of the parallel exporter. It is safe to use from several goroutines.
+type TableDumpRunnerStub struct {
+	tables []string
+	delays map[string]time.Duration
+	// The number of times the dump of a table fails before it succeeds
+	failures    map[string]int
+	mu          sync.Mutex
+	commandsRun []string
+	dumps       map[string]int
+}
+
+func (r *TableDumpRunnerStub) CanRunRemoteCommand(command string) bool {
+	return command == "mysqldump --version"
+}
+
+func (r *TableDumpRunnerStub) RunRemoteCommand(command string) (io.Reader, error) {
+	r.mu.Lock()
+	r.commandsRun = append(r.commandsRun, command)
+	r.mu.Unlock()
+
+	if strings.Contains(command, "information_schema.tables") {
+		return strings.NewReader(strings.Join(r.tables, "\n") + "\n"), nil
+	}
+	if strings.Contains(command, "--no-data") {
+		return strings.NewReader("-- routines\n"), nil
+	}
+
+	for _, table := range r.tables {
+		if !strings.HasSuffix(command, " "+sftp.ShellQuote(table)) {
+			continue
+		}
+
+		r.mu.Lock()
+		if r.dumps == nil {
+			r.dumps = map[string]int{}
+		}
+		r.dumps[table]++
+		failed := r.dumps[table] <= r.failures[table]
+		r.mu.Unlock()
+
+		time.Sleep(r.delays[table])
+		if failed {
+			return nil, errors.New("connection lost")
+		}
+		return strings.NewReader("-- " + table + "\n"), nil
+	}
+
+	return nil, errors.New("unexpected command: " + command)
+}
+
+func (r *TableDumpRunnerStub) ran(command string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.commandsRun {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TableDumpRunnerStub) Upload(src io.Reader, dst string) error   { return nil }
+func (r *TableDumpRunnerStub) Delete(dst string) error                  { return nil }
+func (r *TableDumpRunnerStub) Mkdir(dst string) error                   { return nil }
+func (r *TableDumpRunnerStub) Chmod(dst string, mode os.FileMode) error { return nil }
+func (r *TableDumpRunnerStub) Getwd() (string, error)                   { return "/home/user", nil }
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
 	"io"
@@ -21,43 +22,35 @@ const MYSQLDUMP_PHP_VERSION = "2.11"
 // The script we utilize is bundled with this package to lock its version and ensure that it is always available. It's source can be found here:
 // https://github.com/ifsnop/mysqldump-php
 type PHPDatabaseExporter struct {
-	u           sftp.FileUploadDeleter
+	u           sftp.CommandRunnerUploader
 	p           types.PublicPath
 	siteUrl     types.SiteUrl
 	g           HttpGetter
 	e           emitter.FileEmitter
 	credentials DatabaseCredentials
+	random      func() string
 }
 
 // Export We need to upload the PHP script to the server, then run it to generate the database dump.
 func (e *PHPDatabaseExporter) Export() (io.Reader, error) {
-	// First, create a directory on the remote host to house our working directory
-	dirName := e.p.String() + "wp-zip-database-export"
-	err := e.u.Mkdir(dirName)
-	if err != nil {
-		return nil, err
-	}
-	defer e.deleteUploadedDir(dirName)
-
-	// Next, upload the PHP script to the remote host
 	dumper, err := extractPhpDumpScriptFromZip()
 	if err != nil {
 		return nil, err
 	}
 	defer dumper.Close()
-	err = e.u.Upload(dumper, dirName+"/Mysqldump.php")
-	if err != nil {
-		return nil, err
-	}
-	// We also want to our short script that utilizes the dumper
-	script := getPhpScriptContents(e.credentials)
-	err = e.u.Upload(strings.NewReader(script), dirName+"/dump.php")
+
+	// Upload our short script that utilizes the dumper, along with the dumper itself. They are placed into their own
+	// protected directory on the remote host, which is always removed once we are done.
+	deployment, err := phpscript.NewDeployer(e.u, e.p, e.random).Deploy(getPhpScriptContents(e.credentials), map[string]io.Reader{
+		"Mysqldump.php": dumper,
+	})
 	if err != nil {
 		return nil, err
 	}
+	defer deployment.Remove()
 
-	// Finally, run the script on the remote host by making an HTTP request to it
-	resp, err := e.g.Get(string(e.siteUrl) + "/wp-zip-database-export/dump.php")
+	// Finally, run the script on the remote host, with the PHP CLI if we can and by making an HTTP request to it otherwise
+	resp, err := deployment.Execute(e.u, e.g, e.siteUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", errors.New("invalid response from server"), err)
 	}
@@ -66,13 +59,6 @@ func (e *PHPDatabaseExporter) Export() (io.Reader, error) {
 	return resp, nil
 }
 
-func (e *PHPDatabaseExporter) deleteUploadedDir(dirName string) error {
-	e.u.Delete(dirName + "/Mysqldump.php")
-	e.u.Delete(dirName + "/dump.php")
-	e.u.Delete(dirName + "/dump.sql")
-	return e.u.Delete(dirName)
-}
-
 func extractPhpDumpScriptFromZip() (io.ReadCloser, error) {
 	zf, err := assets.ReadFile("assets/mysqldump-php-" + MYSQLDUMP_PHP_VERSION + ".zip")
 	if err != nil {
@@ -97,9 +83,43 @@ func getPhpScriptContents(creds DatabaseCredentials) string {
 	return fmt.Sprintf(`<?php
 
 include_once(dirname(__FILE__) . '/Mysqldump.php');
-$dump = new Ifsnop\Mysqldump\Mysqldump('mysql:host=localhost;dbname=%s', '%s', '%s');
-$dump->start(dirname(__FILE__) . '/dump.sql');
-$contents = file_get_contents(dirname(__FILE__) . '/dump.sql');
-echo $contents;
-`, creds.Name, creds.User, creds.Pass)
+$dump = new Ifsnop\Mysqldump\Mysqldump(%s, %s, %s);
+// Write straight to the response so that the dump never touches the disk inside the webroot
+$dump->start('php://output');
+`, phpscript.Quote(PhpPdoDsn(creds)), phpscript.Quote(creds.User), phpscript.Quote(creds.Pass))
+}
+
+// PhpPdoDsn returns the PDO data source name for connecting to the database from PHP.
+func PhpPdoDsn(creds DatabaseCredentials) string {
+	if creds.Socket != "" {
+		return "mysql:unix_socket=" + creds.Socket + ";dbname=" + creds.Name
+	}
+
+	dsn := "mysql:host=" + phpHost(creds)
+	if creds.Port != "" {
+		dsn += ";port=" + creds.Port
+	}
+
+	return dsn + ";dbname=" + creds.Name
+}
+
+// PhpMysqliConnect returns a PHP mysqli_connect() call for connecting to the database.
+func PhpMysqliConnect(creds DatabaseCredentials) string {
+	port := "null"
+	if creds.Port != "" {
+		port = creds.Port
+	}
+	socket := "null"
+	if creds.Socket != "" {
+		socket = phpscript.Quote(creds.Socket)
+	}
+
+	return fmt.Sprintf("mysqli_connect(%s, %s, %s, %s, %s, %s)", phpscript.Quote(phpHost(creds)), phpscript.Quote(creds.User), phpscript.Quote(creds.Pass), phpscript.Quote(creds.Name), port, socket)
+}
+
+func phpHost(creds DatabaseCredentials) string {
+	if creds.Host == "" {
+		return "localhost"
+	}
+	return creds.Host
 }
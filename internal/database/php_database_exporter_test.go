@@ -0,0 +1,48 @@
+package database
+
+import "testing"
+
+func TestPhpConnectionStrings(t *testing.T) {
+	var tests = []struct {
+		name        string
+		creds       DatabaseCredentials
+		wantDsn     string
+		wantConnect string
+	}{
+		{
+			"host only",
+			DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"},
+			"mysql:host=localhost;dbname=db",
+			"mysqli_connect('localhost', 'user', 'pass', 'db', null, null)",
+		},
+		{
+			"host and port",
+			DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "db.internal", Port: "3307"},
+			"mysql:host=db.internal;port=3307;dbname=db",
+			"mysqli_connect('db.internal', 'user', 'pass', 'db', 3307, null)",
+		},
+		{
+			"socket",
+			DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Socket: "/tmp/mysql.sock"},
+			"mysql:unix_socket=/tmp/mysql.sock;dbname=db",
+			"mysqli_connect('localhost', 'user', 'pass', 'db', null, '/tmp/mysql.sock')",
+		},
+		{
+			"quotes in password",
+			DatabaseCredentials{User: "user", Pass: `pa'ss\`, Name: "db", Host: "localhost"},
+			"mysql:host=localhost;dbname=db",
+			`mysqli_connect('localhost', 'user', 'pa\'ss\\', 'db', null, null)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := PhpPdoDsn(test.creds); got != test.wantDsn {
+				t.Errorf("got %s; want %s", got, test.wantDsn)
+			}
+			if got := PhpMysqliConnect(test.creds); got != test.wantConnect {
+				t.Errorf("got %s; want %s", got, test.wantConnect)
+			}
+		})
+	}
+}
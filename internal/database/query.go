@@ -0,0 +1,151 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"strings"
+)
+
+// The mysql client escapes these characters in its tab-separated output.
+var cliUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\0`, "\x00")
+
+// Querier runs a query against the site's database, with the mysql client when the server has it. Without it, or when the client's option
+// file can't be uploaded such as in a dry run, the query goes over the MySQL protocol through the SSH tunnel, and a PHP script is the last
+// resort when the server has no mysql client.
+type Querier struct {
+	c           sftp.CommandRunnerUploader
+	p           types.PublicPath
+	siteUrl     types.SiteUrl
+	g           HttpGetter
+	credentials DatabaseCredentials
+	random      func() string
+	// php is whether a PHP script may be deployed into the webroot to run the query
+	php bool
+}
+
+// NewQuerier is the constructor for Querier.
+func NewQuerier(c sftp.CommandRunnerUploader, p types.PublicPath, siteUrl types.SiteUrl, g HttpGetter, credentials DatabaseCredentials) *Querier {
+	return &Querier{c, p, siteUrl, g, credentials, phpscript.RandomName, true}
+}
+
+// Query runs the statement and returns its rows, each of which must have the given number of columns.
+func (q *Querier) Query(stmt string, columns int) ([][]string, error) {
+	rows, err := q.query(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if len(row) != columns {
+			return nil, fmt.Errorf("unexpected row %q", strings.Join(row, "\t"))
+		}
+	}
+
+	return rows, nil
+}
+
+func (q *Querier) query(stmt string) ([][]string, error) {
+	var errs []error
+	cli := q.c.CanRunRemoteCommand(capabilities.Mysql.Command)
+	if cli {
+		rows, err := q.queryWithCli(stmt)
+		if err == nil {
+			return rows, nil
+		}
+		errs = append(errs, err)
+	}
+	if d, ok := q.c.(sftp.Dialer); ok {
+		rows, err := ReadRows(d, q.credentials, stmt)
+		if err == nil {
+			return rows, nil
+		}
+		errs = append(errs, err)
+	}
+	if !cli && q.php {
+		rows, err := q.queryWithPHP(stmt)
+		if err == nil {
+			return rows, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("the server has no mysql client")
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (q *Querier) queryWithCli(stmt string) ([][]string, error) {
+	output, err := NewMysqlCli(q.c, q.credentials).Run("mysql", "--skip-column-names --silent -e "+sftp.ShellQuote(stmt))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	scanner := bufio.NewScanner(output)
+	// An option value can be much longer than the default limit of a line
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		row := strings.Split(scanner.Text(), "\t")
+		for i := range row {
+			row[i] = cliUnescaper.Replace(row[i])
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+func (q *Querier) queryWithPHP(stmt string) ([][]string, error) {
+	deployment, err := phpscript.NewDeployer(q.c, q.p, q.random).Deploy(getQueryScriptContents(q.credentials, stmt), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer deployment.Remove()
+
+	resp, err := deployment.Execute(q.c, q.g, q.siteUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	var result struct {
+		Rows  [][]string `json:"rows"`
+		Error string     `json:"error"`
+	}
+	if err := json.NewDecoder(resp).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unexpected response from the script: %s", err)
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	return result.Rows, nil
+}
+
+func getQueryScriptContents(creds DatabaseCredentials, stmt string) string {
+	return fmt.Sprintf(`<?php
+
+$link = %s;
+$result = mysqli_query($link, %s);
+
+header('Content-Type: application/json');
+if (!$result) {
+    echo json_encode(['error' => mysqli_error($link)]);
+    exit;
+}
+// Every value is returned as a string, the same as the mysql client prints them
+$rows = [];
+while ($row = mysqli_fetch_row($result)) {
+    $rows[] = array_map('strval', $row);
+}
+echo json_encode(['rows' => $rows]);
+mysqli_close($link);
+`, PhpMysqliConnect(creds), phpscript.Quote(stmt))
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The largest estimated number of rows for which a table is still counted exactly with COUNT(*). Larger tables are only compared with the
+// estimate from information_schema, since counting them would take too long.
+const EXACT_COUNT_MAX_ROWS = 250000
+
+// This is the SQL statement used to list the tables along with their estimated number of rows.
+const SELECT_TABLE_ROWS_STMT = "SELECT table_name, table_rows FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE';"
+
+// TableRowCount is how many rows a table holds. Exact is false when the count is only the estimate from information_schema.
+type TableRowCount struct {
+	Rows  int64
+	Exact bool
+}
+
+// RowCounter counts the rows of every table in the database.
+type RowCounter interface {
+	CountRows() (map[string]TableRowCount, error)
+}
+
+// NewRowCounter is a factory function that returns a RowCounter. The mysql client is tried first, and the connection through the SSH tunnel
+// is used if the client can't be run.
+func NewRowCounter(c sftp.Client, creds DatabaseCredentials) RowCounter {
+	return &FallbackRowCounter{[]RowCounter{
+		&CliRowCounter{&MysqlCli{c, creds, phpscript.RandomName}},
+		&NativeRowCounter{c, creds},
+	}}
+}
+
+// SubsiteRowCounter only counts the tables that are dumped for a subsite, so that the tables of the rest of the network aren't reported as
+// missing from its dump.
+type SubsiteRowCounter struct {
+	counter RowCounter
+	filter  *SubsiteFilter
+}
+
+// NewSubsiteRowCounter is the constructor for SubsiteRowCounter.
+func NewSubsiteRowCounter(counter RowCounter, extraction types.Extraction) *SubsiteRowCounter {
+	return &SubsiteRowCounter{counter, NewSubsiteFilter(extraction)}
+}
+
+func (c *SubsiteRowCounter) CountRows() (map[string]TableRowCount, error) {
+	counts, err := c.counter.CountRows()
+	if err != nil {
+		return nil, err
+	}
+
+	for table := range counts {
+		if _, ok := c.filter.Table(table); !ok {
+			delete(counts, table)
+		}
+	}
+
+	return counts, nil
+}
+
+// FallbackRowCounter tries each of its counters in order, and returns the counts of the first one that succeeds.
+type FallbackRowCounter struct {
+	counters []RowCounter
+}
+
+func (c *FallbackRowCounter) CountRows() (map[string]TableRowCount, error) {
+	var errs []error
+	for _, counter := range c.counters {
+		counts, err := counter.CountRows()
+		if err == nil {
+			return counts, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// CliRowCounter counts the rows with the mysql client on the remote server.
+type CliRowCounter struct {
+	cli *MysqlCli
+}
+
+func (c *CliRowCounter) CountRows() (map[string]TableRowCount, error) {
+	return countRows(func(query string) ([][]string, error) {
+		output, err := c.cli.Run("mysql", "--skip-column-names --silent -e "+sftp.ShellQuote(query))
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(output)
+		if err != nil {
+			return nil, err
+		}
+
+		var rows [][]string
+		for _, line := range strings.Split(string(b), "\n") {
+			if line != "" {
+				rows = append(rows, strings.Split(line, "\t"))
+			}
+		}
+		return rows, nil
+	})
+}
+
+// NativeRowCounter counts the rows through a connection that is tunnelled through the SSH connection.
+type NativeRowCounter struct {
+	d           sftp.Dialer
+	credentials DatabaseCredentials
+}
+
+func (c *NativeRowCounter) CountRows() (map[string]TableRowCount, error) {
+	db, err := (&NativeDatabaseExporter{c.d, c.credentials}).open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return countRows(func(query string) ([][]string, error) {
+		result, err := db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer result.Close()
+
+		var rows [][]string
+		for result.Next() {
+			var name string
+			var count sql.NullString
+			if err := result.Scan(&name, &count); err != nil {
+				return nil, err
+			}
+			rows = append(rows, []string{name, count.String})
+		}
+		return rows, result.Err()
+	})
+}
+
+// countRows reads the estimates from information_schema, and then counts the smaller tables exactly, all in a single query. The query
+// function returns each row as a table name and a number.
+func countRows(query func(query string) ([][]string, error)) (map[string]TableRowCount, error) {
+	rows, err := query(SELECT_TABLE_ROWS_STMT)
+	if err != nil {
+		return nil, fmt.Errorf("could not read table rows: %s", err)
+	}
+
+	counts := map[string]TableRowCount{}
+	var selects []string
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("could not read table rows: unexpected row %q", row)
+		}
+		// The estimate is NULL for some engines, which are then counted exactly
+		estimate, _ := strconv.ParseInt(row[1], 10, 64)
+		counts[row[0]] = TableRowCount{Rows: estimate}
+		if estimate <= EXACT_COUNT_MAX_ROWS {
+			selects = append(selects, "SELECT "+QuoteString(row[0])+", COUNT(*) FROM "+QuoteIdentifier(row[0]))
+		}
+	}
+	if len(selects) == 0 {
+		return counts, nil
+	}
+
+	rows, err = query(strings.Join(selects, " UNION ALL ") + ";")
+	if err != nil {
+		return nil, fmt.Errorf("could not count table rows: %s", err)
+	}
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("could not count table rows: unexpected row %q", row)
+		}
+		count, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not count table rows: %s", err)
+		}
+		counts[row[0]] = TableRowCount{Rows: count, Exact: true}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// replaceInValue applies fn to a value, or to every string inside of it when it is serialized by PHP. The length of each serialized string
+// is written again, since changing a string without its length makes the whole value unreadable to PHP.
+func replaceInValue(value string, fn func(string) string) string {
+	if replaced, ok := replaceSerialized(value, fn); ok {
+		return replaced
+	}
+	return fn(value)
+}
+
+// replaceSerialized reports false when the value isn't serialized, or can't be read.
+func replaceSerialized(value string, fn func(string) string) (string, bool) {
+	if len(value) < 2 || !strings.ContainsRune("aObisdNCEr", rune(value[0])) || (value[1] != ':' && value[1] != ';') {
+		return "", false
+	}
+
+	p := &serializedParser{s: value, fn: fn}
+	if !p.value() || p.i != len(p.s) {
+		return "", false
+	}
+
+	return p.b.String(), true
+}
+
+// serializedParser reads a serialized value, and writes it again with fn applied to its strings.
+type serializedParser struct {
+	s  string
+	i  int
+	fn func(string) string
+	b  strings.Builder
+}
+
+func (p *serializedParser) value() bool {
+	if p.i+1 >= len(p.s) {
+		return false
+	}
+
+	switch kind := p.s[p.i]; kind {
+	case 'N':
+		return p.copy("N;")
+	case 'b', 'i', 'd', 'r', 'R':
+		// A scalar or a reference, which is written as it is up to its semicolon
+		end := strings.IndexByte(p.s[p.i:], ';')
+		if end < 0 {
+			return false
+		}
+		p.b.WriteString(p.s[p.i : p.i+end+1])
+		p.i += end + 1
+		return true
+	case 's':
+		p.i += 2
+		contents, ok := p.lengthPrefixed(`";`)
+		if !ok {
+			return false
+		}
+		contents = replaceInValue(contents, p.fn)
+		p.b.WriteString("s:" + strconv.Itoa(len(contents)) + `:"` + contents + `";`)
+		return true
+	case 'E':
+		// An enum case, whose name never holds a link
+		start := p.i
+		p.i += 2
+		if _, ok := p.lengthPrefixed(`";`); !ok {
+			return false
+		}
+		p.b.WriteString(p.s[start:p.i])
+		return true
+	case 'a', 'O':
+		start := p.i
+		p.i += 2
+		if kind == 'O' {
+			if _, ok := p.lengthPrefixed(`":`); !ok {
+				return false
+			}
+		}
+		count, ok := p.number(':')
+		if !ok || !strings.HasPrefix(p.s[p.i:], "{") {
+			return false
+		}
+		p.i++
+		p.b.WriteString(p.s[start:p.i])
+		// Each element is a key followed by its value
+		for n := 0; n < count*2; n++ {
+			if !p.value() {
+				return false
+			}
+		}
+		return p.copy("}")
+	case 'C':
+		// An object that serializes itself, which is kept as it is since only the class can read it
+		start := p.i
+		p.i += 2
+		if _, ok := p.lengthPrefixed(`":`); !ok {
+			return false
+		}
+		length, ok := p.number(':')
+		if !ok || p.i+length+2 > len(p.s) || p.s[p.i] != '{' || p.s[p.i+length+1] != '}' {
+			return false
+		}
+		p.i += length + 2
+		p.b.WriteString(p.s[start:p.i])
+		return true
+	}
+
+	return false
+}
+
+// lengthPrefixed reads a length, followed by a quoted string of that many bytes and then the terminator.
+func (p *serializedParser) lengthPrefixed(terminator string) (string, bool) {
+	length, ok := p.number(':')
+	if !ok || !strings.HasPrefix(p.s[p.i:], `"`) || p.i+1+length > len(p.s) {
+		return "", false
+	}
+	contents := p.s[p.i+1 : p.i+1+length]
+	p.i += 1 + length
+	if !strings.HasPrefix(p.s[p.i:], terminator) {
+		return "", false
+	}
+	p.i += len(terminator)
+
+	return contents, true
+}
+
+// number reads a number up to the separator.
+func (p *serializedParser) number(separator byte) (int, bool) {
+	end := strings.IndexByte(p.s[p.i:], separator)
+	if end < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(p.s[p.i : p.i+end])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	p.i += end + 1
+
+	return n, true
+}
+
+func (p *serializedParser) copy(expected string) bool {
+	if !strings.HasPrefix(p.s[p.i:], expected) {
+		return false
+	}
+	p.b.WriteString(expected)
+	p.i += len(expected)
+
+	return true
+}
+
+// serializedStrings returns the strings inside of a serialized value in order, such as the plugin files of active_plugins. It reports
+// false when the value isn't serialized.
+func serializedStrings(value string) ([]string, bool) {
+	var strs []string
+	_, ok := replaceSerialized(value, func(s string) string {
+		strs = append(strs, s)
+		return s
+	})
+
+	return strs, ok
+}
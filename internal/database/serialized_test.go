@@ -0,0 +1,67 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplaceInValue(t *testing.T) {
+	replace := func(s string) string {
+		return strings.ReplaceAll(s, "example.com/blog", "example.com")
+	}
+
+	var tests = []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "see https://example.com/blog/about", "see https://example.com/about"},
+		{"serialized string", `s:24:"https://example.com/blog";`, `s:19:"https://example.com";`},
+		{
+			"serialized array",
+			`a:2:{s:3:"url";s:24:"https://example.com/blog";i:0;b:1;}`,
+			`a:2:{s:3:"url";s:19:"https://example.com";i:0;b:1;}`,
+		},
+		{
+			"serialized object with a nested serialized string",
+			`O:8:"stdClass":1:{s:4:"data";s:38:"a:1:{i:0;s:20:"//example.com/blog/x";}";}`,
+			`O:8:"stdClass":1:{s:4:"data";s:33:"a:1:{i:0;s:15:"//example.com/x";}";}`,
+		},
+		{"lengths are in bytes", `s:27:"https://example.com/blog/é";`, `s:22:"https://example.com/é";`},
+		{"broken serialization is replaced as text", `s:99:"https://example.com/blog";`, `s:99:"https://example.com";`},
+		{"scalars", `i:5;`, `i:5;`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceInValue(tt.value, replace); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSerializedStrings(t *testing.T) {
+	var tests = []struct {
+		name   string
+		value  string
+		want   []string
+		wantOk bool
+	}{
+		{"values of a list", `a:2:{i:0;s:19:"akismet/akismet.php";i:1;s:9:"hello.php";}`, []string{"akismet/akismet.php", "hello.php"}, true},
+		{"keys of a map", `a:1:{s:19:"akismet/akismet.php";i:1700000000;}`, []string{"akismet/akismet.php"}, true},
+		{"empty", `a:0:{}`, nil, true},
+		{"not serialized", `akismet/akismet.php`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := serializedStrings(tt.value)
+
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, %v; want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The tables that hold the settings of the whole network. They are left out of the dump of a single site.
+var networkTables = []string{"blogs", "blogmeta", "blog_versions", "registration_log", "signups", "site", "sitemeta", "sitecategories"}
+
+var (
+	quotedIdentifier = regexp.MustCompile("`((?:[^`]|``)+)`")
+	columnDefinition = regexp.MustCompile("(?m)^\\s+`((?:[^`]|``)+)`")
+	valuesClause     = regexp.MustCompile(`(?i)\bVALUES?\s*\(`)
+)
+
+// SubsiteFilter rewrites the dump of a whole multisite network into the dump of one of its sites as a single site. The tables of the
+// subsite take the base prefix, the tables of the network and of every other site are left out, and the shared users tables only keep
+// the members of the subsite. Links to the subsite and its uploads are changed to where they will be once it is a single site.
+type SubsiteFilter struct {
+	extraction types.Extraction
+	members    map[string]bool
+	// numbered matches the tables and keys of every site but the first, such as wp_2_posts
+	numbered     *regexp.Regexp
+	replacements []linkReplacement
+}
+
+// linkReplacement replaces a link wherever it is followed by the end of the value or by a character that can't continue it, so that
+// example.com/blog doesn't also match example.com/blog-archive.
+type linkReplacement struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NewSubsiteFilter is the constructor for SubsiteFilter.
+func NewSubsiteFilter(extraction types.Extraction) *SubsiteFilter {
+	f := &SubsiteFilter{
+		extraction: extraction,
+		members:    map[string]bool{},
+		numbered:   regexp.MustCompile("^" + regexp.QuoteMeta(extraction.Prefix) + `\d+_`),
+	}
+	for _, id := range extraction.Members {
+		f.members[fmt.Sprint(id)] = true
+	}
+
+	subsite := extraction.Subsite
+	oldBase := "//" + subsite.Domain + strings.TrimSuffix(subsite.Path, "/")
+	newBase := "//" + subsite.Domain
+	if uploads := extraction.Network.Uploads(subsite); uploads != "uploads" {
+		f.addReplacement("wp-content/"+uploads, "wp-content/uploads")
+	}
+	if extraction.Network.LegacyUploads {
+		// Networks from before WordPress 3.5 link to the uploads of a subsite through its files/ path
+		f.addReplacement(oldBase+"/files", newBase+"/wp-content/uploads")
+	}
+	if oldBase != newBase {
+		f.addReplacement(oldBase, newBase)
+	}
+
+	return f
+}
+
+func (f *SubsiteFilter) addReplacement(old, replacement string) {
+	f.replacements = append(f.replacements, linkReplacement{regexp.MustCompile(regexp.QuoteMeta(old) + `($|[/?#"'\s<>)\]\\])`), replacement + "${1}"})
+}
+
+// Table returns the name of the table in the dump of the subsite, and reports whether it belongs there at all. Tables without the prefix
+// of the network aren't WordPress's, and are kept as they are.
+func (f *SubsiteFilter) Table(name string) (string, bool) {
+	prefix, own := f.extraction.Prefix, f.extraction.TablePrefix()
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok || rest == "users" || rest == "usermeta" {
+		return name, true
+	}
+	if own != prefix {
+		if rest, ok := strings.CutPrefix(name, own); ok {
+			return prefix + rest, true
+		}
+		return "", false
+	}
+	if f.numbered.MatchString(name) || slices.Contains(networkTables, rest) {
+		return "", false
+	}
+
+	return name, true
+}
+
+// Tables returns the tables that belong in the dump of the subsite, under the names they have in the database, in the same order.
+func (f *SubsiteFilter) Tables(tables []string) []string {
+	var kept []string
+	for _, table := range tables {
+		if _, ok := f.Table(table); ok {
+			kept = append(kept, table)
+		}
+	}
+	return kept
+}
+
+// Filter returns a reader of the dump of the subsite, given the dump of the whole network.
+func (f *SubsiteFilter) Filter(r io.Reader) io.Reader {
+	reader, writer := io.Pipe()
+
+	go func() {
+		w := bufio.NewWriter(writer)
+		err := f.filter(newStatementSplitter(r), w)
+		if err == nil {
+			err = w.Flush()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+func (f *SubsiteFilter) filter(splitter *statementSplitter, w io.Writer) error {
+	// The columns of each table, in the order the values of its rows are in
+	columns := map[string][]string{}
+
+	for {
+		statement, err := splitter.Next()
+		if statement != "" {
+			if _, errW := io.WriteString(w, f.statement(statement, columns)); errW != nil {
+				return errW
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// statement rewrites a single statement or comment of the dump, and returns an empty string for one that is left out.
+func (f *SubsiteFilter) statement(statement string, columns map[string][]string) string {
+	header, values := statement, ""
+	if insertStatement.MatchString(strings.TrimSpace(statement)) {
+		if loc := valuesClause.FindStringIndex(statement); loc != nil {
+			header, values = statement[:loc[1]-1], statement[loc[1]-1:]
+		}
+	}
+
+	// The first table the statement names is the one it is about, such as the table of a CREATE TABLE or an INSERT
+	table := ""
+	for _, match := range quotedIdentifier.FindAllStringSubmatch(header, -1) {
+		if name := unquoteIdentifier([]byte(match[1])); strings.HasPrefix(name, f.extraction.Prefix) {
+			table = name
+			break
+		}
+	}
+	if table == "" {
+		return statement
+	}
+	renamed, ok := f.Table(table)
+	if !ok {
+		return ""
+	}
+	header = quotedIdentifier.ReplaceAllStringFunc(header, func(identifier string) string {
+		if name, ok := f.Table(unquoteIdentifier([]byte(identifier[1 : len(identifier)-1]))); ok {
+			return QuoteIdentifier(name)
+		}
+		return identifier
+	})
+
+	if createTableStatement.MatchString(strings.TrimSpace(header)) {
+		columns[renamed] = nil
+		for _, match := range columnDefinition.FindAllStringSubmatch(header, -1) {
+			columns[renamed] = append(columns[renamed], unquoteIdentifier([]byte(match[1])))
+		}
+	}
+	if values == "" {
+		return header
+	}
+
+	// An INSERT that lists its columns overrides the order of CREATE TABLE
+	names := columns[renamed]
+	if _, list, ok := strings.Cut(header, "("); ok {
+		names = nil
+		for _, match := range quotedIdentifier.FindAllStringSubmatch(list, -1) {
+			names = append(names, unquoteIdentifier([]byte(match[1])))
+		}
+	}
+
+	return f.insert(header, values, renamed, names)
+}
+
+// insert rewrites the rows of an INSERT statement, and leaves out the statement when none of its rows are kept. A statement whose values
+// can't be read is kept as it is.
+func (f *SubsiteFilter) insert(header, values, table string, columns []string) string {
+	prefix := f.extraction.Prefix
+	rows, tail, ok := splitRows(values)
+	if !ok {
+		return header + values
+	}
+
+	var transform func(row []string) bool
+	switch table {
+	case prefix + "users":
+		id := slices.Index(columns, "ID")
+		transform = func(row []string) bool {
+			return id < 0 || f.members[unquoteValue(row[id])]
+		}
+	case prefix + "usermeta":
+		userId, key := slices.Index(columns, "user_id"), slices.Index(columns, "meta_key")
+		transform = func(row []string) bool {
+			if userId >= 0 && !f.members[unquoteValue(row[userId])] {
+				return false
+			}
+			if key < 0 {
+				return true
+			}
+			original := unquoteValue(row[key])
+			renamed, ok := f.metaKey(original)
+			if renamed != original {
+				row[key] = QuoteString(renamed)
+			}
+			return ok
+		}
+	case prefix + "options":
+		// The options of a site that are named after its table prefix, such as wp_2_user_roles, take the base prefix
+		if name := slices.Index(columns, "option_name"); name >= 0 && f.extraction.TablePrefix() != prefix {
+			transform = func(row []string) bool {
+				if rest, ok := strings.CutPrefix(unquoteValue(row[name]), f.extraction.TablePrefix()); ok {
+					row[name] = QuoteString(prefix + rest)
+				}
+				return true
+			}
+		}
+	}
+	if transform == nil && len(f.replacements) == 0 {
+		return header + values
+	}
+
+	kept := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if len(columns) > 0 && len(row) != len(columns) {
+			// The row doesn't have the columns we expect, so it isn't safe to change it
+			kept = append(kept, "("+strings.Join(row, ",")+")")
+			continue
+		}
+		if transform != nil && !transform(row) {
+			continue
+		}
+		for i, value := range row {
+			row[i] = f.replaceLinks(value)
+		}
+		kept = append(kept, "("+strings.Join(row, ",")+")")
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+
+	return header + strings.Join(kept, ",") + tail
+}
+
+// metaKey returns the user meta key as it is for a single site, and reports whether the meta belongs to the subsite at all. The keys of
+// a site are prefixed with its table prefix, such as wp_2_capabilities, and those of every other site are left out.
+func (f *SubsiteFilter) metaKey(key string) (string, bool) {
+	prefix, own := f.extraction.Prefix, f.extraction.TablePrefix()
+	if own != prefix {
+		if rest, ok := strings.CutPrefix(key, own); ok {
+			return prefix + rest, true
+		}
+		return key, !strings.HasPrefix(key, prefix)
+	}
+
+	return key, !f.numbered.MatchString(key)
+}
+
+// replaceLinks replaces the links in a string value, including those inside of PHP serialized values. Any other value is returned as is.
+func (f *SubsiteFilter) replaceLinks(value string) string {
+	if len(f.replacements) == 0 || len(value) < 2 || value[0] != '\'' {
+		return value
+	}
+	s, ok := unquoteString(value)
+	if !ok {
+		return value
+	}
+
+	replaced := replaceInValue(s, func(s string) string {
+		for _, r := range f.replacements {
+			s = r.pattern.ReplaceAllString(s, r.replacement)
+		}
+		return s
+	})
+	if replaced == s {
+		return value
+	}
+
+	return QuoteString(replaced)
+}
+
+// splitRows splits the values of an INSERT statement into its rows, each of which is a list of the values as they are written. The tail is
+// whatever comes after the last row, such as the delimiter.
+func splitRows(values string) ([][]string, string, bool) {
+	var rows [][]string
+	i := 0
+	for {
+		for i < len(values) && (isSpace(values[i]) || (values[i] == ',' && len(rows) > 0)) {
+			i++
+		}
+		if i >= len(values) || values[i] != '(' {
+			break
+		}
+		i++
+
+		var row []string
+		for {
+			end, ok := valueEnd(values, i)
+			if !ok {
+				return nil, "", false
+			}
+			row = append(row, strings.TrimSpace(values[i:end]))
+			i = end + 1
+			if values[end] == ')' {
+				break
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, values[i:], len(rows) > 0
+}
+
+// valueEnd returns the index of the comma or closing parenthesis that ends the value starting at i.
+func valueEnd(values string, i int) (int, bool) {
+	depth := 0
+	for i < len(values) {
+		switch c := values[i]; c {
+		case '\'', '"':
+			// Skip to the closing quote
+			for i++; i < len(values) && values[i] != c; i++ {
+				if values[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i, true
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return i, true
+			}
+		}
+		i++
+	}
+
+	return 0, false
+}
+
+// unquoteValue returns a number as it is written, and the contents of a string.
+func unquoteValue(value string) string {
+	if s, ok := unquoteString(value); ok {
+		return s
+	}
+	return value
+}
+
+// unquoteString reads a MySQL string literal, as mysqldump and QuoteString write it.
+func unquoteString(value string) (string, bool) {
+	if len(value) < 2 || value[0] != '\'' || value[len(value)-1] != '\'' {
+		return "", false
+	}
+	value = value[1 : len(value)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte('\x1a')
+			case '%', '_':
+				// These keep their backslash, which only means something in a LIKE pattern
+				b.WriteByte('\\')
+				b.WriteByte(value[i])
+			default:
+				b.WriteByte(value[i])
+			}
+		case c == '\'' && i+1 < len(value) && value[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), true
+}
+
+// statementSplitter reads a dump one statement at a time. A comment is a statement of its own, and so is a DELIMITER command, which
+// changes the delimiter that ends the statements after it.
+type statementSplitter struct {
+	r         *bufio.Reader
+	delimiter string
+}
+
+func newStatementSplitter(r io.Reader) *statementSplitter {
+	return &statementSplitter{bufio.NewReaderSize(r, 64*1024), ";"}
+}
+
+// Next returns the next statement, including its delimiter, the end of its line and any whitespace before it. It returns io.EOF along with whatever is left at
+// the end of the dump.
+func (s *statementSplitter) Next() (string, error) {
+	var b []byte
+	state := scanNormal
+	escaped := false
+	delimiterCommand := false
+	// start is where the statement begins after its whitespace, and comment is where the block comment being read begins
+	start, comment := 0, 0
+
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return string(b), err
+		}
+		b = append(b, c)
+
+		switch state {
+		case scanSingleQuote, scanDoubleQuote:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if (c == '\'' && state == scanSingleQuote) || (c == '"' && state == scanDoubleQuote) {
+				state = scanNormal
+			}
+			continue
+		case scanBacktick:
+			if c == '`' {
+				state = scanNormal
+			}
+			continue
+		case scanLineComment:
+			if c == '\n' {
+				return string(b), nil
+			}
+			continue
+		case scanBlockComment:
+			// A block comment is read as it is, since it may hold a whole trigger with statements of its own
+			if c == '/' && b[len(b)-2] == '*' && len(b)-comment > 3 {
+				state = scanNormal
+			}
+			continue
+		}
+
+		// Whitespace before a statement belongs to it
+		if len(b) == start+1 && isSpace(c) {
+			start++
+			continue
+		}
+		text := b[start:]
+
+		if delimiterCommand {
+			if c == '\n' {
+				if fields := strings.Fields(string(text)); len(fields) == 2 {
+					s.delimiter = fields[1]
+				}
+				return string(b), nil
+			}
+			continue
+		}
+		if len(text) == len("DELIMITER ") && strings.EqualFold(string(text), "DELIMITER ") {
+			delimiterCommand = true
+			continue
+		}
+		if (len(text) == 2 && string(text) == "--") || (len(text) == 1 && c == '#') {
+			state = scanLineComment
+			continue
+		}
+		if c == '*' && len(text) >= 2 && text[len(text)-2] == '/' {
+			state, comment = scanBlockComment, len(b)-2
+			continue
+		}
+		if bytes.HasSuffix(text, []byte(s.delimiter)) {
+			// The end of the line belongs to the statement, so that nothing is left of a statement that is left out
+			if next, err := s.r.Peek(1); err == nil && next[0] == '\n' {
+				s.r.ReadByte()
+				b = append(b, '\n')
+			}
+			return string(b), nil
+		}
+
+		switch c {
+		case '\'':
+			state = scanSingleQuote
+		case '"':
+			state = scanDoubleQuote
+		case '`':
+			state = scanBacktick
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"strings"
+	"testing"
+)
+
+const networkDump = "-- MySQL dump 10.13\n" +
+	"/*!40101 SET NAMES utf8mb4 */;\n" +
+	"--\n-- Table structure for table `wp_2_options`\n--\n\n" +
+	"DROP TABLE IF EXISTS `wp_2_options`;\n" +
+	"CREATE TABLE `wp_2_options` (\n  `option_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n  `option_name` varchar(191) NOT NULL DEFAULT '',\n  `option_value` longtext NOT NULL,\n  PRIMARY KEY (`option_id`)\n) ENGINE=InnoDB;\n" +
+	"LOCK TABLES `wp_2_options` WRITE;\n" +
+	"/*!40000 ALTER TABLE `wp_2_options` DISABLE KEYS */;\n" +
+	"INSERT INTO `wp_2_options` VALUES (1,'siteurl','https://example.com/blog'),(2,'wp_2_user_roles','a:0:{}'),(3,'widget','s:41:\\\"https://example.com/blog/about?it\\'s;(yes)\\\";');\n" +
+	"UNLOCK TABLES;\n" +
+	"DROP TABLE IF EXISTS `wp_3_options`;\n" +
+	"CREATE TABLE `wp_3_options` (\n  `option_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT\n) ENGINE=InnoDB;\n" +
+	"INSERT INTO `wp_3_options` VALUES (1);\n" +
+	"DROP TABLE IF EXISTS `wp_blogs`;\n" +
+	"INSERT INTO `wp_blogs` VALUES (1,1,'example.com','/');\n" +
+	"DROP TABLE IF EXISTS `wp_options`;\n" +
+	"INSERT INTO `wp_options` VALUES (1,'siteurl','https://example.com');\n" +
+	"DROP TABLE IF EXISTS `wp_usermeta`;\n" +
+	"CREATE TABLE `wp_usermeta` (\n  `umeta_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n  `user_id` bigint(20) unsigned NOT NULL DEFAULT '0',\n  `meta_key` varchar(255) DEFAULT NULL,\n  `meta_value` longtext,\n  PRIMARY KEY (`umeta_id`)\n) ENGINE=InnoDB;\n" +
+	"INSERT INTO `wp_usermeta` VALUES (1,1,'nickname','admin'),(2,1,'wp_capabilities','a:0:{}'),(3,2,'wp_2_capabilities','a:0:{}'),(4,2,'wp_3_capabilities','a:0:{}'),(5,2,'nickname','editor');\n" +
+	"DROP TABLE IF EXISTS `wp_users`;\n" +
+	"CREATE TABLE `wp_users` (\n  `ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n  `user_login` varchar(60) NOT NULL DEFAULT ''\n) ENGINE=InnoDB;\n" +
+	"INSERT INTO `wp_users` VALUES (1,'admin'),(2,'editor');\n" +
+	"DELIMITER ;;\n" +
+	"/*!50003 CREATE*/ /*!50003 TRIGGER `wp_3_trigger` BEFORE INSERT ON `wp_3_posts` FOR EACH ROW BEGIN SET NEW.a = 1; END */;;\n" +
+	"DELIMITER ;\n" +
+	"-- Dump completed on 2024-01-01\n"
+
+func TestSubsiteFilter(t *testing.T) {
+	network := types.Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}}
+	filter := NewSubsiteFilter(types.Extraction{Network: network, Subsite: network.Subsites[1], Prefix: "wp_", Members: []int{2}})
+
+	b, err := io.ReadAll(filter.Filter(strings.NewReader(networkDump)))
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	got := string(b)
+
+	for _, want := range []string{
+		"-- Table structure for table `wp_options`",
+		"DROP TABLE IF EXISTS `wp_options`;\nCREATE TABLE `wp_options` (",
+		"/*!40000 ALTER TABLE `wp_options` DISABLE KEYS */;",
+		"INSERT INTO `wp_options` VALUES (1,'siteurl','https://example.com'),(2,'wp_user_roles','a:0:{}'),(3,'widget','s:36:\\\"https://example.com/about?it\\'s;(yes)\\\";');",
+		"INSERT INTO `wp_usermeta` VALUES (3,2,'wp_capabilities','a:0:{}'),(5,2,'nickname','editor');",
+		"INSERT INTO `wp_users` VALUES (2,'editor');",
+		"DELIMITER ;;\nDELIMITER ;\n",
+		"-- Dump completed on 2024-01-01\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got dump:\n%s\nwant it to contain:\n%s", got, want)
+		}
+	}
+	for _, unwanted := range []string{"wp_2_", "wp_3_", "wp_blogs", "'https://example.com');\n"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("got dump:\n%s\nwant it not to contain %s", got, unwanted)
+		}
+	}
+}
+
+func TestSubsiteFilter_Table(t *testing.T) {
+	network := types.Network{Domain: "example.com", Path: "/", MainSiteID: 1}
+	main := NewSubsiteFilter(types.Extraction{Network: network, Subsite: types.Subsite{ID: 1, Domain: "example.com", Path: "/"}, Prefix: "wp_"})
+	blog := NewSubsiteFilter(types.Extraction{Network: network, Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_"})
+
+	var tests = []struct {
+		filter *SubsiteFilter
+		table  string
+		want   string
+		wantOk bool
+	}{
+		{main, "wp_posts", "wp_posts", true},
+		{main, "wp_2_posts", "", false},
+		{main, "wp_sitemeta", "", false},
+		{main, "wp_users", "wp_users", true},
+		{main, "other_table", "other_table", true},
+		{blog, "wp_2_posts", "wp_posts", true},
+		{blog, "wp_23_posts", "", false},
+		{blog, "wp_posts", "", false},
+		{blog, "wp_usermeta", "wp_usermeta", true},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.filter.Table(tt.table)
+
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("got %q and %v for %s; want %q and %v", got, ok, tt.table, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"strconv"
+)
+
+var (
+	ErrCannotListSubsites = errors.New("cannot list the subsites")
+	ErrCannotListMembers  = errors.New("cannot list the members of the subsite")
+)
+
+// This is the SQL statement used to list the subsites of a multisite network, given the blogs table.
+const SELECT_SUBSITES_STMT = "SELECT blog_id, domain, path FROM %s WHERE deleted = 0 ORDER BY blog_id;"
+
+// This is the SQL statement used to list the members of a subsite, given the usermeta table and the capabilities key of the subsite.
+const SELECT_MEMBERS_STMT = "SELECT DISTINCT user_id FROM %s WHERE meta_key = %s ORDER BY user_id;"
+
+// SubsiteLister lists the subsites of a multisite network and their members.
+type SubsiteLister struct {
+	*Querier
+	prefix string
+}
+
+// NewSubsiteLister is the constructor for SubsiteLister.
+func NewSubsiteLister(c sftp.CommandRunnerUploader, p types.PublicPath, siteUrl types.SiteUrl, g HttpGetter, credentials DatabaseCredentials, prefix string) *SubsiteLister {
+	return &SubsiteLister{NewQuerier(c, p, siteUrl, g, credentials), prefix}
+}
+
+// ListSubsites returns the subsites that haven't been deleted, in the order they were created.
+func (l *SubsiteLister) ListSubsites() ([]types.Subsite, error) {
+	rows, err := l.Query(fmt.Sprintf(SELECT_SUBSITES_STMT, QuoteIdentifier(l.prefix+"blogs")), 3)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotListSubsites, err)
+	}
+
+	var subsites []types.Subsite
+	for _, row := range rows {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: unexpected blog id %q", ErrCannotListSubsites, row[0])
+		}
+		subsites = append(subsites, types.Subsite{ID: id, Domain: row[1], Path: row[2]})
+	}
+
+	return subsites, nil
+}
+
+// ListMembers returns the ids of the users who have a role on the subsite, which is whoever has capabilities under its table prefix.
+func (l *SubsiteLister) ListMembers(tablePrefix string) ([]int, error) {
+	rows, err := l.Query(fmt.Sprintf(SELECT_MEMBERS_STMT, QuoteIdentifier(l.prefix+"usermeta"), QuoteString(tablePrefix+"capabilities")), 1)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotListMembers, err)
+	}
+
+	var members []int
+	for _, row := range rows {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: unexpected user id %q", ErrCannotListMembers, row[0])
+		}
+		members = append(members, id)
+	}
+
+	return members, nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSubsiteLister_ListSubsites(t *testing.T) {
+	want := []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}
+
+	var tests = []struct {
+		name    string
+		runner  *ConnectionRunnerStub
+		want    []types.Subsite
+		wantErr error
+	}{
+		{
+			"mysql client",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "1\texample.com\t/\n2\texample.com\t/blog/\n"},
+			want,
+			nil,
+		},
+		{
+			"mysql client unexpected output",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "not a row\n"},
+			nil,
+			ErrCannotListSubsites,
+		},
+		{
+			"mysql client error",
+			&ConnectionRunnerStub{canRun: capabilities.Mysql.Command, err: errors.New("exit status 1: ERROR 1146 (42S02): Table 'db.wp_blogs' doesn't exist")},
+			nil,
+			ErrCannotListSubsites,
+		},
+		{
+			"php script",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"rows":[["1","example.com","/"],["2","example.com","/blog/"]]}`},
+			want,
+			nil,
+		},
+		{
+			"php script error",
+			&ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"error":"Table 'db.wp_blogs' doesn't exist"}`},
+			nil,
+			ErrCannotListSubsites,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := NewSubsiteLister(tt.runner, "public", "https://localhost", nil, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_")
+			lister.random = randomStub
+
+			got, err := lister.ListSubsites()
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubsiteLister_DryRun(t *testing.T) {
+	t.Run("it lists the subsites through the ssh tunnel when the option file of the mysql client can't be uploaded", func(t *testing.T) {
+		server := StartFakeMysqlServer(t, map[string]FakeResult{
+			"SELECT blog_id, domain, path FROM `wp_blogs` WHERE deleted = 0 ORDER BY blog_id;": {
+				[]FakeColumn{{Name: "blog_id", Type: fakeTypeInt}, {Name: "domain", Type: fakeTypeVarchar}, {Name: "path", Type: fakeTypeVarchar}},
+				[][]*string{{str("1"), str("example.com"), str("/")}, {str("2"), str("example.com"), str("/blog/")}},
+			},
+			"SELECT DISTINCT user_id FROM `wp_usermeta` WHERE meta_key = 'wp_2_capabilities' ORDER BY user_id;": {
+				[]FakeColumn{{Name: "user_id", Type: fakeTypeInt}},
+				[][]*string{{str("1")}, {str("4")}},
+			},
+		})
+		runner := &ReadOnlyRunnerStub{ConnectionRunnerStub{canRun: capabilities.Mysql.Command}, DialerSpy{addr: server.Addr()}}
+		lister := NewSubsiteLister(runner, "public", "https://localhost", nil, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_")
+		lister.random = randomStub
+
+		subsites, err := lister.ListSubsites()
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if want := []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}; !reflect.DeepEqual(subsites, want) {
+			t.Errorf("got %v; want %v", subsites, want)
+		}
+		members, err := lister.ListMembers("wp_2_")
+		if err != nil || !reflect.DeepEqual(members, []int{1, 4}) {
+			t.Errorf("got %v, %v; want [1 4]", members, err)
+		}
+	})
+}
+
+// ReadOnlyRunnerStub refuses every upload, as in a dry run, and reaches the database through the ssh tunnel.
+type ReadOnlyRunnerStub struct {
+	ConnectionRunnerStub
+	DialerSpy
+}
+
+func (r *ReadOnlyRunnerStub) Upload(src io.Reader, dst string) error {
+	return errors.New("nothing is written to the server in a dry run")
+}
+
+func TestSubsiteLister_ListMembers(t *testing.T) {
+	var tests = []struct {
+		name    string
+		runner  *ConnectionRunnerStub
+		want    []int
+		wantErr error
+	}{
+		{"mysql client", &ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: "1\n4\n"}, []int{1, 4}, nil},
+		{"mysql client no members", &ConnectionRunnerStub{canRun: capabilities.Mysql.Command, output: ""}, nil, nil},
+		{"php script", &ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"rows":[["1"],["4"]]}`}, []int{1, 4}, nil},
+		{"php script unexpected id", &ConnectionRunnerStub{canRun: capabilities.Php.Command, output: `{"rows":[["one"]]}`}, nil, ErrCannotListMembers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := NewSubsiteLister(tt.runner, "public", "https://localhost", nil, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_")
+			lister.random = randomStub
+
+			got, err := lister.ListMembers("wp_2_")
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+)
+
+// SELECT_TABLE_SIZES_STMT lists the tables of the database, the largest first, with their size in bytes and their number of rows. Both
+// come from the table statistics, so they are estimates, especially for InnoDB.
+const SELECT_TABLE_SIZES_STMT = "SELECT TABLE_NAME, COALESCE(DATA_LENGTH + INDEX_LENGTH, 0), COALESCE(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() ORDER BY 2 DESC, 1"
+
+// TableSize is the estimated size of a table.
+type TableSize struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+	Rows  int64  `json:"rows"`
+}
+
+// ReadTableSizes reads the size of every table over the MySQL protocol, tunnelled through the SSH connection, so that nothing has to be
+// run or written on the server.
+func ReadTableSizes(d sftp.Dialer, credentials DatabaseCredentials) ([]TableSize, error) {
+	db, err := (&NativeDatabaseExporter{d, credentials}).open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), SELECT_TABLE_SIZES_STMT)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the table sizes through the ssh tunnel: %s", err)
+	}
+	defer rows.Close()
+
+	var sizes []TableSize
+	for rows.Next() {
+		var size TableSize
+		if err := rows.Scan(&size.Name, &size.Bytes, &size.Rows); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, rows.Err()
+}
+
+// ReadValue reads the first column of the first row of the statement over the MySQL protocol, tunnelled through the SSH connection, so that
+// nothing has to be run or written on the server. It is empty when there are no rows.
+func ReadValue(d sftp.Dialer, credentials DatabaseCredentials, stmt string) (string, error) {
+	db, err := (&NativeDatabaseExporter{d, credentials}).open()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var value sql.NullString
+	err = db.QueryRowContext(context.Background(), stmt).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not query the database through the ssh tunnel: %s", err)
+	}
+
+	return value.String, nil
+}
+
+// ReadRows reads every row of the statement over the MySQL protocol, tunnelled through the SSH connection, so that nothing has to be run or
+// written on the server. Every value is returned as a string, the same as the mysql client prints them, and NULL is empty.
+func ReadRows(d sftp.Dialer, credentials DatabaseCredentials, stmt string) ([][]string, error) {
+	db, err := (&NativeDatabaseExporter{d, credentials}).open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), stmt)
+	if err != nil {
+		return nil, fmt.Errorf("could not query the database through the ssh tunnel: %s", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(columns))
+		for i, value := range values {
+			row[i] = value.String
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// TotalSize adds up the sizes of the tables.
+func TotalSize(sizes []TableSize) int64 {
+	var total int64
+	for _, size := range sizes {
+		total += size.Bytes
+	}
+
+	return total
+}
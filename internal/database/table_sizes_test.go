@@ -0,0 +1,55 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadTableSizes(t *testing.T) {
+	t.Run("it reads the size of every table", func(t *testing.T) {
+		server := StartFakeMysqlServer(t, map[string]FakeResult{
+			SELECT_TABLE_SIZES_STMT: {
+				[]FakeColumn{{Name: "TABLE_NAME", Type: fakeTypeVarchar}, {Name: "size", Type: fakeTypeInt}, {Name: "rows", Type: fakeTypeInt}},
+				[][]*string{{str("wp_posts"), str("4096"), str("12")}, {str("wp_options"), str("1024"), str("150")}},
+			},
+		})
+
+		got, err := ReadTableSizes(&DialerSpy{addr: server.Addr()}, DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"})
+
+		want := []TableSize{{"wp_posts", 4096, 12}, {"wp_options", 1024, 150}}
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got sizes %v; want %v", got, want)
+		}
+		if total := TotalSize(got); total != 5120 {
+			t.Errorf("got total %d; want 5120", total)
+		}
+	})
+
+	t.Run("it reads a single value", func(t *testing.T) {
+		server := StartFakeMysqlServer(t, map[string]FakeResult{
+			"SELECT option_value FROM wp_options WHERE option_name = 'siteurl';": {[]FakeColumn{{Name: "option_value", Type: fakeTypeVarchar}}, [][]*string{{str("https://example.com")}}},
+			"SELECT option_value FROM wp_options WHERE option_name = 'home';":    {[]FakeColumn{{Name: "option_value", Type: fakeTypeVarchar}}, nil},
+		})
+		dialer := &DialerSpy{addr: server.Addr()}
+		credentials := DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}
+
+		if got, err := ReadValue(dialer, credentials, "SELECT option_value FROM wp_options WHERE option_name = 'siteurl';"); err != nil || got != "https://example.com" {
+			t.Errorf("got %q, %v; want https://example.com", got, err)
+		}
+		if got, err := ReadValue(dialer, credentials, "SELECT option_value FROM wp_options WHERE option_name = 'home';"); err != nil || got != "" {
+			t.Errorf("got %q, %v; want nothing for no rows", got, err)
+		}
+	})
+
+	t.Run("it returns an error when the tunnel cannot be opened", func(t *testing.T) {
+		_, err := ReadTableSizes(&DialerSpy{err: errors.New("administratively prohibited")}, DatabaseCredentials{User: "user", Name: "db", Host: "localhost"})
+
+		if err == nil {
+			t.Errorf("got nil error; want one")
+		}
+	})
+}
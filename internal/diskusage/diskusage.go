@@ -0,0 +1,253 @@
+// Package diskusage finds out where the bytes of a site are on the server, before any of them are downloaded. The files are listed with
+// `find` when the server runs commands, and by walking the directories over SFTP otherwise, and then analyzed for the largest directories
+// and files, and for bulk that doesn't belong in an export, such as old backups.
+package diskusage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LIST_FILES_CMD lists every file under the directory with its size in bytes, its modification time as a unix timestamp and its path
+// relative to the directory. Files that can't be read are left out, instead of failing the whole listing.
+const LIST_FILES_CMD = "cd %s && { find . -type f -printf '%%s %%T@ %%P\\n' 2>/dev/null; true; }"
+
+// TOP is how many of the largest directories and files are reported.
+const TOP = 10
+
+// MIN_FINDING_SIZE is the size below which nothing is flagged, since it makes no difference to the size of an export.
+const MIN_FINDING_SIZE = 1024 * 1024
+
+var ErrCannotListFiles = errors.New("cannot list the files")
+
+// File is a file on the server, with its path relative to the directory that was listed.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// List lists every file under the directory, with `find` when the server has it and over SFTP otherwise.
+func List(client sftp.Client, dir string) ([]File, error) {
+	dir = strings.TrimSuffix(dir, "/")
+	if client.CanRunRemoteCommand(capabilities.Find.Command) {
+		verbose.Printf("listing the files of %s with find", dir)
+		return listWithFind(client, dir)
+	}
+
+	verbose.Printf("listing the files of %s over SFTP", dir)
+	var files []File
+	if err := walk(client, dir, "", &files); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotListFiles, err)
+	}
+
+	return files, nil
+}
+
+func listWithFind(runner sftp.RemoteCommandRunner, dir string) ([]File, error) {
+	output, err := runner.RunRemoteCommand(fmt.Sprintf(LIST_FILES_CMD, sftp.ShellQuote(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotListFiles, err)
+	}
+
+	var files []File
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		// The path comes last, since it may have spaces in it
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, File{fields[2], size, time.Unix(int64(seconds), 0)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotListFiles, err)
+	}
+
+	return files, nil
+}
+
+// walk lists the files of the directory over SFTP, and then those of each directory inside of it. Only the directory that was asked for has
+// to be readable, any other that isn't is skipped.
+func walk(reader sftp.RemoteFileReader, dir, rel string, files *[]File) error {
+	entries, err := reader.ReadDir(path.Join(dir, rel))
+	if err != nil {
+		if rel == "" {
+			return err
+		}
+		verbose.Printf("skipping %s, which can't be read: %s", rel, err)
+		return nil
+	}
+
+	for _, entry := range entries {
+		entryRel := path.Join(rel, entry.Name())
+		switch {
+		case entry.IsDir():
+			if err := walk(reader, dir, entryRel, files); err != nil {
+				return err
+			}
+		// Symlinks are skipped the same as `find -type f` skips them
+		case entry.Mode()&os.ModeType == 0:
+			*files = append(*files, File{entryRel, entry.Size(), entry.ModTime()})
+		}
+	}
+
+	return nil
+}
+
+// Usage is the size of a directory, including everything inside of it, or of a file.
+type Usage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Finding is something large that most likely doesn't belong in an export, along with the pattern that would leave it out.
+type Finding struct {
+	Path    string `json:"path"`
+	Bytes   int64  `json:"bytes"`
+	Kind    string `json:"kind"`
+	Reason  string `json:"reason"`
+	Pattern string `json:"pattern"`
+	// LeftOut is whether an export already leaves it out by default
+	LeftOut bool `json:"leftOut"`
+}
+
+// Report is where the bytes of a directory are.
+type Report struct {
+	Bytes       int64     `json:"bytes"`
+	Files       int       `json:"files"`
+	Directories []Usage   `json:"largestDirectories"`
+	Largest     []Usage   `json:"largestFiles"`
+	Findings    []Finding `json:"findings"`
+}
+
+// archiveExtensions are the extensions of the files that backup plugins and hosts leave behind
+var archiveExtensions = []string{".zip", ".tar", ".tar.gz", ".tgz", ".gz", ".wpress", ".daf", ".7z", ".rar", ".bak"}
+
+// dumpExtensions are the extensions of database dumps, which are checked before the archives so that a .sql.gz is reported as a dump
+var dumpExtensions = []string{".sql", ".sql.gz", ".sql.zst", ".sql.zip"}
+
+// cacheDirectories are the names of the directories that page and asset caches are kept in
+var cacheDirectories = []string{"cache", "et-cache"}
+
+// logFiles are the names of the logs that PHP and WordPress write to, in whichever directory the script that failed was in
+var logFiles = []string{"error_log", "php_errorlog", "debug.log"}
+
+// Analyze adds up the size of every directory and flags what most likely doesn't belong in an export, such as backups, caches, logs and
+// database dumps that were left in the webroot.
+func Analyze(files []File) Report {
+	report := Report{Directories: []Usage{}, Largest: []Usage{}, Findings: []Finding{}}
+	directories := map[string]int64{}
+	for _, file := range files {
+		report.Bytes += file.Size
+		report.Files++
+		for dir := path.Dir(file.Path); dir != "."; dir = path.Dir(dir) {
+			directories[dir] += file.Size
+		}
+	}
+
+	for dir, size := range directories {
+		report.Directories = append(report.Directories, Usage{dir, size})
+	}
+	report.Directories = largest(report.Directories)
+	for _, file := range files {
+		report.Largest = append(report.Largest, Usage{file.Path, file.Size})
+	}
+	report.Largest = largest(report.Largest)
+
+	// Only the outermost cache directory is flagged, since leaving it out leaves out any inside of it
+	var caches []string
+	for dir := range directories {
+		if slices.Contains(cacheDirectories, path.Base(dir)) && directories[dir] >= MIN_FINDING_SIZE {
+			caches = append(caches, dir)
+		}
+	}
+	sort.Strings(caches)
+	for _, dir := range caches {
+		if len(report.Findings) > 0 && isWithin(dir, report.Findings[len(report.Findings)-1].Path) {
+			continue
+		}
+		report.Findings = append(report.Findings, Finding{Path: dir, Bytes: directories[dir], Kind: "cache", Reason: "a cache directory, which the site rebuilds by itself", Pattern: dir})
+	}
+
+	for _, file := range files {
+		if file.Size < MIN_FINDING_SIZE || inCache(file.Path, caches) {
+			continue
+		}
+		if finding, ok := flag(file); ok {
+			report.Findings = append(report.Findings, finding)
+		}
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool { return report.Findings[i].Bytes > report.Findings[j].Bytes })
+
+	return report
+}
+
+// flag reports whether the file is a log, a database dump or a backup archive.
+func flag(file File) (Finding, bool) {
+	name := strings.ToLower(path.Base(file.Path))
+	switch {
+	case slices.Contains(logFiles, name):
+		// The same log is written next to whichever script failed, so it is left out wherever it is
+		return Finding{Path: file.Path, Bytes: file.Size, Kind: "log", Reason: "a log file", Pattern: "**/" + path.Base(file.Path)}, true
+	case hasExtension(name, dumpExtensions):
+		return Finding{Path: file.Path, Bytes: file.Size, Kind: "database dump", Reason: fmt.Sprintf("a database dump, last changed %s", file.ModTime.Format(time.DateOnly)), Pattern: file.Path}, true
+	case hasExtension(name, archiveExtensions):
+		return Finding{Path: file.Path, Bytes: file.Size, Kind: "archive", Reason: fmt.Sprintf("an archive, most likely a backup, last changed %s", file.ModTime.Format(time.DateOnly)), Pattern: file.Path}, true
+	}
+
+	return Finding{}, false
+}
+
+// largest sorts the largest first, and keeps the TOP of them.
+func largest(usages []Usage) []Usage {
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Bytes != usages[j].Bytes {
+			return usages[i].Bytes > usages[j].Bytes
+		}
+		return usages[i].Path < usages[j].Path
+	})
+
+	return usages[:min(len(usages), TOP)]
+}
+
+func inCache(file string, caches []string) bool {
+	for _, dir := range caches {
+		if isWithin(file, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(name string, extensions []string) bool {
+	for _, extension := range extensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
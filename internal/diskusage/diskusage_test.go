@@ -0,0 +1,161 @@
+package diskusage
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	_sftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	t.Run("it lists the files with find when the server has it", func(t *testing.T) {
+		client := &ClientStub{outputs: map[string]string{
+			capabilities.Find.Command: "",
+			"cd '/var/www/html' && { find . -type f -printf '%s %T@ %P\\n' 2>/dev/null; true; }": "9 1700000000.5 index.php\n2048 1600000000.0 wp-content/a backup.zip\nnot a file\n",
+		}}
+
+		got, err := List(client, "/var/www/html/")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		want := []File{{"index.php", 9, time.Unix(1700000000, 0)}, {"wp-content/a backup.zip", 2048, time.Unix(1600000000, 0)}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got files %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it walks the directories over SFTP otherwise", func(t *testing.T) {
+		modified := time.Unix(1700000000, 0)
+		client := &ClientStub{dirs: map[string][]os.FileInfo{
+			"/var/www/html": {
+				&FileInfoStub{"index.php", 9, 0, modified},
+				&FileInfoStub{"wp-content", 0, os.ModeDir, modified},
+				&FileInfoStub{"private", 0, os.ModeDir, modified},
+				&FileInfoStub{"link.php", 9, os.ModeSymlink, modified},
+			},
+			"/var/www/html/wp-content": {&FileInfoStub{"debug.log", 2048, 0, modified}},
+		}}
+
+		got, err := List(client, "/var/www/html")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		want := []File{{"index.php", 9, modified}, {"wp-content/debug.log", 2048, modified}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got files %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it fails when the directory can't be read", func(t *testing.T) {
+		_, err := List(&ClientStub{}, "/var/www/html")
+
+		if !errors.Is(err, ErrCannotListFiles) {
+			t.Errorf("got error %v; want %v", err, ErrCannotListFiles)
+		}
+	})
+}
+
+func TestAnalyze(t *testing.T) {
+	const MB = 1024 * 1024
+	modified := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	files := []File{
+		{"index.php", 1000, modified},
+		{"error_log", 2 * MB, modified},
+		{"backup-2023.zip", 50 * MB, modified},
+		{"small.zip", 1000, modified},
+		{"wp-content/uploads/2023/05/photo.jpg", 8 * MB, modified},
+		{"wp-content/cache/page/index.html", 3 * MB, modified},
+		{"wp-content/cache/page/cache/nested.html", 1 * MB, modified},
+		{"wp-content/cache/old.sql", 1 * MB, modified},
+		{"wp-content/plugins/p/error_log", 1 * MB, modified},
+		{"wp-content/plugins/p/vendor/cache/a.php", 1000, modified},
+		{"db.sql.gz", 4 * MB, modified},
+	}
+
+	report := Analyze(files)
+
+	if report.Files != len(files) || report.Bytes != 70*MB+3000 {
+		t.Errorf("got %d files of %d bytes; want %d of %d", report.Files, report.Bytes, len(files), 70*MB+3000)
+	}
+	if want := (Usage{"wp-content", 14*MB + 1000}); report.Directories[0] != want {
+		t.Errorf("got largest directory %v; want %v", report.Directories[0], want)
+	}
+	if want := (Usage{"backup-2023.zip", 50 * MB}); report.Largest[0] != want {
+		t.Errorf("got largest file %v; want %v", report.Largest[0], want)
+	}
+
+	got := map[string]string{}
+	for _, finding := range report.Findings {
+		got[finding.Path] = finding.Kind + " " + finding.Pattern
+	}
+	want := map[string]string{
+		"backup-2023.zip":                "archive backup-2023.zip",
+		"wp-content/cache":               "cache wp-content/cache",
+		"db.sql.gz":                      "database dump db.sql.gz",
+		"error_log":                      "log **/error_log",
+		"wp-content/plugins/p/error_log": "log **/error_log",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings %v; want %v", got, want)
+	}
+	if report.Findings[0].Path != "backup-2023.zip" {
+		t.Errorf("got %s first; want the largest finding first", report.Findings[0].Path)
+	}
+}
+
+// ClientStub runs the commands it has output for, and lists the directories it has entries for.
+type ClientStub struct {
+	outputs map[string]string
+	dirs    map[string][]os.FileInfo
+}
+
+func (c *ClientStub) CanRunRemoteCommand(command string) bool {
+	_, ok := c.outputs[command]
+	return ok
+}
+func (c *ClientStub) RunRemoteCommand(command string) (io.Reader, error) {
+	output, ok := c.outputs[command]
+	if !ok {
+		return nil, errors.New("command not found")
+	}
+	return strings.NewReader(output), nil
+}
+func (c *ClientStub) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, ok := c.dirs[path]
+	if !ok {
+		return nil, os.ErrPermission
+	}
+	return entries, nil
+}
+func (c *ClientStub) Upload(r io.Reader, dst string) error        { return nil }
+func (c *ClientStub) Delete(dst string) error                     { return nil }
+func (c *ClientStub) Mkdir(dst string) error                      { return nil }
+func (c *ClientStub) Chmod(dst string, mode os.FileMode) error    { return nil }
+func (c *ClientStub) Getwd() (string, error)                      { return "/home/user", nil }
+func (c *ClientStub) Open(path string) (*_sftp.File, error)       { return nil, nil }
+func (c *ClientStub) NewSession() (*ssh.Session, error)           { return nil, nil }
+func (c *ClientStub) Dial(network, addr string) (net.Conn, error) { return nil, nil }
+
+type FileInfoStub struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f *FileInfoStub) Name() string       { return path.Base(f.name) }
+func (f *FileInfoStub) Size() int64        { return f.size }
+func (f *FileInfoStub) Mode() os.FileMode  { return f.mode }
+func (f *FileInfoStub) ModTime() time.Time { return f.modTime }
+func (f *FileInfoStub) IsDir() bool        { return f.mode.IsDir() }
+func (f *FileInfoStub) Sys() any           { return nil }
@@ -1,22 +1,34 @@
 package emitter
 
 import (
+	"github.com/jfortunato/wp-zip/internal/capabilities"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"io"
 )
 
 // A FileEmitter is basically a file downloader, but it doesn't actually download files to the filesystem. Instead, it just emits the file data (name, contents) and it's up to the caller to do something with it.
 type FileEmitter interface {
-	CalculateByteSize(src string) int
-	EmitAll(src string, fn EmitFunc) error
+	CalculateByteSize(src string, f Filter) int
+	EmitAll(src string, f Filter, fn EmitFunc) error
 	EmitSingle(src string, fn EmitFunc) error
 }
 
 type EmitFunc func(path string, contents io.Reader)
 
+// SizedReader is the contents of a file along with its size, which the emitters pass on when they know the size before the file is read,
+// so that a large file can be skipped without reading it.
+type SizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (r *SizedReader) Size() int64 {
+	return r.size
+}
+
 // NewFileEmitter is a factory function that returns a FileEmitter. It detects at runtime whether the remote server supports `tar` or not, and returns the appropriate downloader.
 func NewFileEmitter(client sftp.Client) FileEmitter {
-	if client.CanRunRemoteCommand("tar --version") {
+	if client.CanRunRemoteCommand(capabilities.Tar.Command) {
 		return &TarFileEmitter{client}
 	}
 
@@ -1,9 +1,11 @@
 package emitter
 
 import (
+	"github.com/jfortunato/wp-zip/internal/capabilities"
 	_sftp "github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"os"
 	"reflect"
 	"testing"
@@ -22,7 +24,7 @@ func TestNewFileEmitter(t *testing.T) {
 		for _, test := range tests {
 			supportedCommands := map[string]string{}
 			if test.isTarSupported {
-				supportedCommands["tar --version"] = "tar version 1.0.0"
+				supportedCommands[capabilities.Tar.Command] = "tar version 1.0.0"
 			}
 
 			emitter := NewFileEmitter(&ClientStub{supportedCommands: supportedCommands})
@@ -46,6 +48,9 @@ func (c *ClientStub) RunRemoteCommand(command string) (io.Reader, error) { retur
 func (c *ClientStub) Upload(r io.Reader, dst string) error               { return nil }
 func (c *ClientStub) Delete(dst string) error                            { return nil }
 func (c *ClientStub) Mkdir(dst string) error                             { return nil }
+func (c *ClientStub) Chmod(dst string, mode os.FileMode) error           { return nil }
+func (c *ClientStub) Getwd() (string, error)                             { return "/home/user", nil }
 func (c *ClientStub) ReadDir(path string) ([]os.FileInfo, error)         { return nil, nil }
 func (c *ClientStub) Open(path string) (*_sftp.File, error)              { return nil, nil }
 func (c *ClientStub) NewSession() (*ssh.Session, error)                  { return nil, nil }
+func (c *ClientStub) Dial(network, addr string) (net.Conn, error)        { return nil, nil }
@@ -0,0 +1,70 @@
+package emitter
+
+import (
+	"path"
+	"strings"
+)
+
+// Exclude is a file or directory that an emitter leaves out without downloading it, given relative to the directory that is emitted. The
+// path may also be a glob, where a leading **/ matches at any depth, such as **/error_log.
+type Exclude struct {
+	Path string
+	// MinSize leaves out only the files of at least this many bytes, when it is set
+	MinSize int64
+}
+
+// Matches reports whether the path is left out. A glob leaves out the directories it matches along with everything inside of them.
+func (e Exclude) Matches(p string) bool {
+	if !strings.ContainsAny(e.Path, "*?[") {
+		return p == e.Path || strings.HasPrefix(p, e.Path+"/")
+	}
+
+	pattern, anywhere := strings.CutPrefix(e.Path, "**/")
+	parts := strings.Split(p, "/")
+	for start := range parts {
+		if start > 0 && !anywhere {
+			break
+		}
+		for end := start + 1; end <= len(parts); end++ {
+			if ok, _ := path.Match(pattern, strings.Join(parts[start:end], "/")); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Skips reports whether a file of the given size is left out. A file whose size isn't known, given as -1, is only left out when the
+// exclusion doesn't depend on its size.
+func (e Exclude) Skips(p string, size int64) bool {
+	return e.Matches(p) && (e.MinSize == 0 || size >= e.MinSize)
+}
+
+// Filter is what an emitter leaves out of a directory, so that it is never downloaded.
+type Filter struct {
+	Exclude []Exclude
+	// Skipped is told about each file that is left out, along with its size or -1 when it isn't known, and may be nil
+	Skipped func(path string, size int64)
+}
+
+// skips reports whether a file of the given size, or a directory when the size is -1, is left out by any of the exclusions.
+func (f Filter) skips(p string, size int64) bool {
+	for _, exclude := range f.Exclude {
+		if exclude.Skips(p, size) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) skipped(p string, size int64) {
+	if f.Skipped != nil {
+		f.Skipped(p, size)
+	}
+}
+
+// shellQuote quotes the value for the remote shell, so that globs are passed on as they are.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -7,30 +7,42 @@ type SftpFileEmitter struct {
 	r sftp.RemoteFileReader
 }
 
-func (s *SftpFileEmitter) CalculateByteSize(src string) int {
+func (s *SftpFileEmitter) CalculateByteSize(src string, f Filter) int {
 	// Takes too long to calculate the size of the directory, so just return -1 which indicates
 	// to the progress bar to use an indeterminate spinner
 	return -1
 }
 
-func (s *SftpFileEmitter) EmitAll(src string, fn EmitFunc) error {
+func (s *SftpFileEmitter) EmitAll(src string, f Filter, fn EmitFunc) error {
+	return s.emitDirectory(src, "", f, fn)
+}
+
+// emitDirectory emits every file of the directory that the filter doesn't leave out, given by its path relative to src. The files that are
+// left out are never opened.
+func (s *SftpFileEmitter) emitDirectory(src, dir string, f Filter, fn EmitFunc) error {
 	// Get the list of files in the remote directory
-	remoteFiles, err := s.r.ReadDir(src)
+	remoteFiles, err := s.r.ReadDir(join(src, dir))
 	if err != nil {
 		return err
 	}
 	for _, remoteFile := range remoteFiles {
-		remoteFilepath := src + "/" + remoteFile.Name()
+		rel := join(dir, remoteFile.Name())
 
 		// If the file is a directory, recursively emit it
 		if remoteFile.IsDir() {
-			err := s.EmitAll(remoteFilepath, fn)
+			if f.skips(rel, -1) {
+				s.skipDirectory(src, rel, f)
+				continue
+			}
+			err := s.emitDirectory(src, rel, f, fn)
 			if err != nil {
 				return err
 			}
+		} else if f.skips(rel, remoteFile.Size()) {
+			f.skipped(rel, remoteFile.Size())
 		} else {
 			// Otherwise, emit the file
-			err := s.EmitSingle(remoteFilepath, fn)
+			err := s.EmitSingle(src+"/"+rel, fn)
 			if err != nil {
 				return err
 			}
@@ -40,6 +52,22 @@ func (s *SftpFileEmitter) EmitAll(src string, fn EmitFunc) error {
 	return nil
 }
 
+// skipDirectory tells the filter about every file of a directory that is left out. Only the directories are read, so it is cheap compared
+// to downloading them, and a directory that can't be read is passed over.
+func (s *SftpFileEmitter) skipDirectory(src, dir string, f Filter) {
+	if f.Skipped == nil {
+		return
+	}
+	remoteFiles, _ := s.r.ReadDir(join(src, dir))
+	for _, remoteFile := range remoteFiles {
+		if remoteFile.IsDir() {
+			s.skipDirectory(src, join(dir, remoteFile.Name()), f)
+		} else {
+			f.skipped(join(dir, remoteFile.Name()), remoteFile.Size())
+		}
+	}
+}
+
 func (s *SftpFileEmitter) EmitSingle(src string, fn EmitFunc) error {
 	r, err := s.r.Open(src)
 	if err != nil {
@@ -47,7 +75,23 @@ func (s *SftpFileEmitter) EmitSingle(src string, fn EmitFunc) error {
 	}
 	defer r.Close()
 
-	fn(src, r)
+	info, err := r.Stat()
+	if err != nil {
+		fn(src, r)
+		return nil
+	}
+	fn(src, &SizedReader{r, info.Size()})
 
 	return nil
 }
+
+// join joins two paths of the remote server, either of which may be empty.
+func join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	if name == "" {
+		return dir
+	}
+	return dir + "/" + name
+}
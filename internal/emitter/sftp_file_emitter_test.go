@@ -0,0 +1,66 @@
+package emitter
+
+import (
+	"errors"
+	_sftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSftpFileEmitter_EmitAll(t *testing.T) {
+	t.Run("it never opens the files that are left out", func(t *testing.T) {
+		reader := &RemoteFileReaderStub{dirs: map[string][]os.FileInfo{
+			"/var/www":                    {fileInfo{"wp-content", 0, true}},
+			"/var/www/wp-content":         {fileInfo{"updraft", 0, true}, fileInfo{"backup.zip", 200, false}, fileInfo{"small.zip", 10, false}},
+			"/var/www/wp-content/updraft": {fileInfo{"db.gz", 50, false}},
+		}}
+		skipped := map[string]int64{}
+		filter := Filter{
+			Exclude: []Exclude{{Path: "wp-content/updraft"}, {Path: "**/*.zip", MinSize: 100}},
+			Skipped: func(path string, size int64) { skipped[path] = size },
+		}
+
+		err := (&SftpFileEmitter{reader}).EmitAll("/var/www", filter, func(path string, contents io.Reader) {})
+
+		if err == nil {
+			t.Errorf("got nil error; want the error of opening small.zip")
+		}
+		if want := []string{"/var/www/wp-content/small.zip"}; !reflect.DeepEqual(reader.opened, want) {
+			t.Errorf("got opened %v; want %v", reader.opened, want)
+		}
+		if want := map[string]int64{"wp-content/updraft/db.gz": 50, "wp-content/backup.zip": 200}; !reflect.DeepEqual(skipped, want) {
+			t.Errorf("got skipped %v; want %v", skipped, want)
+		}
+	})
+}
+
+// RemoteFileReaderStub lists the directories it was given, and records every file that is opened, which then fails.
+type RemoteFileReaderStub struct {
+	dirs   map[string][]os.FileInfo
+	opened []string
+}
+
+func (r *RemoteFileReaderStub) ReadDir(path string) ([]os.FileInfo, error) { return r.dirs[path], nil }
+func (r *RemoteFileReaderStub) Open(path string) (*_sftp.File, error) {
+	r.opened = append(r.opened, path)
+	return nil, errors.New("not opened")
+}
+func (r *RemoteFileReaderStub) NewSession() (*ssh.Session, error) { return nil, nil }
+
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() fs.FileMode  { return 0 }
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.isDir }
+func (f fileInfo) Sys() any           { return nil }
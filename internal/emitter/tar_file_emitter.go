@@ -2,6 +2,7 @@ package emitter
 
 import (
 	"archive/tar"
+	"fmt"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"io"
 	"log"
@@ -14,7 +15,7 @@ type TarFileEmitter struct {
 	r sftp.RemoteFileReader
 }
 
-func (t *TarFileEmitter) CalculateByteSize(src string) int {
+func (t *TarFileEmitter) CalculateByteSize(src string, f Filter) int {
 	sess, err := t.r.NewSession()
 	if err != nil {
 		log.Fatalln("failed to create session: %w", err)
@@ -33,20 +34,30 @@ func (t *TarFileEmitter) CalculateByteSize(src string) int {
 		log.Fatalln("failed to convert string to int: %w", err)
 	}
 
+	// The files that are left out are never downloaded, so they don't count
+	for _, file := range t.skippedFiles(src, f) {
+		numFiles -= int(file.size)
+	}
+
 	return numFiles
 }
 
-func (t *TarFileEmitter) EmitAll(src string, fn EmitFunc) error {
-	return t.emit(src, ".", fn)
+func (t *TarFileEmitter) EmitAll(src string, f Filter, fn EmitFunc) error {
+	skipped := t.skippedFiles(src, f)
+	for _, file := range skipped {
+		f.skipped(file.path, file.size)
+	}
+
+	return t.emit(src, ".", f.Exclude, skipped, fn)
 }
 
 func (t *TarFileEmitter) EmitSingle(src string, fn EmitFunc) error {
 	parentDirectory, filepathRelativeToParent := separateParentFromFilename(src)
 
-	return t.emit(parentDirectory, filepathRelativeToParent, fn)
+	return t.emit(parentDirectory, filepathRelativeToParent, nil, nil, fn)
 }
 
-func (t *TarFileEmitter) emit(parentDirectory, filepathRelativeToParent string, fn EmitFunc) error {
+func (t *TarFileEmitter) emit(parentDirectory, filepathRelativeToParent string, exclude []Exclude, skipped []skippedFile, fn EmitFunc) error {
 	// We'll pipe the remote tar output directly into the tar reader
 	reader, writer := io.Pipe()
 
@@ -58,9 +69,13 @@ func (t *TarFileEmitter) emit(parentDirectory, filepathRelativeToParent string,
 			log.Fatalln("failed to create session: %w", err)
 		}
 		sess.Stdout = writer
+		// The files that are left out are listed on stdin, one pattern per line
+		if len(skipped) > 0 {
+			sess.Stdin = strings.NewReader(excludeFromList(skipped))
+		}
 		defer sess.Close()
 
-		if err := sess.Run("tar -C " + parentDirectory + " -cf - " + filepathRelativeToParent); err != nil {
+		if err := sess.Run(tarCommand(parentDirectory, filepathRelativeToParent, exclude, len(skipped) > 0)); err != nil {
 			log.Fatalln("failed to run tar: %w", err)
 		}
 	}()
@@ -98,7 +113,7 @@ func (t *TarFileEmitter) emit(parentDirectory, filepathRelativeToParent string,
 		}
 
 		// Emit the file
-		fn(targetPath, tr)
+		fn(targetPath, &SizedReader{tr, header.Size})
 	}
 
 	return nil
@@ -113,3 +128,102 @@ func separateParentFromFilename(src string) (string, string) {
 
 	return parentDirectory, filepathRelativeToParent
 }
+
+// tarCommand is the command that streams the target, relative to the parent directory, as a tar archive. The directories and globs that
+// are left out are passed with --exclude, where a * doesn't match a slash, the same as Exclude.Matches, and tar doesn't descend into the
+// directories that are left out. A leading ./ anchors an exclusion to the top of the directory, and one that matches at any depth is given
+// without it. The files that are left out, which includes those only left out for their size, are read from stdin when excludeFrom is set.
+func tarCommand(parentDirectory, filepathRelativeToParent string, exclude []Exclude, excludeFrom bool) string {
+	var options []string
+	for _, e := range exclude {
+		if e.MinSize > 0 {
+			continue
+		}
+		if pattern, anywhere := strings.CutPrefix(e.Path, "**/"); anywhere {
+			options = append(options, "--exclude="+shellQuote(pattern))
+		} else {
+			options = append(options, "--exclude="+shellQuote("./"+e.Path))
+		}
+	}
+	if len(options) > 0 {
+		options = append([]string{"--no-wildcards-match-slash"}, options...)
+	}
+	if excludeFrom {
+		options = append(options, "-X -")
+	}
+
+	return strings.Join(append(append([]string{"tar -C " + parentDirectory}, options...), "-cf - "+filepathRelativeToParent), " ")
+}
+
+// skippedFile is a file that is left out, relative to the directory that is emitted, along with its size.
+type skippedFile struct {
+	path string
+	size int64
+}
+
+// skippedFiles lists the files of the directory that the filter leaves out, along with their size, so that they can be reported and left
+// out of the size of the directory. find only narrows them down, since a * in -path also matches a slash, and Exclude.Skips decides. When
+// find can't list them, such as without GNU find, nothing is listed, and the files that are only left out for their size are downloaded
+// before they are left out.
+func (t *TarFileEmitter) skippedFiles(src string, f Filter) []skippedFile {
+	if len(f.Exclude) == 0 {
+		return nil
+	}
+
+	sess, err := t.r.NewSession()
+	if err != nil {
+		return nil
+	}
+	defer sess.Close()
+
+	res, err := sess.Output(findCommand(src, f.Exclude))
+	if err != nil {
+		return nil
+	}
+
+	var skipped []skippedFile
+	for _, line := range strings.Split(string(res), "\n") {
+		size, path, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		bytes, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || !f.skips(path, bytes) {
+			continue
+		}
+		skipped = append(skipped, skippedFile{path, bytes})
+	}
+
+	return skipped
+}
+
+// findCommand is the command that lists the size and the path, relative to the directory, of every file that may be left out.
+func findCommand(src string, exclude []Exclude) string {
+	var tests []string
+	for _, e := range exclude {
+		pattern, anywhere := strings.CutPrefix(e.Path, "**/")
+		prefix := "./"
+		if anywhere {
+			prefix = "*/"
+		}
+		test := fmt.Sprintf("\\( -path %s -o -path %s \\)", shellQuote(prefix+pattern), shellQuote(prefix+pattern+"/*"))
+		if e.MinSize > 0 {
+			test += fmt.Sprintf(" -size +%dc", e.MinSize-1)
+		}
+		tests = append(tests, test)
+	}
+
+	return fmt.Sprintf("cd %s && { find . -type f \\( %s \\) -printf '%%s %%P\\n' 2>/dev/null; true; }", shellQuote(src), strings.Join(tests, " -o "))
+}
+
+// excludeFromList lists the files for tar -X, escaping what tar would otherwise read as a glob.
+func excludeFromList(skipped []skippedFile) string {
+	escape := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`)
+
+	var list strings.Builder
+	for _, file := range skipped {
+		list.WriteString("./" + escape.Replace(file.path) + "\n")
+	}
+
+	return list.String()
+}
@@ -0,0 +1,51 @@
+package emitter
+
+import (
+	"testing"
+)
+
+func TestTarCommand(t *testing.T) {
+	exclude := []Exclude{
+		{Path: "wp-content/updraft"},
+		{Path: "**/error_log"},
+		{Path: "wp-content/uploads/*.log"},
+		{Path: "**/*.zip", MinSize: 100},
+	}
+
+	var tests = []struct {
+		name        string
+		exclude     []Exclude
+		excludeFrom bool
+		want        string
+	}{
+		{"without exclusions", nil, false, "tar -C /var/www -cf - ."},
+		{"with exclusions", exclude, false, `tar -C /var/www --no-wildcards-match-slash --exclude='./wp-content/updraft' --exclude='error_log' --exclude='./wp-content/uploads/*.log' -cf - .`},
+		{"with files to leave out from stdin", exclude, true, `tar -C /var/www --no-wildcards-match-slash --exclude='./wp-content/updraft' --exclude='error_log' --exclude='./wp-content/uploads/*.log' -X - -cf - .`},
+		{"with an exclusion that is quoted", []Exclude{{Path: "it's"}}, false, `tar -C /var/www --no-wildcards-match-slash --exclude='./it'\''s' -cf - .`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tarCommand("/var/www", ".", tt.exclude, tt.excludeFrom); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	got := findCommand("/var/www/my site", []Exclude{{Path: "wp-content/cache"}, {Path: "**/*.sql", MinSize: 1024}})
+
+	want := `cd '/var/www/my site' && { find . -type f \( \( -path './wp-content/cache' -o -path './wp-content/cache/*' \) -o \( -path '*/*.sql' -o -path '*/*.sql/*' \) -size +1023c \) -printf '%s %P\n' 2>/dev/null; true; }`
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestExcludeFromList(t *testing.T) {
+	got := excludeFromList([]skippedFile{{"wp-content/uploads/backup.zip", 100}, {"wp-content/uploads/[1]*.sql", 100}})
+
+	if want := "./wp-content/uploads/backup.zip\n./wp-content/uploads/\\[1]\\*.sql\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
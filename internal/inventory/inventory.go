@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"github.com/jfortunato/wp-zip/internal/database"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// HEADER_SIZE is how much of the start of a file WordPress reads its headers from.
+const HEADER_SIZE = 8192
+
+// The drop-ins that WordPress loads from wp-content when they exist, and whether it loads them on every request. The others are only loaded
+// in some situations, such as advanced-cache.php when WP_CACHE is set, maintenance.php during an update, or blog-deleted.php for a
+// subsite of a multisite network that was deleted.
+var dropIns = map[string]bool{
+	"advanced-cache.php":      false,
+	"db.php":                  true,
+	"db-error.php":            false,
+	"install.php":             false,
+	"maintenance.php":         false,
+	"object-cache.php":        true,
+	"php-error.php":           false,
+	"fatal-error-handler.php": false,
+	"sunrise.php":             false,
+	"blog-deleted.php":        false,
+	"blog-inactive.php":       false,
+	"blog-suspended.php":      false,
+}
+
+// The characters that end a header, the same as _cleanup_header_comment() in WordPress.
+var headerCommentEnd = regexp.MustCompile(`\s*(?:\*/|\?>).*`)
+
+// Plugin is a plugin, must-use plugin or drop-in. File is the way WordPress names it, relative to its directory, such as
+// akismet/akismet.php for a plugin and object-cache.php for a drop-in.
+type Plugin struct {
+	File    string `json:"file"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Active  bool   `json:"active"`
+	// NetworkActive is activated for every site of a multisite network
+	NetworkActive bool `json:"networkActive"`
+	MustUse       bool `json:"mustUse"`
+	DropIn        bool `json:"dropIn"`
+}
+
+// Theme is a theme, named by its directory.
+type Theme struct {
+	Stylesheet string `json:"stylesheet"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	// Template is the parent theme of a child theme
+	Template string `json:"template,omitempty"`
+	Active   bool   `json:"active"`
+	// ActiveParent is the parent of the active child theme
+	ActiveParent bool `json:"activeParent"`
+}
+
+// Inventory is every plugin and theme of a site.
+type Inventory struct {
+	Plugins []Plugin `json:"plugins"`
+	Themes  []Theme  `json:"themes"`
+}
+
+// ActiveReader reads which plugins and themes are active.
+type ActiveReader interface {
+	ReadActiveExtensions() (database.ActiveExtensions, error)
+}
+
+// Collector takes the inventory of a site from the headers of its files as they are downloaded, the same way WordPress reads them, so
+// that no PHP has to run. Which of them are active is read once the files are all downloaded.
+type Collector struct {
+	r       ActiveReader
+	plugins map[string]Plugin
+	themes  map[string]Theme
+}
+
+// NewCollector is the constructor for Collector.
+func NewCollector(r ActiveReader) *Collector {
+	return &Collector{r, map[string]Plugin{}, map[string]Theme{}}
+}
+
+// Wants reports whether a file could be a plugin, must-use plugin, drop-in or theme, given its path relative to wp-content.
+func (c *Collector) Wants(p string) bool {
+	parts := strings.Split(p, "/")
+	isPhp := path.Ext(p) == ".php"
+
+	switch {
+	case len(parts) == 1:
+		_, ok := dropIns[parts[0]]
+		return ok
+	case parts[0] == "plugins":
+		return isPhp && (len(parts) == 2 || len(parts) == 3)
+	case parts[0] == "mu-plugins":
+		return isPhp && len(parts) == 2
+	case parts[0] == "themes":
+		return len(parts) == 3 && parts[2] == "style.css"
+	}
+
+	return false
+}
+
+// Add reads the headers from the start of a file that the collector wants. A plugin file without a plugin name is only one of the
+// files of the plugin, and is left out.
+func (c *Collector) Add(p string, header []byte) {
+	dir, file, _ := strings.Cut(p, "/")
+	if !strings.Contains(p, "/") {
+		dir, file = "", p
+	}
+
+	switch dir {
+	case "":
+		headers := ParseHeaders(header, "Plugin Name", "Version")
+		c.plugins[p] = Plugin{File: file, Name: orDefault(headers["Plugin Name"], file), Version: headers["Version"], Active: dropIns[file], DropIn: true}
+	case "plugins":
+		headers := ParseHeaders(header, "Plugin Name", "Version")
+		if headers["Plugin Name"] != "" {
+			c.plugins[p] = Plugin{File: file, Name: headers["Plugin Name"], Version: headers["Version"]}
+		}
+	case "mu-plugins":
+		// Must-use plugins are always loaded, and don't need a plugin name
+		headers := ParseHeaders(header, "Plugin Name", "Version")
+		c.plugins[p] = Plugin{File: file, Name: orDefault(headers["Plugin Name"], file), Version: headers["Version"], Active: true, MustUse: true}
+	case "themes":
+		stylesheet := path.Dir(file)
+		headers := ParseHeaders(header, "Theme Name", "Version", "Template")
+		c.themes[stylesheet] = Theme{Stylesheet: stylesheet, Name: orDefault(headers["Theme Name"], stylesheet), Version: headers["Version"], Template: headers["Template"]}
+	}
+}
+
+// Inventory returns every plugin and theme that was found. When the active ones can't be read, none of the plugins and themes are
+// marked as active, except for the must-use plugins and the drop-ins that are loaded on every request.
+func (c *Collector) Inventory() Inventory {
+	active, err := c.r.ReadActiveExtensions()
+	if err != nil {
+		log.Printf("could not read which plugins and themes are active: %s", err)
+	}
+
+	inventory := Inventory{Plugins: []Plugin{}, Themes: []Theme{}}
+	for _, plugin := range c.plugins {
+		if !plugin.MustUse && !plugin.DropIn {
+			plugin.NetworkActive = slices.Contains(active.NetworkPlugins, plugin.File)
+			plugin.Active = plugin.NetworkActive || slices.Contains(active.Plugins, plugin.File)
+		}
+		inventory.Plugins = append(inventory.Plugins, plugin)
+	}
+	for _, theme := range c.themes {
+		theme.Active = theme.Stylesheet == active.Stylesheet
+		theme.ActiveParent = !theme.Active && theme.Stylesheet == active.Template
+		inventory.Themes = append(inventory.Themes, theme)
+	}
+
+	// Plugins come before must-use plugins, and must-use plugins before drop-ins
+	kind := func(p Plugin) int {
+		switch {
+		case p.DropIn:
+			return 2
+		case p.MustUse:
+			return 1
+		}
+		return 0
+	}
+	sort.Slice(inventory.Plugins, func(i, j int) bool {
+		a, b := inventory.Plugins[i], inventory.Plugins[j]
+		if kind(a) != kind(b) {
+			return kind(a) < kind(b)
+		}
+		return a.File < b.File
+	})
+	sort.Slice(inventory.Themes, func(i, j int) bool { return inventory.Themes[i].Stylesheet < inventory.Themes[j].Stylesheet })
+
+	return inventory
+}
+
+// ParseHeaders reads the named headers from the start of a file, the same way as get_file_data() in WordPress. A header that isn't there
+// is empty.
+func ParseHeaders(data []byte, names ...string) map[string]string {
+	if len(data) > HEADER_SIZE {
+		data = data[:HEADER_SIZE]
+	}
+	contents := strings.ReplaceAll(string(data), "\r", "\n")
+
+	headers := map[string]string{}
+	for _, name := range names {
+		re := regexp.MustCompile(`(?mi)^(?:[ \t]*<\?php)?[ \t/*#@]*` + regexp.QuoteMeta(name) + `:(.*)$`)
+		if matches := re.FindStringSubmatch(contents); matches != nil {
+			headers[name] = strings.TrimSpace(headerCommentEnd.ReplaceAllString(matches[1], ""))
+		}
+	}
+
+	return headers
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
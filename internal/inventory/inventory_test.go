@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	var tests = []struct {
+		name     string
+		contents string
+		want     map[string]string
+	}{
+		{
+			"docblock",
+			"<?php\n/**\n * Plugin Name: Akismet Anti-spam\n * Version: 5.3\n */\n",
+			map[string]string{"Plugin Name": "Akismet Anti-spam", "Version": "5.3"},
+		},
+		{
+			"single line comments and carriage returns",
+			"<?php\r// Plugin Name: Hello Dolly\r// version:1.7.2\r",
+			map[string]string{"Plugin Name": "Hello Dolly", "Version": "1.7.2"},
+		},
+		{
+			"header on the same line as the opening tag, closed in the same line",
+			"<?php /* Plugin Name: Tiny */ ?>\n",
+			map[string]string{"Plugin Name": "Tiny"},
+		},
+		{
+			"stylesheet",
+			"/*\nTheme Name: Child\nTemplate: parent\nVersion: 1.0\n*/\n",
+			map[string]string{"Version": "1.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseHeaders([]byte(tt.contents), "Plugin Name", "Version"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got headers %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("it only reads the start of the file", func(t *testing.T) {
+		contents := make([]byte, HEADER_SIZE, HEADER_SIZE+32)
+		contents = append(contents, "\nPlugin Name: Too Late\n"...)
+
+		if got := ParseHeaders(contents, "Plugin Name"); len(got) != 0 {
+			t.Errorf("got headers %v; want none", got)
+		}
+	})
+}
+
+func TestCollector_Wants(t *testing.T) {
+	var tests = []struct {
+		path string
+		want bool
+	}{
+		{"plugins/akismet/akismet.php", true},
+		{"plugins/hello.php", true},
+		{"plugins/akismet/views/config.php", false},
+		{"plugins/akismet/readme.txt", false},
+		{"mu-plugins/loader.php", true},
+		{"mu-plugins/loader/loader.php", false},
+		{"themes/child/style.css", true},
+		{"themes/child/assets/style.css", false},
+		{"object-cache.php", true},
+		{"index.php", false},
+		{"uploads/2024/file.php", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := NewCollector(nil).Wants(tt.path); got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_Inventory(t *testing.T) {
+	files := map[string]string{
+		"plugins/akismet/akismet.php":   "<?php\n/*\nPlugin Name: Akismet\nVersion: 5.3\n*/",
+		"plugins/akismet/class.php":     "<?php\nclass Akismet {}",
+		"plugins/hello.php":             "<?php\n/*\nPlugin Name: Hello Dolly\nVersion: 1.7.2\n*/",
+		"plugins/network/network.php":   "<?php\n/*\nPlugin Name: Network Plugin\n*/",
+		"mu-plugins/loader.php":         "<?php\nrequire 'loader/loader.php';",
+		"object-cache.php":              "<?php\n/*\nPlugin Name: Redis Object Cache Drop-In\nVersion: 2.5.0\n*/",
+		"maintenance.php":               "<?php\ndie('Back soon');",
+		"themes/parent/style.css":       "/*\nTheme Name: Parent\nVersion: 2.0\n*/",
+		"themes/child/style.css":        "/*\nTheme Name: Child\nTemplate: parent\nVersion: 1.0\n*/",
+		"themes/twentytwenty/style.css": "/*\nTheme Name: Twenty Twenty\nVersion: 2.6\n*/",
+	}
+	active := database.ActiveExtensions{Plugins: []string{"akismet/akismet.php"}, NetworkPlugins: []string{"network/network.php"}, Template: "parent", Stylesheet: "child"}
+
+	t.Run("it lists the plugins and themes and which of them are active", func(t *testing.T) {
+		c := NewCollector(&ActiveReaderStub{active: active})
+		for path, contents := range files {
+			c.Add(path, []byte(contents))
+		}
+
+		got := c.Inventory()
+
+		want := Inventory{
+			Plugins: []Plugin{
+				{File: "akismet/akismet.php", Name: "Akismet", Version: "5.3", Active: true},
+				{File: "hello.php", Name: "Hello Dolly", Version: "1.7.2"},
+				{File: "network/network.php", Name: "Network Plugin", Active: true, NetworkActive: true},
+				{File: "loader.php", Name: "loader.php", Active: true, MustUse: true},
+				{File: "maintenance.php", Name: "maintenance.php", DropIn: true},
+				{File: "object-cache.php", Name: "Redis Object Cache Drop-In", Version: "2.5.0", Active: true, DropIn: true},
+			},
+			Themes: []Theme{
+				{Stylesheet: "child", Name: "Child", Version: "1.0", Template: "parent", Active: true},
+				{Stylesheet: "parent", Name: "Parent", Version: "2.0", ActiveParent: true},
+				{Stylesheet: "twentytwenty", Name: "Twenty Twenty", Version: "2.6"},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got inventory %+v; want %+v", got, want)
+		}
+	})
+
+	t.Run("it still lists them if the active ones cannot be read", func(t *testing.T) {
+		c := NewCollector(&ActiveReaderStub{err: errors.New("error")})
+		c.Add("plugins/hello.php", []byte(files["plugins/hello.php"]))
+		c.Add("themes/child/style.css", []byte(files["themes/child/style.css"]))
+
+		got := c.Inventory()
+
+		want := Inventory{
+			Plugins: []Plugin{{File: "hello.php", Name: "Hello Dolly", Version: "1.7.2"}},
+			Themes:  []Theme{{Stylesheet: "child", Name: "Child", Version: "1.0", Template: "parent"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got inventory %+v; want %+v", got, want)
+		}
+	})
+
+	t.Run("it is empty when nothing was found", func(t *testing.T) {
+		got := NewCollector(&ActiveReaderStub{}).Inventory()
+
+		if want := (Inventory{Plugins: []Plugin{}, Themes: []Theme{}}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got inventory %+v; want %+v", got, want)
+		}
+	})
+}
+
+type ActiveReaderStub struct {
+	active database.ActiveExtensions
+	err    error
+}
+
+func (r *ActiveReaderStub) ReadActiveExtensions() (database.ActiveExtensions, error) {
+	return r.active, r.err
+}
@@ -1,37 +1,309 @@
 package operations
 
 import (
+	"fmt"
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/inventory"
 	"github.com/jfortunato/wp-zip/internal/types"
 	"github.com/schollz/progressbar/v3"
 	"io"
+	"log"
 	"strings"
 )
 
+// DownloadFilesOperation downloads the site files into the standard layout, whatever their layout on the server. WordPress is placed at
+// the root of files/, wp-content in files/wp-content/ and wp-config.php next to WordPress, which is the layout LocalWP imports.
 type DownloadFilesOperation struct {
-	emitter      emitter.FileEmitter
-	pathToPublic types.PublicPath
+	emitter emitter.FileEmitter
+	layout  types.SiteLayout
+	// subsite is the subsite of a multisite network being exported on its own, whose uploads are the only ones downloaded. It is nil
+	// when the whole site is exported.
+	subsite *types.Extraction
+	// exclude are the files and directories inside of WordPress that are left out, such as other installs nested inside of it
+	exclude []Exclusion
+	// collector is shown the start of the files of wp-content that it wants, and may be nil
+	collector HeaderCollector
+	// skipped are the files that each of the smart exclusions left out, by the path of the exclusion
+	skipped map[string]*skippedFiles
 }
 
-func NewDownloadFilesOperation(directoryEmitter emitter.FileEmitter, pathToPublic types.PublicPath) *DownloadFilesOperation {
-	return &DownloadFilesOperation{directoryEmitter, pathToPublic}
+// skippedFiles are the files that one of the smart exclusions left out, and their size in bytes.
+type skippedFiles struct {
+	paths []string
+	bytes int64
+}
+
+// Exclusion is a file or directory that is left out of the archive, given relative to WordPress, along with why it is left out. The path
+// may also be a glob, where a leading **/ matches at any depth, such as **/error_log.
+type Exclusion struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	// MinSize leaves out only the files of at least this many bytes, when it is set
+	MinSize int64 `json:"minSize,omitempty"`
+	// Smart is whether the exclusion is one of the SmartExclusions, whose skipped files are reported once the files are downloaded
+	Smart bool `json:"smart,omitempty"`
+}
+
+// Matches reports whether the path, relative to WordPress, is left out. A glob leaves out the directories it matches along with everything
+// inside of them.
+func (e Exclusion) Matches(p string) bool {
+	return emitter.Exclude{Path: e.Path}.Matches(p)
+}
+
+// Skips reports whether a file of the given size is left out. A file whose size isn't known, given as -1, is only left out when the
+// exclusion doesn't depend on its size.
+func (e Exclusion) Skips(p string, size int64) bool {
+	return emitter.Exclude{Path: e.Path, MinSize: e.MinSize}.Skips(p, size)
+}
+
+// HeaderCollector reads the headers from the start of files of wp-content as they are downloaded, such as to take an inventory of the
+// plugins and themes. Paths are relative to wp-content.
+type HeaderCollector interface {
+	Wants(path string) bool
+	Add(path string, header []byte)
+}
+
+func NewDownloadFilesOperation(directoryEmitter emitter.FileEmitter, layout types.SiteLayout, subsite *types.Extraction, exclude []Exclusion, collector HeaderCollector) *DownloadFilesOperation {
+	return &DownloadFilesOperation{emitter: directoryEmitter, layout: layout, subsite: subsite, exclude: exclude, collector: collector}
 }
 
 func (o *DownloadFilesOperation) SendFiles(fn SendFilesFunc) error {
-	bar := progressbar.DefaultBytes(int64(o.emitter.CalculateByteSize(string(o.pathToPublic))), "Downloading files")
+	bar := progressbar.DefaultBytes(int64(o.EstimateSize()), "Downloading files")
 	defer bar.Clear()
+	o.skipped = map[string]*skippedFiles{}
+	defer o.reportSkipped()
 
-	// Download the entire public directory and emit each file as they come in to the channel
-	return o.emitter.EmitAll(string(o.pathToPublic), func(path string, contents io.Reader) {
-		// Remove the leading pathToPublic from the path
-		path = strings.TrimPrefix(path, o.pathToPublic.String())
+	contentRel, contentInCore := o.layout.Core.Rel(o.layout.Content)
+	// Download the entire WordPress directory and emit each file as they come in to the channel
+	err := o.emitter.EmitAll(directory(o.layout.Core), o.filter(false), func(path string, contents io.Reader) {
+		// Remove the leading directory from the path
+		path = strings.TrimPrefix(path, o.layout.Core.String())
 
-		f := File{
-			Name: "files/" + path, // We want to store the files in the "files" directory
-			// The progress bar is a writer, so we can write to it to update the progress
-			Body: io.TeeReader(contents, bar),
+		if o.layout.ContentMoved() && (isWithin(path, "wp-content") || (contentInCore && isWithin(path, contentRel))) {
+			return
+		}
+		if o.excluded(path, sizeOf(contents)) {
+			return
+		}
+		// A wp-config.php that only works in the original layout, or for the whole network, is replaced by one that is generated
+		if path == "wp-config.php" && o.generatesConfig() {
+			return
+		}
+		if rest, ok := strings.CutPrefix(path, "wp-content/"); ok {
+			o.sendContent(fn, rest, contents, bar)
+			return
 		}
 
-		fn(f)
+		fn(o.file(path, contents, bar))
 	})
+	if err != nil {
+		return err
+	}
+
+	if o.layout.ContentMoved() {
+		err = o.emitter.EmitAll(directory(o.layout.Content), o.filter(true), func(path string, contents io.Reader) {
+			path = strings.TrimPrefix(path, o.layout.Content.String())
+			if o.excluded("wp-content/"+path, sizeOf(contents)) {
+				return
+			}
+			o.sendContent(fn, path, contents, bar)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// A wp-config.php in the parent of WordPress still works once it is next to WordPress
+	if _, configInCore := o.layout.Core.Rel(types.PublicPath(o.layout.Config)); !o.generatesConfig() && !configInCore {
+		return o.emitter.EmitSingle(o.layout.Config, func(path string, contents io.Reader) {
+			fn(o.file("wp-config.php", contents, bar))
+		})
+	}
+
+	return nil
+}
+
+// EstimateSize returns the size of the files in bytes, without what the emitter leaves out on the server, or -1 when the emitter can't tell.
+func (o *DownloadFilesOperation) EstimateSize() int {
+	size := o.emitter.CalculateByteSize(directory(o.layout.Core), o.filter(false))
+	// wp-content is only downloaded on its own when it isn't already part of the WordPress directory
+	if _, contentInCore := o.layout.Core.Rel(o.layout.Content); o.layout.ContentMoved() && !contentInCore && size >= 0 {
+		size += o.emitter.CalculateByteSize(directory(o.layout.Content), o.filter(true))
+	}
+
+	return size
+}
+
+func (o *DownloadFilesOperation) Describe() string {
+	description := fmt.Sprintf("download the files of %s into files/", o.layout.Core)
+	if o.layout.ContentMoved() {
+		description += fmt.Sprintf(", with wp-content from %s", o.layout.Content)
+	}
+
+	return description
+}
+
+// Exclusions lists everything that is left out of the files, including what is left out when the site is moved into the standard layout
+// or a single subsite is exported.
+func (o *DownloadFilesOperation) Exclusions() []Exclusion {
+	exclusions := append([]Exclusion{}, o.exclude...)
+	if o.layout.ContentMoved() {
+		exclusions = append(exclusions, Exclusion{Path: "wp-content", Reason: "wp-content is downloaded from " + o.layout.Content.String() + " instead"})
+	}
+	if o.generatesConfig() {
+		exclusions = append(exclusions, Exclusion{Path: "wp-config.php", Reason: "replaced by a generated wp-config.php"})
+	}
+	if o.subsite != nil {
+		exclusions = append(exclusions, Exclusion{Path: "wp-content/uploads", Reason: fmt.Sprintf("only the uploads of the subsite %s%s are kept", o.subsite.Subsite.Domain, o.subsite.Subsite.Path)})
+	}
+
+	return exclusions
+}
+
+// filter is what the emitter leaves out on the server, so that it is never downloaded, for either the WordPress directory or the
+// wp-content directory when it is moved. Only the exclusions that apply to wp-content on its own are passed on for it, and the rest are
+// left out as the files come in. The uploads of the other sites of a network are left out on the server as well. The files that are left out are reported the same as those left out as they come in.
+func (o *DownloadFilesOperation) filter(content bool) emitter.Filter {
+	var filter emitter.Filter
+	for _, exclusion := range o.exclude {
+		path := exclusion.Path
+		if content && !strings.HasPrefix(path, "**/") {
+			var ok bool
+			if path, ok = strings.CutPrefix(path, "wp-content/"); !ok {
+				continue
+			}
+		}
+		filter.Exclude = append(filter.Exclude, emitter.Exclude{Path: path, MinSize: exclusion.MinSize})
+	}
+	// The uploads of the other sites of a network are never downloaded when only one subsite is exported
+	if o.subsite != nil && content == o.layout.ContentMoved() {
+		for _, uploads := range o.subsite.OtherUploads() {
+			if !content {
+				uploads = "wp-content/" + uploads
+			}
+			filter.Exclude = append(filter.Exclude, emitter.Exclude{Path: uploads})
+		}
+	}
+
+	contentRel, contentInCore := o.layout.Core.Rel(o.layout.Content)
+	filter.Skipped = func(path string, size int64) {
+		if content {
+			path = "wp-content/" + path
+		} else if o.layout.ContentMoved() && (isWithin(path, "wp-content") || (contentInCore && isWithin(path, contentRel))) {
+			return
+		}
+		o.excluded(path, size)
+	}
+
+	return filter
+}
+
+// excluded reports whether the file, given relative to WordPress in the standard layout, is left out by any of the exclusions. The size
+// is -1 when it isn't known.
+func (o *DownloadFilesOperation) excluded(path string, size int64) bool {
+	for _, exclusion := range o.exclude {
+		if !exclusion.Skips(path, size) {
+			continue
+		}
+		if exclusion.Smart {
+			if o.skipped[exclusion.Path] == nil {
+				o.skipped[exclusion.Path] = &skippedFiles{}
+			}
+			o.skipped[exclusion.Path].paths = append(o.skipped[exclusion.Path].paths, path)
+			o.skipped[exclusion.Path].bytes += max(size, 0)
+		}
+		return true
+	}
+	return false
+}
+
+// sizeOf is the size of a file that the emitters passed on along with its contents, or -1 when they didn't know it.
+func sizeOf(contents io.Reader) int64 {
+	if sized, ok := contents.(interface{ Size() int64 }); ok {
+		return sized.Size()
+	}
+	return -1
+}
+
+// reportSkipped warns about every file that the smart exclusions left out, since they are left out without being asked for.
+func (o *DownloadFilesOperation) reportSkipped() {
+	for _, exclusion := range o.exclude {
+		skipped, ok := o.skipped[exclusion.Path]
+		if !ok {
+			continue
+		}
+		paths := skipped.paths
+		if len(paths) > MAX_REPORTED_SKIPPED {
+			paths = append(paths[:MAX_REPORTED_SKIPPED:MAX_REPORTED_SKIPPED], fmt.Sprintf("and %d more", len(skipped.paths)-MAX_REPORTED_SKIPPED))
+		}
+		log.Printf("left out %d files (%s) of %s, %s: %s", len(skipped.paths), types.FormatBytes(skipped.bytes), exclusion.Path, exclusion.Reason, strings.Join(paths, ", "))
+	}
+	if len(o.skipped) > 0 {
+		log.Printf("to keep them, export with --no-smart-exclude")
+	}
+}
+
+// generatesConfig reports whether the site's own wp-config.php is left out, since it is replaced by the GenerateWPConfigOperation.
+func (o *DownloadFilesOperation) generatesConfig() bool {
+	return !o.layout.Standard() || o.subsite != nil
+}
+
+// sendContent sends a file of wp-content, given its path relative to wp-content. When a single subsite is exported, the uploads of every
+// other site are left out.
+func (o *DownloadFilesOperation) sendContent(fn SendFilesFunc, path string, contents io.Reader, bar io.Writer) {
+	if o.subsite != nil {
+		var ok bool
+		if path, ok = o.subsite.ContentPath(path); !ok {
+			return
+		}
+	}
+
+	if o.collector != nil && o.collector.Wants(path) {
+		contents = &headerReader{r: contents, path: path, c: o.collector}
+	}
+
+	fn(o.file("wp-content/"+path, contents, bar))
+}
+
+// headerReader passes the start of a file on to the collector, once it has been read.
+type headerReader struct {
+	r      io.Reader
+	path   string
+	c      HeaderCollector
+	header []byte
+	done   bool
+}
+
+func (h *headerReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if !h.done {
+		h.header = append(h.header, p[:min(n, inventory.HEADER_SIZE-len(h.header))]...)
+		if len(h.header) == inventory.HEADER_SIZE || err != nil {
+			h.done = true
+			h.c.Add(h.path, h.header)
+		}
+	}
+
+	return n, err
+}
+
+func (o *DownloadFilesOperation) file(path string, contents io.Reader, bar io.Writer) File {
+	return File{
+		Name: "files/" + path, // We want to store the files in the "files" directory
+		// The progress bar is a writer, so we can write to it to update the progress
+		Body: io.TeeReader(contents, bar),
+	}
+}
+
+// directory returns the path of the directory without a trailing slash, so that the emitted paths don't have a double slash.
+func directory(p types.PublicPath) string {
+	if trimmed := strings.TrimSuffix(p.String(), "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
+
+// isWithin reports whether the relative path is the directory or inside of it.
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
 }
@@ -0,0 +1,397 @@
+package operations
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/inventory"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFilesOperation(t *testing.T) {
+	var tests = []struct {
+		name    string
+		files   map[string]string
+		layout  types.SiteLayout
+		subsite *types.Extraction
+		exclude []Exclusion
+		want    map[string]string
+	}{
+		{
+			"standard",
+			map[string]string{
+				"/var/www/html/index.php":                 "index",
+				"/var/www/html/wp-config.php":             "config",
+				"/var/www/html/wp-content/themes/a/a.css": "theme",
+			},
+			types.StandardLayout("/var/www/html"),
+			nil,
+			nil,
+			map[string]string{
+				"files/index.php":                 "index",
+				"files/wp-config.php":             "config",
+				"files/wp-content/themes/a/a.css": "theme",
+			},
+		},
+		{
+			"wp-config.php in the parent",
+			map[string]string{
+				"/var/www/html/index.php": "index",
+				"/var/www/wp-config.php":  "config",
+				"/var/www/other.txt":      "other",
+			},
+			types.SiteLayout{Core: "/var/www/html/", Content: "/var/www/html/wp-content", Config: "/var/www/wp-config.php"},
+			nil,
+			nil,
+			map[string]string{
+				"files/index.php":     "index",
+				"files/wp-config.php": "config",
+			},
+		},
+		{
+			"bedrock",
+			map[string]string{
+				"/srv/site/web/index.php":                    "bedrock index",
+				"/srv/site/web/wp-config.php":                "bedrock config",
+				"/srv/site/web/wp/index.php":                 "index",
+				"/srv/site/web/wp/wp-content/themes/t/t.css": "default theme",
+				"/srv/site/web/app/plugins/p/p.php":          "plugin",
+			},
+			types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"},
+			nil,
+			nil,
+			map[string]string{
+				"files/index.php":                  "index",
+				"files/wp-content/plugins/p/p.php": "plugin",
+			},
+		},
+		{
+			"wp-content inside of wordpress under another name",
+			map[string]string{
+				"/var/www/html/index.php":            "index",
+				"/var/www/html/wp-config.php":        "config",
+				"/var/www/html/wp-content/old.txt":   "old",
+				"/var/www/html/assets/uploads/a.jpg": "upload",
+			},
+			types.SiteLayout{Core: "/var/www/html", Content: "/var/www/html/assets", Config: "/var/www/html/wp-config.php"},
+			nil,
+			nil,
+			map[string]string{
+				"files/index.php":                "index",
+				"files/wp-content/uploads/a.jpg": "upload",
+			},
+		},
+		{
+			"a single subsite",
+			map[string]string{
+				"/var/www/html/index.php":                             "index",
+				"/var/www/html/wp-config.php":                         "network config",
+				"/var/www/html/wp-content/themes/a/a.css":             "theme",
+				"/var/www/html/wp-content/uploads/2024/main.jpg":      "main upload",
+				"/var/www/html/wp-content/uploads/sites/2/2024/a.jpg": "subsite upload",
+				"/var/www/html/wp-content/uploads/sites/3/2024/b.jpg": "other upload",
+			},
+			types.StandardLayout("/var/www/html"),
+			&types.Extraction{Network: types.Network{MainSiteID: 1}, Subsite: types.Subsite{ID: 2}},
+			nil,
+			map[string]string{
+				"files/index.php":                     "index",
+				"files/wp-content/themes/a/a.css":     "theme",
+				"files/wp-content/uploads/2024/a.jpg": "subsite upload",
+			},
+		},
+		{
+			"another install nested inside of WordPress",
+			map[string]string{
+				"/var/www/html/index.php":                 "index",
+				"/var/www/html/wp-config.php":             "config",
+				"/var/www/html/staging/index.php":         "staging index",
+				"/var/www/html/staging/wp-config.php":     "staging config",
+				"/var/www/html/staging-notes/notes.txt":   "notes",
+				"/var/www/html/wp-content/themes/a/a.css": "theme",
+			},
+			types.StandardLayout("/var/www/html"),
+			nil,
+			[]Exclusion{{Path: "staging", Reason: "another install"}},
+			map[string]string{
+				"files/index.php":                 "index",
+				"files/wp-config.php":             "config",
+				"files/staging-notes/notes.txt":   "notes",
+				"files/wp-content/themes/a/a.css": "theme",
+			},
+		},
+		{
+			"globs, with wp-content in a directory of its own",
+			map[string]string{
+				"/srv/site/web/wp/index.php":                  "index",
+				"/srv/site/web/wp/error_log":                  "log",
+				"/srv/site/web/wp/backup.zip":                 "backup",
+				"/srv/site/web/app/cache/page.html":           "cache",
+				"/srv/site/web/app/plugins/p/error_log":       "plugin log",
+				"/srv/site/web/app/plugins/p/p.php":           "plugin",
+				"/srv/site/web/app/plugins/p/assets/font.zip": "font",
+			},
+			types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"},
+			nil,
+			[]Exclusion{{Path: "**/error_log"}, {Path: "*.zip"}, {Path: "wp-content/cache"}},
+			map[string]string{
+				"files/index.php":                            "index",
+				"files/wp-content/plugins/p/p.php":           "plugin",
+				"files/wp-content/plugins/p/assets/font.zip": "font",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation := NewDownloadFilesOperation(&FileEmitterStub{tt.files}, tt.layout, tt.subsite, tt.exclude, nil)
+
+			got := map[string]string{}
+			err := operation.SendFiles(func(file File) error {
+				got[file.Name] = readerToString(file.Body)
+				return nil
+			})
+
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadFilesOperation_Collector(t *testing.T) {
+	files := map[string]string{
+		"/var/www/html/index.php":                     "index",
+		"/var/www/html/wp-content/plugins/a/a.php":    "<?php /* Plugin Name: A */",
+		"/var/www/html/wp-content/plugins/a/long.php": strings.Repeat("x", 10000),
+	}
+	collector := &HeaderCollectorSpy{headers: map[string]string{}}
+	operation := NewDownloadFilesOperation(&FileEmitterStub{files}, types.StandardLayout("/var/www/html"), nil, nil, collector)
+
+	got := map[string]string{}
+	err := operation.SendFiles(func(file File) error {
+		got[file.Name] = readerToString(file.Body)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	// The files are archived whole, and the collector only sees the start of the ones it wants
+	if got["files/wp-content/plugins/a/long.php"] != files["/var/www/html/wp-content/plugins/a/long.php"] {
+		t.Errorf("got a different file in the archive than was downloaded")
+	}
+	want := map[string]string{"plugins/a/a.php": "<?php /* Plugin Name: A */", "plugins/a/long.php": strings.Repeat("x", inventory.HEADER_SIZE)}
+	if !reflect.DeepEqual(collector.headers, want) {
+		t.Errorf("got headers of %d files; want %d", len(collector.headers), len(want))
+	}
+}
+
+func TestDownloadFilesOperation_Exclusions(t *testing.T) {
+	t.Run("it lists the exclusions it was given", func(t *testing.T) {
+		exclude := []Exclusion{{Path: "staging", Reason: "another install"}}
+		operation := NewDownloadFilesOperation(&FileEmitterStub{}, types.StandardLayout("/var/www/html"), nil, exclude, nil)
+
+		if got := operation.Exclusions(); !reflect.DeepEqual(got, exclude) {
+			t.Errorf("got exclusions %v; want %v", got, exclude)
+		}
+	})
+
+	t.Run("it lists what is left out of a site that is moved into the standard layout", func(t *testing.T) {
+		layout := types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"}
+		operation := NewDownloadFilesOperation(&FileEmitterStub{}, layout, nil, nil, nil)
+
+		var got []string
+		for _, exclusion := range operation.Exclusions() {
+			got = append(got, exclusion.Path)
+		}
+
+		if want := []string{"wp-content", "wp-config.php"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got exclusions %v; want %v", got, want)
+		}
+	})
+}
+
+func TestExclusion_Matches(t *testing.T) {
+	var tests = []struct {
+		exclusion string
+		path      string
+		want      bool
+	}{
+		{"staging", "staging/index.php", true},
+		{"staging", "staging-notes/notes.txt", false},
+		{"staging", "wp-content/staging/a.txt", false},
+		{"*.zip", "backup.zip", true},
+		{"*.zip", "wp-content/backup.zip", false},
+		{"wp-content/*.zip", "wp-content/backup.zip", true},
+		{"wp-content/cache*", "wp-content/cache/page/index.html", true},
+		{"**/error_log", "error_log", true},
+		{"**/error_log", "wp-content/plugins/p/error_log", true},
+		{"**/error_log", "wp-content/plugins/p/error_log.txt", false},
+		{"**/cache", "wp-content/cache/page/index.html", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exclusion+" "+tt.path, func(t *testing.T) {
+			if got := (Exclusion{Path: tt.exclusion}).Matches(tt.path); got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusion_Skips(t *testing.T) {
+	exclusion := Exclusion{Path: "**/*.zip", MinSize: 100}
+
+	for size, want := range map[int64]bool{-1: false, 99: false, 100: true} {
+		if got := exclusion.Skips("wp-content/uploads/a.zip", size); got != want {
+			t.Errorf("got %v for a file of %d bytes; want %v", got, size, want)
+		}
+	}
+	if !(Exclusion{Path: "wp-content/cache"}).Skips("wp-content/cache/a.html", -1) {
+		t.Errorf("got a file of unknown size kept; want it left out when the size doesn't matter")
+	}
+}
+
+func TestDownloadFilesOperation_SmartExclusions(t *testing.T) {
+	files := map[string]string{
+		"/var/www/html/index.php":                       "index",
+		"/var/www/html/wp-content/updraft/backup.zip":   "updraft backup",
+		"/var/www/html/wp-content/cache/page.html":      "cache",
+		"/var/www/html/wp-content/uploads/large.zip":    "a large archive",
+		"/var/www/html/wp-content/uploads/small.zip":    "small",
+		"/var/www/html/wp-content/plugins/p/backup.zip": "kept with --exclude",
+	}
+	exclude := []Exclusion{
+		{Path: "wp-content/plugins/p/backup.zip", Reason: "left out with --exclude"},
+		{Path: "wp-content/updraft", Reason: "UpdraftPlus backups", Smart: true},
+		{Path: "wp-content/cache", Reason: "a cache", Smart: true},
+		{Path: "**/*.zip", Reason: "an archive", MinSize: 10, Smart: true},
+	}
+	operation := NewDownloadFilesOperation(&FileEmitterStub{files}, types.StandardLayout("/var/www/html"), nil, exclude, nil)
+
+	got := map[string]string{}
+	err := operation.SendFiles(func(file File) error {
+		got[file.Name] = readerToString(file.Body)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	want := map[string]string{"files/index.php": "index", "files/wp-content/uploads/small.zip": "small"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v; want %v", got, want)
+	}
+	// Only what the smart exclusions left out is reported
+	if len(operation.skipped) != 3 || operation.skipped["**/*.zip"].bytes != 15 || operation.skipped["wp-content/plugins/p/backup.zip"] != nil {
+		t.Errorf("got skipped files %v; want those of the 3 smart exclusions", operation.skipped)
+	}
+}
+
+func TestDownloadFilesOperation_Filter(t *testing.T) {
+	exclude := []Exclusion{
+		{Path: "staging", Reason: "another install"},
+		{Path: "wp-content/updraft", Reason: "UpdraftPlus backups", Smart: true},
+		{Path: "**/*.zip", Reason: "an archive", MinSize: 10, Smart: true},
+	}
+	files := map[string]string{
+		"/srv/site/web/wp/index.php":          "index",
+		"/srv/site/web/app/updraft/backup.gz": "updraft backup",
+		"/srv/site/web/app/uploads/large.zip": "a large archive",
+	}
+	layout := types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"}
+	operation := NewDownloadFilesOperation(&FileEmitterStub{files}, layout, nil, exclude, nil)
+
+	t.Run("it passes the exclusions that apply to wp-content on to the emitter, relative to wp-content", func(t *testing.T) {
+		want := []emitter.Exclude{{Path: "updraft"}, {Path: "**/*.zip", MinSize: 10}}
+		if got := operation.filter(true).Exclude; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; want %v", got, want)
+		}
+		if got := operation.filter(false).Exclude; len(got) != len(exclude) {
+			t.Errorf("got %v; want every exclusion", got)
+		}
+	})
+
+	t.Run("it reports the files that the emitter left out", func(t *testing.T) {
+		operation.SendFiles(func(file File) error { return nil })
+
+		if len(operation.skipped) != 2 || operation.skipped["wp-content/updraft"].paths[0] != "wp-content/updraft/backup.gz" || operation.skipped["**/*.zip"].bytes != 15 {
+			t.Errorf("got skipped files %v; want those of wp-content, relative to WordPress", operation.skipped)
+		}
+	})
+
+	network := types.Network{MainSiteID: 1, Subsites: []types.Subsite{{ID: 1}, {ID: 2}, {ID: 3}}}
+	subsite := &types.Extraction{Network: network, Subsite: network.Subsites[1]}
+
+	t.Run("it leaves out the uploads of the other sites on the server", func(t *testing.T) {
+		operation := NewDownloadFilesOperation(&FileEmitterStub{}, types.StandardLayout("/var/www/html"), subsite, nil, nil)
+
+		got := operation.filter(false).Exclude
+
+		want := []emitter.Exclude{{Path: "wp-content/blogs.dir"}, {Path: "wp-content/uploads/sites/3"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it leaves them out of wp-content when it is moved", func(t *testing.T) {
+		layout := types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"}
+		operation := NewDownloadFilesOperation(&FileEmitterStub{}, layout, subsite, nil, nil)
+
+		if got := operation.filter(false).Exclude; len(got) != 0 {
+			t.Errorf("got %v for WordPress; want nothing", got)
+		}
+		want := []emitter.Exclude{{Path: "blogs.dir"}, {Path: "uploads/sites/3"}}
+		if got := operation.filter(true).Exclude; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v for wp-content; want %v", got, want)
+		}
+	})
+}
+
+// HeaderCollectorSpy wants every PHP file, and records the start of each one.
+type HeaderCollectorSpy struct {
+	headers map[string]string
+}
+
+func (c *HeaderCollectorSpy) Wants(path string) bool { return strings.HasSuffix(path, ".php") }
+
+func (c *HeaderCollectorSpy) Add(path string, header []byte) { c.headers[path] = string(header) }
+
+// FileEmitterStub emits the full path of each file, the way the sftp emitter does.
+type FileEmitterStub struct {
+	files map[string]string
+}
+
+func (e *FileEmitterStub) CalculateByteSize(src string, f emitter.Filter) int { return 0 }
+
+// EmitAll leaves out what the filter leaves out, the same as the emitters do on the server.
+func (e *FileEmitterStub) EmitAll(src string, f emitter.Filter, fn emitter.EmitFunc) error {
+	for path, contents := range e.files {
+		rel, ok := strings.CutPrefix(path, src+"/")
+		if !ok {
+			continue
+		}
+		if slices.ContainsFunc(f.Exclude, func(exclude emitter.Exclude) bool { return exclude.Skips(rel, int64(len(contents))) }) {
+			f.Skipped(rel, int64(len(contents)))
+			continue
+		}
+		fn(path, strings.NewReader(contents))
+	}
+	return nil
+}
+
+func (e *FileEmitterStub) EmitSingle(src string, fn emitter.EmitFunc) error {
+	contents, ok := e.files[src]
+	if !ok {
+		return errors.New("file does not exist")
+	}
+	fn(src, strings.NewReader(contents))
+	return nil
+}
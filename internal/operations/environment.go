@@ -0,0 +1,190 @@
+package operations
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// This is the command used to list the running processes by name, which shows the web server when nothing else does.
+const PROCESSES_CMD = "ps -eo comm="
+
+// This is the command used to read the distribution of the server.
+const OS_RELEASE_CMD = "cat /etc/os-release"
+
+// This is the command used to read the crontab of the SSH user, which is where WP-Cron is often run from instead of on page loads.
+const CRONTAB_CMD = "crontab -l"
+
+var webServerSoftware = regexp.MustCompile(`(?i)\b(apache|nginx|litespeed|openlitespeed|caddy|openresty)(?:/(\d+(?:\.\d+)*))?`)
+
+// The names of the web server processes, and the web server each of them belongs to.
+var webServerProcesses = map[string]string{
+	"nginx":     "nginx",
+	"apache2":   "apache",
+	"httpd":     "apache",
+	"litespeed": "litespeed",
+	"lshttpd":   "litespeed",
+	"caddy":     "caddy",
+}
+
+// Environment is the server that the site runs on, which is written to environment.json. Differences between it and a local environment
+// are the usual reason a migrated site misbehaves.
+type Environment struct {
+	// PHP and MySQL are recorded as the metadata script reports them
+	PHP json.RawMessage `json:"php"`
+	// PHPSource is how the metadata script ran, "http" when the web server ran it, or "cli" when the PHP CLI did. The version and
+	// extensions of the PHP CLI can differ from the ones the site runs with.
+	PHPSource string          `json:"phpSource,omitempty"`
+	OS        OS              `json:"os"`
+	MySQL     json.RawMessage `json:"mysql"`
+	WebServer WebServer       `json:"webServer"`
+	// Files says whether the webroot has an .htaccess and a .user.ini
+	Files map[string]bool `json:"files"`
+	Cron  Cron            `json:"cron"`
+}
+
+// OS is the operating system and kernel, as php_uname() reports them, and the distribution from /etc/os-release.
+type OS struct {
+	Family       string `json:"family"`
+	Name         string `json:"name"`
+	Release      string `json:"release"`
+	Version      string `json:"version"`
+	Machine      string `json:"machine"`
+	Distribution string `json:"distribution,omitempty"`
+}
+
+// WebServer is the type of web server, and its version when it is known. DetectedBy says how it was found: from SERVER_SOFTWARE, from the
+// web server binaries, or from the running processes. The type is empty when it couldn't be found at all.
+type WebServer struct {
+	Type       string `json:"type"`
+	Version    string `json:"version,omitempty"`
+	DetectedBy string `json:"detectedBy,omitempty"`
+	// ServerSoftware is SERVER_SOFTWARE as the web server sent it, which some servers hide or shorten
+	ServerSoftware string `json:"serverSoftware,omitempty"`
+}
+
+// Cron is how WP-Cron runs. SystemCron are the lines of the crontab that run it, which it usually does when it is disabled.
+type Cron struct {
+	Disabled   bool     `json:"disabled"`
+	Alternate  bool     `json:"alternate"`
+	SystemCron []string `json:"systemCron"`
+}
+
+// scriptEnvironment is the environment as the metadata script reports it.
+type scriptEnvironment struct {
+	PHP            json.RawMessage `json:"php"`
+	OS             OS              `json:"os"`
+	MySQL          json.RawMessage `json:"mysql"`
+	ServerSoftware string          `json:"serverSoftware"`
+	Files          map[string]bool `json:"files"`
+}
+
+// newEnvironment completes the environment that the metadata script reported with what can only be found over SSH. The binary is the
+// web server that was found among the binaries on the server, and is empty when none was.
+func newEnvironment(raw json.RawMessage, runner sftp.RemoteCommandRunner, settings parser.WPConfigSettings, binary string) (Environment, error) {
+	var reported scriptEnvironment
+	if err := json.Unmarshal(raw, &reported); err != nil {
+		return Environment{}, err
+	}
+	var php struct {
+		Sapi string `json:"sapi"`
+	}
+	_ = json.Unmarshal(reported.PHP, &php)
+
+	env := Environment{PHP: reported.PHP, OS: reported.OS, MySQL: reported.MySQL, Files: reported.Files}
+	env.OS.Distribution = readDistribution(runner)
+	// The PHP CLI is given SERVER_SOFTWARE from the binaries, so it only comes from the web server itself over HTTP
+	if php.Sapi == "cli" {
+		env.PHPSource = "cli"
+		reported.ServerSoftware = ""
+	} else if php.Sapi != "" {
+		env.PHPSource = "http"
+	}
+	env.WebServer = detectWebServer(runner, reported.ServerSoftware, binary)
+	env.Cron = Cron{Disabled: settings.DisableWPCron, Alternate: settings.AlternateWPCron, SystemCron: readSystemCron(runner)}
+
+	return env, nil
+}
+
+// detectWebServer finds the web server from SERVER_SOFTWARE, and when that is hidden, from the binaries and then the running processes.
+func detectWebServer(runner sftp.RemoteCommandRunner, serverSoftware, binary string) WebServer {
+	if server, ok := parseWebServer(serverSoftware); ok {
+		server.DetectedBy, server.ServerSoftware = "SERVER_SOFTWARE", serverSoftware
+		return server
+	}
+	server := WebServer{ServerSoftware: serverSoftware}
+	if found, ok := parseWebServer(binary); ok {
+		server.Type, server.Version, server.DetectedBy = found.Type, found.Version, "binary"
+		return server
+	}
+
+	output, err := runner.RunRemoteCommand(PROCESSES_CMD)
+	if err != nil {
+		return server
+	}
+	b, _ := io.ReadAll(output)
+	for _, process := range strings.Fields(string(b)) {
+		if serverType, ok := webServerProcesses[process]; ok {
+			server.Type, server.DetectedBy = serverType, "process"
+			return server
+		}
+	}
+
+	return server
+}
+
+// parseWebServer reads the type and version of the web server from a string such as "Apache/2.4.58 (Ubuntu)". A hidden SERVER_SOFTWARE
+// may still name the web server without its version.
+func parseWebServer(s string) (WebServer, bool) {
+	matches := webServerSoftware.FindStringSubmatch(s)
+	if matches == nil {
+		return WebServer{}, false
+	}
+	serverType := strings.ToLower(matches[1])
+	if serverType == "openlitespeed" {
+		serverType = "litespeed"
+	}
+
+	return WebServer{Type: serverType, Version: matches[2]}, true
+}
+
+// readDistribution returns PRETTY_NAME from /etc/os-release, such as "Ubuntu 22.04.4 LTS", and is empty when it can't be read.
+func readDistribution(runner sftp.RemoteCommandRunner) string {
+	output, err := runner.RunRemoteCommand(OS_RELEASE_CMD)
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+
+	return ""
+}
+
+// readSystemCron returns the lines of the crontab that run WP-Cron, either by requesting wp-cron.php or with WP-CLI.
+func readSystemCron(runner sftp.RemoteCommandRunner) []string {
+	lines := []string{}
+	output, err := runner.RunRemoteCommand(CRONTAB_CMD)
+	if err != nil {
+		return lines
+	}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "wp-cron") || strings.Contains(line, "wp cron") {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,93 @@
+package operations
+
+import (
+	"encoding/json"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateJsonOperation_Environment(t *testing.T) {
+	response := `{"name":"Migrated Site","environment":{"php":{"version":"8.2.0","sapi":"fpm-fcgi"},"os":{"family":"Linux","name":"Linux","release":"6.1.0","version":"#1 SMP","machine":"x86_64"},"mysql":{"sqlMode":"STRICT_TRANS_TABLES"},"serverSoftware":"Apache","files":{".htaccess":true,".user.ini":false}}}`
+	operation := newOperation()
+	operation.g = &MockHttpGetter{responseStubs: map[string]GetterResponse{
+		"https://localhost/wp-zip-abc/abc.php": {resp: io.NopCloser(strings.NewReader(response))},
+	}}
+	operation.u = &MockFileUploadDeleter{commandsThatExist: map[string]string{
+		OS_RELEASE_CMD: "NAME=\"Ubuntu\"\nPRETTY_NAME=\"Ubuntu 22.04.4 LTS\"\n",
+		CRONTAB_CMD:    "# */5 * * * * curl https://localhost/wp-cron.php\n*/5 * * * * wp cron event run --due-now --path=public\n",
+	}}
+	operation.settings = parser.WPConfigSettings{DisableWPCron: true}
+
+	files := map[string]string{}
+	err := operation.SendFiles(func(file File) error {
+		files[file.Name] = readerToString(file.Body)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	if want := `{"name":"Migrated Site","wpConfig":{"MULTISITE":false,"SUBDOMAIN_INSTALL":false,"WP_DEBUG":false,"DISABLE_WP_CRON":true}}`; files["wpmigrate-export.json"] != want {
+		t.Errorf("got metadata %s; want %s", files["wpmigrate-export.json"], want)
+	}
+	want := `{"php":{"version":"8.2.0","sapi":"fpm-fcgi"},"phpSource":"http","os":{"family":"Linux","name":"Linux","release":"6.1.0","version":"#1 SMP","machine":"x86_64","distribution":"Ubuntu 22.04.4 LTS"},"mysql":{"sqlMode":"STRICT_TRANS_TABLES"},"webServer":{"type":"apache","detectedBy":"SERVER_SOFTWARE","serverSoftware":"Apache"},"files":{".htaccess":true,".user.ini":false},"cron":{"disabled":true,"alternate":false,"systemCron":["*/5 * * * * wp cron event run --due-now --path=public"]}}`
+	if files["environment.json"] != want {
+		t.Errorf("got environment %s; want %s", files["environment.json"], want)
+	}
+}
+
+func TestDetectWebServer(t *testing.T) {
+	var tests = []struct {
+		name           string
+		serverSoftware string
+		binary         string
+		processes      string
+		want           WebServer
+	}{
+		{"from SERVER_SOFTWARE", "Apache/2.4.58 (Ubuntu)", "", "", WebServer{Type: "apache", Version: "2.4.58", DetectedBy: "SERVER_SOFTWARE", ServerSoftware: "Apache/2.4.58 (Ubuntu)"}},
+		{"from a SERVER_SOFTWARE without a version", "LiteSpeed", "", "", WebServer{Type: "litespeed", DetectedBy: "SERVER_SOFTWARE", ServerSoftware: "LiteSpeed"}},
+		{"from the binary when SERVER_SOFTWARE is hidden", "", "nginx/1.24.0", "", WebServer{Type: "nginx", Version: "1.24.0", DetectedBy: "binary"}},
+		{"from the processes", "cloudflare", "", "systemd\nsshd\nlshttpd\nlsphp\n", WebServer{Type: "litespeed", DetectedBy: "process", ServerSoftware: "cloudflare"}},
+		{"not found", "", "", "systemd\nsshd\n", WebServer{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &MockFileUploadDeleter{commandsThatExist: map[string]string{PROCESSES_CMD: tt.processes}}
+
+			if got := detectWebServer(runner, tt.serverSoftware, tt.binary); got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEnvironment(t *testing.T) {
+	t.Run("it ignores SERVER_SOFTWARE from the php cli", func(t *testing.T) {
+		raw := json.RawMessage(`{"php":{"version":"8.1.2","sapi":"cli","extensions":["Core"],"ini":{}},"serverSoftware":"nginx/1.24.0"}`)
+
+		got, err := newEnvironment(raw, &MockFileUploadDeleter{}, parser.WPConfigSettings{}, "")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if got.WebServer != (WebServer{}) {
+			t.Errorf("got web server %+v; want none", got.WebServer)
+		}
+		if got.PHPSource != "cli" {
+			t.Errorf("got php source %q; want the php cli", got.PHPSource)
+		}
+		if !reflect.DeepEqual(got.Cron, Cron{SystemCron: []string{}}) {
+			t.Errorf("got cron %+v; want none", got.Cron)
+		}
+	})
+
+	t.Run("it returns an error for an environment it cannot read", func(t *testing.T) {
+		if _, err := newEnvironment(json.RawMessage(`[]`), &MockFileUploadDeleter{}, parser.WPConfigSettings{}, ""); err == nil {
+			t.Errorf("got nil error; want one")
+		}
+	})
+}
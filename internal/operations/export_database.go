@@ -1,23 +1,99 @@
 package operations
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jfortunato/wp-zip/internal/database"
 	"github.com/jfortunato/wp-zip/internal/emitter"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"log"
+	"strings"
 )
 
+var ErrIncompleteDump = errors.New("the database dump is incomplete")
+
 type ExportDatabaseOperation struct {
 	exporter database.DatabaseExporter
+	counter  database.RowCounter
+	opts     database.ExportOptions
 }
 
-func NewExportDatabaseOperation(credentials database.DatabaseCredentials, c sftp.Client, pathToPublic types.PublicPath, siteUrl types.SiteUrl, g HttpGetter, e emitter.FileEmitter) *ExportDatabaseOperation {
-	exporter := database.NewDatabaseExporter(c, pathToPublic, siteUrl, g, e, credentials)
+func NewExportDatabaseOperation(credentials database.DatabaseCredentials, c sftp.Client, pathToPublic types.PublicPath, siteUrl types.SiteUrl, g HttpGetter, e emitter.FileEmitter, opts database.ExportOptions) *ExportDatabaseOperation {
+	exporter := database.NewDatabaseExporter(c, pathToPublic, siteUrl, g, e, credentials, opts)
+
+	counter := database.NewRowCounter(c, credentials)
+	if opts.Subsite != nil {
+		counter = database.NewSubsiteRowCounter(counter, *opts.Subsite)
+	}
 
-	return &ExportDatabaseOperation{exporter}
+	return &ExportDatabaseOperation{exporter, counter, opts}
 }
 
+// SendFiles sends the dump, followed by a report on whether it is complete. Every dump file is scanned while it is being sent, so verifying
+// it doesn't need to hold on to it.
 func (o *ExportDatabaseOperation) SendFiles(fn SendFilesFunc) error {
+	send := func(file File) error {
+		file, ok, err := o.extract(file)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// The file must still be read to the end, so that it is verified along with the rest of the dump
+			_, err = io.Copy(io.Discard, file.Body)
+			return err
+		}
+		return fn(o.encode(file))
+	}
+
+	if o.opts.Verify == database.VerifyOff {
+		return o.sendDump(send)
+	}
+
+	verifier := database.NewDumpVerifier(o.counter)
+	err := o.sendDump(func(file File) error {
+		file.Body = verifier.Scan(file.Name, file.Body)
+		err := send(file)
+		// A dump that wasn't read to the end, such as when it couldn't be written, stops being scanned
+		if c, ok := file.Body.(io.Closer); ok {
+			c.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.sendReport(fn, verifier.Report())
+}
+
+func (o *ExportDatabaseOperation) Describe() string {
+	name := "database.sql"
+	if _, ok := o.exporter.(database.TableExporter); ok && o.opts.PerTableFiles {
+		name = "database/<table>.sql"
+	}
+	description := fmt.Sprintf("export the database into %s%s with %s", name, o.opts.Compression.Extension(), strings.TrimPrefix(fmt.Sprintf("%T", o.exporter), "*database."))
+	if o.opts.Verify != database.VerifyOff {
+		description += ", and report on whether it is complete in database-report.json"
+	}
+
+	return description
+}
+
+func (o *ExportDatabaseOperation) sendDump(fn SendFilesFunc) error {
+	// Each table goes into its own file when asked for, as long as the exporter is able to split them up
+	if tableExporter, ok := o.exporter.(database.TableExporter); ok && o.opts.PerTableFiles {
+		return tableExporter.ExportTables(func(table string, r io.Reader) error {
+			return fn(File{
+				Name: "database/" + table + ".sql",
+				Body: r,
+			})
+		})
+	}
+
 	r, err := o.exporter.Export()
 	if err != nil {
 		return err
@@ -28,3 +104,75 @@ func (o *ExportDatabaseOperation) SendFiles(fn SendFilesFunc) error {
 		Body: r,
 	})
 }
+
+// extract turns the dump of a multisite network into the dump of the subsite being exported, if there is one. It reports false for the
+// file of a table that doesn't belong to the subsite. A dump compressed on the remote server is decompressed first, and compressed again
+// by encode.
+func (o *ExportDatabaseOperation) extract(file File) (File, bool, error) {
+	if o.opts.Subsite == nil {
+		return file, true, nil
+	}
+	filter := database.NewSubsiteFilter(*o.opts.Subsite)
+
+	if table, ok := strings.CutPrefix(strings.TrimSuffix(file.Name, ".sql"), "database/"); ok {
+		renamed, ok := filter.Table(table)
+		if !ok {
+			return file, false, nil
+		}
+		file.Name = "database/" + renamed + ".sql"
+	}
+
+	if encoded, ok := file.Body.(*database.EncodedReader); ok {
+		decoded, err := encoded.Encoding.Decompress(encoded.Reader)
+		if err != nil {
+			return file, false, err
+		}
+		file.Body = decoded
+	}
+	file.Body = filter.Filter(file.Body)
+
+	return file, true, nil
+}
+
+// encode compresses the dump file when asked for. The exporter may already have compressed it on the remote server, otherwise it is
+// compressed here.
+func (o *ExportDatabaseOperation) encode(file File) File {
+	compression := o.opts.Compression
+	if encoded, ok := file.Body.(*database.EncodedReader); ok {
+		compression = encoded.Encoding
+	} else {
+		file.Body = compression.Compress(file.Body)
+	}
+	file.Name += compression.Extension()
+
+	return file
+}
+
+// sendReport writes the report into the archive. Any problems are logged, and fail the export in strict mode.
+func (o *ExportDatabaseOperation) sendReport(fn SendFilesFunc, report database.DumpReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = fn(File{
+		Name: "database-report.json",
+		Body: bytes.NewReader(b),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range report.Warnings {
+		log.Printf("could not fully verify the database dump: %s", warning)
+	}
+	for _, problem := range report.Problems {
+		log.Printf("the database dump may be incomplete: %s", problem)
+	}
+
+	if !report.Ok() && o.opts.Verify == database.VerifyStrict {
+		return fmt.Errorf("%w: %s", ErrIncompleteDump, strings.Join(report.Problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package operations
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExportDatabaseOperation(t *testing.T) {
+	complete := "INSERT INTO `wp_options` VALUES (1),(2);\n-- Dump completed on 2024-03-01 12:00:00\n"
+	counts := map[string]database.TableRowCount{"wp_options": {Rows: 2, Exact: true}}
+
+	t.Run("it sends the dump followed by the report", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{complete}, &RowCounterStub{counts}, database.ExportOptions{}}
+
+		files, err := sendAll(o)
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if len(files) != 2 || files["database.sql"] != complete || !strings.Contains(files["database-report.json"], `"complete": true`) {
+			t.Errorf("got files %v; want the dump and a complete report", files)
+		}
+	})
+
+	t.Run("it only warns about an incomplete dump by default", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{"INSERT INTO `wp_options` VALUES (1),(2);\n"}, &RowCounterStub{counts}, database.ExportOptions{}}
+
+		files, err := sendAll(o)
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if !strings.Contains(files["database-report.json"], `"complete": false`) {
+			t.Errorf("got report %s; want it to be incomplete", files["database-report.json"])
+		}
+	})
+
+	t.Run("it fails on an incomplete dump in strict mode", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{"INSERT INTO `wp_options` VALUES (1),(2);\n"}, &RowCounterStub{counts}, database.ExportOptions{Verify: database.VerifyStrict}}
+
+		_, err := sendAll(o)
+
+		assertError(t, err, ErrIncompleteDump)
+	})
+
+	t.Run("it sends only the dump when verification is off", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{"truncated"}, &RowCounterStub{counts}, database.ExportOptions{Verify: database.VerifyOff}}
+
+		files, err := sendAll(o)
+
+		if err != nil || len(files) != 1 {
+			t.Errorf("got files %v and error %v; want only the dump", files, err)
+		}
+	})
+}
+
+func TestExportDatabaseOperation_Subsite(t *testing.T) {
+	dump := "INSERT INTO `wp_options` VALUES (1);\nINSERT INTO `wp_2_options` VALUES (2);\n-- Dump completed on 2024-03-01 12:00:00\n"
+	counts := map[string]database.TableRowCount{}
+	network := types.Network{Domain: "example.com", Path: "/", MainSiteID: 1}
+	subsite := &types.Extraction{Network: network, Subsite: types.Subsite{ID: 2, Domain: "blog.example.com", Path: "/"}, Prefix: "wp_"}
+
+	t.Run("it only sends the tables of the subsite", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{dump}, &RowCounterStub{counts}, database.ExportOptions{Subsite: subsite}}
+
+		files, err := sendAll(o)
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if want := "INSERT INTO `wp_options` VALUES (2);\n-- Dump completed on 2024-03-01 12:00:00\n"; files["database.sql"] != want {
+			t.Errorf("got dump %q; want %q", files["database.sql"], want)
+		}
+		// The dump of the whole network is what is verified
+		if !strings.Contains(files["database-report.json"], `"complete": true`) {
+			t.Errorf("got report %s; want it to be complete", files["database-report.json"])
+		}
+	})
+
+	t.Run("it leaves out the files of the tables of other sites", func(t *testing.T) {
+		exporter := &TableExporterStub{map[string]string{
+			"wp_options":   "INSERT INTO `wp_options` VALUES (1);\n-- Dump completed on 2024-03-01 12:00:00\n",
+			"wp_2_options": "INSERT INTO `wp_2_options` VALUES (2);\n-- Dump completed on 2024-03-01 12:00:00\n",
+		}}
+		o := &ExportDatabaseOperation{exporter, &RowCounterStub{counts}, database.ExportOptions{PerTableFiles: true, Subsite: subsite}}
+
+		files, err := sendAll(o)
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if len(files) != 2 || files["database/wp_options.sql"] != "INSERT INTO `wp_options` VALUES (2);\n-- Dump completed on 2024-03-01 12:00:00\n" {
+			t.Errorf("got files %v; want only the renamed table of the subsite and the report", files)
+		}
+		if !strings.Contains(files["database-report.json"], `"complete": true`) {
+			t.Errorf("got report %s; want it to be complete", files["database-report.json"])
+		}
+	})
+}
+
+type TableExporterStub struct {
+	tables map[string]string
+}
+
+func (e *TableExporterStub) Export() (io.Reader, error) {
+	return nil, errors.New("not a single dump")
+}
+
+func (e *TableExporterStub) ExportTables(fn func(table string, r io.Reader) error) error {
+	for _, table := range []string{"wp_2_options", "wp_options"} {
+		if err := fn(table, strings.NewReader(e.tables[table])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendAll reads each file as it is sent, the same way the runner does.
+func sendAll(o Operation) (map[string]string, error) {
+	files := map[string]string{}
+	err := o.SendFiles(func(file File) error {
+		files[file.Name] = readerToString(file.Body)
+		return nil
+	})
+	return files, err
+}
+
+type ExporterStub struct {
+	dump string
+}
+
+func (e *ExporterStub) Export() (io.Reader, error) {
+	return strings.NewReader(e.dump), nil
+}
+
+type RowCounterStub struct {
+	counts map[string]database.TableRowCount
+}
+
+func (c *RowCounterStub) CountRows() (map[string]database.TableRowCount, error) {
+	return c.counts, nil
+}
+
+func TestExportDatabaseOperation_Compression(t *testing.T) {
+	dump := "INSERT INTO `wp_options` VALUES (1);\n-- Dump completed on 2024-03-01 12:00:00\n"
+	counts := map[string]database.TableRowCount{}
+
+	t.Run("it compresses the dump locally", func(t *testing.T) {
+		o := &ExportDatabaseOperation{&ExporterStub{dump}, &RowCounterStub{counts}, database.ExportOptions{Compression: database.CompressionGzip}}
+
+		files, _ := sendAll(o)
+
+		d, err := database.CompressionGzip.Decompress(strings.NewReader(files["database.sql.gz"]))
+		if err != nil {
+			t.Fatalf("got error %v; want a gzip file", err)
+		}
+		if readerToString(d) != dump {
+			t.Errorf("got a different dump; want %s", dump)
+		}
+		if !strings.Contains(files["database-report.json"], `"complete": true`) {
+			t.Errorf("got report %s; want it to be complete", files["database-report.json"])
+		}
+	})
+
+	t.Run("it keeps a dump that was compressed on the server as is", func(t *testing.T) {
+		compressed := readerToString(database.CompressionZstd.Compress(strings.NewReader(dump)))
+		exporter := &EncodedExporterStub{&database.EncodedReader{Reader: strings.NewReader(compressed), Encoding: database.CompressionZstd}}
+		o := &ExportDatabaseOperation{exporter, &RowCounterStub{counts}, database.ExportOptions{Compression: database.CompressionZstd}}
+
+		files, _ := sendAll(o)
+
+		if files["database.sql.zst"] != compressed {
+			t.Errorf("got files %v; want the compressed dump as is", files)
+		}
+		if !strings.Contains(files["database-report.json"], `"complete": true`) {
+			t.Errorf("got report %s; want it to be complete", files["database-report.json"])
+		}
+	})
+}
+
+type EncodedExporterStub struct {
+	r *database.EncodedReader
+}
+
+func (e *EncodedExporterStub) Export() (io.Reader, error) {
+	return e.r, nil
+}
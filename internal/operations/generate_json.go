@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/inventory"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
 	"github.com/pkg/errors"
 	"io"
-	"math/rand"
 	"net/http"
+	"regexp"
 	"strings"
-	"time"
 )
 
 var (
@@ -22,48 +25,68 @@ var (
 )
 
 type HttpGetter interface {
-	Get(url string) (resp io.ReadCloser, err error)
+	Get(url string, header http.Header) (resp io.ReadCloser, err error)
+}
+
+// InventoryTaker takes the inventory of the plugins and themes, once the files have been downloaded.
+type InventoryTaker interface {
+	Inventory() inventory.Inventory
+}
+
+// WordPressVersioner reads the installed version of WordPress, such as through WP-CLI.
+type WordPressVersioner interface {
+	CoreVersion(publicPath types.PublicPath) (string, error)
 }
 
 type GenerateJsonOperation struct {
-	u               sftp.FileUploadDeleter
-	g               HttpGetter
-	siteUrl         types.SiteUrl
-	publicPath      types.PublicPath
-	credentials     database.DatabaseCredentials
-	randomFileNamer func() string
+	u          sftp.CommandRunnerUploader
+	g          HttpGetter
+	siteUrl    types.SiteUrl
+	publicPath types.PublicPath
+	// core is the directory of WordPress itself, which is usually the public path
+	core        types.PublicPath
+	credentials database.DatabaseCredentials
+	// settings are the settings in wp-config.php, which are recorded as they are
+	settings parser.WPConfigSettings
+	// network describes the subsites of a multisite network, and is nil for any other site
+	network *types.Network
+	// databaseEncoding is recorded in the JSON, so that importers know how to read a compressed dump
+	databaseEncoding database.Compression
+	// versioner is preferred over reading wp-includes/version.php in the script, and may be nil
+	versioner WordPressVersioner
+	// inventory lists the plugins and themes, and may be nil
+	inventory InventoryTaker
+	random    func() string
 }
 
-func NewGenerateJsonOperation(u sftp.FileUploadDeleter, g HttpGetter, siteUrl types.SiteUrl, publicPath types.PublicPath, credentials database.DatabaseCredentials) *GenerateJsonOperation {
-	return &GenerateJsonOperation{u, g, siteUrl, publicPath, credentials, func() string { return "wp-zip-" + randSeq(10) + ".php" }}
+func NewGenerateJsonOperation(u sftp.CommandRunnerUploader, g HttpGetter, siteUrl types.SiteUrl, publicPath, core types.PublicPath, credentials database.DatabaseCredentials, settings parser.WPConfigSettings, network *types.Network, databaseEncoding database.Compression, versioner WordPressVersioner, inventory InventoryTaker) *GenerateJsonOperation {
+	return &GenerateJsonOperation{u, g, siteUrl, publicPath, core, credentials, settings, network, databaseEncoding, versioner, inventory, phpscript.RandomName}
 }
 
 func (o *GenerateJsonOperation) SendFiles(fn SendFilesFunc) (err error) {
 	// We need to:
-	// 1. Upload our custom PHP file to the server
-	// 2. Make an HTTP request to the file, which generates the JSON content we need
+	// 1. Upload our custom PHP file to the server, into its own protected directory
+	// 2. Run the file with the PHP CLI, or make an HTTP request to it, which generates the JSON content we need
 	// 3. Send the JSON content back to the caller with the SendFilesFunc
-	// 4. Delete the file we uploaded from the server
+	// 4. Delete the file and directory we uploaded from the server
 
 	// 1.
-	// Generate a random filename
-	basename := o.randomFileNamer()
-	uploadFilename := string(o.publicPath) + "/" + basename
-	err = o.u.Upload(strings.NewReader(getPhpFileContents(o.credentials, o.siteUrl, o.publicPath)), uploadFilename)
+	deployment, err := phpscript.NewDeployer(o.u, o.publicPath, o.random).Deploy(getPhpFileContents(o.credentials, o.siteUrl, o.publicPath, o.core), nil)
 	if err != nil {
 		return fmt.Errorf("%w: %s", ErrCouldNotUploadFile, err)
 	}
 	// 4.
 	// Use defer to ensure that the file is deleted even if there is an error
 	defer func() {
-		errD := o.u.Delete(uploadFilename)
+		errD := deployment.Remove()
 		if errD != nil {
 			err = fmt.Errorf("%w: %s", ErrCouldNotDeleteFile, errD)
 		}
 	}()
 
 	// 2.
-	resp, err := o.g.Get(string(o.siteUrl) + "/" + basename)
+	serverSoftware := detectServerSoftware(o.u)
+	resp, err := deployment.Execute(o.u, o.g, o.siteUrl, map[string]string{"SERVER_SOFTWARE": serverSoftware})
 	if err != nil {
 		return fmt.Errorf("%w: %s", ErrInvalidResponse, err)
 	}
@@ -76,12 +99,104 @@ func (o *GenerateJsonOperation) SendFiles(fn SendFilesFunc) (err error) {
 	if !assertResponseContainsJsonKey(contents, "name") {
 		return ErrUnexpectedResponse
 	}
+	// The environment is written to a file of its own
+	contents, rawEnvironment, err := cutJsonKey(contents, "environment")
+	if err != nil {
+		return err
+	}
+	if o.versioner != nil {
+		version, err := o.versioner.CoreVersion(o.wordPressPath())
+		if err != nil {
+			verbose.Printf("could not read the WordPress version with %T, using wp-includes/version.php: %s", o.versioner, err)
+		} else {
+			verbose.Printf("read the WordPress version with %T", o.versioner)
+			contents, err = addJsonKey(contents, "wpVersion", version)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if o.settings != (parser.WPConfigSettings{}) {
+		contents, err = addJsonKey(contents, "wpConfig", o.settings)
+		if err != nil {
+			return err
+		}
+	}
+	if o.network != nil {
+		contents, err = addNetworkKeys(contents, o.siteUrl, *o.network)
+		if err != nil {
+			return err
+		}
+	}
+	if o.inventory != nil {
+		inv := o.inventory.Inventory()
+		contents, err = addJsonKey(contents, "plugins", inv.Plugins)
+		if err != nil {
+			return err
+		}
+		contents, err = addJsonKey(contents, "themes", inv.Themes)
+		if err != nil {
+			return err
+		}
+	}
+	if o.databaseEncoding.Enabled() {
+		contents, err = addJsonKey(contents, "databaseEncoding", o.databaseEncoding)
+		if err != nil {
+			return err
+		}
+	}
 
 	// 3.
-	return fn(File{
-		Name: "wpmigrate-export.json",
-		Body: strings.NewReader(contents),
-	})
+	if err := fn(File{Name: "wpmigrate-export.json", Body: strings.NewReader(contents)}); err != nil {
+		return err
+	}
+	if rawEnvironment == nil {
+		return nil
+	}
+	env, err := newEnvironment(rawEnvironment, o.u, o.settings, serverSoftware)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnexpectedResponse, err)
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return fn(File{Name: "environment.json", Body: strings.NewReader(string(b))})
+}
+
+func (o *GenerateJsonOperation) Describe() string {
+	return fmt.Sprintf("run a PHP script in %s to generate wpmigrate-export.json and environment.json", o.publicPath)
+}
+
+// The commands that print the version of the web server, tried in order. They are often only in /usr/sbin, which isn't always on the path.
+const SERVER_VERSION_CMD = "{ nginx -v || /usr/sbin/nginx -v || apache2 -v || /usr/sbin/apache2 -v || httpd -v || /usr/sbin/httpd -v; } 2>&1"
+
+var serverVersion = regexp.MustCompile(`(?i)\b(apache|nginx)/(\d+\.\d+\.\d+)`)
+
+// detectServerSoftware returns the web server the way it would be in SERVER_SOFTWARE, such as "nginx/1.24.0". The PHP CLI isn't run by the
+// web server, so the metadata script needs it passed in. It is empty if the web server can't be found.
+func detectServerSoftware(runner sftp.RemoteCommandRunner) string {
+	output, err := runner.RunRemoteCommand(SERVER_VERSION_CMD)
+	if err != nil {
+		return ""
+	}
+	// The command fails when none of the servers are found, but any of them may still have printed its version before that
+	b, _ := io.ReadAll(output)
+	matches := serverVersion.FindStringSubmatch(string(b))
+	if matches == nil {
+		return ""
+	}
+
+	return strings.ToLower(matches[1]) + "/" + matches[2]
+}
+
+// wordPressPath returns the directory of WordPress itself, which is the public path unless it is known to be elsewhere.
+func (o *GenerateJsonOperation) wordPressPath() types.PublicPath {
+	if o.core == "" {
+		return o.publicPath
+	}
+	return o.core
 }
 
 func assertResponseContainsJsonKey(response, key string) bool {
@@ -98,73 +213,145 @@ func assertResponseContainsJsonKey(response, key string) bool {
 	return true
 }
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+// addNetworkKeys describes a multisite network. The domain of the main site is the one to replace when the site is imported, and every other
+// domain of the network is listed in multiSiteDomains, which LocalWP replaces as well. A subdirectory network that has no mapped domains
+// doesn't have any.
+func addNetworkKeys(contents string, siteUrl types.SiteUrl, network types.Network) (string, error) {
+	type subsite struct {
+		types.Subsite
+		Url     string `json:"url"`
+		Uploads string `json:"uploads"`
+	}
+	subsites := make([]subsite, 0, len(network.Subsites))
+	for _, s := range network.Subsites {
+		subsites = append(subsites, subsite{s, s.Url(siteUrl), "wp-content/" + network.Uploads(s)})
+	}
+
+	var err error
+	for _, key := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"domain", network.Domain},
+		{"multiSite", network.Kind()},
+		{"multiSiteDomains", network.Domains()},
+		{"network", map[string]interface{}{"domain": network.Domain, "path": network.Path, "mainSiteId": network.MainSiteID, "subsites": subsites}},
+	} {
+		contents, err = addJsonKey(contents, key.name, key.value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return contents, nil
+}
+
+// cutJsonKey removes a top level key from the JSON object, and returns its value, which is nil when the key isn't there.
+func cutJsonKey(contents, key string) (string, json.RawMessage, error) {
+	var jsonResp map[string]json.RawMessage
+	err := json.Unmarshal([]byte(contents), &jsonResp)
+	if err != nil {
+		return "", nil, err
+	}
+	value, ok := jsonResp[key]
+	if !ok {
+		return contents, nil, nil
+	}
+	delete(jsonResp, key)
+
+	b, err := json.Marshal(jsonResp)
+	return string(b), value, err
+}
 
-func randSeq(n int) string {
-	rand.Seed(time.Now().UnixNano())
-	//r := rand.New(rand.NewSource(time.Now().UnixNano())
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// addJsonKey adds a top level key to the JSON object.
+func addJsonKey(contents, key string, value interface{}) (string, error) {
+	var jsonResp map[string]interface{}
+	err := json.Unmarshal([]byte(contents), &jsonResp)
+	if err != nil {
+		return "", err
 	}
-	return string(b)
+	jsonResp[key] = value
+
+	b, err := json.Marshal(jsonResp)
+	return string(b), err
 }
 
-func getPhpFileContents(credentials database.DatabaseCredentials, siteUrl types.SiteUrl, publicPath types.PublicPath) string {
+func getPhpFileContents(credentials database.DatabaseCredentials, siteUrl types.SiteUrl, publicPath, core types.PublicPath) string {
+	// WordPress may be in a directory of its own beneath the webroot
+	coreDir, ok := publicPath.Rel(core)
+	if !ok || coreDir == "" {
+		coreDir = "."
+	}
+
 	return fmt.Sprintf(`<?php
 
-// Connect to mysql and get the mysql version
-$link = mysqli_connect('localhost', '%s', '%s', '%s');
+// Connect to mysql and get the mysql version, along with the variables that change how it stores and compares data
+$link = %s;
 $mysqlVersion = mysqli_get_server_info($link);
+$mysqlVariables = [];
+$result = mysqli_query($link, "SHOW VARIABLES WHERE Variable_name = 'sql_mode' OR Variable_name LIKE 'character_set_%%' OR Variable_name LIKE 'collation_%%'");
+if ($result) {
+    while ($row = mysqli_fetch_row($result)) {
+        $mysqlVariables[$row[0]] = $row[1];
+    }
+}
 mysqli_close($link);
+$sqlMode = isset($mysqlVariables['sql_mode']) ? $mysqlVariables['sql_mode'] : '';
+unset($mysqlVariables['sql_mode']);
 
 // Get the server name and version
-preg_match('/^(apache|nginx)\/(\d+\.\d+\.\d+).*/', strtolower($_SERVER['SERVER_SOFTWARE']), $matches);
+$serverSoftware = isset($_SERVER['SERVER_SOFTWARE']) ? $_SERVER['SERVER_SOFTWARE'] : '';
+preg_match('/^(apache|nginx)\/(\d+\.\d+\.\d+).*/', strtolower($serverSoftware), $matches);
 $serverJson = isset($matches[1], $matches[2]) ? [ $matches[1] => [ 'name' => $matches[1], 'version' => $matches[2] ] ] : '';
 
-// Get the current WordPress version by reading the wp-includes/version.php file
-$wpVersionFile = file_get_contents(__DIR__ . DIRECTORY_SEPARATOR . 'wp-includes' . DIRECTORY_SEPARATOR .  'version.php');
+// Get the current WordPress version by reading the wp-includes/version.php file. This script lives in its own directory
+// directly beneath the webroot, so the webroot is our parent directory.
+$wpVersionFile = file_get_contents(dirname(__DIR__) . DIRECTORY_SEPARATOR . %s . DIRECTORY_SEPARATOR . 'wp-includes' . DIRECTORY_SEPARATOR .  'version.php');
 preg_match('/\$wp_version = \'(.*)\';/', $wpVersionFile, $matches);
 $wpVersion = isset($matches[1]) ? $matches[1] : '';
 
+// Describe the environment, which hosts often lock down, so each function is checked for first. The PHP CLI reads a php.ini of its own,
+// and never times out, so its limits say nothing about the ones the site runs with and are left out.
+$extensions = get_loaded_extensions();
+sort($extensions);
+$ini = [];
+if (PHP_SAPI !== 'cli') {
+    foreach (['memory_limit', 'upload_max_filesize', 'post_max_size', 'max_execution_time', 'max_input_time', 'max_input_vars'] as $name) {
+        $ini[$name] = function_exists('ini_get') ? ini_get($name) : false;
+    }
+}
+$uname = function ($mode) {
+    return function_exists('php_uname') ? php_uname($mode) : '';
+};
+$environment = [
+    'php' => ['version' => PHP_VERSION, 'sapi' => PHP_SAPI, 'extensions' => $extensions, 'ini' => (object) $ini],
+    'os' => ['family' => PHP_OS, 'name' => $uname('s'), 'release' => $uname('r'), 'version' => $uname('v'), 'machine' => $uname('m')],
+    'mysql' => ['version' => $mysqlVersion, 'sqlMode' => $sqlMode, 'variables' => (object) $mysqlVariables],
+    'serverSoftware' => $serverSoftware,
+    'files' => [
+        '.htaccess' => file_exists(dirname(__DIR__) . DIRECTORY_SEPARATOR . '.htaccess'),
+        '.user.ini' => file_exists(dirname(__DIR__) . DIRECTORY_SEPARATOR . '.user.ini'),
+    ],
+];
+
 header('Content-Type: application/json');
 echo json_encode(array_merge_recursive([
-    'name' => '%s',
-    'domain' => '%s',
-    'path' => '%s',
+    'name' => %s,
+    'domain' => %s,
+    'path' => %s,
     'wpVersion' => $wpVersion,
     'services' => [
         'php' => [
             'name' => 'php',
             'version' => PHP_VERSION,
+            'sapi' => PHP_SAPI,
         ],
         'mysql' => [
             'name' => 'mysql',
             'version' => $mysqlVersion,
         ],
     ],
+    'environment' => $environment,
 ], ['services' => $serverJson]));
-`, credentials.User, credentials.Pass, credentials.Name, siteUrl.Domain(), siteUrl.Domain(), publicPath)
-}
-
-type BasicHttpGetter struct{}
-
-func (g *BasicHttpGetter) Get(url string) (resp io.ReadCloser, err error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "error making http request")
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "error reading response body")
-	}
-
-	return io.NopCloser(strings.NewReader(string(body))), nil
+`, database.PhpMysqliConnect(credentials), phpscript.Quote(coreDir), phpscript.Quote(siteUrl.Domain()), phpscript.Quote(siteUrl.Domain()), phpscript.Quote(string(publicPath)))
 }
@@ -2,7 +2,13 @@ package operations
 
 import (
 	"errors"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/inventory"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/types"
 	"io"
+	"net/http"
+	"os"
 	"strings"
 	"testing"
 )
@@ -16,6 +22,88 @@ func TestGenerateJsonOperation(t *testing.T) {
 		})
 	})
 
+	t.Run("it records the encoding of a compressed database dump", func(t *testing.T) {
+		operation := newOperation()
+		operation.databaseEncoding = database.CompressionGzip
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"databaseEncoding":"gzip","name":"Migrated Site"}`,
+		})
+	})
+
+	t.Run("it records the settings from wp-config.php", func(t *testing.T) {
+		operation := newOperation()
+		operation.settings = parser.WPConfigSettings{Home: "https://example.com", Multisite: true, Charset: "utf8mb4"}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Migrated Site","wpConfig":{"WP_HOME":"https://example.com","MULTISITE":true,"SUBDOMAIN_INSTALL":false,"DB_CHARSET":"utf8mb4","WP_DEBUG":false}}`,
+		})
+	})
+
+	t.Run("it describes a multisite network", func(t *testing.T) {
+		operation := newOperation()
+		operation.network = &types.Network{Subdomain: true, Domain: "localhost", Path: "/", MainSiteID: 1, Subsites: []types.Subsite{
+			{ID: 1, Domain: "localhost", Path: "/"},
+			{ID: 2, Domain: "blog.localhost", Path: "/"},
+		}}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"domain":"localhost","multiSite":"ms-subdomain","multiSiteDomains":["blog.localhost"],"name":"Migrated Site","network":{"domain":"localhost","mainSiteId":1,"path":"/","subsites":[{"id":1,"domain":"localhost","path":"/","url":"https://localhost","uploads":"wp-content/uploads"},{"id":2,"domain":"blog.localhost","path":"/","url":"https://blog.localhost","uploads":"wp-content/uploads/sites/2"}]}}`,
+		})
+	})
+
+	t.Run("it prefers the version from the versioner", func(t *testing.T) {
+		operation := newOperation()
+		operation.versioner = &VersionerStub{version: "6.4.2"}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Migrated Site","wpVersion":"6.4.2"}`,
+		})
+	})
+
+	t.Run("it keeps the version from the script if the versioner fails", func(t *testing.T) {
+		operation := newOperation()
+		operation.versioner = &VersionerStub{err: errors.New("error")}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Migrated Site"}`,
+		})
+	})
+
+	t.Run("it lists the plugins and themes", func(t *testing.T) {
+		operation := newOperation()
+		operation.inventory = &InventoryStub{inventory.Inventory{
+			Plugins: []inventory.Plugin{{File: "hello.php", Name: "Hello Dolly", Version: "1.7.2", Active: true}},
+			Themes:  []inventory.Theme{},
+		}}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Migrated Site","plugins":[{"active":true,"dropIn":false,"file":"hello.php","mustUse":false,"name":"Hello Dolly","networkActive":false,"version":"1.7.2"}],"themes":[]}`,
+		})
+	})
+
+	t.Run("it runs the php file with the php cli when it can", func(t *testing.T) {
+		operation := newOperation()
+		operation.u = &MockFileUploadDeleter{commandsThatExist: map[string]string{
+			"php -v":           "PHP 8.2.0",
+			SERVER_VERSION_CMD: "nginx version: nginx/1.24.0\n",
+			"SERVER_SOFTWARE='nginx/1.24.0' php -d display_errors=stderr 'public/wp-zip-abc/abc.php'": `{"name":"Site From Cli"}`,
+		}}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Site From Cli"}`,
+		})
+	})
+
+	t.Run("it falls back to http if the php cli fails", func(t *testing.T) {
+		operation := newOperation()
+		operation.u = &MockFileUploadDeleter{commandsThatExist: map[string]string{"php -v": "PHP 8.2.0"}}
+
+		expectFilesSentFromOperation(t, operation, map[string]string{
+			"wpmigrate-export.json": `{"name":"Migrated Site"}`,
+		})
+	})
+
 	t.Run("it returns an error if we cannot upload the php file", func(t *testing.T) {
 		operation := newOperation()
 		operation.u = &MockFileUploadDeleter{uploadErrorStub: errors.New("error upload")}
@@ -30,7 +118,7 @@ func TestGenerateJsonOperation(t *testing.T) {
 		operation := newOperation()
 		operation.g = &MockHttpGetter{
 			responseStubs: map[string]GetterResponse{
-				"https://localhost/wp-zip.php": {
+				"https://localhost/wp-zip-abc/abc.php": {
 					resp: nil,
 					err:  errors.New("error response"),
 				},
@@ -49,7 +137,7 @@ func TestGenerateJsonOperation(t *testing.T) {
 		// No error, but the response is not what we expect
 		operation.g = &MockHttpGetter{
 			responseStubs: map[string]GetterResponse{
-				"https://localhost/wp-zip.php": {
+				"https://localhost/wp-zip-abc/abc.php": {
 					resp: io.NopCloser(strings.NewReader("invalid response")),
 					err:  nil,
 				},
@@ -75,6 +163,28 @@ func TestGenerateJsonOperation(t *testing.T) {
 	})
 }
 
+func TestDetectServerSoftware(t *testing.T) {
+	var tests = []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"nginx", "nginx version: nginx/1.24.0\n", "nginx/1.24.0"},
+		{"apache", "sh: 1: nginx: not found\nServer version: Apache/2.4.41 (Ubuntu)\nServer built:   2023-03-01T00:00:00\n", "apache/2.4.41"},
+		{"none", "sh: 1: httpd: not found\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &MockFileUploadDeleter{commandsThatExist: map[string]string{SERVER_VERSION_CMD: tt.output}}
+
+			if got := detectServerSoftware(runner); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // By default, we will create a completely valid operation. The client code can then override
 // the default behaviour by setting the fields on the operation.
 func newOperation() *GenerateJsonOperation {
@@ -82,7 +192,7 @@ func newOperation() *GenerateJsonOperation {
 		u: &MockFileUploadDeleter{},
 		g: &MockHttpGetter{
 			responseStubs: map[string]GetterResponse{
-				"https://localhost/wp-zip.php": {
+				"https://localhost/wp-zip-abc/abc.php": {
 					resp: io.NopCloser(strings.NewReader(`{"name":"Migrated Site"}`)),
 					err:  nil,
 				},
@@ -90,15 +200,47 @@ func newOperation() *GenerateJsonOperation {
 		},
 		siteUrl:    "https://localhost",
 		publicPath: "public",
-		randomFileNamer: func() string {
-			return "wp-zip.php"
+		random: func() string {
+			return "abc"
 		},
 	}
 }
 
+type InventoryStub struct {
+	inventory inventory.Inventory
+}
+
+func (i *InventoryStub) Inventory() inventory.Inventory {
+	return i.inventory
+}
+
+type VersionerStub struct {
+	version string
+	err     error
+}
+
+func (v *VersionerStub) CoreVersion(publicPath types.PublicPath) (string, error) {
+	return v.version, v.err
+}
+
 type MockFileUploadDeleter struct {
 	uploadErrorStub error
 	deleteErrorStub error
+	// commandsThatExist are the commands that can be run, along with their output
+	commandsThatExist map[string]string
+}
+
+func (m *MockFileUploadDeleter) CanRunRemoteCommand(command string) bool {
+	_, ok := m.commandsThatExist[command]
+	return ok
+}
+
+func (m *MockFileUploadDeleter) RunRemoteCommand(command string) (io.Reader, error) {
+	output, ok := m.commandsThatExist[command]
+	if !ok {
+		return nil, errors.New("command not found")
+	}
+	return strings.NewReader(output), nil
 }
 
 func (m *MockFileUploadDeleter) Upload(r io.Reader, dst string) error {
@@ -113,6 +255,11 @@ func (m *MockFileUploadDeleter) Mkdir(dst string) error {
 	return nil
 }
 
+func (m *MockFileUploadDeleter) Chmod(dst string, mode os.FileMode) error {
+	return nil
+}
+func (m *MockFileUploadDeleter) Getwd() (string, error) { return "/home/user", nil }
+
 type GetterResponse struct {
 	resp io.ReadCloser
 	err  error
@@ -122,7 +269,7 @@ type MockHttpGetter struct {
 	responseStubs map[string]GetterResponse
 }
 
-func (m *MockHttpGetter) Get(url string) (resp io.ReadCloser, err error) {
+func (m *MockHttpGetter) Get(url string, header http.Header) (resp io.ReadCloser, err error) {
 	response, ok := m.responseStubs[url]
 	if !ok {
 		return nil, errors.New("no response stub for url: " + url)
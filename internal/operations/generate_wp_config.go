@@ -0,0 +1,128 @@
+package operations
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/phpscript"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"strings"
+)
+
+// GenerateWPConfigOperation generates the wp-config.php of a site that isn't laid out the standard way. Its own wp-config.php points at
+// the directories it had on the server, so once the files are in the standard layout it is replaced by a standard one with the same
+// database settings.
+type GenerateWPConfigOperation struct {
+	credentials database.DatabaseCredentials
+	prefix      string
+	layout      types.SiteLayout
+	// settings are the other settings of its wp-config.php, such as DB_COLLATE and WP_HOME
+	settings parser.WPConfigSettings
+	// siteUrl is the home of the site when wp-config.php doesn't define WP_HOME
+	siteUrl types.SiteUrl
+	// network is nil unless the site is a multisite network, which can only be loaded with the same multisite settings
+	network *types.Network
+}
+
+func NewGenerateWPConfigOperation(credentials database.DatabaseCredentials, prefix string, layout types.SiteLayout, settings parser.WPConfigSettings, siteUrl types.SiteUrl, network *types.Network) *GenerateWPConfigOperation {
+	return &GenerateWPConfigOperation{credentials, prefix, layout, settings, siteUrl, network}
+}
+
+func (o *GenerateWPConfigOperation) SendFiles(fn SendFilesFunc) error {
+	return fn(File{
+		Name: "files/wp-config.php",
+		Body: strings.NewReader(o.contents()),
+	})
+}
+
+func (o *GenerateWPConfigOperation) Describe() string {
+	return "generate a wp-config.php for the standard layout, replacing " + o.layout.Config
+}
+
+func (o *GenerateWPConfigOperation) contents() string {
+	return fmt.Sprintf(`<?php
+/**
+ * This site was moved into the standard WordPress layout by wp-zip. Its original configuration
+ * was in %s.
+ */
+
+define( 'DB_NAME', %s );
+define( 'DB_USER', %s );
+define( 'DB_PASSWORD', %s );
+define( 'DB_HOST', %s );
+define( 'DB_CHARSET', %s );
+define( 'DB_COLLATE', %s );
+
+$table_prefix = %s;
+
+define( 'WP_DEBUG', false );
+%s%s
+if ( ! defined( 'ABSPATH' ) ) {
+	define( 'ABSPATH', __DIR__ . '/' );
+}
+
+require_once ABSPATH . 'wp-settings.php';
+`, strings.ReplaceAll(o.layout.Config, "*/", "* /"), phpscript.Quote(o.credentials.Name), phpscript.Quote(o.credentials.User), phpscript.Quote(o.credentials.Pass),
+		phpscript.Quote(dbHost(o.credentials)), phpscript.Quote(charset(o.credentials)), phpscript.Quote(o.settings.Collate), phpscript.Quote(o.prefix), o.urls(), o.multisite())
+}
+
+// urls returns WP_HOME and WP_SITEURL, since the siteurl option of a site that had WordPress in its own directory, such as /wp, still
+// points there. WordPress is now at the root of the site, so both are its home. The sites of a network each have their own urls in the
+// database, so nothing is defined for them.
+func (o *GenerateWPConfigOperation) urls() string {
+	if o.network != nil {
+		return ""
+	}
+	home := strings.TrimSuffix(o.settings.Home, "/")
+	if home == "" {
+		home = string(o.siteUrl)
+	}
+	if home == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+define( 'WP_HOME', %s );
+define( 'WP_SITEURL', %s );
+`, phpscript.Quote(home), phpscript.Quote(home))
+}
+
+// multisite returns the settings of a multisite network, or nothing for any other site.
+func (o *GenerateWPConfigOperation) multisite() string {
+	if o.network == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+define( 'MULTISITE', true );
+define( 'SUBDOMAIN_INSTALL', %t );
+define( 'DOMAIN_CURRENT_SITE', %s );
+define( 'PATH_CURRENT_SITE', %s );
+define( 'SITE_ID_CURRENT_SITE', %d );
+define( 'BLOG_ID_CURRENT_SITE', %d );
+`, o.network.Subdomain, phpscript.Quote(o.network.Domain), phpscript.Quote(o.network.Path), o.network.NetworkID, o.network.MainSiteID)
+}
+
+// dbHost puts the host, port and socket back together into a DB_HOST value.
+func dbHost(credentials database.DatabaseCredentials) string {
+	host := credentials.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if credentials.Port != "" {
+		host += ":" + credentials.Port
+	}
+	if credentials.Socket != "" {
+		host += ":" + credentials.Socket
+	}
+
+	return host
+}
+
+// charset returns the DB_CHARSET of the site, or the default of wp-config-sample.php.
+func charset(credentials database.DatabaseCredentials) string {
+	if credentials.Charset == "" {
+		return "utf8mb4"
+	}
+	return credentials.Charset
+}
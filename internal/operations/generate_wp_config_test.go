@@ -0,0 +1,70 @@
+package operations
+
+import (
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"strings"
+	"testing"
+)
+
+func TestGenerateWPConfigOperation(t *testing.T) {
+	t.Run("it generates a standard wp-config.php with the database settings", func(t *testing.T) {
+		credentials := database.DatabaseCredentials{User: "user", Pass: "it's", Name: "db", Host: "::1", Port: "3307"}
+		operation := NewGenerateWPConfigOperation(credentials, "shop_", types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"}, parser.WPConfigSettings{Collate: "utf8mb4_unicode_ci"}, "https://example.com", nil)
+
+		var name, contents string
+		operation.SendFiles(func(file File) error {
+			name, contents = file.Name, readerToString(file.Body)
+			return nil
+		})
+
+		if name != "files/wp-config.php" {
+			t.Errorf("got file %s; want files/wp-config.php", name)
+		}
+		for _, want := range []string{"define( 'DB_PASSWORD', 'it\\'s' );", "define( 'DB_HOST', '[::1]:3307' );", "define( 'DB_CHARSET', 'utf8mb4' );", "define( 'DB_COLLATE', 'utf8mb4_unicode_ci' );", "$table_prefix = 'shop_';", "/srv/site/web/wp-config.php", "define( 'WP_HOME', 'https://example.com' );", "define( 'WP_SITEURL', 'https://example.com' );"} {
+			if !strings.Contains(contents, want) {
+				t.Errorf("got %s; want it to contain %s", contents, want)
+			}
+		}
+		if strings.Contains(contents, "MULTISITE") {
+			t.Errorf("got %s; want it not to contain MULTISITE", contents)
+		}
+	})
+
+	t.Run("it keeps the settings of a multisite network", func(t *testing.T) {
+		network := &types.Network{Subdomain: true, Domain: "example.com", Path: "/", MainSiteID: 3, NetworkID: 2}
+		operation := NewGenerateWPConfigOperation(database.DatabaseCredentials{}, "wp_", types.StandardLayout("/var/www/html"), parser.WPConfigSettings{}, "https://example.com", network)
+
+		var contents string
+		operation.SendFiles(func(file File) error {
+			contents = readerToString(file.Body)
+			return nil
+		})
+
+		for _, want := range []string{"define( 'MULTISITE', true );", "define( 'SUBDOMAIN_INSTALL', true );", "define( 'DOMAIN_CURRENT_SITE', 'example.com' );", "define( 'PATH_CURRENT_SITE', '/' );", "define( 'SITE_ID_CURRENT_SITE', 2 );", "define( 'BLOG_ID_CURRENT_SITE', 3 );"} {
+			if !strings.Contains(contents, want) {
+				t.Errorf("got %s; want it to contain %s", contents, want)
+			}
+		}
+		if strings.Contains(contents, "WP_HOME") {
+			t.Errorf("got %s; want it not to contain WP_HOME", contents)
+		}
+	})
+
+	t.Run("it uses WP_HOME from wp-config.php over the site url", func(t *testing.T) {
+		operation := NewGenerateWPConfigOperation(database.DatabaseCredentials{}, "wp_", types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/wp-config.php"}, parser.WPConfigSettings{Home: "https://example.com/blog/"}, "https://example.com", nil)
+
+		var contents string
+		operation.SendFiles(func(file File) error {
+			contents = readerToString(file.Body)
+			return nil
+		})
+
+		for _, want := range []string{"define( 'WP_HOME', 'https://example.com/blog' );", "define( 'WP_SITEURL', 'https://example.com/blog' );", "define( 'DB_COLLATE', '' );"} {
+			if !strings.Contains(contents, want) {
+				t.Errorf("got %s; want it to contain %s", contents, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package operations
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidHeader  = errors.New("invalid http header, expected Name: value")
+	ErrInvalidResolve = errors.New("invalid resolve, expected host:ip or host:port:ip")
+)
+
+// HttpOptions control how the site is reached over HTTP. Staging sites are often behind basic auth, use a self-signed certificate, or
+// have a site url that doesn't resolve from where the tool runs. The zero value makes plain requests.
+type HttpOptions struct {
+	// User and Pass are sent as basic auth credentials when User is set
+	User string
+	Pass string
+	// Header is added to every request
+	Header http.Header
+	// Resolve connects to the given address instead of looking up the host, keyed by either host or host:port
+	Resolve map[string]string
+	// Insecure skips verifying the TLS certificate
+	Insecure bool
+	// Proxy is the proxy every request goes through, the proxy from the environment is used when nil
+	Proxy *url.URL
+	// Timeout limits each request, including reading the response. Zero means no limit.
+	Timeout time.Duration
+}
+
+// ParseHeader parses a header given as "Name: value", and adds it to the header.
+func ParseHeader(header http.Header, value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidHeader, value)
+	}
+	header.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+
+	return nil
+}
+
+// ParseResolve parses a connection override given as "host:ip" or "host:port:ip", the way curl takes it, and adds it to the overrides.
+func ParseResolve(resolve map[string]string, value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidResolve, value)
+	}
+
+	key, ip := parts[0], strings.Join(parts[1:], ":")
+	if len(parts) == 3 {
+		if _, err := strconv.Atoi(parts[1]); err == nil {
+			key, ip = net.JoinHostPort(parts[0], parts[1]), parts[2]
+		}
+	}
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("%w: %s", ErrInvalidResolve, value)
+	}
+	resolve[key] = ip
+
+	return nil
+}
+
+type BasicHttpGetter struct {
+	opts   HttpOptions
+	client *http.Client
+}
+
+// NewHttpGetter is the constructor for BasicHttpGetter. The zero value of BasicHttpGetter is also usable, and makes plain requests with the
+// default client.
+func NewHttpGetter(opts HttpOptions) *BasicHttpGetter {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if len(opts.Resolve) > 0 {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, resolveAddr(opts.Resolve, addr))
+		}
+	}
+
+	return &BasicHttpGetter{opts, &http.Client{Transport: transport, Timeout: opts.Timeout}}
+}
+
+// resolveAddr swaps the host of the address for its override, if it has one. The host and port are kept in the request itself, so the
+// Host header and the TLS server name still match the site.
+func resolveAddr(resolve map[string]string, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip, ok := resolve[addr]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	if ip, ok := resolve[host]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+
+	return addr
+}
+
+func (g *BasicHttpGetter) Get(url string, header http.Header) (resp io.ReadCloser, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range g.opts.Header {
+		req.Header[key] = values
+	}
+	if g.opts.User != "" {
+		req.SetBasicAuth(g.opts.User, g.opts.Pass)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	client := g.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making http request")
+	}
+	defer res.Body.Close()
+
+	// Our helper scripts respond with an error status when they refuse to run, so never treat those responses as output
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected http status: %s", res.Status)
+	}
+
+	// Read the response body
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response body")
+	}
+
+	return io.NopCloser(strings.NewReader(string(body))), nil
+}
@@ -0,0 +1,142 @@
+package operations
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBasicHttpGetter_Get(t *testing.T) {
+	t.Run("it sends the basic auth credentials and headers", func(t *testing.T) {
+		var got *http.Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		g := NewHttpGetter(HttpOptions{User: "user", Pass: "pass", Header: http.Header{"X-Extra": {"extra"}, "X-Token": {"overridden"}}})
+		_, err := g.Get(server.URL, http.Header{"X-Token": {"token"}})
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if user, pass, _ := got.BasicAuth(); user != "user" || pass != "pass" {
+			t.Errorf("got basic auth %s:%s; want user:pass", user, pass)
+		}
+		if got.Header.Get("X-Extra") != "extra" || got.Header.Get("X-Token") != "token" {
+			t.Errorf("got headers %v; want the extra header and the callers token", got.Header)
+		}
+	})
+
+	t.Run("it connects to the resolved address but keeps the host", func(t *testing.T) {
+		var host string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host = r.Host
+		}))
+		defer server.Close()
+		u, _ := url.Parse(server.URL)
+
+		g := NewHttpGetter(HttpOptions{Resolve: map[string]string{"staging.invalid": "127.0.0.1"}})
+		_, err := g.Get("http://staging.invalid:"+u.Port()+"/", nil)
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if host != "staging.invalid:"+u.Port() {
+			t.Errorf("got host %s; want staging.invalid:%s", host, u.Port())
+		}
+	})
+
+	t.Run("it only accepts a self-signed certificate when insecure", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		if _, err := NewHttpGetter(HttpOptions{}).Get(server.URL, nil); err == nil {
+			t.Errorf("got nil error; want a certificate error")
+		}
+		if _, err := NewHttpGetter(HttpOptions{Insecure: true}).Get(server.URL, nil); err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+	})
+
+	t.Run("it gives up after the timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}))
+		defer server.Close()
+		defer close(done)
+
+		_, err := NewHttpGetter(HttpOptions{Timeout: 50 * time.Millisecond}).Get(server.URL, nil)
+
+		if err == nil {
+			t.Errorf("got nil error; want a timeout")
+		}
+	})
+
+	t.Run("the zero value makes plain requests", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		resp, err := (&BasicHttpGetter{}).Get(server.URL, nil)
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if b, _ := io.ReadAll(resp); string(b) != "ok" {
+			t.Errorf("got %s; want ok", b)
+		}
+	})
+}
+
+func TestParseHeader(t *testing.T) {
+	header := http.Header{}
+
+	if err := ParseHeader(header, "X-Forwarded-Proto:  https "); err != nil || header.Get("X-Forwarded-Proto") != "https" {
+		t.Errorf("got %v, %v; want the header to be added", header, err)
+	}
+	if err := ParseHeader(header, "no colon"); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("got error %v; want ErrInvalidHeader", err)
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	var tests = []struct {
+		value   string
+		key     string
+		ip      string
+		wantErr bool
+	}{
+		{"example.com:10.0.0.1", "example.com", "10.0.0.1", false},
+		{"example.com:443:10.0.0.1", "example.com:443", "10.0.0.1", false},
+		{"example.com:443:[::1]", "example.com:443", "::1", false},
+		{"example.com:::1", "example.com", "::1", false},
+		{"example.com", "", "", true},
+		{"example.com:not-an-ip", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resolve := map[string]string{}
+			err := ParseResolve(resolve, tt.value)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resolve[tt.key] != tt.ip {
+				t.Errorf("got %v; want %s => %s", resolve, tt.key, tt.ip)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.value) {
+				t.Errorf("got error %v; want it to mention %s", err, tt.value)
+			}
+		})
+	}
+}
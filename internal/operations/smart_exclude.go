@@ -0,0 +1,25 @@
+package operations
+
+// SMART_EXCLUDE_MIN_SIZE is the size from which an archive or a database dump anywhere in the site is left out, since plugins and themes
+// also ship small archives of their own.
+const SMART_EXCLUDE_MIN_SIZE = 50 * 1024 * 1024
+
+// MAX_REPORTED_SKIPPED is how many of the files that a smart exclusion left out are listed by name.
+const MAX_REPORTED_SKIPPED = 5
+
+// SmartExclusions are the backups and caches that are left out of every export, unless smart excludes are turned off. Backup plugins keep
+// their backups inside of wp-content, so without them every export would also hold the backups of the earlier ones.
+var SmartExclusions = []Exclusion{
+	{Path: "wp-content/updraft", Reason: "UpdraftPlus backups", Smart: true},
+	{Path: "wp-content/ai1wm-backups", Reason: "All-in-One WP Migration backups", Smart: true},
+	{Path: "wp-content/backups-dup-lite", Reason: "Duplicator backups", Smart: true},
+	{Path: "wp-content/backups-dup-pro", Reason: "Duplicator Pro backups", Smart: true},
+	{Path: "wp-snapshots", Reason: "Duplicator backups", Smart: true},
+	{Path: "wp-content/uploads/backwpup-*", Reason: "BackWPup backups, logs and temporary files", Smart: true},
+	{Path: "wp-content/cache", Reason: "a page and asset cache, which the site rebuilds by itself", Smart: true},
+	{Path: "wp-content/et-cache", Reason: "the Divi cache, which the site rebuilds by itself", Smart: true},
+	{Path: "**/*.zip", Reason: "an archive, most likely a backup", MinSize: SMART_EXCLUDE_MIN_SIZE, Smart: true},
+	{Path: "**/*.tar.gz", Reason: "an archive, most likely a backup", MinSize: SMART_EXCLUDE_MIN_SIZE, Smart: true},
+	{Path: "**/*.wpress", Reason: "an All-in-One WP Migration backup", MinSize: SMART_EXCLUDE_MIN_SIZE, Smart: true},
+	{Path: "**/*.sql", Reason: "a database dump", MinSize: SMART_EXCLUDE_MIN_SIZE, Smart: true},
+}
@@ -11,6 +11,11 @@ type Operation interface {
 	SendFiles(fn SendFilesFunc) error
 }
 
+// Describer is an Operation that can say what it would do without doing any of it, such as for a dry run.
+type Describer interface {
+	Describe() string
+}
+
 type File struct {
 	Name string
 	Body io.Reader
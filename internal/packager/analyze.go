@@ -0,0 +1,93 @@
+package packager
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/diskusage"
+	"github.com/jfortunato/wp-zip/internal/operations"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"strings"
+)
+
+// Analysis is where the bytes of a site are, in its files and in its database, along with the --exclude patterns that would leave out
+// what most likely doesn't belong in an export. The size of the database is -1 when it can't be read.
+type Analysis struct {
+	PublicPath   types.PublicPath     `json:"publicPath"`
+	Files        diskusage.Report     `json:"files"`
+	Tables       []database.TableSize `json:"tables"`
+	DatabaseSize int64                `json:"databaseSize"`
+	Excludes     []string             `json:"excludes"`
+	Warnings     []string             `json:"warnings"`
+}
+
+// Analyze lists the files under the webroot and reads the size of each database table, without downloading or writing anything.
+func (p *Packager) Analyze() (Analysis, error) {
+	info := p.i
+	files, err := diskusage.List(p.s.client, info.publicPath.String())
+	if err != nil {
+		return Analysis{}, err
+	}
+
+	analysis := Analysis{
+		PublicPath:   info.publicPath,
+		Files:        diskusage.Analyze(files),
+		Tables:       []database.TableSize{},
+		DatabaseSize: -1,
+		Excludes:     []string{},
+		Warnings:     []string{},
+	}
+	seen := map[string]bool{}
+	for i, finding := range analysis.Files.Findings {
+		// What isn't part of WordPress or wp-content isn't downloaded anyway
+		rel, ok := excludePattern(info.layout, info.publicPath, finding.Path)
+		if !ok {
+			continue
+		}
+		if !p.s.opts.NoSmartExclude && smartlyExcluded(rel, finding.Bytes) {
+			analysis.Files.Findings[i].LeftOut = true
+			continue
+		}
+		if exclude, _ := excludePattern(info.layout, info.publicPath, finding.Pattern); !seen[exclude] {
+			seen[exclude] = true
+			analysis.Excludes = append(analysis.Excludes, exclude)
+		}
+	}
+
+	tables, err := database.ReadTableSizes(p.s.client, info.dbCredentials)
+	if err != nil {
+		analysis.Warnings = append(analysis.Warnings, fmt.Sprintf("could not read the size of the database: %s", err))
+	} else {
+		analysis.Tables = tables
+		analysis.DatabaseSize = database.TotalSize(tables)
+	}
+
+	return analysis, nil
+}
+
+// smartlyExcluded reports whether the file or directory, relative to WordPress, is left out by the smart exclusions.
+func smartlyExcluded(rel string, size int64) bool {
+	for _, exclusion := range operations.SmartExclusions {
+		if exclusion.Skips(rel, size) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludePattern turns a pattern relative to the webroot into the --exclude pattern that leaves it out, which is relative to WordPress in
+// the standard layout. It reports false when the pattern is outside of both WordPress and wp-content.
+func excludePattern(layout types.SiteLayout, webroot types.PublicPath, pattern string) (string, bool) {
+	if strings.HasPrefix(pattern, "**/") {
+		return pattern, true
+	}
+
+	target := types.PublicPath(webroot.String() + pattern)
+	if rel, ok := layout.Content.Rel(target); ok {
+		return strings.TrimSuffix("wp-content/"+rel, "/"), true
+	}
+	if rel, ok := layout.Core.Rel(target); ok {
+		return rel, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,59 @@
+package packager
+
+import (
+	"github.com/jfortunato/wp-zip/internal/types"
+	"testing"
+)
+
+func TestExcludePattern(t *testing.T) {
+	bedrock := types.SiteLayout{Core: "/srv/site/web/wp/", Content: "/srv/site/web/app", Config: "/srv/site/web/wp-config.php"}
+	var tests = []struct {
+		name    string
+		layout  types.SiteLayout
+		webroot types.PublicPath
+		pattern string
+		want    string
+		wantOk  bool
+	}{
+		{"standard", types.StandardLayout("/var/www/html"), "/var/www/html", "wp-content/cache", "wp-content/cache", true},
+		{"any depth", bedrock, "/srv/site/web", "**/error_log", "**/error_log", true},
+		{"inside of wp-content", bedrock, "/srv/site/web", "app/updraft/backup.zip", "wp-content/updraft/backup.zip", true},
+		{"inside of WordPress", bedrock, "/srv/site/web", "wp/backup.zip", "backup.zip", true},
+		{"outside of both", bedrock, "/srv/site/web", "backup.zip", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := excludePattern(tt.layout, tt.webroot, tt.pattern)
+
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got %q, %v; want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSmartlyExcluded(t *testing.T) {
+	const MB = 1024 * 1024
+	var tests = []struct {
+		rel  string
+		size int64
+		want bool
+	}{
+		{"wp-content/updraft/backup_2024-01-01-db.gz", 1 * MB, true},
+		{"wp-content/cache", 10 * MB, true},
+		{"wp-content/uploads/backwpup-abc123-backups/backup.zip", 1 * MB, true},
+		{"wp-content/uploads/site.wpress", 500 * MB, true},
+		{"wp-content/uploads/site.wpress", 1 * MB, false},
+		{"old.sql", 80 * MB, true},
+		{"error_log", 80 * MB, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			if got := smartlyExcluded(tt.rel, tt.size); got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package packager
 
 import (
+	"github.com/jfortunato/wp-zip/internal/database"
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/inventory"
 	"github.com/jfortunato/wp-zip/internal/operations"
+	"github.com/jfortunato/wp-zip/internal/parser"
 	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/wpcli"
 )
 
 // Builder is responsible for building the operations that will be run by the Runner.
@@ -11,15 +15,73 @@ type Builder struct {
 	c sftp.Client
 	e emitter.FileEmitter
 	g operations.HttpGetter
+	// dbOptions control how the database is exported
+	dbOptions database.ExportOptions
+	// wp is nil when WP-CLI is not available on the server
+	wp *wpcli.WPCli
+	// wpDbExport exports the database with `wp db export` when WP-CLI is available
+	wpDbExport bool
+	// exclude are the files and directories that are left out with --exclude
+	exclude []string
+	// noSmartExclude keeps the backups and caches that are otherwise left out
+	noSmartExclude bool
 }
 
 func (b *Builder) Build(info SiteInfo) ([]operations.Operation, error) {
-	return []operations.Operation{
-		// The DownloadFilesOperation is responsible for downloading the entire site files from the server.
-		operations.NewDownloadFilesOperation(b.e, info.publicPath),
+	// A single subsite of a network is archived as a site of its own
+	info = info.single()
+	dbOptions := b.dbOptions
+	dbOptions.Subsite = info.subsite
+	// A nil *WPCli must not end up in an interface, where it would no longer be nil
+	var versioner operations.WordPressVersioner
+	if b.wp != nil {
+		versioner = b.wp
+		if b.wpDbExport {
+			dbOptions.Preferred = b.wp.DatabaseExporter(info.layout.Core, info.dbCredentials, dbOptions)
+		}
+	}
+
+	// The plugins and themes are read from the files as they are downloaded, and listed in the metadata
+	optionsTable, sitemetaTable := extensionTables(info)
+	collector := inventory.NewCollector(database.NewExtensionsReader(b.c, info.dbCredentials, optionsTable, sitemetaTable))
+
+	var exclude []operations.Exclusion
+	for _, nested := range info.nested {
+		exclude = append(exclude, operations.Exclusion{Path: nested, Reason: "another WordPress install, which is exported on its own"})
+	}
+	for _, pattern := range b.exclude {
+		exclude = append(exclude, operations.Exclusion{Path: pattern, Reason: "left out with --exclude"})
+	}
+	if !b.noSmartExclude {
+		exclude = append(exclude, operations.SmartExclusions...)
+	}
+
+	ops := []operations.Operation{
+		// The DownloadFilesOperation is responsible for downloading the entire site files from the server, in the standard layout.
+		operations.NewDownloadFilesOperation(b.e, info.layout, info.subsite, exclude, collector),
 		// The ExportDatabaseOperation is responsible for exporting the database from the server.
-		operations.NewExportDatabaseOperation(info.dbCredentials, b.c, info.publicPath, info.siteUrl, b.g, b.e),
+		operations.NewExportDatabaseOperation(info.dbCredentials, b.c, info.publicPath, info.siteUrl, b.g, b.e, dbOptions),
 		// The GenerateJsonOperation is responsible for generating a JSON file containing metadata about the site (url, php version, etc).
-		operations.NewGenerateJsonOperation(b.c, b.g, info.siteUrl, info.publicPath, info.dbCredentials),
-	}, nil
+		operations.NewGenerateJsonOperation(b.c, b.g, info.siteUrl, info.publicPath, info.layout.Core, info.dbCredentials, info.settings, info.network, b.dbOptions.Compression, versioner, collector),
+	}
+
+	// A wp-config.php that only works in the layout the site had on the server, or for the whole network, is replaced by a standard one
+	if !info.layout.Standard() || info.subsite != nil {
+		ops = append(ops, operations.NewGenerateWPConfigOperation(info.dbCredentials, info.prefix, info.layout, info.settings, info.siteUrl, info.network))
+	}
+
+	return ops, nil
+}
+
+// extensionTables returns the options table of the site being exported, which has its active plugins and themes, and the sitemeta table
+// of its network, which has the plugins that are active on every site. The sitemeta table is empty unless the site is part of a network.
+func extensionTables(info SiteInfo) (string, string) {
+	switch {
+	case info.subsite != nil:
+		return info.subsite.TablePrefix() + "options", info.prefix + "sitemeta"
+	case info.network != nil:
+		return optionsTable(parser.WPConfigFields{Prefix: info.prefix, WPConfigSettings: info.settings}), info.prefix + "sitemeta"
+	}
+
+	return info.prefix + "options", ""
 }
@@ -2,10 +2,16 @@ package packager
 
 import (
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/operations"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/types"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -24,6 +30,58 @@ func TestBuilder_Build(t *testing.T) {
 			t.Errorf("got 0 operations; want > 0")
 		}
 	})
+
+	t.Run("it should leave out the nested installs and what was excluded", func(t *testing.T) {
+		builder := createBuilderWithStubs()
+		builder.exclude = []string{"**/error_log"}
+		builder.noSmartExclude = true
+
+		ops, _ := builder.Build(SiteInfo{layout: types.StandardLayout("/var/www/html"), nested: []string{"staging"}})
+
+		var got []string
+		for _, exclusion := range ops[0].(*operations.DownloadFilesOperation).Exclusions() {
+			got = append(got, exclusion.Path)
+		}
+		if want := []string{"staging", "**/error_log"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got exclusions %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it should leave out the backups and caches by default", func(t *testing.T) {
+		builder := createBuilderWithStubs()
+
+		ops, _ := builder.Build(SiteInfo{layout: types.StandardLayout("/var/www/html")})
+
+		got := ops[0].(*operations.DownloadFilesOperation).Exclusions()
+		if !reflect.DeepEqual(got, operations.SmartExclusions) {
+			t.Errorf("got exclusions %v; want the smart exclusions", got)
+		}
+	})
+}
+
+func TestExtensionTables(t *testing.T) {
+	network := &types.Network{MainSiteID: 1}
+	var tests = []struct {
+		name         string
+		info         SiteInfo
+		wantOptions  string
+		wantSitemeta string
+	}{
+		{"single site", SiteInfo{prefix: "wp_"}, "wp_options", ""},
+		{"network", SiteInfo{prefix: "wp_", network: network, settings: parser.WPConfigSettings{Multisite: true}}, "wp_options", "wp_sitemeta"},
+		{"network with another main site", SiteInfo{prefix: "wp_", network: network, settings: parser.WPConfigSettings{Multisite: true, BlogIdCurrentSite: "3"}}, "wp_3_options", "wp_sitemeta"},
+		{"subsite", SiteInfo{prefix: "wp_", subsite: &types.Extraction{Network: *network, Subsite: types.Subsite{ID: 2}, Prefix: "wp_"}}, "wp_2_options", "wp_sitemeta"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, sitemeta := extensionTables(tt.info)
+
+			if options != tt.wantOptions || sitemeta != tt.wantSitemeta {
+				t.Errorf("got %s and %s; want %s and %s", options, sitemeta, tt.wantOptions, tt.wantSitemeta)
+			}
+		})
+	}
 }
 
 func createBuilderWithStubs() *Builder {
@@ -41,16 +99,21 @@ func (c *ClientStub) RunRemoteCommand(command string) (io.Reader, error) { retur
 func (c *ClientStub) Upload(r io.Reader, dst string) error               { return nil }
 func (c *ClientStub) Delete(dst string) error                            { return nil }
 func (c *ClientStub) Mkdir(dst string) error                             { return nil }
+func (c *ClientStub) Chmod(dst string, mode os.FileMode) error           { return nil }
+func (c *ClientStub) Getwd() (string, error)                             { return "/home/user", nil }
 func (c *ClientStub) ReadDir(path string) ([]os.FileInfo, error)         { return nil, nil }
 func (c *ClientStub) Open(path string) (*sftp.File, error)               { return nil, nil }
 func (c *ClientStub) NewSession() (*ssh.Session, error)                  { return nil, nil }
+func (c *ClientStub) Dial(network, addr string) (net.Conn, error)        { return nil, nil }
 
 type FileEmitterStub struct{}
 
-func (e *FileEmitterStub) CalculateByteSize(src string) int                  { return 0 }
-func (e *FileEmitterStub) EmitSingle(path string, fn emitter.EmitFunc) error { return nil }
-func (e *FileEmitterStub) EmitAll(path string, fn emitter.EmitFunc) error    { return nil }
+func (e *FileEmitterStub) CalculateByteSize(src string, f emitter.Filter) int { return 0 }
+func (e *FileEmitterStub) EmitSingle(path string, fn emitter.EmitFunc) error  { return nil }
+func (e *FileEmitterStub) EmitAll(path string, f emitter.Filter, fn emitter.EmitFunc) error {
+	return nil
+}
 
 type HttpGetterStub struct{}
 
-func (g *HttpGetterStub) Get(url string) (io.ReadCloser, error) { return nil, nil }
+func (g *HttpGetterStub) Get(url string, header http.Header) (io.ReadCloser, error) { return nil, nil }
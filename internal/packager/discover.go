@@ -0,0 +1,222 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This is the command used to find the wp-config.php and wp-settings.php files beneath a directory, which are where the WordPress installs
+// are. Directories that can't be read are skipped.
+const FIND_INSTALLS_CMD = `find -L %s -type f \( -name 'wp-config.php' -o -name 'wp-settings.php' \) 2>/dev/null`
+
+var wpVersion = regexp.MustCompile(`\$wp_version\s*=\s*['"]([^'"]+)['"]`)
+
+// Install is a WordPress install found on the server. The site url and version are empty when they can't be read.
+type Install struct {
+	PublicPath types.PublicPath
+	SiteUrl    types.SiteUrl
+	Version    string
+}
+
+func (i Install) String() string {
+	siteUrl, version := string(i.SiteUrl), "WordPress "+i.Version
+	if siteUrl == "" {
+		siteUrl = "unknown site url"
+	}
+	if i.Version == "" {
+		version = "unknown version"
+	}
+
+	return fmt.Sprintf("%s (%s, %s)", string(i.PublicPath), siteUrl, version)
+}
+
+// foundInstall is where an install is. Its wp-config.php is either in the public path, or in the parent of WordPress.
+type foundInstall struct {
+	publicPath types.PublicPath
+	configDir  string
+}
+
+// DiscoverInstalls lists every WordPress install in the home directory, including the installs nested inside of another one.
+func DiscoverInstalls(runner sftp.CommandRunnerUploader, parser WPConfigParser, finder SiteUrlFinder) ([]Install, error) {
+	found, err := findInstalls(runner, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	return describeInstalls(found, runner, parser, finder), nil
+}
+
+// findInstalls finds the installs beneath the directory. WordPress reads the wp-config.php next to it, or else the one in its parent, so
+// each wp-settings.php belongs to the wp-config.php in its own directory or its parent. When it is in the parent, the parent is the public
+// path if it has an index.php, as it does when WordPress is in a directory of its own. Otherwise wp-config.php is above the webroot.
+func findInstalls(runner sftp.RemoteCommandRunner, dir string) ([]foundInstall, error) {
+	output, err := runner.RunRemoteCommand(fmt.Sprintf(FIND_INSTALLS_CMD, sftp.ShellQuote(dir)))
+	if err != nil {
+		return nil, err
+	}
+	// find fails when any directory can't be read, but everything it could read is still listed
+	b, _ := io.ReadAll(output)
+
+	configDirs, coreDirs := map[string]bool{}, map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		switch path.Base(line) {
+		case "wp-config.php":
+			configDirs[dirname(line)] = true
+		case "wp-settings.php":
+			coreDirs[dirname(line)] = true
+		}
+	}
+
+	// A wp-config.php without WordPress next to it or beneath it is still an install, since ABSPATH may point anywhere
+	publicPaths := map[string]string{}
+	for configDir := range configDirs {
+		publicPaths[configDir] = configDir
+	}
+	for coreDir := range coreDirs {
+		parent := dirname(coreDir)
+		if configDirs[coreDir] || !configDirs[parent] || coreDirs[parent] {
+			continue
+		}
+		if !runner.CanRunRemoteCommand("test -f " + sftp.ShellQuote(parent+"/index.php")) {
+			publicPaths[parent] = coreDir
+		}
+	}
+
+	var found []foundInstall
+	for configDir, publicPath := range publicPaths {
+		found = append(found, foundInstall{types.PublicPath(publicPath), configDir})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].publicPath < found[j].publicPath })
+
+	return found, nil
+}
+
+// dirname is the directory of the path, which is kept the way find printed it, such as ./public_html for ./public_html/wp-config.php.
+func dirname(p string) string {
+	i := strings.LastIndex(p, "/")
+	switch {
+	case i < 0:
+		return "."
+	case i == 0:
+		return "/"
+	}
+
+	return p[:i]
+}
+
+// describeInstalls reads the site url and WordPress version of each install, without prompting for anything.
+func describeInstalls(found []foundInstall, runner sftp.CommandRunnerUploader, parser WPConfigParser, finder SiteUrlFinder) []Install {
+	var installs []Install
+	for _, f := range found {
+		install := Install{PublicPath: f.publicPath}
+		// Whatever could be read from wp-config.php is still enough to look for the site url
+		fields, _ := parser.ParseWPConfig(f.publicPath)
+		install.SiteUrl = findSiteUrl(f.publicPath, fields, finder, runner)
+		install.Version = readCoreVersion(runner, findCore(f.publicPath, fields, runner))
+		installs = append(installs, install)
+	}
+
+	return installs
+}
+
+// readCoreVersion reads $wp_version from wp-includes/version.php, and is empty when it can't be read.
+func readCoreVersion(runner sftp.RemoteCommandRunner, core types.PublicPath) string {
+	output, err := runner.RunRemoteCommand("cat " + sftp.ShellQuote(core.String()+"wp-includes/version.php"))
+	if err != nil {
+		return ""
+	}
+	b, err := io.ReadAll(output)
+	if matches := wpVersion.FindSubmatch(b); err == nil && matches != nil {
+		return string(matches[1])
+	}
+
+	return ""
+}
+
+// chooseInstall lists the installs and asks which of them to export, by its number or its public path.
+func chooseInstall(installs []Install, prompter Prompter) (types.PublicPath, error) {
+	var question strings.Builder
+	question.WriteString("Found these WordPress installs:\n")
+	for i, install := range installs {
+		fmt.Fprintf(&question, "  %d) %s\n", i+1, install)
+	}
+	question.WriteString("Which one should be exported? Enter its number or public path.")
+
+	response := strings.TrimSpace(prompter.Prompt(question.String()))
+	if n, err := strconv.Atoi(response); err == nil {
+		if n < 1 || n > len(installs) {
+			return "", fmt.Errorf("there is no install %d", n)
+		}
+		return installs[n-1].PublicPath, nil
+	}
+	if response == "" {
+		return "", errors.New("public path cannot be empty")
+	}
+
+	return types.PublicPath(response), nil
+}
+
+// DetermineNestedInstalls finds the other installs inside of WordPress, such as a staging copy in the webroot of the live site. They are
+// returned relative to WordPress, so that they can be left out of its files.
+func DetermineNestedInstalls(info SiteInfo, runner sftp.RemoteCommandRunner) []string {
+	found, err := findInstalls(runner, strings.TrimSuffix(info.layout.Core.String(), "/"))
+	if err != nil {
+		return nil
+	}
+
+	var nested []string
+	for _, f := range found {
+		if rel, ok := info.layout.Core.Rel(types.PublicPath(f.configDir)); ok && rel != "" {
+			nested = append(nested, rel)
+		}
+	}
+
+	return nested
+}
+
+// ArchiveNames names the archive of each install after the output filename, with the domain of the install added. Installs on the same
+// domain, such as a staging copy in a subdirectory, are told apart by their public paths.
+func ArchiveNames(output string, installs []Install) []string {
+	ext := path.Ext(output)
+	base := strings.TrimSuffix(output, ext)
+
+	labels := make([]string, len(installs))
+	count := map[string]int{}
+	for i, install := range installs {
+		labels[i] = install.SiteUrl.Domain()
+		if labels[i] == "" {
+			labels[i] = pathLabel(install.PublicPath)
+		}
+		count[labels[i]]++
+	}
+
+	names := make([]string, len(installs))
+	for i, install := range installs {
+		label := labels[i]
+		if count[label] > 1 {
+			label += "-" + pathLabel(install.PublicPath)
+		}
+		names[i] = base + "-" + label + ext
+	}
+
+	return names
+}
+
+// pathLabel turns a public path into something that can be part of a filename, such as public_html-staging for ./public_html/staging.
+func pathLabel(p types.PublicPath) string {
+	label := strings.Trim(strings.ReplaceAll(path.Clean(string(p)), "/", "-"), ".-")
+	if label == "" {
+		return "home"
+	}
+
+	return label
+}
@@ -0,0 +1,151 @@
+package packager
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"reflect"
+	"testing"
+)
+
+var findInstallsCmd = fmt.Sprintf(FIND_INSTALLS_CMD, "'.'")
+
+func TestFindInstalls(t *testing.T) {
+	var tests = []struct {
+		name  string
+		cmds  map[string]string
+		found []foundInstall
+	}{
+		{
+			"addon domains",
+			map[string]string{findInstallsCmd: "./public_html/wp-config.php\n./public_html/wp-settings.php\n./addon.com/wp-config.php\n./addon.com/wp-settings.php\n"},
+			[]foundInstall{{"./addon.com", "./addon.com"}, {"./public_html", "./public_html"}},
+		},
+		{
+			"a staging copy inside the webroot",
+			map[string]string{findInstallsCmd: "./public_html/wp-config.php\n./public_html/wp-settings.php\n./public_html/staging/wp-config.php\n./public_html/staging/wp-settings.php\n"},
+			[]foundInstall{{"./public_html", "./public_html"}, {"./public_html/staging", "./public_html/staging"}},
+		},
+		{
+			"wp-config.php above the webroot",
+			map[string]string{findInstallsCmd: "./wp-config.php\n./public_html/wp-settings.php\n"},
+			[]foundInstall{{"./public_html", "."}},
+		},
+		{
+			"WordPress in a directory of its own",
+			map[string]string{findInstallsCmd: "./web/wp-config.php\n./web/wp/wp-settings.php\n", "test -f './web/index.php'": ""},
+			[]foundInstall{{"./web", "./web"}},
+		},
+		{
+			"WordPress without a wp-config.php",
+			map[string]string{findInstallsCmd: "./downloads/wordpress/wp-settings.php\n"},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findInstalls(&MockCommandRunner{tt.cmds}, ".")
+
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.found) {
+				t.Errorf("got installs %v; want %v", got, tt.found)
+			}
+		})
+	}
+}
+
+func TestDiscoverInstalls(t *testing.T) {
+	runner := &MockCommandRunner{map[string]string{
+		findInstallsCmd:                     "./a/wp-config.php\n./a/wp-settings.php\n./b/wp-config.php\n",
+		"test -f './a/wp-settings.php'":     "",
+		"cat './a/wp-includes/version.php'": "<?php\n$wp_version = '6.5.2';\n",
+		"cat './b/wp-includes/version.php'": "",
+	}}
+	configParser := newConfigParserStub()
+	configParser.fieldsStub.WPConfigSettings = parser.WPConfigSettings{Home: "https://example.com"}
+
+	got, err := DiscoverInstalls(runner, configParser, nil)
+
+	want := []Install{{"./a", "https://example.com", "6.5.2"}, {"./b", "https://example.com", ""}}
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got installs %v; want %v", got, want)
+	}
+}
+
+func TestChooseInstall(t *testing.T) {
+	installs := []Install{{PublicPath: "./a", SiteUrl: "https://a.com", Version: "6.5"}, {PublicPath: "./b"}}
+
+	var tests = []struct {
+		response string
+		want     types.PublicPath
+		wantErr  bool
+	}{
+		{"2", "./b", false},
+		{"./c", "./c", false},
+		{"3", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("response %q", tt.response), func(t *testing.T) {
+			var question string
+			got, err := chooseInstall(installs, PrompterFunc(func(q string) string {
+				question = q
+				return tt.response
+			}))
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got public path %v; want %v", got, tt.want)
+			}
+			wantQuestion := "Found these WordPress installs:\n  1) ./a (https://a.com, WordPress 6.5)\n  2) ./b (unknown site url, unknown version)\nWhich one should be exported? Enter its number or public path."
+			if question != wantQuestion {
+				t.Errorf("got question %q; want %q", question, wantQuestion)
+			}
+		})
+	}
+}
+
+func TestDetermineNestedInstalls(t *testing.T) {
+	info := SiteInfo{layout: types.StandardLayout("/home/u/public_html")}
+	runner := &MockCommandRunner{map[string]string{
+		fmt.Sprintf(FIND_INSTALLS_CMD, "'/home/u/public_html'"): "/home/u/public_html/wp-config.php\n/home/u/public_html/wp-settings.php\n/home/u/public_html/staging/wp-config.php\n/home/u/public_html/staging/wp-settings.php\n/home/u/public_html/old/wp/wp-settings.php\n",
+	}}
+
+	got := DetermineNestedInstalls(info, runner)
+
+	if want := []string{"staging"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nested installs %v; want %v", got, want)
+	}
+}
+
+func TestArchiveNames(t *testing.T) {
+	installs := []Install{
+		{PublicPath: "./public_html", SiteUrl: "https://example.com"},
+		{PublicPath: "./public_html/staging", SiteUrl: "https://example.com"},
+		{PublicPath: "./addon.com", SiteUrl: "https://addon.com"},
+		{PublicPath: "./unknown"},
+	}
+
+	got := ArchiveNames("backup.zip", installs)
+
+	want := []string{"backup-example.com-public_html.zip", "backup-example.com-public_html-staging.zip", "backup-addon.com.zip", "backup-unknown.zip"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got names %v; want %v", got, want)
+	}
+}
+
+// PrompterFunc answers each question with a function.
+type PrompterFunc func(question string) string
+
+func (f PrompterFunc) Prompt(question string) string {
+	return f(question)
+}
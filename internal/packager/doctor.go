@@ -0,0 +1,92 @@
+package packager
+
+import (
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/types"
+)
+
+// Strategy is how one step of an export would be done on the server, and why.
+type Strategy struct {
+	Step   string `json:"step"`
+	Choice string `json:"choice"`
+	Reason string `json:"reason"`
+}
+
+// Profile returns what the server can run.
+func (s *Session) Profile() *capabilities.Profile {
+	return s.profile
+}
+
+// ProbeWebroot checks whether the webroot is writable, which the PHP helper scripts need.
+func (s *Session) ProbeWebroot(publicPath types.PublicPath) bool {
+	return s.profile.ProbeWebroot(s.client, publicPath)
+}
+
+// Strategies explains how each step of an export would be done, the same way the factories choose. The PHP helper scripts are only used
+// when the webroot is writable.
+func (s *Session) Strategies(writableWebroot bool) []Strategy {
+	p, opts := s.profile, s.opts
+
+	var strategies []Strategy
+	add := func(step, choice, reason string, args ...interface{}) {
+		strategies = append(strategies, Strategy{step, choice, fmt.Sprintf(reason, args...)})
+	}
+
+	if _, ok := s.e.(*emitter.TarFileEmitter); ok {
+		add("files", "tar over SSH", "tar is available, so every file is streamed in one session")
+	} else {
+		add("files", "SFTP", "tar is not available, so each file is downloaded on its own")
+	}
+
+	switch {
+	case s.wp != nil:
+		add("site settings", "WP-CLI", "wp --info succeeds, and WP-CLI reads the site the same way WordPress does")
+	case opts.NoWPCli:
+		add("site settings", "wp-config.php parser", "--no-wp-cli was given")
+	default:
+		add("site settings", "wp-config.php parser", "WP-CLI is not available")
+	}
+
+	phpScript, phpReason := "PHP script over HTTP", "the PHP CLI is not available"
+	if p.Has(capabilities.Php) {
+		phpScript, phpReason = "PHP script with the PHP CLI", "the PHP CLI is available"
+	}
+	if !writableWebroot {
+		phpScript, phpReason = "none", "the webroot is not writable, so no PHP script can be uploaded"
+	}
+
+	if p.Has(capabilities.Mysql) {
+		add("database queries", "mysql client", "mysql is available")
+	} else {
+		add("database queries", phpScript, "mysql is not available, and %s", phpReason)
+	}
+
+	program := p.DumpProgram()
+	switch {
+	case s.wp != nil && opts.WPCliDbExport && !opts.Database.Parallel():
+		add("database export", "wp db export", "--wp-cli-db-export was given, and the other exporters are the fallback")
+	case program != "" && opts.Database.Parallel():
+		add("database export", program+", in parallel", "%s is available, and a parallel export was asked for", program)
+	case program != "":
+		add("database export", program, "%s is available", program)
+	case writableWebroot:
+		add("database export", phpScript+", then the MySQL protocol over SSH", "neither mariadb-dump nor mysqldump is available, and %s", phpReason)
+	default:
+		add("database export", "MySQL protocol over SSH", "neither mariadb-dump nor mysqldump is available, and %s", phpReason)
+	}
+
+	// The dump is only compressed when --db-compress asks for it
+	if compression := opts.Database.Compression; compression.Enabled() {
+		if capability, _ := compression.Capability(); p.Has(capability) {
+			add("database compression", "on the server", "%s is available, so the server compresses the dump, which also speeds up the download", compression)
+		} else {
+			add("database compression", "locally", "%s is not available, so the dump is compressed after the download", compression)
+		}
+	}
+
+	add("metadata", phpScript, phpReason)
+
+	return strategies
+}
@@ -0,0 +1,71 @@
+package packager
+
+import (
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/wpcli"
+	"testing"
+)
+
+func TestSession_Strategies(t *testing.T) {
+	probe := func(output string) *capabilities.Profile {
+		return capabilities.Probe(&MockCommandRunner{map[string]string{capabilities.ProbeScript(): output}})
+	}
+
+	var tests = []struct {
+		name     string
+		session  *Session
+		writable bool
+		want     map[string]string
+	}{
+		{
+			"a server with every tool",
+			&Session{profile: probe("tar yes\nmysqldump yes\nmysql yes\nphp yes\ngzip yes\nzstd yes\n"), e: &emitter.TarFileEmitter{}, wp: wpcli.New(nil), opts: Options{Database: database.ExportOptions{Compression: database.CompressionZstd}}},
+			true,
+			map[string]string{"files": "tar over SSH", "site settings": "WP-CLI", "database queries": "mysql client", "database export": "mysqldump", "database compression": "on the server", "metadata": "PHP script with the PHP CLI"},
+		},
+		{
+			"a parallel export with wp db export asked for",
+			&Session{profile: probe("mariadb-dump yes\n"), e: &emitter.SftpFileEmitter{}, wp: wpcli.New(nil), opts: Options{WPCliDbExport: true, Database: database.ExportOptions{Concurrency: 4}}},
+			true,
+			map[string]string{"database export": "mariadb-dump, in parallel"},
+		},
+		{
+			"a server without a shell",
+			&Session{profile: probe(""), e: &emitter.SftpFileEmitter{}, opts: Options{NoWPCli: true, Database: database.ExportOptions{Compression: database.CompressionGzip}}},
+			true,
+			map[string]string{"files": "SFTP", "site settings": "wp-config.php parser", "database queries": "PHP script over HTTP", "database export": "PHP script over HTTP, then the MySQL protocol over SSH", "database compression": "locally", "metadata": "PHP script over HTTP"},
+		},
+		{
+			"a webroot that isn't writable",
+			&Session{profile: probe("php yes\n"), e: &emitter.SftpFileEmitter{}},
+			false,
+			map[string]string{"database queries": "none", "database export": "MySQL protocol over SSH", "metadata": "none"},
+		},
+		{
+			"an export without --db-compress",
+			&Session{profile: probe("gzip yes\n"), e: &emitter.SftpFileEmitter{}},
+			true,
+			map[string]string{"database compression": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			for _, s := range tt.session.Strategies(tt.writable) {
+				got[s.Step] = s.Choice
+				if s.Reason == "" {
+					t.Errorf("got no reason for %s", s.Step)
+				}
+			}
+
+			for step, want := range tt.want {
+				if got[step] != want {
+					t.Errorf("got %s %q; want %q", step, got[step], want)
+				}
+			}
+		})
+	}
+}
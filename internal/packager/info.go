@@ -7,7 +7,13 @@ import (
 	"github.com/jfortunato/wp-zip/internal/parser"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
 	"io"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -21,33 +27,120 @@ type SiteInfo struct {
 	siteUrl       types.SiteUrl
 	publicPath    types.PublicPath
 	dbCredentials database.DatabaseCredentials
+	prefix        string
+	// layout is where WordPress, wp-content and wp-config.php are, which may be outside of the public path
+	layout types.SiteLayout
+	// settings are the other settings in wp-config.php, which are recorded in the metadata
+	settings parser.WPConfigSettings
+	// network is nil unless the site is a multisite network
+	network *types.Network
+	// subsite is the subsite of the network that is exported as a single site, or nil to export the whole site
+	subsite *types.Extraction
+	// nested are the other installs inside of WordPress, relative to it, which are left out of its files
+	nested []string
 }
 
 type WPConfigParser interface {
 	ParseWPConfig(publicPath types.PublicPath) (parser.WPConfigFields, error)
 }
 
+// SiteUrlFinder finds the site url some other way than querying the database ourselves, such as through WP-CLI.
+type SiteUrlFinder interface {
+	FindSiteUrl(publicPath types.PublicPath) (types.SiteUrl, error)
+}
+
+// SubsiteLister lists the subsites of a multisite network, and the members of a subsite given its table prefix.
+type SubsiteLister interface {
+	ListSubsites() ([]types.Subsite, error)
+	ListMembers(tablePrefix string) ([]int, error)
+}
+
+// FallbackWPConfigParser tries each of its parsers in order, and returns the fields of the first one that succeeds.
+type FallbackWPConfigParser struct {
+	parsers []WPConfigParser
+}
+
+// NewFallbackWPConfigParser is the constructor for FallbackWPConfigParser.
+func NewFallbackWPConfigParser(parsers ...WPConfigParser) *FallbackWPConfigParser {
+	return &FallbackWPConfigParser{parsers}
+}
+
+// ParseWPConfig returns the fields of the first parser that succeeds. When none of them do, whatever the last one could read is returned
+// along with the errors.
+func (p *FallbackWPConfigParser) ParseWPConfig(publicPath types.PublicPath) (parser.WPConfigFields, error) {
+	var fields parser.WPConfigFields
+	var errs []error
+	for _, candidate := range p.parsers {
+		var err error
+		fields, err = candidate.ParseWPConfig(publicPath)
+		if err == nil {
+			verbose.Printf("read wp-config.php with %T", candidate)
+			return fields, nil
+		}
+		verbose.Printf("could not read wp-config.php with %T: %s", candidate, err)
+		errs = append(errs, err)
+	}
+
+	return fields, errors.Join(errs...)
+}
+
+// DatabaseOverrides replace the database credentials and table prefix read from wp-config.php, field by field. An empty field is not
+// overridden.
+type DatabaseOverrides struct {
+	User string
+	Pass string
+	Name string
+	// Host is a DB_HOST value, which may include a port or socket
+	Host   string
+	Prefix string
+}
+
+func (o DatabaseOverrides) apply(fields parser.WPConfigFields) parser.WPConfigFields {
+	for _, override := range []struct {
+		value string
+		field *string
+	}{{o.User, &fields.Credentials.User}, {o.Pass, &fields.Credentials.Pass}, {o.Name, &fields.Credentials.Name}, {o.Prefix, &fields.Prefix}} {
+		if override.value != "" {
+			*override.field = override.value
+		}
+	}
+	if o.Host != "" {
+		fields.Credentials.Host, fields.Credentials.Port, fields.Credentials.Socket = parser.ParseDbHost(o.Host)
+	}
+
+	return fields
+}
+
 // DetermineSiteInfo determines the site info needed to package a WordPress site. Some of the information is determined at runtime, such as the database credentials.
-func DetermineSiteInfo(siteUrl types.SiteUrl, publicPath types.PublicPath, parser WPConfigParser, runner sftp.RemoteCommandRunner, prompter Prompter) (SiteInfo, error) {
+// The finder is asked for the site url before the database is queried, and may be nil. The overrides replace what is read from wp-config.php,
+// and whatever can't be read from it is prompted for.
+func DetermineSiteInfo(siteUrl types.SiteUrl, publicPath types.PublicPath, parser WPConfigParser, finder SiteUrlFinder, overrides DatabaseOverrides, runner sftp.CommandRunnerUploader, prompter Prompter) (SiteInfo, error) {
 	var err error
 
 	// If the publicPath is empty, we need to determine it at runtime
 	if publicPath == "" {
-		publicPath, err = determinePublicPath(runner, prompter)
+		publicPath, err = determinePublicPath(runner, parser, finder, prompter)
 		if err != nil {
 			return SiteInfo{}, err
 		}
 	}
+	publicPath = absolutePublicPath(runner, publicPath)
 
 	// We need to determine the database credentials & table prefix at runtime
 	fields, err := parser.ParseWPConfig(publicPath)
+	fields = overrides.apply(fields)
 	if err != nil {
-		return SiteInfo{}, fmt.Errorf("%w: %s", ErrCannotParseWPConfig, err)
+		log.Printf("could not read everything from wp-config.php: %s", err)
+		fields, err = promptForDatabaseFields(fields, err, prompter)
+		if err != nil {
+			return SiteInfo{}, err
+		}
 	}
+	reportSources(fields)
 
 	// If the siteUrl is empty, we need to determine it at runtime
 	if siteUrl == "" {
-		siteUrl, err = determineSiteUrl(fields, runner, prompter)
+		siteUrl, err = determineSiteUrl(publicPath, fields, finder, runner, prompter)
 		if err != nil {
 			return SiteInfo{}, err
 		}
@@ -57,19 +150,245 @@ func DetermineSiteInfo(siteUrl types.SiteUrl, publicPath types.PublicPath, parse
 		siteUrl:       siteUrl,
 		publicPath:    publicPath,
 		dbCredentials: fields.Credentials,
+		prefix:        fields.Prefix,
+		layout:        determineLayout(publicPath, fields, runner),
+		settings:      fields.WPConfigSettings,
 	}, nil
 }
 
-func determineSiteUrl(fields parser.WPConfigFields, runner sftp.RemoteCommandRunner, prompter Prompter) (types.SiteUrl, error) {
-	stmt := fmt.Sprintf(SELECT_SITE_URL_STMT, fields.Prefix+"options")
-	cmd := fmt.Sprintf(`mysql %s --skip-column-names --silent -e "%s"`, database.MysqlCliCredentials(fields.Credentials), stmt)
+// determineLayout finds WordPress and wp-content through ABSPATH, WP_CONTENT_DIR and WP_SITEURL, since they aren't always in the public path.
+// WordPress is in whichever of the candidate directories holds wp-settings.php, and when that can't be checked the first candidate is used.
+func determineLayout(publicPath types.PublicPath, fields parser.WPConfigFields, runner sftp.RemoteCommandRunner) types.SiteLayout {
+	layout := types.StandardLayout(findCore(publicPath, fields, runner))
+	if fields.ContentDir != "" {
+		layout.Content = types.PublicPath(fields.ContentDir)
+	}
+	layout.Config = publicPath.String() + "wp-config.php"
+	if fields.ConfigFile != "" {
+		layout.Config = fields.ConfigFile
+	}
 
-	var siteUrl types.SiteUrl
+	if !layout.Standard() {
+		log.Printf("WordPress is in %s, wp-content in %s and wp-config.php in %s, they will be archived in the standard layout", layout.Core, layout.Content, layout.Config)
+	}
+
+	return layout
+}
 
-	// First we'll try to automatically get the siteurl from the database.
-	if runner.CanRunRemoteCommand(cmd) {
-		siteUrl = queryForSiteUrl(runner, cmd)
+// findCore returns the directory of WordPress, which is whichever of the candidate directories holds wp-settings.php.
+func findCore(publicPath types.PublicPath, fields parser.WPConfigFields, runner sftp.RemoteCommandRunner) types.PublicPath {
+	var candidates []types.PublicPath
+	if dir := coreSubdirectory(fields); dir != "" {
+		candidates = append(candidates, types.PublicPath(publicPath.String()+dir))
+	}
+	if fields.AbsPath != "" {
+		candidates = append(candidates, types.PublicPath(fields.AbsPath))
 	}
+	candidates = append(candidates, publicPath)
+
+	for _, candidate := range candidates {
+		if runner.CanRunRemoteCommand("test -f " + sftp.ShellQuote(candidate.String()+"wp-settings.php")) {
+			return candidate
+		}
+	}
+
+	return candidates[0]
+}
+
+// coreSubdirectory returns the directory of WordPress beneath the public path when it is in a directory of its own, which is where WP_SITEURL
+// is beneath WP_HOME. For example, it is wp/ when WP_SITEURL is https://example.com/wp and WP_HOME is https://example.com.
+func coreSubdirectory(fields parser.WPConfigFields) string {
+	siteUrl, err := url.Parse(fields.SiteUrl)
+	if err != nil || fields.SiteUrl == "" {
+		return ""
+	}
+	dir := strings.Trim(siteUrl.Path, "/")
+
+	if home, err := url.Parse(fields.Home); err == nil && fields.Home != "" {
+		homeDir := strings.Trim(home.Path, "/")
+		if homeDir != "" {
+			if !strings.HasPrefix(dir, homeDir+"/") {
+				return ""
+			}
+			dir = strings.TrimPrefix(dir, homeDir+"/")
+		}
+	}
+	if dir == "" {
+		return ""
+	}
+
+	return dir + "/"
+}
+
+// absolutePublicPath returns the absolute path of the public path, so that it can be compared with the paths in wp-config.php. It is
+// returned as is when it can't be resolved.
+func absolutePublicPath(runner sftp.RemoteCommandRunner, publicPath types.PublicPath) types.PublicPath {
+	if path.IsAbs(string(publicPath)) {
+		return publicPath
+	}
+	output, err := runner.RunRemoteCommand("cd " + sftp.ShellQuote(string(publicPath)) + " && pwd -P")
+	if err != nil {
+		return publicPath
+	}
+	b, err := io.ReadAll(output)
+	if dir := strings.TrimSpace(string(b)); err == nil && path.IsAbs(dir) && !strings.Contains(dir, "\n") {
+		return types.PublicPath(dir)
+	}
+
+	return publicPath
+}
+
+// promptForDatabaseFields prompts for the credentials and prefix that are still missing once wp-config.php couldn't be fully read.
+func promptForDatabaseFields(fields parser.WPConfigFields, parseErr error, prompter Prompter) (parser.WPConfigFields, error) {
+	var prompted DatabaseOverrides
+	for _, field := range []struct {
+		question string
+		value    string
+		response *string
+		secret   bool
+	}{
+		{"What is the database name?", fields.Credentials.Name, &prompted.Name, false},
+		{"What is the database user?", fields.Credentials.User, &prompted.User, false},
+		{"What is the database password?", fields.Credentials.Pass, &prompted.Pass, true},
+		{"What is the database host?", fields.Credentials.Host + fields.Credentials.Socket, &prompted.Host, false},
+		{"What is the table prefix?", fields.Prefix, &prompted.Prefix, false},
+	} {
+		if field.value != "" {
+			continue
+		}
+		if p, ok := prompter.(PasswordPrompter); ok && field.secret {
+			*field.response = p.PromptForPassword(field.question)
+		} else {
+			*field.response = prompter.Prompt(field.question)
+		}
+	}
+	fields = prompted.apply(fields)
+
+	// The password may be empty, but nothing else can be
+	if fields.Credentials.Name == "" || fields.Credentials.User == "" || fields.Credentials.Host+fields.Credentials.Socket == "" || fields.Prefix == "" {
+		return fields, fmt.Errorf("%w: %s", ErrCannotParseWPConfig, parseErr)
+	}
+
+	return fields, nil
+}
+
+// reportSources logs where each field was read from. A field that wasn't in wp-config.php itself is always worth knowing about.
+func reportSources(fields parser.WPConfigFields) {
+	names := make([]string, 0, len(fields.Sources))
+	for name := range fields.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		source := fields.Sources[name]
+		if path.Base(source) == "wp-config.php" {
+			verbose.Printf("read %s from %s", name, source)
+		} else if source != "" {
+			log.Printf("read %s from %s", name, source)
+		}
+	}
+}
+
+// DetermineNetwork describes the multisite network of the site, and is nil when the site isn't one. When the subsites can't be listed,
+// the network is described with only its main site.
+func DetermineNetwork(info SiteInfo, lister SubsiteLister, runner sftp.RemoteCommandRunner) *types.Network {
+	if !info.settings.Multisite {
+		return nil
+	}
+
+	network := &types.Network{
+		Subdomain:  info.settings.SubdomainInstall,
+		Domain:     info.settings.DomainCurrentSite,
+		Path:       info.settings.PathCurrentSite,
+		MainSiteID: mainSiteID(info.settings),
+		NetworkID:  networkID(info.settings),
+	}
+	if network.Domain == "" {
+		network.Domain = info.siteUrl.Domain()
+	}
+	if network.Path == "" {
+		network.Path = "/"
+	}
+	network.LegacyUploads = runner.CanRunRemoteCommand("test -d " + sftp.ShellQuote(info.layout.Content.String()+"blogs.dir"))
+
+	subsites, err := lister.ListSubsites()
+	if err != nil || len(subsites) == 0 {
+		log.Printf("could not list the subsites of the multisite network, only its main site is recorded: %v", err)
+		subsites = []types.Subsite{{ID: network.MainSiteID, Domain: network.Domain, Path: network.Path}}
+	}
+	network.Subsites = subsites
+	verbose.Printf("the site is a %s multisite network of %d sites", network.Kind(), len(subsites))
+
+	return network
+}
+
+// DetermineSubsite finds the subsite to export as a single site, given its blog id or domain, along with its members.
+func DetermineSubsite(info SiteInfo, key string, lister SubsiteLister) (*types.Extraction, error) {
+	if info.network == nil {
+		return nil, errors.New("the site is not a multisite network")
+	}
+	subsite, ok := info.network.Find(key)
+	if !ok {
+		return nil, fmt.Errorf("there is no subsite %s in the network", key)
+	}
+
+	extraction := &types.Extraction{Network: *info.network, Subsite: subsite, Prefix: info.prefix}
+	members, err := lister.ListMembers(extraction.TablePrefix())
+	if err != nil {
+		return nil, err
+	}
+	extraction.Members = members
+	log.Printf("exporting the subsite %s%s as a single site, along with its %d members", subsite.Domain, subsite.Path, len(extraction.Members))
+
+	return extraction, nil
+}
+
+// single returns the info of the subsite being exported, as if it were a single site. It is the info itself when the whole site is exported.
+func (i SiteInfo) single() SiteInfo {
+	if i.subsite == nil {
+		return i
+	}
+
+	if u, err := types.NewSiteUrl(i.subsite.Subsite.Url(i.siteUrl)); err == nil {
+		i.siteUrl = u
+	}
+	// WP_HOME and WP_SITEURL are those of the main site
+	i.settings.Home, i.settings.SiteUrl = "", ""
+	i.settings.Multisite, i.settings.SubdomainInstall = false, false
+	i.settings.DomainCurrentSite, i.settings.PathCurrentSite, i.settings.BlogIdCurrentSite, i.settings.SiteIdCurrentSite = "", "", "", ""
+	i.network = nil
+
+	return i
+}
+
+// mainSiteID returns BLOG_ID_CURRENT_SITE, which is 1 unless the main site of the network has been changed.
+func mainSiteID(settings parser.WPConfigSettings) int {
+	if id, err := strconv.Atoi(settings.BlogIdCurrentSite); err == nil && id > 0 {
+		return id
+	}
+	return 1
+}
+
+// networkID returns SITE_ID_CURRENT_SITE, which is 1 unless the network has been created under another id.
+func networkID(settings parser.WPConfigSettings) int {
+	if id, err := strconv.Atoi(settings.SiteIdCurrentSite); err == nil && id > 0 {
+		return id
+	}
+	return 1
+}
+
+// optionsTable returns the options table of the main site. The first site of a network has the unnumbered tables, and any other site has
+// tables numbered with its id, such as wp_2_options.
+func optionsTable(fields parser.WPConfigFields) string {
+	if id := mainSiteID(fields.WPConfigSettings); fields.Multisite && id != 1 {
+		return fmt.Sprintf("%s%d_options", fields.Prefix, id)
+	}
+	return fields.Prefix + "options"
+}
+
+func determineSiteUrl(publicPath types.PublicPath, fields parser.WPConfigFields, finder SiteUrlFinder, runner sftp.CommandRunnerUploader, prompter Prompter) (types.SiteUrl, error) {
+	siteUrl := findSiteUrl(publicPath, fields, finder, runner)
 
 	// If we don't have a siteUrl at this point, we need to prompt for it
 	if siteUrl == "" {
@@ -83,8 +402,56 @@ func determineSiteUrl(fields parser.WPConfigFields, runner sftp.RemoteCommandRun
 	return siteUrl, nil
 }
 
-func queryForSiteUrl(runner sftp.RemoteCommandRunner, cmd string) types.SiteUrl {
-	output, err := runner.RunRemoteCommand(cmd)
+// findSiteUrl finds the site url without prompting for it, and is empty when it can't be found.
+func findSiteUrl(publicPath types.PublicPath, fields parser.WPConfigFields, finder SiteUrlFinder, runner sftp.CommandRunnerUploader) types.SiteUrl {
+	stmt := fmt.Sprintf(SELECT_SITE_URL_STMT, optionsTable(fields))
+	args := fmt.Sprintf(`--skip-column-names --silent -e "%s"`, stmt)
+	cli := database.NewMysqlCli(runner, fields.Credentials)
+
+	var siteUrl types.SiteUrl
+
+	// First we'll use WP_HOME or WP_SITEURL, which override the options in the database
+	if u, err := types.NewSiteUrl(fields.Home); err == nil {
+		verbose.Printf("found the site url in WP_HOME")
+		siteUrl = u
+	} else if u, err := types.NewSiteUrl(fields.SiteUrl); err == nil {
+		verbose.Printf("found the site url in WP_SITEURL")
+		siteUrl = u
+	}
+
+	// Then we'll ask the finder, if there is one
+	if siteUrl == "" && finder != nil {
+		var err error
+		siteUrl, err = finder.FindSiteUrl(publicPath)
+		if err != nil {
+			verbose.Printf("could not find the site url with %T: %s", finder, err)
+		} else {
+			verbose.Printf("found the site url with %T", finder)
+		}
+	}
+
+	// Then we'll try to automatically get the siteurl from the database.
+	if siteUrl == "" && cli.CanRun("mysql", args) {
+		siteUrl = queryForSiteUrl(cli, args)
+		if siteUrl != "" {
+			verbose.Printf("found the site url in the database")
+		}
+	}
+
+	// Without the mysql client, or in a dry run, which can't upload the client's option file, the database is read over the MySQL protocol
+	if d, ok := runner.(sftp.Dialer); ok && siteUrl == "" {
+		value, err := database.ReadValue(d, fields.Credentials, stmt)
+		if u, errU := types.NewSiteUrl(strings.TrimSpace(value)); err == nil && errU == nil {
+			verbose.Printf("found the site url in the database, through the ssh tunnel")
+			siteUrl = u
+		}
+	}
+
+	return siteUrl
+}
+
+func queryForSiteUrl(cli *database.MysqlCli, args string) types.SiteUrl {
+	output, err := cli.Run("mysql", args)
 	if err != nil {
 		return ""
 	}
@@ -104,35 +471,22 @@ func promptForSiteUrl(prompter Prompter) (types.SiteUrl, error) {
 	return u, nil
 }
 
-func determinePublicPath(runner sftp.RemoteCommandRunner, prompter Prompter) (types.PublicPath, error) {
-	cmd := `find -L . -type f -name 'wp-config.php'`
-
-	var publicPath types.PublicPath
-
-	if runner.CanRunRemoteCommand(cmd) {
-		output, err := runner.RunRemoteCommand(cmd)
-		if err != nil {
-			return "", err
-		}
-		// If there are multiple results, we don't want to guess which one is the correct one, so we'll prompt for the public path
-		// We'll determine that there are multiple results by counting the number of newlines in the output
-		b, _ := io.ReadAll(output)
-		totalNewLines := strings.Count(string(b), "\n")
-		if totalNewLines <= 1 {
-			str := strings.TrimSpace(string(b))
-			publicPath = types.PublicPath(strings.TrimSuffix(str, "/wp-config.php"))
-		}
+// determinePublicPath looks for the WordPress installs in the home directory. A single install is used as it is, the user picks one of
+// several, and the public path is prompted for when none are found.
+func determinePublicPath(runner sftp.CommandRunnerUploader, parser WPConfigParser, finder SiteUrlFinder, prompter Prompter) (types.PublicPath, error) {
+	found, err := findInstalls(runner, ".")
+	if err != nil {
+		return "", err
 	}
 
-	if publicPath == "" {
-		var err error
-		publicPath, err = promptForPublicPath(prompter)
-		if err != nil {
-			return "", err
-		}
+	switch len(found) {
+	case 0:
+		return promptForPublicPath(prompter)
+	case 1:
+		return found[0].publicPath, nil
 	}
 
-	return publicPath, nil
+	return chooseInstall(describeInstalls(found, runner, parser, finder), prompter)
 }
 
 func promptForPublicPath(prompter Prompter) (types.PublicPath, error) {
@@ -6,30 +6,35 @@ import (
 	"github.com/jfortunato/wp-zip/internal/parser"
 	"github.com/jfortunato/wp-zip/internal/types"
 	"io"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 )
 
 func TestDetermineSiteInfo(t *testing.T) {
 	t.Run("it should return the site info", func(t *testing.T) {
-		got, err := DetermineSiteInfo("localhost", "public", newConfigParserStub(), &MockCommandRunner{}, &PrompterSpy{})
+		got, err := DetermineSiteInfo("localhost", "public", newConfigParserStub(), nil, DatabaseOverrides{}, &MockCommandRunner{}, &PrompterSpy{})
 
 		if err != nil {
 			t.Errorf("got error %v; want nil", err)
 		}
 
 		// Assert that we got the site info we expect
-		want := SiteInfo{"localhost", "public", database.DatabaseCredentials{"user", "pass", "db", "localhost"}}
-		if got != want {
+		want := SiteInfo{"localhost", "public", database.DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_", types.StandardLayout("public"), parser.WPConfigSettings{}, nil, nil, nil}
+		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got site info %v; want %v", got, want)
 		}
 	})
 
-	t.Run("it should return an error if the wp config parser fails", func(t *testing.T) {
-		parser := newConfigParserStub()
-		parser.errorStub = errors.New("error")
+	t.Run("it should return an error if the wp config parser fails and nothing is prompted for", func(t *testing.T) {
+		configParser := newConfigParserStub()
+		configParser.fieldsStub = parser.WPConfigFields{}
+		configParser.errorStub = errors.New("error")
 
-		_, err := DetermineSiteInfo("localhost", "public", parser, &MockCommandRunner{}, &PrompterSpy{})
+		_, err := DetermineSiteInfo("localhost", "public", configParser, nil, DatabaseOverrides{}, &MockCommandRunner{}, &PrompterSpy{})
 
 		// Assert that we got the error we expect
 		if !errors.Is(err, ErrCannotParseWPConfig) {
@@ -37,10 +42,46 @@ func TestDetermineSiteInfo(t *testing.T) {
 		}
 	})
 
+	t.Run("it should merge the overrides and prompts with what the parser could read", func(t *testing.T) {
+		configParser := newConfigParserStub()
+		configParser.fieldsStub = parser.WPConfigFields{Credentials: database.DatabaseCredentials{User: "parsed-user", Name: "parsed-db"}}
+		configParser.errorStub = errors.New("DB_PASSWORD depends on the environment variable DB_PASSWORD")
+		prompter := &PrompterSpy{responses: map[string]string{"What is the database password?": "prompted-pass", "What is the table prefix?": "prompted_"}}
+
+		got, err := DetermineSiteInfo("localhost", "public", configParser, nil, DatabaseOverrides{Name: "override-db", Host: "db.internal:3307"}, &MockCommandRunner{}, prompter)
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		want := database.DatabaseCredentials{User: "parsed-user", Pass: "prompted-pass", Name: "override-db", Host: "db.internal", Port: "3307"}
+		if got.dbCredentials != want || got.prefix != "prompted_" {
+			t.Errorf("got %v and prefix %s; want %v and prefix prompted_", got.dbCredentials, got.prefix, want)
+		}
+		if prompter.calls != 2 {
+			t.Errorf("got %d prompt calls; want 2", prompter.calls)
+		}
+	})
+
+	t.Run("it should only apply the overrides when the parser succeeds", func(t *testing.T) {
+		prompter := &PrompterSpy{}
+
+		got, err := DetermineSiteInfo("localhost", "public", newConfigParserStub(), nil, DatabaseOverrides{Pass: "override-pass", Prefix: "xx_"}, &MockCommandRunner{}, prompter)
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if got.dbCredentials.Pass != "override-pass" || got.dbCredentials.User != "user" || got.prefix != "xx_" {
+			t.Errorf("got %v and prefix %s; want the overridden password and prefix", got.dbCredentials, got.prefix)
+		}
+		if prompter.calls != 0 {
+			t.Errorf("got %d prompt calls; want 0", prompter.calls)
+		}
+	})
+
 	t.Run("it should determine the site url at runtime if not given", func(t *testing.T) {
 		cmds := map[string]string{
-			"default":            `mysql --user='user' --password='pass' --host=localhost db --skip-column-names --silent -e "SELECT option_value FROM wp_options WHERE option_name = 'siteurl';"`,
-			"alternative-prefix": `mysql --user='user' --password='pass' --host=localhost db --skip-column-names --silent -e "SELECT option_value FROM xx_options WHERE option_name = 'siteurl';"`,
+			"default":            `trap "rm -f '/home/user/.wp-zip-client.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-client.cnf' --skip-column-names --silent -e "SELECT option_value FROM wp_options WHERE option_name = 'siteurl';" 'db'`,
+			"alternative-prefix": `trap "rm -f '/home/user/.wp-zip-client.cnf'" EXIT; mysql --defaults-extra-file='/home/user/.wp-zip-client.cnf' --skip-column-names --silent -e "SELECT option_value FROM xx_options WHERE option_name = 'siteurl';" 'db'`,
 		}
 
 		var tests = []struct {
@@ -98,17 +139,17 @@ func TestDetermineSiteInfo(t *testing.T) {
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
 				prompter := &PrompterSpy{}
-				parser := newConfigParserStub()
-				parser.fieldsStub.Prefix = tt.prefix
+				configParser := newConfigParserStub()
+				configParser.fieldsStub.Prefix = tt.prefix
 
-				got, err := DetermineSiteInfo("", "public", parser, &MockCommandRunner{tt.stubbedCmds}, prompter)
+				got, err := DetermineSiteInfo("", "public", configParser, nil, DatabaseOverrides{}, &MockCommandRunner{tt.stubbedCmds}, prompter)
 
 				if err != nil {
 					t.Errorf("got error %v; want nil", err)
 				}
 
-				want := SiteInfo{tt.wantSiteUrl, "public", database.DatabaseCredentials{"user", "pass", "db", "localhost"}}
-				if got != want {
+				want := SiteInfo{tt.wantSiteUrl, "public", database.DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, tt.prefix, types.StandardLayout("public"), parser.WPConfigSettings{}, nil, nil, nil}
+				if !reflect.DeepEqual(got, want) {
 					t.Errorf("got site info %v; want %v", got, want)
 				}
 
@@ -120,6 +161,80 @@ func TestDetermineSiteInfo(t *testing.T) {
 		}
 	})
 
+	t.Run("it should read the site url through the ssh tunnel when the mysql client can't be run", func(t *testing.T) {
+		runner := &TunnelRunnerStub{MockCommandRunner: &MockCommandRunner{}}
+
+		_, err := DetermineSiteInfo("", "public", newConfigParserStub(), nil, DatabaseOverrides{}, runner, &PrompterSpy{})
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if runner.dialed != "localhost:3306" {
+			t.Errorf("got %q dialed; want the database at localhost:3306", runner.dialed)
+		}
+	})
+
+	t.Run("it should prefer the site url finder over the database", func(t *testing.T) {
+		var tests = []struct {
+			name        string
+			finder      *SiteUrlFinderStub
+			promptCalls int
+			wantSiteUrl types.SiteUrl
+		}{
+			{"finder succeeds", &SiteUrlFinderStub{siteUrl: "https://found.example.com"}, 0, "https://found.example.com"},
+			{"finder fails - fall back to the prompter", &SiteUrlFinderStub{err: errors.New("error")}, 1, "http://prompted-localhost"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prompter := &PrompterSpy{}
+
+				got, err := DetermineSiteInfo("", "public", newConfigParserStub(), tt.finder, DatabaseOverrides{}, &MockCommandRunner{}, prompter)
+
+				if err != nil {
+					t.Errorf("got error %v; want nil", err)
+				}
+				if got.siteUrl != tt.wantSiteUrl {
+					t.Errorf("got site url %v; want %v", got.siteUrl, tt.wantSiteUrl)
+				}
+				if prompter.calls != tt.promptCalls {
+					t.Errorf("got %d prompt calls; want %d", prompter.calls, tt.promptCalls)
+				}
+			})
+		}
+	})
+
+	t.Run("it should prefer WP_HOME and WP_SITEURL over the site url finder", func(t *testing.T) {
+		var tests = []struct {
+			name        string
+			settings    parser.WPConfigSettings
+			wantSiteUrl types.SiteUrl
+		}{
+			{"WP_HOME", parser.WPConfigSettings{Home: "https://home.example.com", SiteUrl: "https://siteurl.example.com"}, "https://home.example.com"},
+			{"WP_SITEURL", parser.WPConfigSettings{SiteUrl: "https://siteurl.example.com/wp"}, "https://siteurl.example.com"},
+			{"neither", parser.WPConfigSettings{Home: "http://"}, "https://found.example.com"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				configParser := newConfigParserStub()
+				configParser.fieldsStub.WPConfigSettings = tt.settings
+
+				got, err := DetermineSiteInfo("", "public", configParser, &SiteUrlFinderStub{siteUrl: "https://found.example.com"}, DatabaseOverrides{}, &MockCommandRunner{}, &PrompterSpy{})
+
+				if err != nil {
+					t.Errorf("got error %v; want nil", err)
+				}
+				if got.siteUrl != tt.wantSiteUrl {
+					t.Errorf("got site url %v; want %v", got.siteUrl, tt.wantSiteUrl)
+				}
+				if got.settings != tt.settings {
+					t.Errorf("got settings %v; want %v", got.settings, tt.settings)
+				}
+			})
+		}
+	})
+
 	t.Run("it should determine the public path at runtime if not given", func(t *testing.T) {
 		var tests = []struct {
 			name           string
@@ -129,7 +244,7 @@ func TestDetermineSiteInfo(t *testing.T) {
 		}{
 			{
 				"find 1 public path",
-				map[string]string{"find -L . -type f -name 'wp-config.php'": "./public/wp-config.php\n"},
+				map[string]string{findInstallsCmd: "./public/wp-config.php\n"},
 				0,
 				"./public",
 			},
@@ -141,13 +256,13 @@ func TestDetermineSiteInfo(t *testing.T) {
 			},
 			{
 				"no public path found - prompt",
-				map[string]string{"find -L . -type f -name 'wp-config.php'": ""},
+				map[string]string{findInstallsCmd: ""},
 				1,
 				"./path/to/public",
 			},
 			{
-				"multiple public paths found - use none and prompt",
-				map[string]string{"find -L . -type f -name 'wp-config.php'": "./public/wp-config.php\n./public2/wp-config.php\n"},
+				"multiple public paths found - choose one",
+				map[string]string{findInstallsCmd: "./public/wp-config.php\n./public2/wp-config.php\n"},
 				1,
 				"./path/to/public",
 			},
@@ -157,14 +272,14 @@ func TestDetermineSiteInfo(t *testing.T) {
 			t.Run(tt.name, func(t *testing.T) {
 				prompter := &PrompterSpy{}
 
-				got, err := DetermineSiteInfo("localhost", "", newConfigParserStub(), &MockCommandRunner{tt.stubbedCmds}, prompter)
+				got, err := DetermineSiteInfo("localhost", "", newConfigParserStub(), nil, DatabaseOverrides{}, &MockCommandRunner{tt.stubbedCmds}, prompter)
 
 				if err != nil {
 					t.Errorf("got error %v; want nil", err)
 				}
 
-				want := SiteInfo{"localhost", tt.wantPublicPath, database.DatabaseCredentials{"user", "pass", "db", "localhost"}}
-				if got != want {
+				want := SiteInfo{"localhost", tt.wantPublicPath, database.DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, "wp_", types.StandardLayout(tt.wantPublicPath), parser.WPConfigSettings{}, nil, nil, nil}
+				if !reflect.DeepEqual(got, want) {
 					t.Errorf("got site info %v; want %v", got, want)
 				}
 
@@ -177,6 +292,230 @@ func TestDetermineSiteInfo(t *testing.T) {
 	})
 }
 
+func TestDetermineLayout(t *testing.T) {
+	var tests = []struct {
+		name        string
+		fields      parser.WPConfigFields
+		stubbedCmds map[string]string
+		want        types.SiteLayout
+	}{
+		{
+			"standard",
+			parser.WPConfigFields{AbsPath: "/var/www/html/", ConfigFile: "/var/www/html/wp-config.php"},
+			map[string]string{"test -f '/var/www/html/wp-settings.php'": ""},
+			types.StandardLayout("/var/www/html/"),
+		},
+		{
+			"wp-config.php in the parent",
+			parser.WPConfigFields{AbsPath: "/var/www/html/", ConfigFile: "/var/www/wp-config.php"},
+			map[string]string{"test -f '/var/www/html/wp-settings.php'": ""},
+			types.SiteLayout{Core: "/var/www/html/", Content: "/var/www/html/wp-content", Config: "/var/www/wp-config.php"},
+		},
+		{
+			"wordpress in its own directory",
+			parser.WPConfigFields{AbsPath: "/var/www/html/", WPConfigSettings: parser.WPConfigSettings{SiteUrl: "https://example.com/blog/wp", Home: "https://example.com/blog"}, ConfigFile: "/var/www/html/wp-config.php"},
+			map[string]string{"test -f '/var/www/html/wp/wp-settings.php'": ""},
+			types.SiteLayout{Core: "/var/www/html/wp/", Content: "/var/www/html/wp/wp-content", Config: "/var/www/html/wp-config.php"},
+		},
+		{
+			"wordpress in its own directory that does not exist",
+			parser.WPConfigFields{WPConfigSettings: parser.WPConfigSettings{SiteUrl: "https://example.com/wp"}, ConfigFile: "/var/www/html/wp-config.php"},
+			map[string]string{"test -f '/var/www/html/wp-settings.php'": ""},
+			types.StandardLayout("/var/www/html"),
+		},
+		{
+			"bedrock",
+			parser.WPConfigFields{AbsPath: "/var/www/html/wp/", WPConfigSettings: parser.WPConfigSettings{ContentDir: "/var/www/html/app", SiteUrl: "https://example.com/wp", Home: "https://example.com"}, ConfigFile: "/var/www/html/wp-config.php"},
+			map[string]string{"test -f '/var/www/html/wp/wp-settings.php'": ""},
+			types.SiteLayout{Core: "/var/www/html/wp/", Content: "/var/www/html/app", Config: "/var/www/html/wp-config.php"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := determineLayout("/var/www/html", tt.fields, &MockCommandRunner{tt.stubbedCmds})
+
+			if got != tt.want {
+				t.Errorf("got layout %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetermineNetwork(t *testing.T) {
+	subsites := []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}
+	info := SiteInfo{
+		siteUrl:  "https://example.com",
+		layout:   types.StandardLayout("/var/www/html"),
+		settings: parser.WPConfigSettings{Home: "https://example.com", Multisite: true, DomainCurrentSite: "example.com", PathCurrentSite: "/"},
+	}
+
+	t.Run("it is nil unless the site is a multisite network", func(t *testing.T) {
+		if got := DetermineNetwork(SiteInfo{}, &SubsiteListerStub{subsites: subsites}, &MockCommandRunner{}); got != nil {
+			t.Errorf("got network %v; want nil", got)
+		}
+	})
+
+	t.Run("it describes the network and its subsites", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{"test -d '/var/www/html/wp-content/blogs.dir'": ""}}
+
+		got := DetermineNetwork(info, &SubsiteListerStub{subsites: subsites}, runner)
+
+		want := &types.Network{Domain: "example.com", Path: "/", MainSiteID: 1, NetworkID: 1, Subsites: subsites, LegacyUploads: true}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got network %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it only records the main site if the subsites cannot be listed", func(t *testing.T) {
+		info := info
+		info.settings = parser.WPConfigSettings{Multisite: true, SubdomainInstall: true, BlogIdCurrentSite: "3", SiteIdCurrentSite: "2"}
+
+		got := DetermineNetwork(info, &SubsiteListerStub{err: errors.New("error")}, &MockCommandRunner{})
+
+		want := &types.Network{Subdomain: true, Domain: "example.com", Path: "/", MainSiteID: 3, NetworkID: 2, Subsites: []types.Subsite{{ID: 3, Domain: "example.com", Path: "/"}}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got network %v; want %v", got, want)
+		}
+	})
+}
+
+func TestDetermineSubsite(t *testing.T) {
+	network := &types.Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}}
+	info := SiteInfo{siteUrl: "https://example.com", prefix: "wp_", network: network}
+
+	t.Run("it finds the subsite and its members", func(t *testing.T) {
+		for _, key := range []string{"2", "example.com/blog"} {
+			got, err := DetermineSubsite(info, key, &SubsiteListerStub{members: []int{1, 4}})
+
+			want := &types.Extraction{Network: *network, Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_", Members: []int{1, 4}}
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got extraction %v; want %v", got, want)
+			}
+		}
+	})
+
+	var tests = []struct {
+		name   string
+		info   SiteInfo
+		key    string
+		lister *SubsiteListerStub
+	}{
+		{"not a network", SiteInfo{siteUrl: "https://example.com", prefix: "wp_"}, "2", &SubsiteListerStub{}},
+		{"no such subsite", info, "5", &SubsiteListerStub{}},
+		{"members cannot be listed", info, "2", &SubsiteListerStub{err: errors.New("error")}},
+	}
+
+	for _, tt := range tests {
+		t.Run("it returns an error if "+tt.name, func(t *testing.T) {
+			if _, err := DetermineSubsite(tt.info, tt.key, tt.lister); err == nil {
+				t.Errorf("got nil error; want error")
+			}
+		})
+	}
+}
+
+func TestSiteInfo_Single(t *testing.T) {
+	network := &types.Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []types.Subsite{{ID: 1, Domain: "example.com", Path: "/"}, {ID: 2, Domain: "example.com", Path: "/blog/"}}}
+	info := SiteInfo{
+		siteUrl:  "https://example.com",
+		settings: parser.WPConfigSettings{Home: "https://example.com", Multisite: true, DomainCurrentSite: "example.com", PathCurrentSite: "/", BlogIdCurrentSite: "1"},
+		network:  network,
+	}
+
+	t.Run("it is the same info when the whole site is exported", func(t *testing.T) {
+		if got := info.single(); !reflect.DeepEqual(got, info) {
+			t.Errorf("got info %v; want %v", got, info)
+		}
+	})
+
+	t.Run("it describes the subsite as a single site", func(t *testing.T) {
+		info := info
+		info.subsite = &types.Extraction{Network: *network, Subsite: network.Subsites[1], Prefix: "wp_"}
+
+		got := info.single()
+
+		if got.siteUrl != "https://example.com" || got.network != nil || got.settings != (parser.WPConfigSettings{}) {
+			t.Errorf("got site url %v, network %v and settings %v; want the subsite as a single site", got.siteUrl, got.network, got.settings)
+		}
+	})
+}
+
+func TestOptionsTable(t *testing.T) {
+	var tests = []struct {
+		name     string
+		settings parser.WPConfigSettings
+		want     string
+	}{
+		{"single site", parser.WPConfigSettings{BlogIdCurrentSite: "3"}, "wp_options"},
+		{"network", parser.WPConfigSettings{Multisite: true}, "wp_options"},
+		{"network with another main site", parser.WPConfigSettings{Multisite: true, BlogIdCurrentSite: "3"}, "wp_3_options"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := optionsTable(parser.WPConfigFields{Prefix: "wp_", WPConfigSettings: tt.settings}); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackWPConfigParser(t *testing.T) {
+	t.Run("it returns the fields of the first parser that succeeds", func(t *testing.T) {
+		failing := newConfigParserStub()
+		failing.errorStub = errors.New("error")
+		succeeding := newConfigParserStub()
+		succeeding.fieldsStub.Prefix = "xx_"
+
+		got, err := NewFallbackWPConfigParser(failing, succeeding).ParseWPConfig("public")
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		if got.Prefix != "xx_" {
+			t.Errorf("got prefix %v; want xx_", got.Prefix)
+		}
+	})
+
+	t.Run("it returns an error if every parser fails", func(t *testing.T) {
+		failing := newConfigParserStub()
+		failing.errorStub = errors.New("error")
+
+		_, err := NewFallbackWPConfigParser(failing, failing).ParseWPConfig("public")
+
+		if err == nil {
+			t.Errorf("got nil error; want error")
+		}
+	})
+}
+
+type SiteUrlFinderStub struct {
+	siteUrl types.SiteUrl
+	err     error
+}
+
+func (f *SiteUrlFinderStub) FindSiteUrl(publicPath types.PublicPath) (types.SiteUrl, error) {
+	return f.siteUrl, f.err
+}
+
+type SubsiteListerStub struct {
+	subsites []types.Subsite
+	members  []int
+	err      error
+}
+
+func (l *SubsiteListerStub) ListSubsites() ([]types.Subsite, error) {
+	return l.subsites, l.err
+}
+
+func (l *SubsiteListerStub) ListMembers(tablePrefix string) ([]int, error) {
+	return l.members, l.err
+}
+
 type ConfigParserStub struct {
 	errorStub  error
 	fieldsStub parser.WPConfigFields
@@ -184,7 +523,7 @@ type ConfigParserStub struct {
 
 func newConfigParserStub() *ConfigParserStub {
 	return &ConfigParserStub{
-		fieldsStub: parser.WPConfigFields{Credentials: database.DatabaseCredentials{"user", "pass", "db", "localhost"}, Prefix: "wp_"},
+		fieldsStub: parser.WPConfigFields{Credentials: database.DatabaseCredentials{User: "user", Pass: "pass", Name: "db", Host: "localhost"}, Prefix: "wp_"},
 	}
 }
 
@@ -194,10 +533,15 @@ func (p *ConfigParserStub) ParseWPConfig(publicPath types.PublicPath) (parser.WP
 
 type PrompterSpy struct {
 	calls int
+	// responses are the answers to any other questions
+	responses map[string]string
 }
 
 func (p *PrompterSpy) Prompt(question string) string {
 	p.calls++
+	if response, ok := p.responses[question]; ok {
+		return response
+	}
 
 	switch question {
 	case "What is the site url?":
@@ -205,19 +549,42 @@ func (p *PrompterSpy) Prompt(question string) string {
 	case "What is the public path?":
 		return "./path/to/public"
 	}
+	if strings.HasPrefix(question, "Found these WordPress installs") {
+		return "./path/to/public"
+	}
 
 	return ""
 }
 
+// TunnelRunnerStub runs the commands of the MockCommandRunner, and records the address it is asked to dial, which it refuses.
+type TunnelRunnerStub struct {
+	*MockCommandRunner
+	dialed string
+}
+
+func (r *TunnelRunnerStub) Dial(network, addr string) (net.Conn, error) {
+	r.dialed = addr
+	return nil, errors.New("administratively prohibited")
+}
+
 type MockCommandRunner struct {
 	commandsThatExist map[string]string
 }
 
+// The mysql option files are uploaded with a random name, so we normalize it before looking up the command
+var optionFileName = regexp.MustCompile(`\.wp-zip-[0-9a-f]+\.cnf`)
+
 func (m *MockCommandRunner) CanRunRemoteCommand(command string) bool {
-	_, ok := m.commandsThatExist[command]
+	_, ok := m.commandsThatExist[optionFileName.ReplaceAllString(command, ".wp-zip-client.cnf")]
 	return ok
 }
 
 func (m *MockCommandRunner) RunRemoteCommand(command string) (io.Reader, error) {
-	return strings.NewReader(m.commandsThatExist[command]), nil
+	return strings.NewReader(m.commandsThatExist[optionFileName.ReplaceAllString(command, ".wp-zip-client.cnf")]), nil
 }
+
+func (m *MockCommandRunner) Upload(r io.Reader, dst string) error     { return nil }
+func (m *MockCommandRunner) Delete(dst string) error                  { return nil }
+func (m *MockCommandRunner) Mkdir(dst string) error                   { return nil }
+func (m *MockCommandRunner) Chmod(dst string, mode os.FileMode) error { return nil }
+func (m *MockCommandRunner) Getwd() (string, error)                   { return "/home/user", nil }
@@ -3,18 +3,26 @@ package packager
 import (
 	"errors"
 	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/database"
 	"github.com/jfortunato/wp-zip/internal/emitter"
 	"github.com/jfortunato/wp-zip/internal/operations"
 	"github.com/jfortunato/wp-zip/internal/parser"
 	"github.com/jfortunato/wp-zip/internal/sftp"
 	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"github.com/jfortunato/wp-zip/internal/wpcli"
 	"io"
+	"log"
 	"os"
+	"strings"
 )
 
 var (
 	ErrCannotCreateClient      = errors.New("cannot create client")
 	ErrCannotDetermineSiteInfo = errors.New("cannot determine site info")
+	ErrCannotConnectToDatabase = errors.New("cannot connect to the database")
+	ErrCannotExportSubsite     = errors.New("cannot export the subsite")
 	ErrCannotBuildOperations   = errors.New("cannot build operations")
 	ErrCannotCreateZipFile     = errors.New("cannot create zip file")
 	ErrCannotRunOperations     = errors.New("cannot run operations")
@@ -35,29 +43,144 @@ type Packager struct {
 	b OperationsBuilder
 	r OperationsRunner
 	i SiteInfo
+	// s is the session the site is packaged over, which plans the export
+	s *Session
 }
 
-// NewPackager is the constructor for Packager. It will create the default implementations of OperationsBuilder and OperationsRunner.
-func NewPackager(sshCredentials sftp.SSHCredentials, siteUrl types.SiteUrl, publicPath types.PublicPath) (*Packager, error) {
-	client, err := sftp.NewClient(sshCredentials)
+// Options are the optional settings that change how a site is packaged. The zero value packages the site the default way.
+type Options struct {
+	Database database.ExportOptions
+	// NoWPCli never uses WP-CLI, even when it is available on the server
+	NoWPCli bool
+	// WPCliDbExport exports the database with `wp db export` when WP-CLI is available
+	WPCliDbExport bool
+	// Http controls how the site is reached over HTTP
+	Http operations.HttpOptions
+	// DatabaseOverrides replace the database settings read from wp-config.php
+	DatabaseOverrides DatabaseOverrides
+	// Subsite is the blog id or domain of the subsite of a multisite network to export as a single site
+	Subsite string
+	// Exclude are files and directories inside of WordPress, or globs, that are left out of the archive
+	Exclude []string
+	// NoSmartExclude keeps the backups of backup plugins, large archives and database dumps, and caches, which are left out by default
+	NoSmartExclude bool
+	// DryRun never writes to the server, so that the plan of an export can be worked out safely
+	DryRun bool
+}
+
+// Session is a connection to the server, over which any number of the WordPress installs on it can be packaged.
+type Session struct {
+	conn *sftp.ClientWrapper
+	// client answers the checks for what the server can run from the profile
+	client       sftp.Client
+	profile      *capabilities.Profile
+	e            emitter.FileEmitter
+	wp           *wpcli.WPCli
+	configParser WPConfigParser
+	finder       SiteUrlFinder
+	opts         Options
+}
+
+// NewSession connects to the server, and checks once what it has available, such as tar and WP-CLI.
+func NewSession(sshCredentials sftp.SSHCredentials, opts Options) (*Session, error) {
+	conn, err := sftp.NewClient(sshCredentials)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", ErrCannotCreateClient, err)
 	}
+	profile := capabilities.Probe(conn)
+	var client sftp.Client = capabilities.NewClient(conn, profile)
+	if opts.DryRun {
+		client = &readOnlyClient{client}
+	}
 
 	e := emitter.NewFileEmitter(client)
 
-	info, err := DetermineSiteInfo(siteUrl, publicPath, parser.NewEmitterCredentialsParser(e), client, &RuntimePrompter{})
+	// WP-CLI reads the site the same way WordPress does, so it is preferred when it is available. Our own parser and queries are the
+	// fallback.
+	wp := wpcli.New(client)
+	var configParser WPConfigParser = parser.NewEmitterCredentialsParser(e)
+	var finder SiteUrlFinder
+	if opts.NoWPCli || !wp.Available() {
+		verbose.Printf("not using WP-CLI, reading wp-config.php and the database directly")
+		wp = nil
+	} else {
+		verbose.Printf("WP-CLI is available on the server")
+		configParser = NewFallbackWPConfigParser(wp, configParser)
+		finder = wp
+	}
+
+	return &Session{conn, client, profile, e, wp, configParser, finder, opts}, nil
+}
+
+// Discover lists every WordPress install on the server.
+func (s *Session) Discover() ([]Install, error) {
+	return DiscoverInstalls(s.client, s.configParser, s.finder)
+}
+
+// Close closes the connection to the server.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// NewPackager is the constructor for Packager. It will create the default implementations of OperationsBuilder and OperationsRunner.
+func NewPackager(sshCredentials sftp.SSHCredentials, siteUrl types.SiteUrl, publicPath types.PublicPath, opts Options) (*Packager, error) {
+	s, err := NewSession(sshCredentials, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.NewPackager(siteUrl, publicPath)
+}
+
+// NewPackager creates a Packager for one install on the server, which is found at runtime when the public path is empty.
+func (s *Session) NewPackager(siteUrl types.SiteUrl, publicPath types.PublicPath) (*Packager, error) {
+	client, opts := s.client, s.opts
+
+	info, err := DetermineSiteInfo(siteUrl, publicPath, s.configParser, s.finder, opts.DatabaseOverrides, client, &RuntimePrompter{})
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", ErrCannotDetermineSiteInfo, err)
 	}
 
+	g := operations.NewHttpGetter(opts.Http)
+
+	// Wrong credentials are reported before anything is downloaded, instead of as a failed export at the end. Testing them uploads a file,
+	// so a dry run reads the size of the database over the MySQL protocol instead.
+	if !opts.DryRun {
+		err = database.NewConnectionTester(client, info.publicPath, info.siteUrl, g, info.dbCredentials).Test()
+	}
+	if errors.Is(err, database.ErrConnectionUntested) {
+		log.Printf("could not test the database connection, continuing anyway: %s", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotConnectToDatabase, err)
+	}
+	lister := database.NewSubsiteLister(client, info.publicPath, info.siteUrl, g, info.dbCredentials, info.prefix)
+	info.network = DetermineNetwork(info, lister, client)
+	if opts.Subsite != "" {
+		info.subsite, err = DetermineSubsite(info, opts.Subsite, lister)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCannotExportSubsite, err)
+		}
+	}
+	if !s.ProbeWebroot(info.publicPath) {
+		log.Printf("the webroot %s is not writable, so any of the PHP helper scripts that are needed will fail to upload", info.publicPath)
+	}
+	info.nested = DetermineNestedInstalls(info, client)
+	if len(info.nested) > 0 {
+		log.Printf("leaving out the other WordPress installs inside of this one: %s", strings.Join(info.nested, ", "))
+	}
+
 	builder := &Builder{
-		c: client,
-		e: e,
-		g: &operations.BasicHttpGetter{},
+		c:              client,
+		e:              s.e,
+		g:              g,
+		dbOptions:      opts.Database,
+		wp:             s.wp,
+		wpDbExport:     opts.WPCliDbExport,
+		exclude:        opts.Exclude,
+		noSmartExclude: opts.NoSmartExclude,
 	}
 
-	return &Packager{builder, &Runner{}, info}, nil
+	return &Packager{builder, &Runner{}, info, s}, nil
 }
 
 // PackageWP packages a WordPress site. It will build the operations, run them, and output the zip file.
@@ -66,6 +189,7 @@ func (p *Packager) PackageWP(outputFilename string) error {
 	// 1. All site files, placed into a files/ directory
 	// 2. A sql database dump, placed in the root of the archive
 	// 3. A JSON file containing some metadata about the site & it's environment, placed in the root of the archive
+	// 4. A JSON report on whether the database dump is complete, placed in the root of the archive
 	ops, err := p.b.Build(p.i)
 	if err != nil {
 		return fmt.Errorf("%w: %s", ErrCannotBuildOperations, err)
@@ -0,0 +1,147 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/emitter"
+	"github.com/jfortunato/wp-zip/internal/operations"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"os"
+)
+
+var ErrDryRun = errors.New("nothing is written to the server in a dry run")
+
+// Plan is what an export would do, worked out without transferring any data or writing to the server. The sizes are -1 when they can't
+// be estimated.
+type Plan struct {
+	PublicPath types.PublicPath `json:"publicPath"`
+	SiteUrl    types.SiteUrl    `json:"siteUrl"`
+	Core       types.PublicPath `json:"core"`
+	Content    types.PublicPath `json:"content"`
+	Config     string           `json:"config"`
+	// Multisite is the kind of network the site is, and empty unless it is one
+	Multisite string `json:"multisite,omitempty"`
+	// Subsite is the subsite that would be exported on its own
+	Subsite      string                 `json:"subsite,omitempty"`
+	FilesSize    int64                  `json:"filesSize"`
+	DatabaseSize int64                  `json:"databaseSize"`
+	Operations   []string               `json:"operations"`
+	Strategies   []Strategy             `json:"strategies"`
+	Uploads      []Upload               `json:"uploads"`
+	Exclusions   []operations.Exclusion `json:"exclusions"`
+	// Warnings are what couldn't be worked out, such as the size of the database
+	Warnings []string `json:"warnings"`
+}
+
+// Upload is a file or directory that an export would upload to the server, and what it is for. Everything that is uploaded is removed
+// again once it has been used.
+type Upload struct {
+	Path    string `json:"path"`
+	Purpose string `json:"purpose"`
+}
+
+// Plan builds the operations the same as PackageWP does, and describes them instead of running them.
+func (p *Packager) Plan() (Plan, error) {
+	ops, err := p.b.Build(p.i)
+	if err != nil {
+		return Plan{}, fmt.Errorf("%w: %s", ErrCannotBuildOperations, err)
+	}
+
+	info := p.i
+	plan := Plan{
+		PublicPath:   info.publicPath,
+		SiteUrl:      info.siteUrl,
+		Core:         info.layout.Core,
+		Content:      info.layout.Content,
+		Config:       info.layout.Config,
+		FilesSize:    -1,
+		DatabaseSize: -1,
+		Operations:   []string{},
+		Strategies:   []Strategy{},
+		Uploads:      []Upload{},
+		Exclusions:   []operations.Exclusion{},
+		Warnings:     []string{},
+	}
+	if info.network != nil {
+		plan.Multisite = fmt.Sprintf("%s network of %d sites", info.network.Kind(), len(info.network.Subsites))
+	}
+	if info.subsite != nil {
+		plan.Subsite = info.subsite.Subsite.Domain + info.subsite.Subsite.Path
+	}
+
+	for _, op := range ops {
+		if d, ok := op.(operations.Describer); ok {
+			plan.Operations = append(plan.Operations, d.Describe())
+		}
+		if f, ok := op.(*operations.DownloadFilesOperation); ok {
+			plan.Exclusions = append(plan.Exclusions, f.Exclusions()...)
+			// tar without du can't measure the files
+			if _, isTar := p.s.e.(*emitter.TarFileEmitter); !isTar || p.s.profile.Has(capabilities.Du) {
+				plan.FilesSize = int64(f.EstimateSize())
+			}
+		}
+	}
+	if plan.FilesSize < 0 {
+		plan.Warnings = append(plan.Warnings, "the size of the files can't be estimated without tar and du on the server")
+	}
+
+	sizes, err := database.ReadTableSizes(p.s.client, info.dbCredentials)
+	if err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("could not read the size of the database: %s", err))
+	} else {
+		plan.DatabaseSize = database.TotalSize(sizes)
+	}
+
+	writable := p.s.ProbeWebroot(info.publicPath)
+	plan.Strategies = p.s.Strategies(writable)
+	plan.Uploads = p.s.uploads(info.publicPath, writable)
+
+	return plan, nil
+}
+
+// uploads lists what an export would upload to the server, following the same choices as the strategies.
+func (s *Session) uploads(publicPath types.PublicPath, writableWebroot bool) []Upload {
+	uploads := []Upload{}
+	if s.profile.Has(capabilities.Mysql) || s.profile.DumpProgram() != "" {
+		uploads = append(uploads, Upload{"~/.wp-zip-<random>.cnf", "a mysql option file with the database credentials, readable only by the SSH user"})
+	}
+	// The PHP scripts each get a directory of their own in the webroot, which also holds an .htaccess that protects them
+	script := publicPath.String() + "wp-zip-<random>/"
+	if !writableWebroot {
+		return uploads
+	}
+	if !s.profile.Has(capabilities.Mysql) {
+		uploads = append(uploads, Upload{script, "a PHP script that queries the database, such as for the active plugins"})
+	}
+	if s.profile.DumpProgram() == "" {
+		uploads = append(uploads, Upload{script, "a PHP script that exports the database"})
+	}
+	uploads = append(uploads, Upload{script, "a PHP script that generates the metadata"})
+
+	return uploads
+}
+
+// readOnlyClient refuses every write to the server, so that a dry run can't change anything on it.
+type readOnlyClient struct {
+	sftp.Client
+}
+
+func (c *readOnlyClient) Upload(r io.Reader, dst string) error {
+	return fmt.Errorf("%w: %s", ErrDryRun, dst)
+}
+
+func (c *readOnlyClient) Delete(dst string) error {
+	return fmt.Errorf("%w: %s", ErrDryRun, dst)
+}
+
+func (c *readOnlyClient) Mkdir(dst string) error {
+	return fmt.Errorf("%w: %s", ErrDryRun, dst)
+}
+
+func (c *readOnlyClient) Chmod(dst string, mode os.FileMode) error {
+	return fmt.Errorf("%w: %s", ErrDryRun, dst)
+}
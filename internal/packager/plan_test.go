@@ -0,0 +1,73 @@
+package packager
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"strings"
+	"testing"
+)
+
+func TestSession_Uploads(t *testing.T) {
+	probe := func(output string) *capabilities.Profile {
+		return capabilities.Probe(&MockCommandRunner{map[string]string{capabilities.ProbeScript(): output}})
+	}
+
+	var tests = []struct {
+		name     string
+		session  *Session
+		writable bool
+		want     []string
+	}{
+		{
+			"a server with the mysql tools",
+			&Session{profile: probe("mysqldump yes\nmysql yes\nphp yes\n")},
+			true,
+			[]string{"a mysql option file", "generates the metadata"},
+		},
+		{
+			"a server without a shell",
+			&Session{profile: probe("")},
+			true,
+			[]string{"queries the database", "exports the database", "generates the metadata"},
+		},
+		{
+			"a webroot that isn't writable",
+			&Session{profile: probe("mysql yes\n")},
+			false,
+			[]string{"a mysql option file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.session.uploads("/var/www/html/", tt.writable)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d uploads %v; want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i].Purpose, want) {
+					t.Errorf("got upload %q; want one for %q", got[i].Purpose, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	var client sftp.Client = &readOnlyClient{}
+
+	for name, write := range map[string]func() error{
+		"upload": func() error { return client.Upload(strings.NewReader(""), "/var/www/html/a.php") },
+		"delete": func() error { return client.Delete("/var/www/html/a.php") },
+		"mkdir":  func() error { return client.Mkdir("/var/www/html/a") },
+		"chmod":  func() error { return client.Chmod("/var/www/html/a", 0700) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := write(); !errors.Is(err, ErrDryRun) {
+				t.Errorf("got error %v; want %v", err, ErrDryRun)
+			}
+		})
+	}
+}
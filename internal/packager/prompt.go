@@ -11,6 +11,11 @@ type Prompter interface {
 	Prompt(question string) string
 }
 
+// PasswordPrompter is a Prompter that can also prompt without echoing the input, which is used for passwords when it is available.
+type PasswordPrompter interface {
+	PromptForPassword(question string) string
+}
+
 // RuntimePrompter is a Prompter that prompts the user at runtime for input.
 type RuntimePrompter struct{}
 
@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	dotEnvLine     = regexp.MustCompile(`^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*=\s*(.*)$`)
+	dotEnvVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// ParseDotEnv parses the variables from the contents of a .env file, the way phpdotenv does for the common cases. A value may be single
+// quoted, which is taken literally, or double quoted, which may span lines and handles escapes and ${NAME} references to earlier variables.
+// An unquoted value ends at a comment.
+func ParseDotEnv(contents string) map[string]string {
+	env := map[string]string{}
+
+	lines := strings.Split(strings.ReplaceAll(contents, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		matches := dotEnvLine.FindStringSubmatch(lines[i])
+		if matches == nil {
+			continue
+		}
+		name, value := matches[1], strings.TrimSpace(matches[2])
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			if end := strings.IndexByte(value[1:], '\''); end != -1 {
+				value = value[1 : end+1]
+			}
+		case strings.HasPrefix(value, `"`):
+			// A double quoted value continues until its closing quote, even onto the following lines
+			raw := value[1:]
+			for closingQuote(raw) == -1 && i+1 < len(lines) {
+				i++
+				raw += "\n" + lines[i]
+			}
+			if end := closingQuote(raw); end != -1 {
+				raw = raw[:end]
+			}
+			value = expandDotEnv(unescapeDotEnv(raw), env)
+		default:
+			if j := strings.Index(value, " #"); j != -1 {
+				value = value[:j]
+			} else if strings.HasPrefix(value, "#") {
+				value = ""
+			}
+			value = expandDotEnv(strings.TrimSpace(value), env)
+		}
+
+		env[name] = value
+	}
+
+	return env
+}
+
+// closingQuote returns the index of the first double quote that isn't escaped, or -1.
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+func unescapeDotEnv(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\t`, "\t", `\$`, "$").Replace(s)
+}
+
+func expandDotEnv(s string, env map[string]string) string {
+	return dotEnvVariable.ReplaceAllStringFunc(s, func(reference string) string {
+		return env[dotEnvVariable.FindStringSubmatch(reference)[1]]
+	})
+}
@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	contents := `# A comment
+APP_ENV=production
+export DB_NAME = shop
+DB_USER='sh"op'
+DB_PASSWORD="p#ss\"word" # a comment
+DB_HOST=localhost # a comment
+DB_URL="mysql://${DB_USER}@${DB_HOST}"
+MULTILINE="first
+second"
+EMPTY=
+not a variable
+`
+
+	want := map[string]string{
+		"APP_ENV":     "production",
+		"DB_NAME":     "shop",
+		"DB_USER":     `sh"op`,
+		"DB_PASSWORD": `p#ss"word`,
+		"DB_HOST":     "localhost",
+		"DB_URL":      `mysql://sh"op@localhost`,
+		"MULTILINE":   "first\nsecond",
+		"EMPTY":       "",
+	}
+
+	if got := ParseDotEnv(contents); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
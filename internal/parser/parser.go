@@ -7,6 +7,7 @@ import (
 	"github.com/jfortunato/wp-zip/internal/emitter"
 	"github.com/jfortunato/wp-zip/internal/types"
 	"io"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -32,6 +33,60 @@ type Emitter interface {
 type WPConfigFields struct {
 	Credentials database.DatabaseCredentials
 	Prefix      string
+	// Sources is the file each of DB_NAME, DB_USER, DB_PASSWORD, DB_HOST and table_prefix was read from, when it is known
+	Sources map[string]string
+	// ConfigFile is the path of the wp-config.php file that was read, when it is known
+	ConfigFile string
+	// AbsPath is ABSPATH, which is empty when it isn't defined or can't be resolved
+	AbsPath string
+	WPConfigSettings
+}
+
+// WPConfigSettings are the optional settings in wp-config.php, which are recorded in the metadata. A setting that isn't defined or can't
+// be resolved is empty.
+type WPConfigSettings struct {
+	Home              string `json:"WP_HOME,omitempty"`
+	SiteUrl           string `json:"WP_SITEURL,omitempty"`
+	Multisite         bool   `json:"MULTISITE"`
+	SubdomainInstall  bool   `json:"SUBDOMAIN_INSTALL"`
+	DomainCurrentSite string `json:"DOMAIN_CURRENT_SITE,omitempty"`
+	PathCurrentSite   string `json:"PATH_CURRENT_SITE,omitempty"`
+	SiteIdCurrentSite string `json:"SITE_ID_CURRENT_SITE,omitempty"`
+	BlogIdCurrentSite string `json:"BLOG_ID_CURRENT_SITE,omitempty"`
+	Charset           string `json:"DB_CHARSET,omitempty"`
+	Collate           string `json:"DB_COLLATE,omitempty"`
+	CustomUserTable   string `json:"CUSTOM_USER_TABLE,omitempty"`
+	ContentDir        string `json:"WP_CONTENT_DIR,omitempty"`
+	Debug             bool   `json:"WP_DEBUG"`
+	DisableWPCron     bool   `json:"DISABLE_WP_CRON,omitempty"`
+	AlternateWPCron   bool   `json:"ALTERNATE_WP_CRON,omitempty"`
+}
+
+// NewWPConfigSettings reads the settings with the given function, which returns the value of a constant or an empty string.
+func NewWPConfigSettings(constant func(name string) string) WPConfigSettings {
+	return WPConfigSettings{
+		Home:              constant("WP_HOME"),
+		SiteUrl:           constant("WP_SITEURL"),
+		Multisite:         Truthy(constant("MULTISITE")),
+		SubdomainInstall:  Truthy(constant("SUBDOMAIN_INSTALL")),
+		DomainCurrentSite: constant("DOMAIN_CURRENT_SITE"),
+		PathCurrentSite:   constant("PATH_CURRENT_SITE"),
+		SiteIdCurrentSite: constant("SITE_ID_CURRENT_SITE"),
+		BlogIdCurrentSite: constant("BLOG_ID_CURRENT_SITE"),
+		Charset:           constant("DB_CHARSET"),
+		Collate:           constant("DB_COLLATE"),
+		CustomUserTable:   constant("CUSTOM_USER_TABLE"),
+		ContentDir:        constant("WP_CONTENT_DIR"),
+		Debug:             Truthy(constant("WP_DEBUG")),
+		DisableWPCron:     Truthy(constant("DISABLE_WP_CRON")),
+		AlternateWPCron:   Truthy(constant("ALTERNATE_WP_CRON")),
+	}
+}
+
+// Truthy reports whether PHP considers the value of a constant true, where true is "1" and false is an empty string.
+func Truthy(value string) bool {
+	value = strings.TrimSpace(value)
+	return value != "" && value != "0" && !strings.EqualFold(value, "false")
 }
 
 // NewEmitterCredentialsParser is a constructor that returns an EmitterWPConfigParser.
@@ -40,76 +95,162 @@ func NewEmitterCredentialsParser(e Emitter) *EmitterWPConfigParser {
 }
 
 // ParseWPConfig is the main function of the EmitterWPConfigParser. It downloads the wp-config.php file and parses the fields we need
-// (database credentials, table prefix) from it.
+// (database credentials, table prefix) from it. Any files it includes are followed, and the .env files in the public path and its parent
+// are read for the values that come from the environment. Like WordPress, it also looks for wp-config.php in the parent of the public path.
+// When only some of the credentials or the prefix can be resolved, the fields that could be are returned along with the error.
 func (p *EmitterWPConfigParser) ParseWPConfig(publicPath types.PublicPath) (WPConfigFields, error) {
+	// The file is evaluated rather than matched against, so that comments, escapes, concatenation and constants are all understood
+	config := NewPHPEvaluator(nil)
+
 	// Download/read the wp-config.php file
-	contents, err := p.fetchWPConfigContents(publicPath)
+	file := publicPath.String() + "wp-config.php"
+	contents, err := p.fetch(file)
 	if err != nil {
+		parent := path.Join(phpDirname(publicPath.String()), "wp-config.php")
+		var errParent error
+		if contents, errParent = p.fetch(parent); errParent != nil {
+			return WPConfigFields{}, fmt.Errorf("%w: %s", ErrCouldNotReadWPConfig, err)
+		}
+		// WordPress is in the public path, and has already defined ABSPATH by the time it loads the wp-config.php in its parent
+		file = parent
+		config.Define("ABSPATH", publicPath.String())
+	}
+
+	p.readDotEnv(config, publicPath)
+	config.Include = p.fetch
+	config.WorkingDir = publicPath.String()
+	if err := config.Evaluate(file, contents); err != nil {
 		return WPConfigFields{}, fmt.Errorf("%w: %s", ErrCouldNotReadWPConfig, err)
 	}
 
-	credentials, err := parseDatabaseCredentials(contents)
-	if err != nil {
-		return WPConfigFields{}, fmt.Errorf("%w: %s", ErrCantFindCredentials, err)
+	credentials, errCredentials := parseDatabaseCredentials(config)
+	prefix, errPrefix := parsePrefix(config)
+
+	sources := map[string]string{"table_prefix": config.VariableSource("table_prefix")}
+	for _, field := range []string{"DB_NAME", "DB_USER", "DB_PASSWORD", "DB_HOST"} {
+		sources[field] = config.ConstantSource(field)
 	}
 
-	prefix, err := parsePrefix(contents)
-	if err != nil {
-		return WPConfigFields{}, fmt.Errorf("%w: %s", ErrCantFindPrefix, err)
+	// Everything else is optional
+	constant := func(name string) string {
+		value, _ := config.Constant(name)
+		return value
+	}
+
+	fields := WPConfigFields{
+		Credentials:      credentials,
+		Prefix:           prefix,
+		Sources:          sources,
+		ConfigFile:       file,
+		AbsPath:          constant("ABSPATH"),
+		WPConfigSettings: NewWPConfigSettings(constant),
+	}
+
+	if errCredentials != nil {
+		return fields, fmt.Errorf("%w: %s", ErrCantFindCredentials, errCredentials)
+	}
+	if errPrefix != nil {
+		return fields, fmt.Errorf("%w: %s", ErrCantFindPrefix, errPrefix)
 	}
 
-	return WPConfigFields{Credentials: credentials, Prefix: prefix}, nil
+	return fields, nil
 }
 
-// fetchWPConfigContents downloads the wp-config.php file and returns its full contents.
-func (p *EmitterWPConfigParser) fetchWPConfigContents(publicPath types.PublicPath) (string, error) {
-	var wpConfigFileContents string
-	err := p.e.EmitSingle(publicPath.String()+"wp-config.php", func(path string, contents io.Reader) {
-		wpConfigFileContents = readerToString(contents)
+// fetch downloads a file and returns its full contents.
+func (p *EmitterWPConfigParser) fetch(file string) (string, error) {
+	var fileContents string
+	err := p.e.EmitSingle(file, func(path string, contents io.Reader) {
+		fileContents = readerToString(contents)
 	})
 	if err != nil {
 		return "", err
 	}
-	if wpConfigFileContents == "" {
+	if fileContents == "" {
 		return "", ErrEmptyContents
 	}
 
-	return wpConfigFileContents, nil
+	return fileContents, nil
 }
 
-// parseDatabaseCredentials parses the database credentials from the wp-config.php file.
-func parseDatabaseCredentials(contents string) (database.DatabaseCredentials, error) {
-	var fields = map[string]string{"DB_USER": "", "DB_PASSWORD": "", "DB_NAME": "", "DB_HOST": ""}
+// readDotEnv reads the .env files in the public path and in its parent, where Bedrock keeps it. The environment is only known when at
+// least one of them exists, and a variable in the public path wins over one in its parent.
+func (p *EmitterWPConfigParser) readDotEnv(config *PHPEvaluator, publicPath types.PublicPath) {
+	dir := strings.TrimSuffix(publicPath.String(), "/")
+	if dir == "" {
+		dir = "/"
+	}
+
+	for _, file := range []string{path.Join(dir, ".env"), path.Join(phpDirname(dir), ".env")} {
+		contents, err := p.fetch(file)
+		if err != nil {
+			continue
+		}
+		if config.Env == nil {
+			config.Env = map[string]string{}
+		}
+		for name, value := range ParseDotEnv(contents) {
+			if _, ok := config.Env[name]; !ok {
+				config.Env[name] = value
+				config.EnvFiles[name] = file
+			}
+		}
+	}
+}
 
-	for field, _ := range fields {
-		value, err := parseWpConfigField(contents, field)
+// parseDatabaseCredentials parses the database credentials from the wp-config.php file. The credentials that can be resolved are returned
+// even when the others can't.
+func parseDatabaseCredentials(config *PHPEvaluator) (database.DatabaseCredentials, error) {
+	fields := map[string]string{}
+	var errs []error
+	for _, field := range []string{"DB_NAME", "DB_USER", "DB_PASSWORD", "DB_HOST"} {
+		value, err := config.Constant(field)
 		if err != nil {
-			return database.DatabaseCredentials{}, fmt.Errorf("%w: %s", ErrCantFindCredentials, err)
+			errs = append(errs, err)
 		}
 		fields[field] = value
 	}
 
-	return database.DatabaseCredentials{User: fields["DB_USER"], Pass: fields["DB_PASSWORD"], Name: fields["DB_NAME"], Host: fields["DB_HOST"]}, nil
+	host, port, socket := ParseDbHost(fields["DB_HOST"])
+
+	// DB_CHARSET is optional, without it the server's default charset is used
+	charset, _ := config.Constant("DB_CHARSET")
+
+	// Every field that is missing is named at once, rather than one per attempt
+	return database.DatabaseCredentials{User: fields["DB_USER"], Pass: fields["DB_PASSWORD"], Name: fields["DB_NAME"], Host: host, Port: port, Socket: socket, Charset: charset}, errors.Join(errs...)
 }
 
-// parsePrefix parses the table name prefix from the wp-config.php file.
-func parsePrefix(contents string) (string, error) {
-	re := regexp.MustCompile(`\$table_prefix  *= *['"](.*)['"];`)
-	matches := re.FindStringSubmatch(contents)
-	if len(matches) != 2 {
-		return "", fmt.Errorf("could not parse table prefix from wp-config.php")
+// bracketedDbHost is a bracketed IPv6 address, optionally followed by a port.
+var bracketedDbHost = regexp.MustCompile(`^\[([0-9a-fA-F:.]+)\](?::(\d+))?$`)
+
+// ParseDbHost splits a DB_HOST value into its host, port and socket, the same way WordPress does. All of these are valid:
+// localhost, localhost:3307, localhost:/var/run/mysqld/mysqld.sock, :/tmp/mysql.sock, db.internal:3306, [::1]:3306
+func ParseDbHost(value string) (host, port, socket string) {
+	host = strings.TrimSpace(value)
+
+	// Anything after ":/" is a socket path
+	if i := strings.Index(host, ":/"); i != -1 {
+		socket = host[i+1:]
+		host = host[:i]
 	}
-	return matches[1], nil
-}
 
-// parseWpConfigField parses a single field from the wp-config.php file.
-func parseWpConfigField(contents, field string) (string, error) {
-	re := regexp.MustCompile(`define\( *['"]` + field + `['"], *['"](.*)['"] *\);`)
-	matches := re.FindStringSubmatch(contents)
-	if len(matches) != 2 {
-		return "", fmt.Errorf("could not parse %s from wp-config.php", field)
+	if strings.HasPrefix(host, "[") {
+		// A bracketed IPv6 address, optionally followed by a port
+		matches := bracketedDbHost.FindStringSubmatch(host)
+		if matches != nil {
+			return matches[1], matches[2], socket
+		}
+	} else if strings.Count(host, ":") == 1 {
+		// Only a single colon means host:port, more than that is a bare IPv6 address
+		parts := strings.SplitN(host, ":", 2)
+		host, port = parts[0], parts[1]
 	}
-	return matches[1], nil
+
+	return host, port, socket
+}
+
+// parsePrefix parses the table name prefix from the wp-config.php file.
+func parsePrefix(config *PHPEvaluator) (string, error) {
+	return config.Variable("table_prefix")
 }
 
 func readerToString(r io.Reader) string {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/jfortunato/wp-zip/internal/database"
 	"github.com/jfortunato/wp-zip/internal/emitter"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -35,6 +36,27 @@ $table_prefix = 'wp_';
 		}
 	})
 
+	t.Run("it should parse the optional charset from the wp-config.php file", func(t *testing.T) {
+		contents := `
+<?php
+define('DB_NAME', 'name');
+define('DB_USER', 'user');
+define('DB_PASSWORD', 'pass');
+define('DB_HOST', 'localhost');
+define('DB_CHARSET', 'utf8mb4');
+
+$table_prefix = 'wp_';
+`
+
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents})
+
+		fields, _ := parser.ParseWPConfig("/var/www/html/")
+
+		if fields.Credentials.Charset != "utf8mb4" {
+			t.Errorf("got charset %s; want utf8mb4", fields.Credentials.Charset)
+		}
+	})
+
 	t.Run("it should parse the prefix from the wp-config.php file", func(t *testing.T) {
 		contents := `
 <?php
@@ -43,7 +65,8 @@ define('DB_USER', 'user');
 define('DB_PASSWORD', 'pass');
 define('DB_HOST', 'localhost');
 
-$table_prefix  = 'foo_';
+$table_prefix	=
+	"foo_" ;
 `
 
 		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents})
@@ -94,6 +117,194 @@ $table_prefix  = 'foo_';
 		}
 	})
 
+	t.Run("it should name the constants whose value cannot be resolved", func(t *testing.T) {
+		contents := `<?php
+define('DB_NAME', 'name');
+define('DB_USER', getenv('DB_USER'));
+define('DB_PASSWORD', $_ENV['DB_PASSWORD'] ?? 'pass');
+define('DB_HOST', 'localhost');
+$table_prefix = 'wp_';
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents})
+
+		fields, err := parser.ParseWPConfig("/var/www/html/")
+
+		if !errors.Is(err, ErrCantFindCredentials) {
+			t.Errorf("got error %v; want ErrCantFindCredentials", err)
+		}
+		for _, want := range []string{"DB_USER depends on the environment variable DB_USER", "DB_PASSWORD depends on the environment variable DB_PASSWORD"} {
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v; want it to contain %s", err, want)
+			}
+		}
+		// The fields that could be resolved are still returned
+		expectedCreds := database.DatabaseCredentials{Name: "name", Host: "localhost"}
+		if fields.Credentials != expectedCreds || fields.Prefix != "wp_" {
+			t.Errorf("got %v and prefix %s; want %v and prefix wp_", fields.Credentials, fields.Prefix, expectedCreds)
+		}
+	})
+
+	t.Run("it should follow included files and report where each field came from", func(t *testing.T) {
+		contents := `<?php
+define('DB_NAME', 'name');
+if (file_exists(__DIR__ . '/wp-config-local.php')) {
+    include __DIR__ . '/wp-config-local.php';
+}
+define('DB_USER', 'user');
+define('DB_PASSWORD', 'overridden');
+$table_prefix = 'wp_';
+require_once ABSPATH . 'wp-settings.php';
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents, files: map[string]string{
+			"/var/www/html/wp-config-local.php": "<?php\ndefine('DB_PASSWORD', 'local');\ndefine('DB_HOST', 'db');\n",
+		}})
+
+		fields, err := parser.ParseWPConfig("/var/www/html/")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		expectedCreds := database.DatabaseCredentials{User: "user", Pass: "local", Name: "name", Host: "db"}
+		if fields.Credentials != expectedCreds {
+			t.Errorf("got %v; want %v", fields.Credentials, expectedCreds)
+		}
+		expectedSources := map[string]string{
+			"DB_NAME":      "/var/www/html/wp-config.php",
+			"DB_USER":      "/var/www/html/wp-config.php",
+			"DB_PASSWORD":  "/var/www/html/wp-config-local.php",
+			"DB_HOST":      "/var/www/html/wp-config-local.php",
+			"table_prefix": "/var/www/html/wp-config.php",
+		}
+		if !reflect.DeepEqual(fields.Sources, expectedSources) {
+			t.Errorf("got sources %v; want %v", fields.Sources, expectedSources)
+		}
+	})
+
+	t.Run("it should resolve the environment from .env files, the way Bedrock is set up", func(t *testing.T) {
+		contents := `<?php
+require_once dirname(__DIR__) . '/vendor/autoload.php';
+require_once dirname(__DIR__) . '/config/application.php';
+require_once ABSPATH . 'wp-settings.php';
+`
+		application := `<?php
+use Roots\WPConfig\Config;
+use function Env\env;
+
+$root_dir = dirname(__DIR__);
+$webroot_dir = $root_dir . '/web';
+Config::define('WP_HOME', env('WP_HOME'));
+Config::define('WP_SITEURL', env('WP_SITEURL'));
+Config::define('CONTENT_DIR', '/app');
+Config::define('WP_CONTENT_DIR', $webroot_dir . Config::get('CONTENT_DIR'));
+Config::define('DB_NAME', env('DB_NAME'));
+Config::define('DB_USER', env('DB_USER'));
+Config::define('DB_PASSWORD', env('DB_PASSWORD'));
+Config::define('DB_HOST', env('DB_HOST') ?: 'localhost');
+$table_prefix = env('DB_PREFIX') ?: 'wp_';
+Config::apply();
+
+if (!defined('ABSPATH')) {
+    define('ABSPATH', $webroot_dir . '/wp/');
+}
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents, files: map[string]string{
+			"/srv/site/config/application.php": application,
+			"/srv/site/.env":                   "# Database\nDB_NAME=shop\nexport DB_USER='shop'\nDB_PASSWORD=\"p#ss\" # comment\nDB_PREFIX=shop_\n" + "WP_HOME='https://shop.test'\nWP_SITEURL=\"${WP_HOME}/wp\"\n",
+			"/srv/site/web/.env":               "DB_NAME=webroot-shop\n",
+		}})
+
+		fields, err := parser.ParseWPConfig("/srv/site/web")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		expectedCreds := database.DatabaseCredentials{User: "shop", Pass: "p#ss", Name: "webroot-shop", Host: "localhost"}
+		if fields.Credentials != expectedCreds {
+			t.Errorf("got %v; want %v", fields.Credentials, expectedCreds)
+		}
+		if fields.Prefix != "shop_" {
+			t.Errorf("got prefix %s; want shop_", fields.Prefix)
+		}
+		if fields.Sources["DB_NAME"] != "/srv/site/web/.env" || fields.Sources["DB_PASSWORD"] != "/srv/site/.env" || fields.Sources["DB_HOST"] != "/srv/site/config/application.php" {
+			t.Errorf("got sources %v; want the .env files and application.php", fields.Sources)
+		}
+		if fields.AbsPath != "/srv/site/web/wp/" || fields.ContentDir != "/srv/site/web/app" || fields.ConfigFile != "/srv/site/web/wp-config.php" {
+			t.Errorf("got ABSPATH %s, WP_CONTENT_DIR %s and wp-config.php %s; want the Bedrock layout", fields.AbsPath, fields.ContentDir, fields.ConfigFile)
+		}
+		if fields.SiteUrl != "https://shop.test/wp" || fields.Home != "https://shop.test" {
+			t.Errorf("got WP_SITEURL %s and WP_HOME %s; want https://shop.test/wp and https://shop.test", fields.SiteUrl, fields.Home)
+		}
+	})
+
+	t.Run("it should read the other settings", func(t *testing.T) {
+		contents := `<?php
+define('DB_NAME', 'name');
+define('DB_USER', 'user');
+define('DB_PASSWORD', 'pass');
+define('DB_HOST', 'localhost');
+define('DB_CHARSET', 'utf8mb4');
+define('DB_COLLATE', '');
+define('WP_HOME', 'https://example.com');
+define('WP_SITEURL', WP_HOME . '/wp');
+define('MULTISITE', true);
+define('SUBDOMAIN_INSTALL', false);
+define('DOMAIN_CURRENT_SITE', 'example.com');
+define('PATH_CURRENT_SITE', '/');
+define('BLOG_ID_CURRENT_SITE', 1);
+define('CUSTOM_USER_TABLE', 'shared_users');
+define('WP_DEBUG', getenv('WP_DEBUG') ?: false);
+$table_prefix = 'wp_';
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents})
+
+		fields, err := parser.ParseWPConfig("/var/www/html/")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		// WP_DEBUG depends on the environment, which isn't known, so it is left empty
+		expected := WPConfigSettings{
+			Home:              "https://example.com",
+			SiteUrl:           "https://example.com/wp",
+			Multisite:         true,
+			DomainCurrentSite: "example.com",
+			PathCurrentSite:   "/",
+			BlogIdCurrentSite: "1",
+			Charset:           "utf8mb4",
+			CustomUserTable:   "shared_users",
+		}
+		if fields.WPConfigSettings != expected {
+			t.Errorf("got %+v; want %+v", fields.WPConfigSettings, expected)
+		}
+	})
+
+	t.Run("it should read wp-config.php from the parent of the public path, the way WordPress does", func(t *testing.T) {
+		contents := `<?php
+define('DB_NAME', 'name');
+define('DB_USER', 'user');
+define('DB_PASSWORD', 'pass');
+define('DB_HOST', 'localhost');
+$table_prefix = 'wp_';
+if (!defined('ABSPATH')) {
+    define('ABSPATH', __DIR__ . '/');
+}
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{files: map[string]string{"/var/www/wp-config.php": contents}})
+
+		fields, err := parser.ParseWPConfig("/var/www/html")
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if fields.ConfigFile != "/var/www/wp-config.php" {
+			t.Errorf("got wp-config.php %s; want /var/www/wp-config.php", fields.ConfigFile)
+		}
+		// WordPress defines ABSPATH before it loads wp-config.php
+		if fields.AbsPath != "/var/www/html/" {
+			t.Errorf("got ABSPATH %s; want /var/www/html/", fields.AbsPath)
+		}
+	})
+
 	t.Run("it should return an error if the credentials can be extracted from the file, but the prefix cannot", func(t *testing.T) {
 		contents := `
 <?php
@@ -125,7 +336,7 @@ define('DB_HOST', 'localhost');
 				define('DB_NAME', 'dbname');
 				define('DB_HOST', 'localhost');
 				`,
-				database.DatabaseCredentials{"user", "pass", "dbname", "localhost"},
+				database.DatabaseCredentials{User: "user", Pass: "pass", Name: "dbname", Host: "localhost"},
 			},
 			{
 				"spaces",
@@ -138,7 +349,7 @@ define('DB_HOST', 'localhost');
 				define('DB_NAME','dbname');
 				define('DB_HOST','localhost');
 				`,
-				database.DatabaseCredentials{"user", "pass", "dbname", "localhost"},
+				database.DatabaseCredentials{User: "user", Pass: "pass", Name: "dbname", Host: "localhost"},
 			},
 			{
 				"double quotes",
@@ -148,7 +359,7 @@ define('DB_HOST', 'localhost');
 				define( "DB_NAME", "dbname" );
 				define( "DB_HOST", "localhost" );
 				`,
-				database.DatabaseCredentials{"user", "pass", "dbname", "localhost"},
+				database.DatabaseCredentials{User: "user", Pass: "pass", Name: "dbname", Host: "localhost"},
 			},
 			{
 				"quote usage in values",
@@ -158,18 +369,55 @@ define('DB_HOST', 'localhost');
 				define('DB_NAME', 'dbname');
 				define('DB_HOST', 'localhost');
 				`,
-				database.DatabaseCredentials{"us\"er", "pa'ss", "dbname", "localhost"},
+				database.DatabaseCredentials{User: "us\"er", Pass: "pa'ss", Name: "dbname", Host: "localhost"},
+			},
+			{
+				"commented out defines",
+				`<?php
+				// define('DB_USER', 'old-user');
+				# define('DB_USER', 'older-user');
+				/* define('DB_USER', 'oldest-user'); */
+				define('DB_USER', 'user');
+				define('DB_PASSWORD', 'pass');
+				define('DB_NAME', 'dbname');
+				define('DB_HOST', 'localhost');
+				`,
+				database.DatabaseCredentials{User: "user", Pass: "pass", Name: "dbname", Host: "localhost"},
+			},
+			{
+				"escaped quotes",
+				`<?php
+				define('DB_USER', 'us\'er');
+				define('DB_PASSWORD', "pa\"ss\\");
+				define('DB_NAME', 'dbname');
+				define('DB_HOST', 'localhost');
+				`,
+				database.DatabaseCredentials{User: "us'er", Pass: `pa"ss\`, Name: "dbname", Host: "localhost"},
+			},
+			{
+				"concatenation and constants",
+				`<?php
+				const SITE = 'shop';
+				define('DB_USER', SITE . '_user');
+				define('DB_PASSWORD', 'pa' . "ss");
+				define('DB_NAME', DB_USER . 'db');
+				define('DB_HOST', 'localhost' . ':' . 3307);
+				`,
+				database.DatabaseCredentials{User: "shop_user", Pass: "pass", Name: "shop_userdb", Host: "localhost", Port: "3307"},
+			},
+			{
+				"variables and heredoc",
+				`<?php
+				$user = 'user';
+				define('DB_USER', "$user");
+				define('DB_PASSWORD', <<<'EOT'
+				pa$s
+				EOT);
+				define('DB_NAME', "{$user}_db");
+				define('DB_HOST', "local\x68ost");
+				`,
+				database.DatabaseCredentials{User: "user", Pass: "pa$s", Name: "user_db", Host: "localhost"},
 			},
-			//{
-			//	"docker env",
-			//	`<?php
-			//	define('DB_USER', getenv_docker('WORDPRESS_DB_USER', 'default-user'));
-			//	define('DB_PASSWORD', getenv_docker('WORDPRESS_DB_PASSWORD', 'default-pass'));
-			//	define('DB_NAME', getenv_docker('WORDPRESS_DB_NAME', 'default-dbname'));
-			//	define('DB_HOST', getenv_docker('WORDPRESS_DB_HOST', 'default-localhost'));
-			//	`,
-			//	DatabaseCredentials{"user", "pass", "dbname", "localhost"},
-			//},
 		}
 
 		for _, test := range tests {
@@ -194,12 +442,69 @@ define('DB_HOST', 'localhost');
 	})
 }
 
+func TestParseDbHost(t *testing.T) {
+	var tests = []struct {
+		input      string
+		wantHost   string
+		wantPort   string
+		wantSocket string
+	}{
+		{"localhost", "localhost", "", ""},
+		{"localhost:3307", "localhost", "3307", ""},
+		{"db.internal:3306", "db.internal", "3306", ""},
+		{"127.0.0.1", "127.0.0.1", "", ""},
+		{"localhost:/var/run/mysqld/mysqld.sock", "localhost", "", "/var/run/mysqld/mysqld.sock"},
+		{":/tmp/mysql.sock", "", "", "/tmp/mysql.sock"},
+		{"[::1]:3306", "::1", "3306", ""},
+		{"[::1]", "::1", "", ""},
+		{"::1", "::1", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			host, port, socket := ParseDbHost(test.input)
+
+			if host != test.wantHost || port != test.wantPort || socket != test.wantSocket {
+				t.Errorf("got (%q, %q, %q); want (%q, %q, %q)", host, port, socket, test.wantHost, test.wantPort, test.wantSocket)
+			}
+		})
+	}
+
+	t.Run("it is used when parsing the wp-config.php file", func(t *testing.T) {
+		contents := `<?php
+define('DB_NAME', 'name');
+define('DB_USER', 'user');
+define('DB_PASSWORD', 'pass');
+define('DB_HOST', 'localhost:/var/run/mysqld/mysqld.sock');
+$table_prefix = 'wp_';
+`
+		parser := NewEmitterCredentialsParser(&EmitterStub{contentsToEmit: contents})
+
+		fields, _ := parser.ParseWPConfig("/var/www/html/")
+
+		expectedCreds := database.DatabaseCredentials{User: "user", Pass: "pass", Name: "name", Host: "localhost", Socket: "/var/run/mysqld/mysqld.sock"}
+		if fields.Credentials != expectedCreds {
+			t.Errorf("got %v; want %v", fields.Credentials, expectedCreds)
+		}
+	})
+}
+
 type EmitterStub struct {
 	contentsToEmit string
 	errorStub      error
+	// files are the other files that exist next to wp-config.php
+	files map[string]string
 }
 
 func (e *EmitterStub) EmitSingle(src string, fn emitter.EmitFunc) error {
+	if contents, ok := e.files[src]; ok {
+		fn(src, strings.NewReader(contents))
+		return nil
+	}
+	if !strings.HasSuffix(src, "/wp-config.php") {
+		return errors.New("file does not exist")
+	}
+
 	fn(src, strings.NewReader(e.contentsToEmit))
 
 	return e.errorStub
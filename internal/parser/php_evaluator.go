@@ -0,0 +1,980 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUndefinedConstant = errors.New("constant is not defined")
+	ErrUndefinedVariable = errors.New("variable is not set")
+	ErrUnresolvable      = errors.New("value cannot be resolved statically")
+)
+
+// phpValue is the value of an expression. It is unresolved when it depends on something that is only known when the code runs, in which
+// case unresolved says what that is.
+type phpValue struct {
+	value      string
+	null       bool
+	unresolved string
+	// source is the file the value was read from, when it came from somewhere other than where it is used, such as a .env file
+	source string
+}
+
+func resolved(value string) phpValue {
+	return phpValue{value: value}
+}
+
+func unresolved(reason string) phpValue {
+	return phpValue{unresolved: reason}
+}
+
+var phpNull = phpValue{null: true}
+
+func (v phpValue) ok() bool {
+	return v.unresolved == ""
+}
+
+func (v phpValue) truthy() bool {
+	return !v.null && v.value != "" && v.value != "0"
+}
+
+// PHPEvaluator follows the constants and variables that PHP code defines, without running it. It understands string literals, heredocs,
+// concatenation, constants, the ternary and null coalescing operators, and a few functions that configuration files commonly use. Anything
+// else, such as a call to an unknown function, leaves the value unresolved, along with the reason why.
+//
+// The code is followed from top to bottom, the same way PHP runs it. A constant keeps the first value it is defined with, and a variable
+// the last value it is assigned. Included files are followed where they are included. An if statement whose conditions can be resolved
+// runs the branch that PHP would, and when they can't, whatever its branches don't all agree on is left unresolved.
+type PHPEvaluator struct {
+	// Env is the environment that getenv(), env() and $_ENV read. When it is nil, the environment is unknown, and anything that reads it
+	// can't be resolved.
+	Env map[string]string
+	// EnvFiles is the file each environment variable was read from
+	EnvFiles map[string]string
+	// Include fetches the contents of an included file. Includes are not followed when it is nil.
+	Include func(file string) (string, error)
+	// WorkingDir is the directory that relative includes are looked for in first, the same as the . of PHP's include_path, which is the
+	// public path when WordPress is loaded through its index.php. Relative includes are only looked for next to the including file when
+	// it is empty, or when they aren't found there.
+	WorkingDir string
+
+	constants map[string]phpValue
+	variables map[string]phpValue
+	included  map[string]bool
+	// evaluating are the files that are being evaluated, from the first file down to the one that is including another
+	evaluating map[string]bool
+	// fetched are the files that Include has already fetched, so that a file included more than once is only fetched once
+	fetched map[string]fetchedFile
+	depth   int
+}
+
+// fetchedFile is what Include returned for a file.
+type fetchedFile struct {
+	contents string
+	err      error
+}
+
+// The deepest that includes are followed.
+const MAX_INCLUDE_DEPTH = 8
+
+// Included files that are never followed, since they are part of WordPress or of Composer rather than of the site's configuration.
+var skippedIncludes = regexp.MustCompile(`(^|/)(wp-settings\.php$|wp-load\.php$|wp-includes/|wp-admin/|vendor/)`)
+
+// errSkippedInclude is returned for an included file that is never followed.
+var errSkippedInclude = errors.New("the included file is not part of the site's configuration")
+
+// NewPHPEvaluator is the constructor for PHPEvaluator.
+func NewPHPEvaluator(env map[string]string) *PHPEvaluator {
+	return &PHPEvaluator{Env: env, EnvFiles: map[string]string{}, constants: map[string]phpValue{}, variables: map[string]phpValue{}, included: map[string]bool{}, evaluating: map[string]bool{}, fetched: map[string]fetchedFile{}}
+}
+
+// Evaluate follows the code of the file at the given path, which is what __FILE__ and __DIR__ resolve to.
+func (e *PHPEvaluator) Evaluate(file, contents string) error {
+	tokens, err := tokenizePHP(contents)
+	if err != nil {
+		return err
+	}
+
+	e.included[file] = true
+	e.evaluating[file] = true
+	defer delete(e.evaluating, file)
+	(&phpScope{e: e, file: file, tokens: tokens}).run()
+
+	return nil
+}
+
+// fetch returns the contents of a file with Include, which is only called once for each file.
+func (e *PHPEvaluator) fetch(file string) (string, error) {
+	f, ok := e.fetched[file]
+	if !ok {
+		f.contents, f.err = e.Include(file)
+		e.fetched[file] = f
+	}
+
+	return f.contents, f.err
+}
+
+// Define defines a constant before the file is evaluated, the way WordPress defines ABSPATH before it loads wp-config.php.
+func (e *PHPEvaluator) Define(name, value string) {
+	e.constants[name] = resolved(value)
+}
+
+// Constant returns the value of a constant. The error names the constant, and says why when it can't be resolved.
+func (e *PHPEvaluator) Constant(name string) (string, error) {
+	v, ok := e.constants[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUndefinedConstant, name)
+	}
+	if !v.ok() {
+		return "", fmt.Errorf("%w: %s depends on %s", ErrUnresolvable, name, v.unresolved)
+	}
+
+	return v.value, nil
+}
+
+// ConstantSource returns the file that the value of a constant came from.
+func (e *PHPEvaluator) ConstantSource(name string) string {
+	return e.constants[name].source
+}
+
+// VariableSource returns the file that the value of a global variable came from.
+func (e *PHPEvaluator) VariableSource(name string) string {
+	return e.variables[name].source
+}
+
+// Variable returns the value of a global variable, with its name given without the $.
+func (e *PHPEvaluator) Variable(name string) (string, error) {
+	v, ok := e.variables[name]
+	if !ok || v.null {
+		return "", fmt.Errorf("%w: $%s", ErrUndefinedVariable, name)
+	}
+	if !v.ok() {
+		return "", fmt.Errorf("%w: $%s depends on %s", ErrUnresolvable, name, v.unresolved)
+	}
+
+	return v.value, nil
+}
+
+// phpScope walks the tokens of a single file.
+type phpScope struct {
+	e      *PHPEvaluator
+	file   string
+	tokens []phpToken
+	pos    int
+}
+
+func (s *phpScope) peek(offset int) phpToken {
+	if s.pos+offset >= len(s.tokens) || s.pos+offset < 0 {
+		return phpToken{kind: tokenOperator, value: ";"}
+	}
+	return s.tokens[s.pos+offset]
+}
+
+func (s *phpScope) isOperator(offset int, op string) bool {
+	t := s.peek(offset)
+	return t.kind == tokenOperator && t.value == op
+}
+
+func (s *phpScope) isIdentifier(offset int, name string) bool {
+	t := s.peek(offset)
+	return t.kind == tokenIdentifier && strings.EqualFold(t.value, name)
+}
+
+// statement looks for a definition at the current token, and otherwise moves on to the next one.
+func (s *phpScope) statement() {
+	// Bedrock defines its constants with Config::define(), which works the same as define() for our purposes
+	bedrock := s.isOperator(-1, "::") && s.isIdentifier(-2, "Config")
+
+	// A method or a function of another name is not a definition
+	if s.pos > 0 && !bedrock && (s.isOperator(-1, "->") || s.isOperator(-1, "?->") || s.isOperator(-1, "::") || s.isIdentifier(-1, "function")) {
+		s.pos++
+		return
+	}
+
+	switch {
+	case s.isIdentifier(0, "require") || s.isIdentifier(0, "require_once") || s.isIdentifier(0, "include") || s.isIdentifier(0, "include_once"):
+		s.include()
+	case s.isIdentifier(0, "if") && s.isOperator(1, "("):
+		s.conditional()
+	case s.isIdentifier(0, "define") && s.isOperator(1, "("):
+		s.define()
+	case s.isIdentifier(0, "const") && s.peek(1).kind == tokenIdentifier && s.isOperator(2, "="):
+		s.constant()
+	case s.peek(0).kind == tokenVariable && (s.isOperator(1, "=") || s.isOperator(1, ".=") || s.isOperator(1, "??=")):
+		s.assign()
+	case s.isIdentifier(0, "function") || s.isIdentifier(0, "class"):
+		// Whatever a function or class does is only done when it is called, if at all
+		s.skipBlock()
+	default:
+		s.pos++
+	}
+}
+
+func (s *phpScope) define() {
+	s.pos += 2
+	name := s.expression()
+	if !name.ok() || !s.isOperator(0, ",") {
+		return
+	}
+	s.pos++
+
+	value := s.complete(s.expression(), ",", ")")
+	// A constant can't be redefined, so the first definition is the one that counts
+	if _, ok := s.e.constants[name.value]; !ok {
+		s.e.constants[name.value] = s.sourced(value)
+	}
+}
+
+// ifBranch is a branch of an if statement. The condition of an else branch is nil.
+type ifBranch struct {
+	condition []phpToken
+	body      []phpToken
+}
+
+// conditional follows an if statement. A branch whose condition can be resolved is either followed or skipped, the same as PHP would. When
+// a condition can't be resolved, each of the branches that may run is followed on its own, and any constant or variable that they don't all
+// leave with the same value is unresolved.
+func (s *phpScope) conditional() {
+	line := s.peek(0).line
+
+	var branches []ifBranch
+	certain := false
+	for _, branch := range s.ifStatement() {
+		condition := resolved("1")
+		if branch.condition != nil {
+			condition = s.sub(branch.condition).condition()
+		}
+		if condition.ok() && !condition.truthy() {
+			continue
+		}
+		branches = append(branches, branch)
+		// Nothing after a branch that certainly runs is reached
+		if condition.ok() {
+			certain = true
+			break
+		}
+	}
+	if !certain {
+		// It may be that none of them run
+		branches = append(branches, ifBranch{})
+	}
+	if len(branches) == 1 {
+		s.sub(branches[0].body).run()
+		return
+	}
+
+	constants, variables := s.e.constants, s.e.variables
+	var constantOutcomes, variableOutcomes []map[string]phpValue
+	for _, branch := range branches {
+		s.e.constants, s.e.variables = maps.Clone(constants), maps.Clone(variables)
+		s.sub(branch.body).run()
+		constantOutcomes = append(constantOutcomes, s.e.constants)
+		variableOutcomes = append(variableOutcomes, s.e.variables)
+	}
+
+	reason := fmt.Sprintf("the condition on line %d of %s", line, s.file)
+	s.e.constants = mergeOutcomes(constantOutcomes, reason)
+	s.e.variables = mergeOutcomes(variableOutcomes, reason)
+}
+
+// mergeOutcomes keeps the values that every branch agrees on, and leaves the rest unresolved, including those that only some of the
+// branches set.
+func mergeOutcomes(outcomes []map[string]phpValue, reason string) map[string]phpValue {
+	merged := map[string]phpValue{}
+	for _, outcome := range outcomes {
+		for name := range outcome {
+			if _, ok := merged[name]; ok {
+				continue
+			}
+			first, set := outcomes[0][name]
+			merged[name] = first
+			for _, other := range outcomes[1:] {
+				value, ok := other[name]
+				if ok != set || value.value != first.value || value.null != first.null || value.unresolved != first.unresolved {
+					merged[name] = unresolved(reason)
+					break
+				}
+			}
+		}
+	}
+
+	return merged
+}
+
+// ifStatement reads an if statement, along with its elseif and else branches, in either the usual or the alternative syntax.
+func (s *phpScope) ifStatement() []ifBranch {
+	var branches []ifBranch
+	alternative := false
+	for {
+		// At the if or the elseif
+		s.pos++
+		condition := s.parenthesized()
+		alternative = s.isOperator(0, ":")
+		branches = append(branches, ifBranch{condition, s.branchBody(alternative)})
+		if !s.isIdentifier(0, "elseif") {
+			break
+		}
+	}
+	if s.isIdentifier(0, "else") {
+		s.pos++
+		branches = append(branches, ifBranch{nil, s.branchBody(s.isOperator(0, ":"))})
+	}
+	if alternative && s.isIdentifier(0, "endif") {
+		s.pos++
+		if s.isOperator(0, ";") {
+			s.pos++
+		}
+	}
+
+	return branches
+}
+
+// parenthesized returns the tokens between the parentheses that follow, and moves past them.
+func (s *phpScope) parenthesized() []phpToken {
+	start := s.pos + 1
+	if !s.isOperator(0, "(") {
+		return s.tokens[s.pos:s.pos]
+	}
+
+	depth := 0
+	for ; s.pos < len(s.tokens); s.pos++ {
+		if s.isOperator(0, "(") {
+			depth++
+		} else if s.isOperator(0, ")") {
+			depth--
+			if depth == 0 {
+				s.pos++
+				return s.tokens[start : s.pos-1]
+			}
+		}
+	}
+
+	return s.tokens[start:]
+}
+
+// branchBody returns the tokens of the body of a branch, and moves past them. In the alternative syntax, the body follows a colon and lasts
+// until the next branch or the endif, and otherwise it is a single statement or block.
+func (s *phpScope) branchBody(alternative bool) []phpToken {
+	if !alternative {
+		start := s.pos
+		s.skipStatement()
+		return s.tokens[start:s.pos]
+	}
+
+	s.pos++
+	start := s.pos
+	for s.pos < len(s.tokens) && !s.isIdentifier(0, "elseif") && !s.isIdentifier(0, "else") && !s.isIdentifier(0, "endif") {
+		s.skipStatement()
+	}
+
+	return s.tokens[start:s.pos]
+}
+
+// skipStatement moves past a single statement, which may be a block, or an if statement along with all of its branches.
+func (s *phpScope) skipStatement() {
+	switch {
+	case s.isOperator(0, "{"):
+		s.skipBlock()
+		return
+	case s.isIdentifier(0, "if") && s.isOperator(1, "("):
+		s.ifStatement()
+		return
+	}
+
+	depth := 0
+	for ; s.pos < len(s.tokens); s.pos++ {
+		switch {
+		case s.isOperator(0, "(") || s.isOperator(0, "[") || s.isOperator(0, "{"):
+			depth++
+		case s.isOperator(0, ")") || s.isOperator(0, "]") || s.isOperator(0, "}"):
+			depth--
+			// A block ends the statement, such as the body of a loop
+			if depth <= 0 && s.isOperator(0, "}") {
+				s.pos++
+				return
+			}
+		case s.isOperator(0, ";") && depth <= 0:
+			s.pos++
+			return
+		}
+	}
+}
+
+// sub returns a scope for some of the tokens of this one, such as the body of a branch.
+func (s *phpScope) sub(tokens []phpToken) *phpScope {
+	return &phpScope{e: s.e, file: s.file, tokens: tokens}
+}
+
+// run follows every statement of the scope.
+func (s *phpScope) run() {
+	for s.pos < len(s.tokens) {
+		s.statement()
+	}
+}
+
+// condition evaluates the scope as the condition of an if statement, which has to be a single expression.
+func (s *phpScope) condition() phpValue {
+	value := s.expression()
+	if s.pos < len(s.tokens) {
+		return unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", s.peek(0).line, s.file))
+	}
+
+	return value
+}
+
+// include follows an included file, as long as its path can be resolved and it is part of the site's configuration.
+func (s *phpScope) include() {
+	once := strings.HasSuffix(strings.ToLower(s.peek(0).value), "_once")
+	s.pos++
+
+	file := s.complete(s.expression(), ";", ")")
+	if !file.ok() || file.value == "" || s.e.Include == nil || s.e.depth >= MAX_INCLUDE_DEPTH {
+		return
+	}
+
+	name, contents, err := s.resolveInclude(file.value)
+	// A file that includes itself, or a file that includes it, would include it again and again
+	if err != nil || (once && s.e.included[name]) || s.e.evaluating[name] {
+		return
+	}
+
+	s.e.depth++
+	defer func() { s.e.depth-- }()
+	s.e.Evaluate(name, contents)
+}
+
+// resolveInclude finds and fetches an included file. A relative path is looked for in the working directory first, and then next to the
+// including file, the same way PHP looks for it.
+func (s *phpScope) resolveInclude(file string) (string, string, error) {
+	candidates := []string{file}
+	if !strings.HasPrefix(file, "/") {
+		candidates = []string{path.Join(phpDirname(s.file), file)}
+		if s.e.WorkingDir != "" {
+			candidates = append([]string{path.Join(s.e.WorkingDir, file)}, candidates...)
+		}
+	}
+
+	var err error
+	for _, name := range candidates {
+		if skippedIncludes.MatchString(name) {
+			return name, "", errSkippedInclude
+		}
+		var contents string
+		if contents, err = s.e.fetch(name); err == nil {
+			return name, contents, nil
+		}
+	}
+
+	return "", "", err
+}
+
+// sourced records the current file as the source of the value, unless it already came from elsewhere.
+func (s *phpScope) sourced(value phpValue) phpValue {
+	if value.source == "" {
+		value.source = s.file
+	}
+	return value
+}
+
+func (s *phpScope) constant() {
+	name := s.peek(1).value
+	s.pos += 3
+
+	value := s.complete(s.expression(), ";", ",")
+	if _, ok := s.e.constants[name]; !ok {
+		s.e.constants[name] = s.sourced(value)
+	}
+}
+
+func (s *phpScope) assign() {
+	name, op := s.peek(0).value, s.peek(1).value
+	s.pos += 2
+
+	value := s.complete(s.expression(), ";")
+	current, set := s.e.variables[name]
+	switch {
+	case op == ".=" && !set:
+		value = unresolved(fmt.Sprintf("the undefined variable $%s", name))
+	case op == ".=":
+		value = concat(current, value)
+	case op == "??=" && set && !current.ok():
+		value = current
+	case op == "??=" && set && !current.null:
+		value = current
+	}
+	s.e.variables[name] = s.sourced(value)
+}
+
+// complete makes sure the expression is followed by one of the expected tokens. Otherwise there was more to it than we understand, and
+// its value can't be trusted.
+func (s *phpScope) complete(value phpValue, next ...string) phpValue {
+	for _, op := range next {
+		if s.isOperator(0, op) {
+			return value
+		}
+	}
+
+	return unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", s.peek(0).line, s.file))
+}
+
+// skipBlock skips past the braces that follow, along with everything between them.
+func (s *phpScope) skipBlock() {
+	for s.pos < len(s.tokens) && !s.isOperator(0, "{") {
+		if s.isOperator(0, ";") {
+			return
+		}
+		s.pos++
+	}
+
+	depth := 0
+	for ; s.pos < len(s.tokens); s.pos++ {
+		if s.isOperator(0, "{") {
+			depth++
+		} else if s.isOperator(0, "}") {
+			depth--
+			if depth == 0 {
+				s.pos++
+				return
+			}
+		}
+	}
+}
+
+func (s *phpScope) expression() phpValue {
+	condition := s.coalesce()
+
+	if !s.isOperator(0, "?") {
+		return condition
+	}
+	s.pos++
+
+	// The short ternary a ?: b
+	if s.isOperator(0, ":") {
+		s.pos++
+		otherwise := s.expression()
+		switch {
+		case !condition.ok():
+			return condition
+		case condition.truthy():
+			return condition
+		}
+		return otherwise
+	}
+
+	then := s.expression()
+	if !s.isOperator(0, ":") {
+		return unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", s.peek(0).line, s.file))
+	}
+	s.pos++
+	otherwise := s.expression()
+
+	switch {
+	case !condition.ok():
+		return condition
+	case condition.truthy():
+		return then
+	}
+	return otherwise
+}
+
+func (s *phpScope) coalesce() phpValue {
+	value := s.concatenation()
+
+	if !s.isOperator(0, "??") {
+		return value
+	}
+	s.pos++
+	otherwise := s.coalesce()
+
+	if !value.ok() || !value.null {
+		return value
+	}
+	return otherwise
+}
+
+func (s *phpScope) concatenation() phpValue {
+	value := s.unary()
+	for s.isOperator(0, ".") {
+		s.pos++
+		value = concat(value, s.unary())
+	}
+
+	return value
+}
+
+func concat(a, b phpValue) phpValue {
+	if !a.ok() {
+		return a
+	}
+	if !b.ok() {
+		return b
+	}
+
+	value := resolved(a.value + b.value)
+	value.source = a.source
+	if value.source == "" {
+		value.source = b.source
+	}
+	return value
+}
+
+func (s *phpScope) unary() phpValue {
+	switch {
+	case s.isOperator(0, "@"):
+		// Only silences errors
+		s.pos++
+		return s.unary()
+	case s.isOperator(0, "!"):
+		s.pos++
+		value := s.unary()
+		if !value.ok() {
+			return value
+		}
+		if value.truthy() {
+			return resolved("")
+		}
+		return resolved("1")
+	case s.isOperator(0, "-") && s.peek(1).kind == tokenNumber:
+		s.pos += 2
+		return resolved("-" + s.peek(-1).value)
+	case s.isOperator(0, "(") && s.peek(1).kind == tokenIdentifier && s.isOperator(2, ")") && strings.EqualFold(s.peek(1).value, "string"):
+		// A (string) cast
+		s.pos += 3
+		return s.unary()
+	}
+
+	return s.primary()
+}
+
+func (s *phpScope) primary() phpValue {
+	t := s.peek(0)
+	unsupported := unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", t.line, s.file))
+
+	switch t.kind {
+	case tokenString:
+		s.pos++
+		return resolved(t.value)
+	case tokenTemplate:
+		s.pos++
+		return s.interpolate(t.value)
+	case tokenNumber:
+		s.pos++
+		return resolved(normalizeNumber(t.value))
+	case tokenVariable:
+		s.pos++
+		return s.variable(t.value)
+	case tokenIdentifier:
+		s.pos++
+		if s.isOperator(0, "(") {
+			return s.call(t.value, t.line)
+		}
+		// Bedrock reads its own definitions back with Config::get()
+		if strings.EqualFold(t.value, "Config") && s.isOperator(0, "::") && s.isIdentifier(1, "get") && s.isOperator(2, "(") {
+			s.pos += 2
+			return s.call("constant", t.line)
+		}
+		if s.isOperator(0, "::") {
+			s.pos += 2
+			return unresolved(fmt.Sprintf("the class constant %s::%s", t.value, s.peek(-1).value))
+		}
+		return s.lookupConstant(t.value, t.line)
+	}
+
+	if s.isOperator(0, "(") {
+		s.pos++
+		value := s.expression()
+		if !s.isOperator(0, ")") {
+			return unsupported
+		}
+		s.pos++
+		return value
+	}
+
+	return unsupported
+}
+
+func (s *phpScope) variable(name string) phpValue {
+	if !s.isOperator(0, "[") {
+		value, ok := s.e.variables[name]
+		if !ok {
+			return unresolved(fmt.Sprintf("the undefined variable $%s", name))
+		}
+		return value
+	}
+
+	s.pos++
+	key := s.expression()
+	if !s.isOperator(0, "]") {
+		return unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", s.peek(0).line, s.file))
+	}
+	s.pos++
+	if !key.ok() {
+		return key
+	}
+
+	switch name {
+	case "_ENV":
+		return s.env(key.value, phpNull)
+	case "_SERVER":
+		// Most of $_SERVER comes from the request, so only what the environment holds is known
+		if value, ok := s.e.Env[key.value]; ok {
+			return resolved(value)
+		}
+		return unresolved(fmt.Sprintf("$_SERVER['%s']", key.value))
+	}
+
+	return unresolved(fmt.Sprintf("$%s['%s']", name, key.value))
+}
+
+// env reads an environment variable, which is the fallback when it isn't set.
+func (s *phpScope) env(name string, fallback phpValue) phpValue {
+	if s.e.Env == nil {
+		return unresolved(fmt.Sprintf("the environment variable %s", name))
+	}
+	if value, ok := s.e.Env[name]; ok {
+		return phpValue{value: value, source: s.e.EnvFiles[name]}
+	}
+
+	return fallback
+}
+
+func (s *phpScope) lookupConstant(name string, line int) phpValue {
+	switch strings.ToLower(name) {
+	case "true":
+		return resolved("1")
+	case "false":
+		return resolved("")
+	case "null":
+		return phpNull
+	}
+
+	switch name {
+	case "__FILE__":
+		return resolved(s.file)
+	case "__DIR__":
+		return resolved(phpDirname(s.file))
+	case "__LINE__":
+		return resolved(strconv.Itoa(line))
+	case "DIRECTORY_SEPARATOR":
+		return resolved("/")
+	case "PHP_EOL":
+		return resolved("\n")
+	}
+
+	if value, ok := s.e.constants[name]; ok {
+		return value
+	}
+
+	return unresolved(fmt.Sprintf("the undefined constant %s", name))
+}
+
+// call evaluates a call to one of the few functions that configuration files commonly use.
+func (s *phpScope) call(name string, line int) phpValue {
+	s.pos++
+	var args []phpValue
+	for !s.isOperator(0, ")") {
+		args = append(args, s.expression())
+		if s.isOperator(0, ",") {
+			s.pos++
+		} else if !s.isOperator(0, ")") {
+			return unresolved(fmt.Sprintf("an unsupported expression on line %d of %s", s.peek(0).line, s.file))
+		}
+	}
+	s.pos++
+
+	for _, arg := range args {
+		if !arg.ok() {
+			return arg
+		}
+	}
+	arg := func(i int, fallback string) string {
+		if i < len(args) {
+			return args[i].value
+		}
+		return fallback
+	}
+
+	switch strings.ToLower(name) {
+	case "getenv":
+		// getenv() returns false for a variable that isn't set
+		return s.env(arg(0, ""), resolved(""))
+	case "env":
+		// The env() helper that Bedrock uses, which turns a few special words into what they stand for
+		value := s.env(arg(0, ""), phpNull)
+		switch strings.ToLower(value.value) {
+		case "true", "(true)":
+			value.value = "1"
+		case "false", "(false)", "empty", "(empty)":
+			value.value = ""
+		case "null", "(null)":
+			value = phpNull
+		}
+		if len(args) > 1 && value.null {
+			return args[1]
+		}
+		return value
+	case "getenv_docker":
+		// The helper from the official Docker image, which falls back to its second argument
+		return s.env(arg(0, ""), resolved(arg(1, "")))
+	case "constant":
+		return s.lookupConstant(arg(0, ""), line)
+	case "defined":
+		if _, ok := s.e.constants[arg(0, "")]; ok {
+			return resolved("1")
+		}
+		return resolved("")
+	case "file_exists", "is_file", "is_readable":
+		// A file exists when it can be fetched, which is only known when includes are followed and the path is absolute
+		if s.e.Include == nil || !strings.HasPrefix(arg(0, ""), "/") {
+			return unresolved(fmt.Sprintf("a call to %s() on line %d of %s", name, line, s.file))
+		}
+		if _, err := s.e.fetch(arg(0, "")); err != nil {
+			return resolved("")
+		}
+		return resolved("1")
+	case "dirname":
+		levels, _ := strconv.Atoi(arg(1, "1"))
+		dir := arg(0, "")
+		for i := 0; i < levels; i++ {
+			dir = phpDirname(dir)
+		}
+		return resolved(dir)
+	case "trim":
+		return resolved(strings.Trim(arg(0, ""), arg(1, " \t\n\r\x00\x0B")))
+	case "rtrim":
+		return resolved(strings.TrimRight(arg(0, ""), arg(1, " \t\n\r\x00\x0B")))
+	case "ltrim":
+		return resolved(strings.TrimLeft(arg(0, ""), arg(1, " \t\n\r\x00\x0B")))
+	case "strtolower":
+		return resolved(strings.ToLower(arg(0, "")))
+	case "strtoupper":
+		return resolved(strings.ToUpper(arg(0, "")))
+	}
+
+	return unresolved(fmt.Sprintf("a call to %s() on line %d of %s", name, line, s.file))
+}
+
+// interpolate handles the escapes and simple variables of a double quoted string or heredoc.
+func (s *phpScope) interpolate(raw string) phpValue {
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\\' && i+1 < len(raw):
+			n, value := unescape(raw[i+1:])
+			b.WriteString(value)
+			i += n
+		case c == '$' && i+1 < len(raw) && isIdentifierStart(raw[i+1]):
+			name := identifierPrefix(raw[i+1:])
+			i += len(name)
+			if i+1 < len(raw) && (raw[i+1] == '[' || strings.HasPrefix(raw[i+1:], "->")) {
+				return unresolved(fmt.Sprintf("a string interpolating $%s in %s", name, s.file))
+			}
+			value := s.variable(name)
+			if !value.ok() {
+				return value
+			}
+			b.WriteString(value.value)
+		case (c == '{' && strings.HasPrefix(raw[i+1:], "$")) || (c == '$' && strings.HasPrefix(raw[i+1:], "{")):
+			end := strings.IndexByte(raw[i:], '}')
+			name := strings.Trim(raw[i:max(i, i+end)], "{$")
+			if end == -1 || identifierPrefix(name) != name || name == "" {
+				return unresolved(fmt.Sprintf("a string with complex interpolation in %s", s.file))
+			}
+			value := s.variable(name)
+			if !value.ok() {
+				return value
+			}
+			b.WriteString(value.value)
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return resolved(b.String())
+}
+
+// unescape handles a single escape sequence in a double quoted string, given what follows the backslash. It returns how many bytes it
+// used, and what they stand for.
+func unescape(s string) (int, string) {
+	switch s[0] {
+	case 'n':
+		return 1, "\n"
+	case 't':
+		return 1, "\t"
+	case 'r':
+		return 1, "\r"
+	case 'v':
+		return 1, "\v"
+	case 'e':
+		return 1, "\x1b"
+	case 'f':
+		return 1, "\f"
+	case '\\', '$', '"':
+		return 1, s[:1]
+	case 'x':
+		n := 1
+		for n < 3 && n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) != -1 {
+			n++
+		}
+		if n > 1 {
+			v, _ := strconv.ParseUint(s[1:n], 16, 8)
+			return n, string([]byte{byte(v)})
+		}
+	case 'u':
+		if end := strings.IndexByte(s, '}'); strings.HasPrefix(s, "u{") && end != -1 {
+			if v, err := strconv.ParseUint(s[2:end], 16, 32); err == nil {
+				return end + 1, string(rune(v))
+			}
+		}
+	}
+	if s[0] >= '0' && s[0] <= '7' {
+		n := 1
+		for n < 3 && n < len(s) && s[n] >= '0' && s[n] <= '7' {
+			n++
+		}
+		v, _ := strconv.ParseUint(s[:n], 8, 16)
+		return n, string([]byte{byte(v)})
+	}
+
+	// Anything else is not an escape, so the backslash stays
+	return 0, "\\"
+}
+
+func identifierPrefix(s string) string {
+	for i := 0; i < len(s); i++ {
+		if !isIdentifierChar(s[i]) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// normalizeNumber returns a number the way PHP would turn it into a string, for the simple cases.
+func normalizeNumber(raw string) string {
+	raw = strings.ReplaceAll(raw, "_", "")
+	if v, err := strconv.ParseInt(raw, 0, 64); err == nil {
+		return strconv.FormatInt(v, 10)
+	}
+
+	return raw
+}
+
+// phpDirname works like PHP's dirname(), which ignores a trailing slash.
+func phpDirname(p string) string {
+	if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+		p = trimmed
+	}
+	if !strings.Contains(p, "/") {
+		return "."
+	}
+
+	return path.Dir(p)
+}
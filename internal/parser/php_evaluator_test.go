@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPHPEvaluator(t *testing.T) {
+	t.Run("it resolves the value of constants", func(t *testing.T) {
+		var tests = []struct {
+			name     string
+			contents string
+			env      map[string]string
+			want     string
+		}{
+			{"single quotes", `define('X', 'a\'b\\c\n');`, nil, `a'b\c\n`},
+			{"double quote escapes", `define('X', "a\tb\x41\101\u{1F600}\$x\q");`, nil, "a\tbAA\U0001F600$x\\q"},
+			{"concatenation", `define('A', 'a'); define('X', A . '-' . "b" . 1);`, nil, "a-b1"},
+			{"const", `const A = 'a', B = 'b'; define('X', A);`, nil, "a"},
+			{"first definition wins", `if (!defined('X')) { define('X', 'first'); } define('X', 'second');`, nil, "first"},
+			{"magic constants", `define('X', dirname(__FILE__) . DIRECTORY_SEPARATOR . __LINE__);`, nil, "/var/www/html/2"},
+			{"dir", `define('X', __DIR__ . '/wp-content');`, nil, "/var/www/html/wp-content"},
+			{"dirname levels", `define('X', dirname(__DIR__, 2));`, nil, "/var"},
+			{"getenv", `define('X', getenv('DB_HOST'));`, map[string]string{"DB_HOST": "db"}, "db"},
+			{"getenv fallback", `define('X', getenv('DB_HOST') ?: 'localhost');`, map[string]string{}, "localhost"},
+			{"env fallback", `define('X', $_ENV['DB_HOST'] ?? 'localhost');`, map[string]string{}, "localhost"},
+			{"docker fallback", `define('X', getenv_docker('WORDPRESS_DB_HOST', 'mysql'));`, map[string]string{}, "mysql"},
+			{"ternary", `define('X', defined('Y') ? 'y' : 'no y');`, nil, "no y"},
+			{"silenced and namespaced", `\define('X', @\getenv('A'));`, map[string]string{"A": "a"}, "a"},
+			{"nowdoc", "define('X', <<<'EOT'\n  a\n   \\n\n  EOT\n);", nil, "a\n \\n"},
+			{"heredoc", "$v = 'x';\ndefine('X', <<<EOT\n$v\\t{$v}\nEOT);", nil, "x\tx"},
+			{"closing tag ends a statement", `define('X', 'a') ?>`, nil, "a"},
+			{"function bodies are skipped", `function setup() { define('X', 'inside'); } define('X', 'outside');`, nil, "outside"},
+			{"bedrock config", `Config::define('A', 'a'); define('X', Config::get('A') . '/b');`, nil, "a/b"},
+			{"methods are not define", `$config->define('X', 'method'); define('X', 'function');`, nil, "function"},
+			{"condition that holds", `if (getenv('A')) define('X', 'a'); else define('X', 'b');`, map[string]string{"A": "1"}, "a"},
+			{"condition that fails", `if (getenv('A')) define('X', 'a'); else define('X', 'b');`, map[string]string{}, "b"},
+			{"elseif", `if (getenv('A')) { define('X', 'a'); } elseif (getenv('B')) { define('X', 'b'); } else if (getenv('C')) { define('X', 'c'); } else { define('X', 'd'); }`, map[string]string{"C": "1"}, "c"},
+			{"alternative syntax", "if (getenv('A')):\n  define('X', 'a');\nelseif (true):\n  if (true) { $b = 'b'; }\n  define('X', $b);\nelse:\n  define('X', 'c');\nendif;", map[string]string{}, "b"},
+			{"branches that agree", `if (isset($_SERVER['HTTPS'])) { define('X', 'same'); } else { define('X', 'same'); }`, nil, "same"},
+			{"unknown condition after the definition", `define('X', 'first'); if (getenv('A')) { define('X', 'second'); }`, nil, "first"},
+			{"unknown condition on another constant", `if (getenv('A')) { define('Y', 'y'); } define('X', 'x');`, nil, "x"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				e := NewPHPEvaluator(tt.env)
+				if err := e.Evaluate("/var/www/html/wp-config.php", "<html><?php\n"+tt.contents); err != nil {
+					t.Fatalf("got error %v; want nil", err)
+				}
+
+				got, err := e.Constant("X")
+
+				if err != nil {
+					t.Fatalf("got error %v; want nil", err)
+				}
+				if got != tt.want {
+					t.Errorf("got %q; want %q", got, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("it explains why a constant cannot be resolved", func(t *testing.T) {
+		var tests = []struct {
+			name     string
+			contents string
+			want     string
+		}{
+			{"unknown environment", `define('X', getenv('DB_HOST') ?: 'localhost');`, "X depends on the environment variable DB_HOST"},
+			{"request", `define('X', 'https://' . $_SERVER['HTTP_HOST']);`, "X depends on $_SERVER['HTTP_HOST']"},
+			{"unknown function", `define('X', secret('db'));`, "X depends on a call to secret() on line 2"},
+			{"undefined constant", `define('X', ABSPATH . 'wp-content');`, "X depends on the undefined constant ABSPATH"},
+			{"undefined variable", `define('X', $password);`, "X depends on the undefined variable $password"},
+			{"unsupported expression", `define('X', 1 + 2);`, "X depends on an unsupported expression on line 2"},
+			{"class constant", `define('X', Config::PASSWORD);`, "X depends on the class constant Config::PASSWORD"},
+			{"branches that disagree", `if (getenv('A')) define('X', 'a'); else define('X', 'b');`, "X depends on the condition on line 2 of /var/www/html/wp-config.php"},
+			{"branch that may not run", "$prefix = 'wp_';\nif (getenv('A')) {\n  define('X', 'a');\n}", "X depends on the condition on line 3"},
+			{"unknown condition before the definition", `if (isset($_SERVER['HTTP_HOST'])) { define('X', 'web'); } define('X', 'cli');`, "X depends on the condition on line 2"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				e := NewPHPEvaluator(nil)
+				_ = e.Evaluate("/var/www/html/wp-config.php", "<?php\n"+tt.contents)
+
+				_, err := e.Constant("X")
+
+				if !errors.Is(err, ErrUnresolvable) || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("got error %v; want ErrUnresolvable containing %s", err, tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("it reports the name of a constant whose definition depends on a condition", func(t *testing.T) {
+		e := NewPHPEvaluator(nil)
+		_ = e.Evaluate("/var/www/html/wp-config.php", "<?php\nif (getenv('X')) define('DB_HOST','a'); else define('DB_HOST','b');\n$table_prefix = 'wp_';")
+
+		_, err := e.Constant("DB_HOST")
+
+		if !errors.Is(err, ErrUnresolvable) || !strings.Contains(err.Error(), "DB_HOST depends on the condition on line 2") {
+			t.Errorf("got error %v; want ErrUnresolvable naming DB_HOST", err)
+		}
+		if got, _ := e.Variable("table_prefix"); got != "wp_" {
+			t.Errorf("got %q; want the code after the condition to be followed", got)
+		}
+
+		e = NewPHPEvaluator(map[string]string{"X": "1"})
+		_ = e.Evaluate("/var/www/html/wp-config.php", "<?php\nif (getenv('X')) define('DB_HOST','a'); else define('DB_HOST','b');")
+
+		if got, _ := e.Constant("DB_HOST"); got != "a" {
+			t.Errorf("got %q; want a, since the environment is known", got)
+		}
+	})
+
+	t.Run("it follows variable assignments", func(t *testing.T) {
+		e := NewPHPEvaluator(nil)
+		_ = e.Evaluate("wp-config.php", `<?php
+$table_prefix = 'wp';
+$table_prefix .= '_';
+$other ??= 'set';
+$other ??= 'ignored';
+`)
+
+		if got, _ := e.Variable("table_prefix"); got != "wp_" {
+			t.Errorf("got %q; want wp_", got)
+		}
+		if got, _ := e.Variable("other"); got != "set" {
+			t.Errorf("got %q; want set", got)
+		}
+		if _, err := e.Variable("missing"); !errors.Is(err, ErrUndefinedVariable) {
+			t.Errorf("got error %v; want ErrUndefinedVariable", err)
+		}
+		if _, err := e.Constant("MISSING"); !errors.Is(err, ErrUndefinedConstant) {
+			t.Errorf("got error %v; want ErrUndefinedConstant", err)
+		}
+	})
+
+	t.Run("it fetches each included file once, and stops when a file includes itself again", func(t *testing.T) {
+		files := map[string]string{
+			"/site/common.php":   "<?php\n$count .= 'c';\ninclude __DIR__ . '/settings.php';\n",
+			"/site/settings.php": "<?php\ndefine('X', 'settings');\ninclude __DIR__ . '/common.php';\ninclude __FILE__;\n",
+		}
+		fetches := map[string]int{}
+		e := NewPHPEvaluator(nil)
+		e.Include = func(file string) (string, error) {
+			fetches[file]++
+			contents, ok := files[file]
+			if !ok {
+				return "", errors.New("not found")
+			}
+			return contents, nil
+		}
+
+		_ = e.Evaluate("/site/wp-config.php", "<?php\n$count = '';\ninclude 'common.php';\ninclude 'common.php';\ninclude 'missing.php';\ninclude 'missing.php';\n")
+
+		if got, _ := e.Constant("X"); got != "settings" {
+			t.Errorf("got %q; want settings", got)
+		}
+		if got, _ := e.Variable("count"); got != "cc" {
+			t.Errorf("got %q; want common.php to be followed each time it is included", got)
+		}
+		for file, count := range fetches {
+			if count != 1 {
+				t.Errorf("got %s fetched %d times; want once", file, count)
+			}
+		}
+	})
+
+	t.Run("it looks for relative includes in the working directory first, then next to the including file", func(t *testing.T) {
+		files := map[string]string{
+			"/srv/www/local.php":        "<?php\ndefine('DB_USER', 'webroot');\n",
+			"/srv/config/local.php":     "<?php\ndefine('DB_USER', 'config');\n",
+			"/srv/config/secrets.php":   "<?php\ndefine('DB_PASSWORD', 'secret');\n",
+			"/srv/www/wp-settings.php":  "<?php\ndefine('DB_NAME', 'wordpress');\n",
+			"/srv/config/wp-config.php": "<?php\ninclude 'local.php';\ninclude 'secrets.php';\nrequire_once 'wp-settings.php';\n",
+		}
+		e := NewPHPEvaluator(nil)
+		e.WorkingDir = "/srv/www/"
+		e.Include = func(file string) (string, error) {
+			contents, ok := files[file]
+			if !ok {
+				return "", errors.New("not found")
+			}
+			return contents, nil
+		}
+
+		_ = e.Evaluate("/srv/config/wp-config.php", files["/srv/config/wp-config.php"])
+
+		if got, _ := e.Constant("DB_USER"); got != "webroot" {
+			t.Errorf("got %q; want the local.php of the working directory", got)
+		}
+		if got, _ := e.Constant("DB_PASSWORD"); got != "secret" {
+			t.Errorf("got %q; want the secrets.php next to wp-config.php", got)
+		}
+		if _, err := e.Constant("DB_NAME"); !errors.Is(err, ErrUndefinedConstant) {
+			t.Errorf("got error %v; want wp-settings.php never to be followed", err)
+		}
+	})
+
+	t.Run("it returns an error for php it cannot tokenize", func(t *testing.T) {
+		for _, contents := range []string{"<?php define('X', 'unterminated);", `<?php define("X", "unterminated);`, "<?php /* unterminated", "<?php $x = <<<EOT\nunterminated"} {
+			if err := NewPHPEvaluator(nil).Evaluate("wp-config.php", contents); !errors.Is(err, ErrInvalidPHP) {
+				t.Errorf("got error %v; want ErrInvalidPHP for %s", err, contents)
+			}
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidPHP = errors.New("invalid php")
+
+const (
+	tokenIdentifier = iota
+	tokenVariable
+	// tokenString is a string whose value is already known, which is a single quoted string or a nowdoc
+	tokenString
+	// tokenTemplate is the raw contents of a double quoted string or a heredoc, which still need their escapes and variables handled
+	tokenTemplate
+	tokenNumber
+	tokenOperator
+)
+
+type phpToken struct {
+	kind  int
+	value string
+	line  int
+}
+
+// The operators that are longer than a single character, longest first so that the longest one always matches.
+var phpOperators = []string{
+	"<<=", ">>=", "**=", "...", "<=>", "===", "!==", "??=", "?->",
+	"**", "++", "--", "->", "=>", "::", "==", "!=", "<>", "<=", ">=", "&&", "||", "??", ".=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<", ">>",
+}
+
+// tokenizePHP splits PHP source into tokens. Everything outside of the PHP tags and every comment is left out, and a closing tag counts as
+// the end of a statement. It only needs to be good enough for a configuration file, so casts and the like come out as plain operators.
+func tokenizePHP(contents string) ([]phpToken, error) {
+	t := &phpTokenizer{src: contents, line: 1}
+	if err := t.tokenize(); err != nil {
+		return nil, err
+	}
+
+	return t.tokens, nil
+}
+
+type phpTokenizer struct {
+	src    string
+	pos    int
+	line   int
+	tokens []phpToken
+}
+
+func (t *phpTokenizer) tokenize() error {
+	for t.pos < len(t.src) {
+		if !t.skipInlineHTML() {
+			return nil
+		}
+		if err := t.tokenizeCode(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipInlineHTML skips ahead to the next opening tag, and reports whether there is one.
+func (t *phpTokenizer) skipInlineHTML() bool {
+	i := strings.Index(t.src[t.pos:], "<?")
+	if i == -1 {
+		t.advance(len(t.src) - t.pos)
+		return false
+	}
+	t.advance(i + 2)
+	if strings.HasPrefix(strings.ToLower(t.src[t.pos:]), "php") {
+		t.advance(3)
+	} else if strings.HasPrefix(t.src[t.pos:], "=") {
+		t.advance(1)
+	}
+
+	return true
+}
+
+// tokenizeCode tokenizes until the closing tag or the end of the file.
+func (t *phpTokenizer) tokenizeCode() error {
+	for t.pos < len(t.src) {
+		rest := t.src[t.pos:]
+		c := rest[0]
+		line := t.line
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			t.advance(1)
+		case strings.HasPrefix(rest, "?>"):
+			t.advance(2)
+			t.emit(tokenOperator, ";", line)
+			return nil
+		case strings.HasPrefix(rest, "//") || c == '#':
+			t.skipLineComment()
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end == -1 {
+				return fmt.Errorf("%w: unterminated comment on line %d", ErrInvalidPHP, line)
+			}
+			t.advance(end + 4)
+		case c == '\'':
+			value, err := t.singleQuoted()
+			if err != nil {
+				return err
+			}
+			t.emit(tokenString, value, line)
+		case c == '"':
+			value, err := t.doubleQuoted()
+			if err != nil {
+				return err
+			}
+			t.emit(tokenTemplate, value, line)
+		case strings.HasPrefix(rest, "<<<"):
+			kind, value, err := t.heredoc()
+			if err != nil {
+				return err
+			}
+			t.emit(kind, value, line)
+		case c == '$' && len(rest) > 1 && isIdentifierStart(rest[1]):
+			t.advance(1)
+			t.emit(tokenVariable, t.identifier(), line)
+		case isIdentifierStart(c) || (c == '\\' && len(rest) > 1 && isIdentifierStart(rest[1])):
+			name := t.identifier()
+			// Only the last part of a namespaced name matters, so that \define() is still define()
+			if i := strings.LastIndex(name, "\\"); i != -1 {
+				name = name[i+1:]
+			}
+			t.emit(tokenIdentifier, name, line)
+		case c >= '0' && c <= '9' || (c == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9'):
+			t.emit(tokenNumber, t.number(), line)
+		default:
+			op := string(c)
+			for _, candidate := range phpOperators {
+				if strings.HasPrefix(rest, candidate) {
+					op = candidate
+					break
+				}
+			}
+			t.advance(len(op))
+			t.emit(tokenOperator, op, line)
+		}
+	}
+
+	return nil
+}
+
+func (t *phpTokenizer) emit(kind int, value string, line int) {
+	t.tokens = append(t.tokens, phpToken{kind, value, line})
+}
+
+func (t *phpTokenizer) advance(n int) {
+	t.line += strings.Count(t.src[t.pos:t.pos+n], "\n")
+	t.pos += n
+}
+
+// skipLineComment skips to the end of the line, or to a closing tag, which also ends a line comment.
+func (t *phpTokenizer) skipLineComment() {
+	rest := t.src[t.pos:]
+	end := len(rest)
+	if i := strings.IndexByte(rest, '\n'); i != -1 {
+		end = i
+	}
+	if i := strings.Index(rest[:end], "?>"); i != -1 {
+		end = i
+	}
+	t.advance(end)
+}
+
+func (t *phpTokenizer) identifier() string {
+	start := t.pos
+	for t.pos < len(t.src) && (isIdentifierChar(t.src[t.pos]) || (t.src[t.pos] == '\\' && t.pos+1 < len(t.src) && isIdentifierStart(t.src[t.pos+1]))) {
+		t.pos++
+	}
+
+	return t.src[start:t.pos]
+}
+
+func (t *phpTokenizer) number() string {
+	start := t.pos
+	for t.pos < len(t.src) && (isIdentifierChar(t.src[t.pos]) || t.src[t.pos] == '.') {
+		t.pos++
+	}
+
+	return t.src[start:t.pos]
+}
+
+// singleQuoted reads a single quoted string, in which only \' and \\ are escapes.
+func (t *phpTokenizer) singleQuoted() (string, error) {
+	line := t.line
+	var b strings.Builder
+	for i := t.pos + 1; i < len(t.src); i++ {
+		switch c := t.src[i]; {
+		case c == '\\' && i+1 < len(t.src) && (t.src[i+1] == '\'' || t.src[i+1] == '\\'):
+			b.WriteByte(t.src[i+1])
+			i++
+		case c == '\'':
+			t.advance(i + 1 - t.pos)
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("%w: unterminated string on line %d", ErrInvalidPHP, line)
+}
+
+// doubleQuoted reads the raw contents of a double quoted string. The escapes are handled when it is evaluated, along with its variables.
+func (t *phpTokenizer) doubleQuoted() (string, error) {
+	line := t.line
+	for i := t.pos + 1; i < len(t.src); i++ {
+		switch t.src[i] {
+		case '\\':
+			i++
+		case '"':
+			value := t.src[t.pos+1 : i]
+			t.advance(i + 1 - t.pos)
+			return value, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: unterminated string on line %d", ErrInvalidPHP, line)
+}
+
+// heredoc reads a heredoc or a nowdoc. The closing identifier may be indented, in which case that indentation is removed from every line.
+func (t *phpTokenizer) heredoc() (int, string, error) {
+	line := t.line
+	rest := t.src[t.pos+3:]
+	header, body, ok := strings.Cut(rest, "\n")
+	if !ok {
+		return 0, "", fmt.Errorf("%w: unterminated heredoc on line %d", ErrInvalidPHP, line)
+	}
+
+	kind := tokenTemplate
+	label := strings.TrimSpace(strings.TrimSuffix(header, "\r"))
+	if strings.HasPrefix(label, "'") && strings.HasSuffix(label, "'") && len(label) > 1 {
+		kind = tokenString
+		label = label[1 : len(label)-1]
+	} else {
+		label = strings.Trim(label, `"`)
+	}
+	if label == "" {
+		return 0, "", fmt.Errorf("%w: heredoc without a label on line %d", ErrInvalidPHP, line)
+	}
+
+	lines := strings.SplitAfter(body, "\n")
+	offset := len(t.src) - len(body)
+	for i, l := range lines {
+		trimmed := strings.TrimLeft(l, " \t")
+		if !strings.HasPrefix(trimmed, label) || (len(trimmed) > len(label) && isIdentifierChar(trimmed[len(label)])) {
+			offset += len(l)
+			continue
+		}
+
+		indent := len(l) - len(trimmed)
+		var value []string
+		for _, bodyLine := range lines[:i] {
+			if len(bodyLine) >= indent && strings.TrimLeft(bodyLine[:indent], " \t") == "" {
+				bodyLine = bodyLine[indent:]
+			}
+			value = append(value, bodyLine)
+		}
+		// The newline before the closing identifier is not part of the string
+		contents := strings.TrimSuffix(strings.TrimSuffix(strings.Join(value, ""), "\n"), "\r")
+
+		t.advance(offset + indent + len(label) - t.pos)
+		return kind, contents, nil
+	}
+
+	return 0, "", fmt.Errorf("%w: unterminated heredoc on line %d", ErrInvalidPHP, line)
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
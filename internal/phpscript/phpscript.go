@@ -0,0 +1,260 @@
+package phpscript
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"github.com/jfortunato/wp-zip/internal/verbose"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TokenHeader is the request header that must carry the deployment token. PHP exposes it to the script as $_SERVER['HTTP_X_WP_ZIP_TOKEN'].
+const TokenHeader = "X-WP-Zip-Token"
+
+// DefaultTTL is how long an uploaded script will agree to run, measured from its own modification time on the server.
+const DefaultTTL = 5 * time.Minute
+
+var (
+	ErrCouldNotCreateDirectory = errors.New("could not create helper directory")
+	ErrCouldNotUploadFile      = errors.New("could not upload helper file")
+	ErrCouldNotDeleteFile      = errors.New("could not delete helper file")
+	ErrNoOutput                = errors.New("helper script produced no output")
+)
+
+type HttpGetter interface {
+	Get(url string, header http.Header) (resp io.ReadCloser, err error)
+}
+
+// Deployer uploads PHP helper scripts to the server. Every deployment gets its own unguessable directory under the public path, and the entry
+// script inside it refuses to run unless the request carries a one-time token and the script is younger than its TTL.
+type Deployer struct {
+	u          sftp.FileUploadDeleter
+	publicPath types.PublicPath
+	ttl        time.Duration
+	random     func() string
+}
+
+// NewDeployer is the constructor for Deployer. The random function generates the directory name, file name and token, and should
+// almost always be RandomName.
+func NewDeployer(u sftp.FileUploadDeleter, publicPath types.PublicPath, random func() string) *Deployer {
+	return &Deployer{u, publicPath, DefaultTTL, random}
+}
+
+// Deployment is a helper script that has been uploaded to the server. It must always be removed once the caller is done with it.
+type Deployment struct {
+	u sftp.FileUploadDeleter
+	// dir is the directory name relative to the public path, which is also its path relative to the site url.
+	dir        string
+	publicPath types.PublicPath
+	entry      string
+	token      string
+	uploaded   []string
+}
+
+// Deploy creates the deployment directory and uploads the entry script along with any support files it needs. The script must begin with
+// an opening `<?php` tag; the token and expiry checks are inserted directly after it. If any part of the upload fails, whatever was
+// already uploaded is removed again.
+func (d *Deployer) Deploy(script string, support map[string]io.Reader) (*Deployment, error) {
+	deployment := &Deployment{
+		u:          d.u,
+		dir:        "wp-zip-" + d.random(),
+		publicPath: d.publicPath,
+		entry:      d.random() + ".php",
+		token:      d.random(),
+	}
+
+	if err := d.u.Mkdir(deployment.path("")); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCouldNotCreateDirectory, err)
+	}
+
+	// The .htaccess goes up first so that nothing is ever reachable before it is in place, and the entry script goes up last so
+	// that it never runs without its support files
+	names := []string{".htaccess"}
+	files := map[string]io.Reader{".htaccess": strings.NewReader(htaccess(deployment.entry))}
+	for _, name := range sortedKeys(support) {
+		names = append(names, name)
+		files[name] = support[name]
+	}
+	names = append(names, deployment.entry)
+	files[deployment.entry] = strings.NewReader(guard(script, deployment.token, d.ttl))
+
+	for _, name := range names {
+		if err := d.u.Upload(files[name], deployment.path(name)); err != nil {
+			deployment.Remove()
+			return nil, fmt.Errorf("%w: %s", ErrCouldNotUploadFile, err)
+		}
+		deployment.uploaded = append(deployment.uploaded, name)
+	}
+
+	return deployment, nil
+}
+
+// Get runs the deployed script by requesting it over HTTP with the deployment token attached.
+func (d *Deployment) Get(g HttpGetter, siteUrl types.SiteUrl) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set(TokenHeader, d.token)
+
+	return g.Get(d.Url(siteUrl), header)
+}
+
+// CanRunCli reports whether the PHP CLI can be run over SSH, which lets a deployed script be run without going through the web server.
+func CanRunCli(runner sftp.RemoteCommandRunner) bool {
+	return runner.CanRunRemoteCommand(capabilities.Php.Command)
+}
+
+// Run runs the deployed script with the PHP CLI over SSH, and streams its output. The CLI exposes its environment to the script in $_SERVER,
+// so the server values are passed in as environment variables to stand in for what the web server would set. Run waits until the script
+// starts writing, so an error before that is returned here, and the deployment can already be removed once Run returns.
+func (d *Deployment) Run(runner sftp.RemoteCommandRunner, server map[string]string) (io.Reader, error) {
+	var env []string
+	for _, name := range sortedKeys(server) {
+		env = append(env, name+"="+sftp.ShellQuote(server[name]))
+	}
+	// Errors go to stderr, where they can't end up in the output
+	cmd := strings.Join(append(env, "php -d display_errors=stderr "+sftp.ShellQuote(d.path(d.entry))), " ")
+
+	output, err := runner.RunRemoteCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(output)
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			err = ErrNoOutput
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Execute runs the deployed script with the PHP CLI when it can be run over SSH, and falls back to requesting it over HTTP otherwise. The
+// HTTP request depends on the site url resolving to this server and on nothing in front of it getting in the way, so it is only the
+// fallback.
+func (d *Deployment) Execute(runner sftp.RemoteCommandRunner, g HttpGetter, siteUrl types.SiteUrl, server map[string]string) (io.ReadCloser, error) {
+	if CanRunCli(runner) {
+		output, err := d.Run(runner, server)
+		if err == nil {
+			verbose.Printf("ran %s with the PHP CLI", d.entry)
+			return io.NopCloser(output), nil
+		}
+		verbose.Printf("could not run %s with the PHP CLI, requesting it over HTTP instead: %s", d.entry, err)
+	}
+
+	return d.Get(g, siteUrl)
+}
+
+// Url returns the public url of the entry script.
+func (d *Deployment) Url(siteUrl types.SiteUrl) string {
+	return string(siteUrl) + "/" + d.dir + "/" + d.entry
+}
+
+// Remove deletes every uploaded file, the marker written by the script when it ran, and finally the directory itself.
+func (d *Deployment) Remove() error {
+	var err error
+	for _, name := range d.uploaded {
+		if errD := d.u.Delete(d.path(name)); errD != nil && err == nil {
+			err = fmt.Errorf("%w: %s", ErrCouldNotDeleteFile, errD)
+		}
+	}
+	// The marker only exists if the script was actually run, so a failure here is expected
+	d.u.Delete(d.path(markerFilename))
+
+	if errD := d.u.Delete(d.path("")); errD != nil && err == nil {
+		err = fmt.Errorf("%w: %s", ErrCouldNotDeleteFile, errD)
+	}
+
+	return err
+}
+
+func (d *Deployment) path(name string) string {
+	if name == "" {
+		return d.publicPath.String() + d.dir
+	}
+	return d.publicPath.String() + d.dir + "/" + name
+}
+
+// Quote returns the value as a single quoted PHP string literal.
+func Quote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// RandomName returns an unguessable name that is safe to use in both paths and urls.
+func RandomName() string {
+	return RandomString(16)
+}
+
+// RandomString returns n random bytes from crypto/rand, hex encoded.
+func RandomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// markerFilename is created by the entry script the first time it runs, which is what makes the token single use.
+const markerFilename = ".used"
+
+// guard inserts the token, expiry and single use checks at the top of the script. A script run with the PHP CLI only has to be younger than
+// its TTL.
+func guard(script, token string, ttl time.Duration) string {
+	checks := fmt.Sprintf(`
+if (time() - filemtime(__FILE__) > %d) {
+    http_response_code(410);
+    exit(1);
+}
+// Only someone who is already logged in to the server can run the CLI, so only requests need the token
+if (PHP_SAPI !== 'cli') {
+    $wpZipToken = isset($_SERVER['HTTP_X_WP_ZIP_TOKEN']) ? $_SERVER['HTTP_X_WP_ZIP_TOKEN'] : '';
+    if (!hash_equals('%s', $wpZipToken)) {
+        http_response_code(403);
+        exit(1);
+    }
+    $wpZipMarker = @fopen(__DIR__ . '/%s', 'x');
+    if ($wpZipMarker === false) {
+        http_response_code(403);
+        exit(1);
+    }
+    fclose($wpZipMarker);
+}
+`, int(ttl.Seconds()), token, markerFilename)
+
+	return "<?php\n" + checks + strings.TrimPrefix(script, "<?php")
+}
+
+// htaccess denies access to everything in the deployment directory except the entry script.
+func htaccess(entry string) string {
+	return fmt.Sprintf(`<IfModule mod_authz_core.c>
+    Require all denied
+    <Files "%[1]s">
+        Require all granted
+    </Files>
+</IfModule>
+<IfModule !mod_authz_core.c>
+    Order deny,allow
+    Deny from all
+    <Files "%[1]s">
+        Allow from all
+    </Files>
+</IfModule>
+`, entry)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
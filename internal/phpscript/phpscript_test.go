@@ -0,0 +1,236 @@
+package phpscript
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeployer_Deploy(t *testing.T) {
+	t.Run("it uploads the script into its own protected directory", func(t *testing.T) {
+		u := &UploaderSpy{}
+
+		_, err := newDeployer(u).Deploy("<?php\necho 'hello';\n", map[string]io.Reader{"Support.php": strings.NewReader("support")})
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+
+		if len(u.dirs) != 1 || u.dirs[0] != "/var/www/html/wp-zip-abc" {
+			t.Errorf("got dirs %v; want [/var/www/html/wp-zip-abc]", u.dirs)
+		}
+
+		// The .htaccess must go up first, and the entry script last
+		want := []string{"/var/www/html/wp-zip-abc/.htaccess", "/var/www/html/wp-zip-abc/Support.php", "/var/www/html/wp-zip-abc/abc.php"}
+		if strings.Join(u.order, ",") != strings.Join(want, ",") {
+			t.Errorf("got uploads %v; want %v", u.order, want)
+		}
+
+		if !strings.Contains(u.uploads["/var/www/html/wp-zip-abc/.htaccess"], `<Files "abc.php">`) {
+			t.Errorf("got .htaccess %s; want it to allow only the entry script", u.uploads["/var/www/html/wp-zip-abc/.htaccess"])
+		}
+	})
+
+	t.Run("the entry script checks the token and ttl before running", func(t *testing.T) {
+		u := &UploaderSpy{}
+
+		_, _ = newDeployer(u).Deploy("<?php\necho 'hello';\n", nil)
+
+		script := u.uploads["/var/www/html/wp-zip-abc/abc.php"]
+
+		for _, want := range []string{"filemtime(__FILE__) > 300", "PHP_SAPI !== 'cli'", "hash_equals('abc', $wpZipToken)", "fopen(__DIR__ . '/.used', 'x')"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("got script %s; want it to contain %s", script, want)
+			}
+		}
+
+		if !strings.HasPrefix(script, "<?php\n") || strings.Count(script, "<?php") != 1 || !strings.HasSuffix(script, "echo 'hello';\n") {
+			t.Errorf("got script %s; want the checks inserted after the opening tag", script)
+		}
+	})
+
+	t.Run("it removes everything again if an upload fails", func(t *testing.T) {
+		u := &UploaderSpy{uploadErrorStub: errors.New("error upload")}
+
+		_, err := newDeployer(u).Deploy("<?php", nil)
+
+		if !errors.Is(err, ErrCouldNotUploadFile) {
+			t.Errorf("got error %v; want ErrCouldNotUploadFile", err)
+		}
+
+		if len(u.deleted) == 0 || u.deleted[len(u.deleted)-1] != "/var/www/html/wp-zip-abc" {
+			t.Errorf("got deleted %v; want the directory to be removed", u.deleted)
+		}
+	})
+}
+
+func TestDeployment(t *testing.T) {
+	t.Run("it requests the script with the token attached", func(t *testing.T) {
+		deployment, _ := newDeployer(&UploaderSpy{}).Deploy("<?php", nil)
+		g := &HttpGetterSpy{}
+
+		_, _ = deployment.Get(g, "https://example.com")
+
+		if g.url != "https://example.com/wp-zip-abc/abc.php" {
+			t.Errorf("got url %s; want https://example.com/wp-zip-abc/abc.php", g.url)
+		}
+		if g.header.Get(TokenHeader) != "abc" {
+			t.Errorf("got token %s; want abc", g.header.Get(TokenHeader))
+		}
+	})
+
+	t.Run("it runs the script with the php cli, passing the server values in the environment", func(t *testing.T) {
+		deployment, _ := newDeployer(&UploaderSpy{}).Deploy("<?php", nil)
+		runner := &CommandRunnerStub{map[string]string{
+			"HTTPS='on' SERVER_SOFTWARE='nginx/1.24.0' php -d display_errors=stderr '/var/www/html/wp-zip-abc/abc.php'": "output",
+		}}
+
+		r, err := deployment.Run(runner, map[string]string{"SERVER_SOFTWARE": "nginx/1.24.0", "HTTPS": "on"})
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		if b, _ := io.ReadAll(r); string(b) != "output" {
+			t.Errorf("got output %s; want output", b)
+		}
+	})
+
+	t.Run("it returns an error if the php cli produces no output", func(t *testing.T) {
+		deployment, _ := newDeployer(&UploaderSpy{}).Deploy("<?php", nil)
+		runner := &CommandRunnerStub{map[string]string{"php -d display_errors=stderr '/var/www/html/wp-zip-abc/abc.php'": ""}}
+
+		_, err := deployment.Run(runner, nil)
+
+		if !errors.Is(err, ErrNoOutput) {
+			t.Errorf("got error %v; want ErrNoOutput", err)
+		}
+	})
+
+	t.Run("it only requests the script over http when the php cli cannot be used", func(t *testing.T) {
+		var tests = []struct {
+			name        string
+			commands    map[string]string
+			wantHttpGet bool
+		}{
+			{"php cli works", map[string]string{capabilities.Php.Command: "", "php -d display_errors=stderr '/var/www/html/wp-zip-abc/abc.php'": "output"}, false},
+			{"php cli is missing", map[string]string{}, true},
+			{"php cli fails", map[string]string{capabilities.Php.Command: ""}, true},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				deployment, _ := newDeployer(&UploaderSpy{}).Deploy("<?php", nil)
+				g := &HttpGetterSpy{}
+
+				_, err := deployment.Execute(&CommandRunnerStub{tt.commands}, g, "https://example.com", nil)
+
+				if err != nil {
+					t.Errorf("got error %v; want nil", err)
+				}
+				if gotHttpGet := g.url != ""; gotHttpGet != tt.wantHttpGet {
+					t.Errorf("got http request %v; want %v", gotHttpGet, tt.wantHttpGet)
+				}
+			})
+		}
+	})
+
+	t.Run("it removes every uploaded file and the directory", func(t *testing.T) {
+		u := &UploaderSpy{}
+		deployment, _ := newDeployer(u).Deploy("<?php", map[string]io.Reader{"Support.php": strings.NewReader("")})
+
+		err := deployment.Remove()
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+
+		want := []string{
+			"/var/www/html/wp-zip-abc/.htaccess",
+			"/var/www/html/wp-zip-abc/Support.php",
+			"/var/www/html/wp-zip-abc/abc.php",
+			"/var/www/html/wp-zip-abc/.used",
+			"/var/www/html/wp-zip-abc",
+		}
+		if strings.Join(u.deleted, ",") != strings.Join(want, ",") {
+			t.Errorf("got deleted %v; want %v", u.deleted, want)
+		}
+	})
+}
+
+func TestRandomName(t *testing.T) {
+	a, b := RandomName(), RandomName()
+
+	if len(a) != 32 || a == b {
+		t.Errorf("got %s and %s; want two different 32 character names", a, b)
+	}
+}
+
+func newDeployer(u *UploaderSpy) *Deployer {
+	return NewDeployer(u, "/var/www/html", func() string { return "abc" })
+}
+
+type UploaderSpy struct {
+	uploadErrorStub error
+	uploads         map[string]string
+	order           []string
+	dirs            []string
+	deleted         []string
+}
+
+func (u *UploaderSpy) Upload(r io.Reader, dst string) error {
+	if u.uploadErrorStub != nil {
+		return u.uploadErrorStub
+	}
+	if u.uploads == nil {
+		u.uploads = map[string]string{}
+	}
+	b, _ := io.ReadAll(r)
+	u.uploads[dst] = string(b)
+	u.order = append(u.order, dst)
+	return nil
+}
+
+func (u *UploaderSpy) Delete(dst string) error {
+	u.deleted = append(u.deleted, dst)
+	return nil
+}
+
+func (u *UploaderSpy) Mkdir(dst string) error {
+	u.dirs = append(u.dirs, dst)
+	return nil
+}
+
+func (u *UploaderSpy) Chmod(dst string, mode os.FileMode) error {
+	return nil
+}
+func (u *UploaderSpy) Getwd() (string, error) { return "/home/user", nil }
+
+type CommandRunnerStub struct {
+	commandsThatExist map[string]string
+}
+
+func (r *CommandRunnerStub) CanRunRemoteCommand(command string) bool {
+	_, ok := r.commandsThatExist[command]
+	return ok
+}
+
+func (r *CommandRunnerStub) RunRemoteCommand(command string) (io.Reader, error) {
+	output, ok := r.commandsThatExist[command]
+	if !ok {
+		return nil, errors.New("command not found")
+	}
+	return strings.NewReader(output), nil
+}
+
+type HttpGetterSpy struct {
+	url    string
+	header http.Header
+}
+
+func (g *HttpGetterSpy) Get(url string, header http.Header) (io.ReadCloser, error) {
+	g.url = url
+	g.header = header
+	return io.NopCloser(strings.NewReader("")), nil
+}
@@ -1,12 +1,16 @@
 package sftp
 
 import (
+	"bytes"
+	"fmt"
 	_sftp "github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"log"
 	"net"
 	"os"
+	"regexp"
+	"strings"
 )
 
 type SSHCredentials struct {
@@ -27,6 +31,15 @@ type FileUploadDeleter interface {
 	Upload(r io.Reader, dst string) error
 	Delete(dst string) error
 	Mkdir(dst string) error
+	Chmod(dst string, mode os.FileMode) error
+	// Getwd returns the directory that relative paths are resolved against, which is the home directory of the SSH user
+	Getwd() (string, error)
+}
+
+// CommandRunnerUploader is an interface for objects that need to place a file on the remote server before running a command that uses it.
+type CommandRunnerUploader interface {
+	RemoteCommandRunner
+	FileUploadDeleter
 }
 
 // FileEmitter is an interface that allows us to download files from the remote server. An object may choose to use this interface instead of a full Client if it only needs to download files.
@@ -36,11 +49,18 @@ type RemoteFileReader interface {
 	NewSession() (*ssh.Session, error)
 }
 
+// Dialer is an interface that allows us to open network connections from the remote server, tunnelled through the SSH connection. An object
+// may choose to use this interface instead of a full Client if it only needs to reach services that are only available to the server.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
 // A Client is the full interface for interacting with the remote server. It combines the interfaces above.
 type Client interface {
 	RemoteCommandRunner
 	FileUploadDeleter
 	RemoteFileReader
+	Dialer
 }
 
 // ClientWrapper Our ClientWrapper is a wrapper around the pkg/sftp Client
@@ -90,6 +110,11 @@ func (c *ClientWrapper) NewSession() (*ssh.Session, error) {
 	return c.conn.NewSession()
 }
 
+// Dial opens a direct-tcpip channel for "tcp" addresses, or a direct-streamlocal channel for "unix" socket paths.
+func (c *ClientWrapper) Dial(network, addr string) (net.Conn, error) {
+	return c.conn.Dial(network, addr)
+}
+
 func (c *ClientWrapper) CanRunRemoteCommand(cmd string) bool {
 	sess, err := c.conn.NewSession()
 	if err != nil {
@@ -111,18 +136,25 @@ func (c *ClientWrapper) RunRemoteCommand(command string) (io.Reader, error) {
 	reader, writer := io.Pipe()
 
 	go func() {
-		defer writer.Close()
-
 		sess, err := c.conn.NewSession()
 		if err != nil {
 			log.Printf("failed to create session: %s", err)
+			writer.CloseWithError(err)
+			return
 		}
-		sess.Stdout = writer
 		defer sess.Close()
+		sess.Stdout = writer
+		// Keep the end of stderr, so that a failing command can explain itself
+		stderr := &tailBuffer{max: 1024}
+		sess.Stderr = stderr
 
+		// A failed command closes the reader with an error, so the caller can tell a complete output from a truncated one
 		if err := sess.Run(command); err != nil {
-			log.Printf("failed to run command: %s", err)
+			log.Printf("failed to run command %s: %s", RedactCommand(command), err)
+			writer.CloseWithError(fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes())))
+			return
 		}
+		writer.Close()
 	}()
 
 	return reader, nil
@@ -162,7 +194,46 @@ func (c *ClientWrapper) Mkdir(dst string) error {
 	return nil
 }
 
+func (c *ClientWrapper) Chmod(dst string, mode os.FileMode) error {
+	return c.wrapper.Chmod(dst, mode)
+}
+
+func (c *ClientWrapper) Getwd() (string, error) {
+	return c.wrapper.Getwd()
+}
+
 func (c *ClientWrapper) Close() error {
 	defer c.wrapper.Close()
 	return c.conn.Close()
 }
+
+var secretsInCommand = regexp.MustCompile(`(--password=|MYSQL_PWD=)('(?:[^']|'\\'')*'|"[^"]*"|[^\s;]+)`)
+
+// ShellQuote wraps the value in single quotes. Since the value is wrapped in single quotes, any single quote inside it has to close the
+// quoted string, add an escaped quote, and then reopen the quoted string.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// RedactCommand masks any secrets in a command line, so that the command can safely be logged.
+func RedactCommand(command string) string {
+	return secretsInCommand.ReplaceAllString(command, "${1}[REDACTED]")
+}
+
+// tailBuffer is a writer that only keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
@@ -0,0 +1,25 @@
+package sftp
+
+import "testing"
+
+func TestRedactCommand(t *testing.T) {
+	var tests = []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{"no secrets", "mysqldump --version", "mysqldump --version"},
+		{"single quoted password", "mysql --user='u' --password='p a'\\''ss' db", "mysql --user='u' --password=[REDACTED] db"},
+		{"unquoted password", "mysql --password=secret db", "mysql --password=[REDACTED] db"},
+		{"environment variable", "MYSQL_PWD=\"secret\" mysqldump db", "MYSQL_PWD=[REDACTED] mysqldump db"},
+		{"followed by another command", "MYSQL_PWD=secret; mysql", "MYSQL_PWD=[REDACTED]; mysql"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := RedactCommand(test.command); got != test.expected {
+				t.Errorf("got %s; want %s", got, test.expected)
+			}
+		})
+	}
+}
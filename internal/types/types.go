@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"net/url"
+	"path"
 	"strings"
 )
 
@@ -42,3 +43,227 @@ func (p PublicPath) String() string {
 
 	return string(p)
 }
+
+// Rel returns the path of target relative to p, and reports whether target is p itself or inside of it.
+func (p PublicPath) Rel(target PublicPath) (string, bool) {
+	base, other := path.Clean(string(p)), path.Clean(string(target))
+	switch {
+	case other == base:
+		return "", true
+	case base == "." && !path.IsAbs(other) && other != ".." && !strings.HasPrefix(other, "../"):
+		return other, true
+	case strings.HasPrefix(other, strings.TrimSuffix(base, "/")+"/"):
+		return strings.TrimPrefix(other, strings.TrimSuffix(base, "/")+"/"), true
+	}
+
+	return "", false
+}
+
+// SiteLayout is where the parts of a WordPress site are on the server. A standard install has all of them in the public path, but
+// wp-config.php may also be in the parent of WordPress, WordPress may be in a directory of its own, and wp-content may be anywhere.
+type SiteLayout struct {
+	// Core is ABSPATH, the directory that holds WordPress itself
+	Core PublicPath
+	// Content is WP_CONTENT_DIR
+	Content PublicPath
+	// Config is the path of wp-config.php
+	Config string
+}
+
+// StandardLayout returns the layout of a standard install in the public path.
+func StandardLayout(publicPath PublicPath) SiteLayout {
+	return SiteLayout{publicPath, PublicPath(publicPath.String() + "wp-content"), publicPath.String() + "wp-config.php"}
+}
+
+// ContentMoved reports whether wp-content is somewhere other than in the directory of WordPress.
+func (l SiteLayout) ContentMoved() bool {
+	rel, ok := l.Core.Rel(l.Content)
+	return !ok || rel != "wp-content"
+}
+
+// Standard reports whether the site already has the layout of a standard install, where wp-content is in the directory of WordPress and
+// wp-config.php is either there as well or in its parent. Its wp-config.php keeps working when it is moved next to WordPress.
+func (l SiteLayout) Standard() bool {
+	if l.ContentMoved() {
+		return false
+	}
+	rel, ok := l.Core.Rel(PublicPath(l.Config))
+	if ok {
+		return rel == "wp-config.php"
+	}
+
+	return path.Clean(l.Config) == path.Join(string(l.Core), "..", "wp-config.php")
+}
+
+// Subsite is a site of a multisite network, as it is in the blogs table.
+type Subsite struct {
+	ID     int    `json:"id"`
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+}
+
+// Network is a multisite network, where every subsite shares the one WordPress install and database.
+type Network struct {
+	// Subdomain is true when the subsites are on subdomains of the main site, rather than in subdirectories of it
+	Subdomain bool
+	// Domain and Path are where the main site of the network is
+	Domain string
+	Path   string
+	// MainSiteID is the blog id of the main site, which is almost always 1
+	MainSiteID int
+	// NetworkID is the id of the network in the site table, which is also almost always 1
+	NetworkID int
+	Subsites  []Subsite
+	// LegacyUploads is true when the uploads of the subsites are in blogs.dir, as in networks created before WordPress 3.5
+	LegacyUploads bool
+}
+
+// Kind returns how the network is set up, the way LocalWP names it.
+func (n Network) Kind() string {
+	if n.Subdomain {
+		return "ms-subdomain"
+	}
+	return "ms-subdir"
+}
+
+// Domains returns the domains of the subsites other than the domain of the main site, such as the subdomains of a subdomain network or
+// the mapped domains of any network.
+func (n Network) Domains() []string {
+	domains := []string{}
+	seen := map[string]bool{n.Domain: true}
+	for _, subsite := range n.Subsites {
+		if !seen[subsite.Domain] {
+			seen[subsite.Domain] = true
+			domains = append(domains, subsite.Domain)
+		}
+	}
+
+	return domains
+}
+
+// Uploads returns the uploads directory of the subsite, relative to wp-content. The uploads of the main site are always in uploads/
+// itself, and those of every other site in a directory of their own.
+func (n Network) Uploads(subsite Subsite) string {
+	switch {
+	case subsite.ID == n.MainSiteID:
+		return "uploads"
+	case n.LegacyUploads:
+		return fmt.Sprintf("blogs.dir/%d/files", subsite.ID)
+	}
+	return fmt.Sprintf("uploads/sites/%d", subsite.ID)
+}
+
+// Url returns the url of the subsite, with the scheme of the main site.
+func (s Subsite) Url(main SiteUrl) string {
+	scheme, _, _ := strings.Cut(string(main), "://")
+	return scheme + "://" + s.Domain + strings.TrimSuffix(s.Path, "/")
+}
+
+// Find returns the subsite with the given blog id, domain, or domain and path such as example.com/blog.
+func (n Network) Find(key string) (Subsite, bool) {
+	for _, subsite := range n.Subsites {
+		if key == fmt.Sprint(subsite.ID) || key == subsite.Domain+strings.TrimSuffix(subsite.Path, "/") || key == subsite.Domain+subsite.Path {
+			return subsite, true
+		}
+	}
+	// A domain alone matches the subsite at the root of it
+	for _, subsite := range n.Subsites {
+		if key == subsite.Domain && subsite.Path == n.Path {
+			return subsite, true
+		}
+	}
+
+	return Subsite{}, false
+}
+
+// Extraction is a single subsite of a network that is exported as a site of its own. Its tables lose their number, its uploads move to
+// uploads/ and only its members are kept.
+type Extraction struct {
+	Network Network
+	Subsite Subsite
+	// Prefix is the base table prefix of the network
+	Prefix string
+	// Members are the ids of the users who have a role on the subsite
+	Members []int
+}
+
+// TablePrefix returns the prefix of the subsite's own tables. The first site of a network has the base prefix, and every other site has it
+// numbered with its id, such as wp_2_.
+func (e Extraction) TablePrefix() string {
+	if e.Subsite.ID == 1 {
+		return e.Prefix
+	}
+	return fmt.Sprintf("%s%d_", e.Prefix, e.Subsite.ID)
+}
+
+// ContentPath returns where a file of wp-content goes when only the subsite is exported, given its path relative to wp-content, and
+// reports whether it belongs to the subsite at all. The uploads of the subsite move to uploads/, and those of every other site are left out.
+func (e Extraction) ContentPath(p string) (string, bool) {
+	uploads := e.Network.Uploads(e.Subsite)
+	if rest, ok := cutDirectory(p, uploads); ok && (uploads != "uploads" || !withinDirectory(p, "uploads/sites")) {
+		return "uploads" + rest, true
+	}
+	if withinDirectory(p, "uploads") || withinDirectory(p, "blogs.dir") {
+		return "", false
+	}
+
+	return p, true
+}
+
+// OtherUploads returns the directories of wp-content with the uploads of the other sites of the network, which are left out when only the
+// subsite is exported. The main site's own uploads are mixed in with everything else in uploads/, so they are only left out by ContentPath.
+func (e Extraction) OtherUploads() []string {
+	if e.Subsite.ID == e.Network.MainSiteID {
+		return []string{"uploads/sites", "blogs.dir"}
+	}
+
+	var others []string
+	if e.Network.LegacyUploads {
+		others = append(others, "uploads")
+	} else {
+		others = append(others, "blogs.dir")
+	}
+	for _, subsite := range e.Network.Subsites {
+		if subsite.ID != e.Subsite.ID && subsite.ID != e.Network.MainSiteID {
+			others = append(others, strings.TrimSuffix(e.Network.Uploads(subsite), "/files"))
+		}
+	}
+
+	return others
+}
+
+// cutDirectory returns the rest of the path after the directory, with its leading slash, and reports whether the path is within it.
+func cutDirectory(p, dir string) (string, bool) {
+	if p == dir {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(p, dir+"/")
+	if !ok {
+		return "", false
+	}
+	return "/" + rest, true
+}
+
+func withinDirectory(p, dir string) bool {
+	_, ok := cutDirectory(p, dir)
+	return ok
+}
+
+// FormatBytes formats a size the way du -h does, such as 1.5G. A negative size is unknown.
+func FormatBytes(size int64) string {
+	if size < 0 {
+		return "unknown"
+	}
+
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%c", float64(size)/float64(div), "KMGTPE"[exp])
+}
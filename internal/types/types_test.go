@@ -1,6 +1,9 @@
 package types
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestSiteUrl(t *testing.T) {
 	t.Run("it can be created from the constructor", func(t *testing.T) {
@@ -94,3 +97,229 @@ func TestPublicPath_String(t *testing.T) {
 		}
 	})
 }
+
+func TestPublicPath_Rel(t *testing.T) {
+	var tests = []struct {
+		base, target string
+		want         string
+		wantOk       bool
+	}{
+		{"/var/www/html", "/var/www/html/", "", true},
+		{"/var/www/html/", "/var/www/html/wp", "wp", true},
+		{"./public", "public/wp-content", "wp-content", true},
+		{".", "public", "public", true},
+		{".", "../public", "", false},
+		{"/", "/var", "var", true},
+		{"/var/www/html", "/var/www/html2", "", false},
+		{"/var/www/html", "/var/www", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := PublicPath(test.base).Rel(PublicPath(test.target))
+
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("got %q, %v for %s in %s; want %q, %v", got, ok, test.target, test.base, test.want, test.wantOk)
+		}
+	}
+}
+
+func TestSiteLayout_Standard(t *testing.T) {
+	var tests = []struct {
+		name   string
+		layout SiteLayout
+		want   bool
+	}{
+		{"standard", StandardLayout("/var/www/html"), true},
+		{"wp-config.php in the parent", SiteLayout{"/var/www/html", "/var/www/html/wp-content", "/var/www/wp-config.php"}, true},
+		{"relative wp-config.php in the parent", SiteLayout{"public", "./public/wp-content/", "wp-config.php"}, true},
+		{"wordpress in its own directory", SiteLayout{"/var/www/html/wp/", "/var/www/html/wp-content", "/var/www/html/wp-config.php"}, false},
+		{"bedrock", SiteLayout{"/srv/site/web/wp/", "/srv/site/web/app", "/srv/site/web/wp-config.php"}, false},
+		{"wp-config.php elsewhere", SiteLayout{"/var/www/html", "/var/www/html/wp-content", "/etc/wordpress/wp-config.php"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.layout.Standard(); got != test.want {
+				t.Errorf("got %v; want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	subdirectories := Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []Subsite{
+		{1, "example.com", "/"},
+		{2, "example.com", "/blog/"},
+		{3, "mapped.org", "/"},
+	}}
+	subdomains := Network{Subdomain: true, Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []Subsite{
+		{1, "example.com", "/"},
+		{2, "blog.example.com", "/"},
+		{3, "shop.example.com", "/"},
+	}}
+
+	t.Run("it names the kind of network", func(t *testing.T) {
+		if subdirectories.Kind() != "ms-subdir" || subdomains.Kind() != "ms-subdomain" {
+			t.Errorf("got %s and %s; want ms-subdir and ms-subdomain", subdirectories.Kind(), subdomains.Kind())
+		}
+	})
+
+	t.Run("it lists the domains other than the main one", func(t *testing.T) {
+		if got := subdirectories.Domains(); !reflect.DeepEqual(got, []string{"mapped.org"}) {
+			t.Errorf("got %v; want [mapped.org]", got)
+		}
+		if got := subdomains.Domains(); !reflect.DeepEqual(got, []string{"blog.example.com", "shop.example.com"}) {
+			t.Errorf("got %v; want [blog.example.com shop.example.com]", got)
+		}
+	})
+
+	t.Run("it finds the uploads of each subsite", func(t *testing.T) {
+		legacy := subdirectories
+		legacy.LegacyUploads = true
+
+		var tests = []struct {
+			network Network
+			subsite Subsite
+			want    string
+		}{
+			{subdirectories, subdirectories.Subsites[0], "uploads"},
+			{subdirectories, subdirectories.Subsites[1], "uploads/sites/2"},
+			{legacy, legacy.Subsites[0], "uploads"},
+			{legacy, legacy.Subsites[1], "blogs.dir/2/files"},
+		}
+
+		for _, tt := range tests {
+			if got := tt.network.Uploads(tt.subsite); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		}
+	})
+
+	t.Run("it builds the url of a subsite with the scheme of the main site", func(t *testing.T) {
+		if got := subdirectories.Subsites[1].Url("https://example.com"); got != "https://example.com/blog" {
+			t.Errorf("got %s; want https://example.com/blog", got)
+		}
+		if got := subdomains.Subsites[1].Url("http://example.com"); got != "http://blog.example.com" {
+			t.Errorf("got %s; want http://blog.example.com", got)
+		}
+	})
+}
+
+func TestNetwork_Find(t *testing.T) {
+	network := Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []Subsite{
+		{1, "example.com", "/"},
+		{2, "example.com", "/blog/"},
+		{3, "mapped.org", "/"},
+	}}
+
+	var tests = []struct {
+		key    string
+		want   int
+		wantOk bool
+	}{
+		{"2", 2, true},
+		{"example.com", 1, true},
+		{"example.com/blog", 2, true},
+		{"example.com/blog/", 2, true},
+		{"mapped.org", 3, true},
+		{"4", 0, false},
+		{"other.org", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, ok := network.Find(tt.key)
+
+			if ok != tt.wantOk || got.ID != tt.want {
+				t.Errorf("got subsite %v and %v; want %d and %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestExtraction(t *testing.T) {
+	network := Network{Domain: "example.com", Path: "/", MainSiteID: 1, Subsites: []Subsite{{1, "example.com", "/"}, {2, "example.com", "/blog/"}}}
+	main := Extraction{Network: network, Subsite: network.Subsites[0], Prefix: "wp_"}
+	blog := Extraction{Network: network, Subsite: network.Subsites[1], Prefix: "wp_"}
+	legacy := blog
+	legacy.Network.LegacyUploads = true
+
+	t.Run("it numbers the table prefix of every site but the first", func(t *testing.T) {
+		if main.TablePrefix() != "wp_" || blog.TablePrefix() != "wp_2_" {
+			t.Errorf("got %s and %s; want wp_ and wp_2_", main.TablePrefix(), blog.TablePrefix())
+		}
+	})
+
+	t.Run("it lists the uploads of the other sites", func(t *testing.T) {
+		network := Network{MainSiteID: 1, Subsites: []Subsite{{1, "example.com", "/"}, {2, "example.com", "/blog/"}, {3, "example.com", "/shop/"}}}
+		legacy := network
+		legacy.LegacyUploads = true
+
+		var tests = []struct {
+			name       string
+			extraction Extraction
+			want       []string
+		}{
+			{"main site", Extraction{Network: network, Subsite: network.Subsites[0]}, []string{"uploads/sites", "blogs.dir"}},
+			{"subsite", Extraction{Network: network, Subsite: network.Subsites[1]}, []string{"blogs.dir", "uploads/sites/3"}},
+			{"legacy subsite", Extraction{Network: legacy, Subsite: legacy.Subsites[1]}, []string{"uploads", "blogs.dir/3"}},
+		}
+
+		for _, tt := range tests {
+			if got := tt.extraction.OtherUploads(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v for the %s; want %v", got, tt.name, tt.want)
+			}
+		}
+	})
+
+	t.Run("it moves the uploads of the subsite to uploads", func(t *testing.T) {
+		var tests = []struct {
+			extraction Extraction
+			path       string
+			want       string
+			wantOk     bool
+		}{
+			{main, "uploads/2024/01/a.jpg", "uploads/2024/01/a.jpg", true},
+			{main, "uploads/sites/2/2024/01/a.jpg", "", false},
+			{main, "plugins/p/p.php", "plugins/p/p.php", true},
+			{blog, "uploads/sites/2/2024/01/a.jpg", "uploads/2024/01/a.jpg", true},
+			{blog, "uploads/sites/2", "uploads", true},
+			{blog, "uploads/sites/3/a.jpg", "", false},
+			{blog, "uploads/2024/01/a.jpg", "", false},
+			{blog, "uploads-backup/a.jpg", "uploads-backup/a.jpg", true},
+			{blog, "themes/t/style.css", "themes/t/style.css", true},
+			{legacy, "blogs.dir/2/files/2012/01/a.jpg", "uploads/2012/01/a.jpg", true},
+			{legacy, "blogs.dir/3/files/2012/01/a.jpg", "", false},
+		}
+
+		for _, tt := range tests {
+			got, ok := tt.extraction.ContentPath(tt.path)
+
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got %q and %v for %s; want %q and %v", got, ok, tt.path, tt.want, tt.wantOk)
+			}
+		}
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	var tests = []struct {
+		size int64
+		want string
+	}{
+		{-1, "unknown"},
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.5K"},
+		{50 * 1024 * 1024, "50.0M"},
+		{3 * 1024 * 1024 * 1024, "3.0G"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := FormatBytes(tt.size); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
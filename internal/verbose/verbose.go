@@ -0,0 +1,14 @@
+// Package verbose logs the decisions the tool makes along the way, such as which method is used to read the site, when asked for.
+package verbose
+
+import "log"
+
+// Enabled turns the verbose output on.
+var Enabled bool
+
+// Printf logs the message only when the verbose output is enabled.
+func Printf(format string, v ...any) {
+	if Enabled {
+		log.Printf(format, v...)
+	}
+}
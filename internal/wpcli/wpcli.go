@@ -0,0 +1,206 @@
+// Package wpcli uses WP-CLI on the remote server, when it is installed. WP-CLI loads the site the same way WordPress does, so it gets edge
+// cases right that reading wp-config.php and the database ourselves would not.
+package wpcli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jfortunato/wp-zip/internal/capabilities"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/sftp"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"strings"
+)
+
+var (
+	ErrCommandFailed = errors.New("wp-cli command failed")
+	ErrInvalidOutput = errors.New("unexpected wp-cli output")
+)
+
+// WPCli runs WP-CLI commands against the site in the public path.
+type WPCli struct {
+	runner sftp.RemoteCommandRunner
+}
+
+// New is the constructor for WPCli.
+func New(runner sftp.RemoteCommandRunner) *WPCli {
+	return &WPCli{runner}
+}
+
+// Available reports whether WP-CLI is installed on the remote server.
+func (w *WPCli) Available() bool {
+	return w.runner.CanRunRemoteCommand(capabilities.WPCli.Command)
+}
+
+// Stream runs the WP-CLI command and streams its output. Plugins and themes are never loaded, so that a broken plugin can't get in the way.
+func (w *WPCli) Stream(publicPath types.PublicPath, args string) (io.Reader, error) {
+	return w.runner.RunRemoteCommand("wp --path=" + sftp.ShellQuote(string(publicPath)) + " --skip-plugins --skip-themes " + args)
+}
+
+// Run runs the WP-CLI command and returns its output, without the final newline.
+func (w *WPCli) Run(publicPath types.PublicPath, args string) (string, error) {
+	output, err := w.Stream(publicPath, args)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrCommandFailed, err)
+	}
+	b, err := io.ReadAll(output)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrCommandFailed, err)
+	}
+
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// ConfigList returns every constant and variable defined in wp-config.php, in a single run of WP-CLI. Values are written the same way
+// `wp config get` prints them, where true is "1" and false is an empty string.
+func (w *WPCli) ConfigList(publicPath types.PublicPath) (map[string]string, error) {
+	output, err := w.Run(publicPath, "config list --format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%w: config list: %s", ErrInvalidOutput, err)
+	}
+
+	values := map[string]string{}
+	for _, entry := range entries {
+		switch value := entry.Value.(type) {
+		case bool:
+			if value {
+				values[entry.Name] = "1"
+			} else {
+				values[entry.Name] = ""
+			}
+		case nil:
+			values[entry.Name] = ""
+		default:
+			values[entry.Name] = fmt.Sprint(value)
+		}
+	}
+
+	return values, nil
+}
+
+// ParseWPConfig reads the fields we need from wp-config.php, the same as the parser does.
+func (w *WPCli) ParseWPConfig(publicPath types.PublicPath) (parser.WPConfigFields, error) {
+	values, err := w.ConfigList(publicPath)
+	if err != nil {
+		return parser.WPConfigFields{}, err
+	}
+	for _, name := range []string{"DB_NAME", "DB_USER", "DB_PASSWORD", "DB_HOST", "table_prefix"} {
+		if _, ok := values[name]; !ok {
+			return parser.WPConfigFields{}, fmt.Errorf("%w: %s is not defined", ErrInvalidOutput, name)
+		}
+	}
+	// Everything else is optional, such as DB_CHARSET, without which the server's default charset is used
+	constant := func(name string) string {
+		return values[name]
+	}
+	settings := parser.NewWPConfigSettings(constant)
+	// Only finds the file, without loading WordPress
+	configFile, _ := w.Run(publicPath, "config path")
+
+	host, port, socket := parser.ParseDbHost(values["DB_HOST"])
+
+	return parser.WPConfigFields{
+		Credentials:      database.DatabaseCredentials{User: values["DB_USER"], Pass: values["DB_PASSWORD"], Name: values["DB_NAME"], Host: host, Port: port, Socket: socket, Charset: settings.Charset},
+		Prefix:           values["table_prefix"],
+		ConfigFile:       configFile,
+		AbsPath:          constant("ABSPATH"),
+		WPConfigSettings: settings,
+	}, nil
+}
+
+// FindSiteUrl reads the site url from the siteurl option, or from the home option if that isn't set.
+func (w *WPCli) FindSiteUrl(publicPath types.PublicPath) (types.SiteUrl, error) {
+	var errs []error
+	for _, option := range []string{"siteurl", "home"} {
+		value, err := w.Run(publicPath, "option get "+option)
+		if err == nil {
+			var u types.SiteUrl
+			u, err = types.NewSiteUrl(strings.TrimSpace(value))
+			if err == nil {
+				return u, nil
+			}
+			err = fmt.Errorf("%w: %s is %q", ErrInvalidOutput, option, value)
+		}
+		errs = append(errs, err)
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// CoreVersion returns the installed version of WordPress.
+func (w *WPCli) CoreVersion(publicPath types.PublicPath) (string, error) {
+	version, err := w.Run(publicPath, "core version")
+	if err != nil {
+		return "", err
+	}
+	if version = strings.TrimSpace(version); version == "" || strings.ContainsAny(version, " \n") {
+		return "", fmt.Errorf("%w: core version is %q", ErrInvalidOutput, version)
+	}
+
+	return version, nil
+}
+
+// DatabaseExporter returns an exporter that dumps the database with `wp db export`.
+func (w *WPCli) DatabaseExporter(publicPath types.PublicPath, credentials database.DatabaseCredentials, opts database.ExportOptions) *DatabaseExporter {
+	return &DatabaseExporter{w, publicPath, credentials, opts}
+}
+
+// DatabaseExporter is a database.DatabaseExporter that uses `wp db export`, which runs mysqldump with the credentials WP-CLI reads itself.
+type DatabaseExporter struct {
+	w           *WPCli
+	publicPath  types.PublicPath
+	credentials database.DatabaseCredentials
+	opts        database.ExportOptions
+}
+
+func (e *DatabaseExporter) Export() (io.Reader, error) {
+	profile, err := database.ParseDumpProfile(string(e.opts.Profile))
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether we may dump the events isn't known here, so they are left out
+	dump := &database.Mysqldump{Profile: profile, Charset: e.credentials.Charset, ExtraArgs: e.opts.ExtraArgs}
+	args := dump.Args()
+
+	// Only the tables of a subsite are dumped, rather than the whole network
+	if e.opts.Subsite != nil {
+		output, err := e.w.Run(e.publicPath, "db tables --all-tables --format=csv")
+		if err != nil {
+			return nil, err
+		}
+		tables := database.NewSubsiteFilter(*e.opts.Subsite).Tables(strings.Split(strings.TrimSpace(output), ","))
+		args += " --tables=" + sftp.ShellQuote(strings.Join(tables, ","))
+	}
+
+	// The dump goes to stdout when the file name is -
+	output, err := e.w.Stream(e.publicPath, "db export - "+args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCommandFailed, err)
+	}
+
+	// The command only fails once its output is read, so wait for the start of the dump before committing to this exporter
+	r := bufio.NewReader(output)
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			err = errors.New("db export produced no output")
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCommandFailed, err)
+	}
+
+	return r, nil
+}
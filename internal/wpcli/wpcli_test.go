@@ -0,0 +1,230 @@
+package wpcli
+
+import (
+	"errors"
+	"github.com/jfortunato/wp-zip/internal/database"
+	"github.com/jfortunato/wp-zip/internal/parser"
+	"github.com/jfortunato/wp-zip/internal/types"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const prefix = "wp --path='public' --skip-plugins --skip-themes "
+
+func TestWPCli_ParseWPConfig(t *testing.T) {
+	t.Run("it reads the fields with a single wp config list", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{
+			prefix + "config list --format=json": `[{"name":"DB_NAME","value":"db","type":"constant"},{"name":"DB_USER","value":"user","type":"constant"},` +
+				`{"name":"DB_PASSWORD","value":"pa ss","type":"constant"},{"name":"DB_HOST","value":"127.0.0.1:3307","type":"constant"},` +
+				`{"name":"DB_CHARSET","value":"utf8mb4","type":"constant"},{"name":"table_prefix","value":"xx_","type":"variable"},` +
+				`{"name":"WP_CONTENT_DIR","value":"/srv/app","type":"constant"},{"name":"WP_DEBUG","value":true,"type":"constant"},` +
+				`{"name":"BLOG_ID_CURRENT_SITE","value":1,"type":"constant"},{"name":"MULTISITE","value":false,"type":"constant"}]` + "\n",
+			prefix + "config path": "/srv/wp-config.php\n",
+		}}
+
+		got, err := New(runner).ParseWPConfig("public")
+
+		if err != nil {
+			t.Errorf("got error %v; want nil", err)
+		}
+		want := parser.WPConfigFields{
+			Credentials: database.DatabaseCredentials{User: "user", Pass: "pa ss", Name: "db", Host: "127.0.0.1", Port: "3307", Charset: "utf8mb4"},
+			Prefix:      "xx_",
+			ConfigFile:  "/srv/wp-config.php",
+			WPConfigSettings: parser.WPConfigSettings{
+				Charset:           "utf8mb4",
+				ContentDir:        "/srv/app",
+				Debug:             true,
+				BlogIdCurrentSite: "1",
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v; want %v", got, want)
+		}
+	})
+
+	t.Run("it returns an error if a required field cannot be read", func(t *testing.T) {
+		_, err := New(&MockCommandRunner{}).ParseWPConfig("public")
+
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Errorf("got error %v; want ErrCommandFailed", err)
+		}
+	})
+
+	t.Run("it returns an error if a required field is not defined", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{prefix + "config list --format=json": `[{"name":"DB_NAME","value":"db","type":"constant"}]`}}
+
+		_, err := New(runner).ParseWPConfig("public")
+
+		if !errors.Is(err, ErrInvalidOutput) {
+			t.Errorf("got error %v; want ErrInvalidOutput", err)
+		}
+	})
+}
+
+func TestWPCli_FindSiteUrl(t *testing.T) {
+	var tests = []struct {
+		name    string
+		outputs map[string]string
+		want    types.SiteUrl
+		wantErr bool
+	}{
+		{"siteurl", map[string]string{prefix + "option get siteurl": "https://example.com/\n"}, "https://example.com", false},
+		{"home when siteurl fails", map[string]string{prefix + "option get home": "https://home.example.com\n"}, "https://home.example.com", false},
+		{"home when siteurl is invalid", map[string]string{prefix + "option get siteurl": "\n", prefix + "option get home": "https://home.example.com\n"}, "https://home.example.com", false},
+		{"neither", map[string]string{}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(&MockCommandRunner{tt.outputs}).FindSiteUrl("public")
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWPCli_CoreVersion(t *testing.T) {
+	var tests = []struct {
+		name    string
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{"version", "6.4.2\n", "6.4.2", false},
+		{"empty", "\n", "", true},
+		{"warning", "PHP Warning: something\n6.4.2\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(&MockCommandRunner{map[string]string{prefix + "core version": tt.output}}).CoreVersion("public")
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseExporter_Export(t *testing.T) {
+	t.Run("it streams wp db export with the profile arguments", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{
+			prefix + "db export - --no-tablespaces --single-transaction --quick --triggers --hex-blob --routines": "-- dump\n",
+		}}
+		exporter := New(runner).DatabaseExporter("public", database.DatabaseCredentials{}, database.ExportOptions{})
+
+		r, err := exporter.Export()
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "-- dump\n" {
+			t.Errorf("got %q; want the dump", b)
+		}
+	})
+
+	t.Run("it only exports the tables of a subsite", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{
+			prefix + "db tables --all-tables --format=csv": "wp_blogs,wp_options,wp_2_options,wp_2_posts,wp_3_posts,wp_users,wp_usermeta,legacy_log\n",
+			prefix + "db export - --no-tablespaces --single-transaction --quick --triggers --hex-blob --routines --tables='wp_2_options,wp_2_posts,wp_users,wp_usermeta,legacy_log'": "-- dump\n",
+		}}
+		subsite := &types.Extraction{Subsite: types.Subsite{ID: 2, Domain: "example.com", Path: "/blog/"}, Prefix: "wp_"}
+		exporter := New(runner).DatabaseExporter("public", database.DatabaseCredentials{}, database.ExportOptions{Subsite: subsite})
+
+		r, err := exporter.Export()
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "-- dump\n" {
+			t.Errorf("got %q; want the dump", b)
+		}
+	})
+
+	t.Run("it fails before returning when the command fails", func(t *testing.T) {
+		exporter := New(&FailingCommandRunner{errors.New("Error: Error establishing a database connection")}).DatabaseExporter("public", database.DatabaseCredentials{}, database.ExportOptions{})
+
+		_, err := exporter.Export()
+
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Errorf("got error %v; want %v", err, ErrCommandFailed)
+		}
+	})
+
+	t.Run("it fails when the command has no output", func(t *testing.T) {
+		runner := &MockCommandRunner{map[string]string{
+			prefix + "db export - --no-tablespaces --single-transaction --quick --triggers --hex-blob --routines": "",
+		}}
+		exporter := New(runner).DatabaseExporter("public", database.DatabaseCredentials{}, database.ExportOptions{})
+
+		_, err := exporter.Export()
+
+		if !errors.Is(err, ErrCommandFailed) {
+			t.Errorf("got error %v; want %v", err, ErrCommandFailed)
+		}
+	})
+
+	t.Run("it falls back to the next exporter when the stream fails on its first read", func(t *testing.T) {
+		preferred := New(&FailingCommandRunner{errors.New("Error: Error establishing a database connection")}).DatabaseExporter("public", database.DatabaseCredentials{}, database.ExportOptions{})
+		exporter := database.NewFallbackDatabaseExporter(preferred, &ExporterStub{"-- mysqldump\n"})
+
+		r, err := exporter.Export()
+
+		if err != nil {
+			t.Fatalf("got error %v; want nil", err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "-- mysqldump\n" {
+			t.Errorf("got %q; want the dump of the next exporter", b)
+		}
+	})
+}
+
+type ExporterStub struct {
+	output string
+}
+
+func (e *ExporterStub) Export() (io.Reader, error) {
+	return strings.NewReader(e.output), nil
+}
+
+// FailingCommandRunner runs every command, but fails as soon as the output is read, the same as a command that exits with an error does.
+type FailingCommandRunner struct {
+	err error
+}
+
+func (f *FailingCommandRunner) CanRunRemoteCommand(command string) bool { return true }
+func (f *FailingCommandRunner) RunRemoteCommand(command string) (io.Reader, error) {
+	return iotest.ErrReader(f.err), nil
+}
+
+type MockCommandRunner struct {
+	outputs map[string]string
+}
+
+func (m *MockCommandRunner) CanRunRemoteCommand(command string) bool {
+	_, ok := m.outputs[command]
+	return ok
+}
+
+func (m *MockCommandRunner) RunRemoteCommand(command string) (io.Reader, error) {
+	output, ok := m.outputs[command]
+	if !ok {
+		return nil, errors.New("command failed")
+	}
+	return strings.NewReader(output), nil
+}
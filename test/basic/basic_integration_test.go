@@ -31,11 +31,12 @@ func TestZipFileCreated(t *testing.T) {
 	filename := filepath.Join(os.TempDir(), "wp-zip-basic.zip")
 	defer cleanup(t, filename)
 
-	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, DOCUMENT_ROOT)
+	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, DOCUMENT_ROOT, packager.Options{})
 	_ = p.PackageWP(filename)
 
-	test.AssertZipContainsFiles(t, filename, []string{"files/index.php", "files/wp-config.php", "database.sql", "wpmigrate-export.json"})
+	test.AssertZipContainsFiles(t, filename, []string{"files/index.php", "files/wp-config.php", "database.sql", "wpmigrate-export.json", "environment.json"})
 	test.AssertFileContainsMatch(t, filename, "wpmigrate-export.json", `"name":"`+url.Domain()+`"`)
+	test.AssertFileContainsMatch(t, filename, "environment.json", `"extensions":\[`)
 }
 
 func TestUploadedFileIsAlwaysDeleted(t *testing.T) {
@@ -53,7 +54,7 @@ func TestUploadedFileIsAlwaysDeleted(t *testing.T) {
 	defer cleanup(t, filename)
 
 	// We expect an error here because the url is invalid
-	p, _ := packager.NewPackager(credentials, invalidDomain, DOCUMENT_ROOT)
+	p, _ := packager.NewPackager(credentials, invalidDomain, DOCUMENT_ROOT, packager.Options{})
 	err := p.PackageWP(filename)
 	if err == nil {
 		t.Errorf("Expected error, got nil")
@@ -72,7 +73,7 @@ func TestDomainCanBeInferredFromDatabase(t *testing.T) {
 	filename := filepath.Join(os.TempDir(), "wp-zip-basic-detect-domain.zip")
 	defer cleanup(t, filename)
 
-	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, "", DOCUMENT_ROOT)
+	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, "", DOCUMENT_ROOT, packager.Options{})
 	_ = p.PackageWP(filename)
 
 	test.AssertZipContainsFiles(t, filename, []string{"files/index.php", "files/wp-config.php", "database.sql", "wpmigrate-export.json"})
@@ -89,7 +90,7 @@ func TestSiteRootCanBeInferredFromDatabase(t *testing.T) {
 	filename := filepath.Join(os.TempDir(), "wp-zip-basic-detect-site-root.zip")
 	defer cleanup(t, filename)
 
-	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, "")
+	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, "", packager.Options{})
 	_ = p.PackageWP(filename)
 
 	test.AssertZipContainsFiles(t, filename, []string{"files/index.php", "files/wp-config.php", "database.sql", "wpmigrate-export.json"})
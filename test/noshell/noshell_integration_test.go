@@ -31,7 +31,7 @@ func TestZipFileCreatedNoShell(t *testing.T) {
 	filename := filepath.Join(os.TempDir(), "wp-zip-noshell.zip")
 	defer cleanup(t, filename)
 
-	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, DOCUMENT_ROOT)
+	p, _ := packager.NewPackager(sftp.SSHCredentials{User: SSH_USER, Pass: SSH_PASS, Host: SSH_HOST, Port: containers["wordpress"].MappedPort("22/tcp")}, url, DOCUMENT_ROOT, packager.Options{})
 	_ = p.PackageWP(filename)
 
 	test.AssertZipContainsFiles(t, filename, []string{"files/index.php", "files/wp-config.php", "database.sql", "wpmigrate-export.json"})
@@ -52,7 +52,7 @@ func TestUploadedFileIsAlwaysDeletedNoShell(t *testing.T) {
 	defer cleanup(t, filename)
 
 	// We expect an error here because the url is invalid
-	p, _ := packager.NewPackager(credentials, invalidDomain, DOCUMENT_ROOT)
+	p, _ := packager.NewPackager(credentials, invalidDomain, DOCUMENT_ROOT, packager.Options{})
 	err := p.PackageWP(filename)
 	if err == nil {
 		t.Errorf("Expected error, got nil")